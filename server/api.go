@@ -2,12 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
 )
 
 // ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
@@ -23,8 +31,21 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	apiRouter.HandleFunc("/hello", p.HelloWorld).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/config", p.GetConfig).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/config", p.UpdateConfig).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/config/migrate", p.MigrateConfig).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/rules/lint", p.LintRules).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/archives", p.LookupArchive).Methods(http.MethodGet).Queries("url", "{url}")
+	apiRouter.HandleFunc("/archives", p.ListArchives).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/archives/purge", p.PurgeArchives).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/archives/purge", p.GetPurgeStatus).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/archives/{postId}", p.GetArchives).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/archives/{postId}/detail", p.GetArchiveDetail).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/archives/{postId}", p.DeleteArchive).Methods(http.MethodDelete)
 	apiRouter.HandleFunc("/archival-tools", p.GetArchivalTools).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/archival-tools/metrics", p.GetToolMetrics).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mime-defaults", p.GetMimeDefaults).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mime-defaults", p.UpdateMimeDefaults).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/mime-tools", p.GetMimeToolMap).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/mime-tools", p.UpdateMimeToolMap).Methods(http.MethodPost)
 
 	router.ServeHTTP(w, r)
 }
@@ -115,30 +136,10 @@ func (p *Plugin) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 
 	archivalRules := requestConfig.ArchivalRules
 
-	// Validate that each rule has required fields
-	for i, rule := range archivalRules {
-		if rule.Kind == "" {
-			http.Error(w, fmt.Sprintf("Rule at index %d must have a kind (hostname or mimetype)", i), http.StatusBadRequest)
-			return
-		}
-		// Reject "default" kind - it's system-generated only
-		if rule.Kind == "default" {
-			http.Error(w, fmt.Sprintf("Rule at index %d has invalid kind 'default'. The default rule is system-generated and cannot be created by users", i), http.StatusBadRequest)
-			return
-		}
-		if rule.Kind != "hostname" && rule.Kind != "mimetype" {
-			http.Error(w, fmt.Sprintf("Rule at index %d has invalid kind '%s'. Must be 'hostname' or 'mimetype'", i, rule.Kind), http.StatusBadRequest)
-			return
-		}
-		// Require pattern for hostname and mimetype rules
-		if rule.Pattern == "" {
-			http.Error(w, fmt.Sprintf("Rule at index %d (kind: %s) must have a pattern", i, rule.Kind), http.StatusBadRequest)
-			return
-		}
-		if rule.ArchivalTool == "" {
-			http.Error(w, fmt.Sprintf("Rule at index %d must have an archival tool", i), http.StatusBadRequest)
-			return
-		}
+	// Rules are validated like on configuration load, so saved rules never break it
+	if err := p.validateArchivalRules(archivalRules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Save default archival tool to KV store (this persists)
@@ -164,14 +165,18 @@ func (p *Plugin) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 	p.configuration.ArchivalRules = archivalRules
 	p.configurationLock.Unlock()
 
-	// Return the full configuration
+	// Return the full configuration, with warnings about rules that can never match
 	responseConfig := struct {
 		ArchivalRules       []ArchivalRule `json:"archivalRules"`
 		DefaultArchivalTool string         `json:"defaultArchivalTool"`
+		Warnings            []string       `json:"warnings,omitempty"`
 	}{
 		ArchivalRules:       archivalRules,
 		DefaultArchivalTool: requestConfig.DefaultArchivalTool,
 	}
+	if p.archiveProcessor != nil {
+		responseConfig.Warnings = p.archiveProcessor.lintArchivalRules(archivalRules)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -180,7 +185,92 @@ func (p *Plugin) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetArchivalTools returns the list of available archival tools (admin only)
+// LintRules reports the archival rules that can never match because an earlier rule matches
+// everything they do (admin only). The rules are not saved.
+func (p *Plugin) LintRules(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		ArchivalRules []ArchivalRule `json:"archivalRules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if p.archiveProcessor == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Warnings []string `json:"warnings"`
+	}{
+		Warnings: p.archiveProcessor.lintArchivalRules(request.ArchivalRules),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode rule warnings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// MigrateConfig rewrites archival rules stored in a legacy format into the current format (admin only),
+// reporting how many legacy MIME type mappings were migrated
+func (p *Plugin) MigrateConfig(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	migrated, source, err := p.migrateArchivalConfig()
+	if err != nil {
+		p.API.LogError("Failed to migrate archival configuration", "error", err.Error())
+		http.Error(w, fmt.Sprintf("Failed to migrate configuration: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	p.API.LogInfo("Migrated archival configuration", "source", source, "migrated", migrated.Migrated)
+
+	response := struct {
+		Source              string         `json:"source"`
+		MigratedMappings    int            `json:"migratedMappings"`
+		ArchivalRules       []ArchivalRule `json:"archivalRules"`
+		DefaultArchivalTool string         `json:"defaultArchivalTool"`
+	}{
+		Source:              source,
+		MigratedMappings:    migrated.Migrated,
+		ArchivalRules:       migrated.ArchivalRules,
+		DefaultArchivalTool: migrated.DefaultArchivalTool,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode migration result", "error", err)
+	}
+}
+
+// GetArchivalTools returns the available archival tools with their limits and capabilities (admin only)
 func (p *Plugin) GetArchivalTools(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("Mattermost-User-ID")
 	if userID == "" {
@@ -201,11 +291,11 @@ func (p *Plugin) GetArchivalTools(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tools := p.archiveProcessor.GetAvailableArchivalTools()
+	tools := p.archiveProcessor.DescribeArchivalTools()
 
 	// Return as JSON
 	response := struct {
-		Tools []string `json:"tools"`
+		Tools []archiver.ToolDescriptor `json:"tools"`
 	}{
 		Tools: tools,
 	}
@@ -217,6 +307,359 @@ func (p *Plugin) GetArchivalTools(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetToolMetrics returns the number of archives and durations of each archival tool on this server
+// since the plugin started (admin only)
+func (p *Plugin) GetToolMetrics(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if p.archiveProcessor == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	since, tools := p.archiveProcessor.ToolMetrics()
+	response := struct {
+		Since time.Time            `json:"since"`
+		Tools []toolMetricsSummary `json:"tools"`
+	}{
+		Since: since,
+		Tools: tools,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode tool metrics", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetMimeDefaults returns the MIME category to archival tool mapping (admin only)
+func (p *Plugin) GetMimeDefaults(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	categoryDefaults, err := p.loadCategoryDefaults()
+	if err != nil {
+		p.API.LogError("Failed to load MIME category defaults from KV store", "error", err.Error())
+		http.Error(w, "Failed to load MIME category defaults", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		CategoryDefaults map[string]string `json:"categoryDefaults"`
+	}{
+		CategoryDefaults: categoryDefaults,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode MIME category defaults", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UpdateMimeDefaults replaces the MIME category to archival tool mapping (admin only)
+func (p *Plugin) UpdateMimeDefaults(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		CategoryDefaults map[string]string `json:"categoryDefaults"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.CategoryDefaults == nil {
+		request.CategoryDefaults = map[string]string{}
+	}
+
+	if p.archiveProcessor == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	// Mapped tools must exist and archive the content of their category
+	if err := validateCategoryDefaults(request.CategoryDefaults, p.archiveProcessor.GetAvailableArchivalTools()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	categoryPatterns := make(map[string]string, len(request.CategoryDefaults))
+	for category, tool := range request.CategoryDefaults {
+		categoryPatterns[category+"/*"] = tool
+	}
+	if err := p.archiveProcessor.validateToolMimeTypes(categoryPatterns); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Save MIME category defaults to KV store (this persists)
+	if err := p.saveCategoryDefaults(request.CategoryDefaults); err != nil {
+		p.API.LogError("Failed to save MIME category defaults to KV store", "error", err.Error())
+		http.Error(w, "Failed to save MIME category defaults", http.StatusInternalServerError)
+		return
+	}
+
+	// Update in-memory configuration
+	p.configurationLock.Lock()
+	if p.configuration == nil {
+		p.configuration = &configuration{}
+	}
+	p.configuration.CategoryDefaults = request.CategoryDefaults
+	p.configurationLock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(request); err != nil {
+		p.API.LogError("Failed to encode MIME category defaults", "error", err)
+	}
+}
+
+// GetMimeToolMap returns the MIME type to archival tool mapping (admin only)
+func (p *Plugin) GetMimeToolMap(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	mimeToolMap, err := p.loadMimeToolMap()
+	if err != nil {
+		p.API.LogError("Failed to load MIME type to tool mapping from KV store", "error", err.Error())
+		http.Error(w, "Failed to load MIME type to tool mapping", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		MimeToolMap map[string]string `json:"mimeToolMap"`
+	}{
+		MimeToolMap: mimeToolMap,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode MIME type to tool mapping", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// UpdateMimeToolMap replaces the MIME type to archival tool mapping (admin only)
+func (p *Plugin) UpdateMimeToolMap(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		MimeToolMap map[string]string `json:"mimeToolMap"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.MimeToolMap == nil {
+		request.MimeToolMap = map[string]string{}
+	}
+
+	if p.archiveProcessor == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	// Mapped tools must exist and archive the MIME types they're mapped to
+	if err := validateMimeToolMap(request.MimeToolMap, p.archiveProcessor.GetAvailableArchivalTools()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := p.archiveProcessor.validateToolMimeTypes(request.MimeToolMap); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Save the MIME type to tool mapping to KV store (this persists)
+	if err := p.saveMimeToolMap(request.MimeToolMap); err != nil {
+		p.API.LogError("Failed to save MIME type to tool mapping to KV store", "error", err.Error())
+		http.Error(w, "Failed to save MIME type to tool mapping", http.StatusInternalServerError)
+		return
+	}
+
+	// Update in-memory configuration
+	p.configurationLock.Lock()
+	if p.configuration == nil {
+		p.configuration = &configuration{}
+	}
+	p.configuration.MimeToolMap = request.MimeToolMap
+	p.configurationLock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(request); err != nil {
+		p.API.LogError("Failed to encode MIME type to tool mapping", "error", err)
+	}
+}
+
+// LookupArchive returns the most recent archive of a URL and its history of previous captures (admin only).
+// The scopeId query parameter selects the team or channel when deduplication isn't global.
+func (p *Plugin) LookupArchive(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Archives of a URL can come from any channel, so only system admins can look them up
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	archivedURL := r.URL.Query().Get("url")
+	if archivedURL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	metadata, err := p.archiveProcessor.storageService.GetExistingArchiveForURL(archivedURL, r.URL.Query().Get("scopeId"))
+	if err != nil {
+		p.API.LogError("Failed to look up archive for URL", "url", redactURL(archivedURL), "error", err.Error())
+		http.Error(w, "Failed to look up archive", http.StatusInternalServerError)
+		return
+	}
+	if metadata == nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		p.API.LogError("Failed to encode archive", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+const (
+	// defaultArchivesPerPage is the page size of archive listings when not requested
+	defaultArchivesPerPage = 50
+	// maxArchivesPerPage bounds the page size of archive listings
+	maxArchivesPerPage = 200
+)
+
+// ListArchives returns a page of the archived URLs, most recently archived first (admin only)
+func (p *Plugin) ListArchives(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Archives can come from any channel, so only system admins can list them
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	page, perPage := 0, defaultArchivesPerPage
+	if value := r.URL.Query().Get("page"); value != "" {
+		var err error
+		if page, err = strconv.Atoi(value); err != nil || page < 0 {
+			http.Error(w, "page must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("perPage"); value != "" {
+		var err error
+		if perPage, err = strconv.Atoi(value); err != nil || perPage <= 0 {
+			http.Error(w, "perPage must be a positive number", http.StatusBadRequest)
+			return
+		}
+		perPage = min(perPage, maxArchivesPerPage)
+	}
+
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	archives, total, err := p.archiveProcessor.storageService.ListGlobalArchives(page, perPage)
+	if err != nil {
+		p.API.LogError("Failed to list archives", "error", err.Error())
+		http.Error(w, "Failed to list archives", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Archives []*ArchiveMetadata `json:"archives"`
+		Total    int                `json:"total"`
+		Page     int                `json:"page"`
+		PerPage  int                `json:"perPage"`
+		HasMore  bool               `json:"hasMore"`
+	}{
+		Archives: archives,
+		Total:    total,
+		Page:     page,
+		PerPage:  perPage,
+		HasMore:  (page+1)*perPage < total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode archives", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
 // GetArchives returns archive information for a specific post
 func (p *Plugin) GetArchives(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("Mattermost-User-ID")
@@ -267,3 +710,363 @@ func (p *Plugin) GetArchives(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// GetArchiveDetail returns the full archive metadata of every URL archived for a post, including the
+// tool used and the content hash, for auditing what was captured. Requires read access to the post's channel.
+func (p *Plugin) GetArchiveDetail(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	postID := mux.Vars(r)["postId"]
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannelContent) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	// The links of the post find the archives stored before posts had an archive index
+	var urls []string
+	if extractor := p.archiveProcessor.linkExtractor; extractor != nil {
+		urls = append(extractor.ExtractURLs(post.Message), extractor.ExtractAttachmentURLs(post)...)
+	}
+	archives, err := p.archiveProcessor.storageService.GetPostArchives(postID, urls)
+	if err != nil {
+		p.API.LogError("Failed to get post archives", "postID", postID, "error", err.Error())
+		http.Error(w, "Failed to get archives", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		PostID   string             `json:"postId"`
+		Archives []*ArchiveMetadata `json:"archives"`
+	}{
+		PostID:   postID,
+		Archives: archives,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode archives", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteArchive removes the archive of a URL from a post (channel or system admin only).
+// The archived file is removed once no other archive references it, by deleting the bot reply it's attached to.
+// With deleteReply=true, the bot replies in the post's thread with the archived file are deleted as well.
+func (p *Plugin) DeleteArchive(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	postID := vars["postId"]
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	archiveURL := r.URL.Query().Get("url")
+	if archiveURL == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+	deleteReply := r.URL.Query().Get("deleteReply") == "true"
+
+	// Get post to verify user has access
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	// Deleting requires being a system admin, or an admin of the channel
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !user.IsInRole(model.SystemAdminRoleId) {
+		member, appErr := p.API.GetChannelMember(post.ChannelId, userID)
+		if appErr != nil || member == nil || channel.IsGroupOrDirect() ||
+			!(member.SchemeAdmin || strings.Contains(member.Roles, model.ChannelAdminRoleId)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	if p.archiveProcessor == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+	storage := p.archiveProcessor.storageService
+
+	removed, err := storage.DeleteArchiveMetadata(postID, archiveURL)
+	if err != nil {
+		p.API.LogError("Failed to delete archive metadata", "postID", postID, "error", err.Error())
+		http.Error(w, "Failed to delete archive", http.StatusInternalServerError)
+		return
+	}
+	if removed == nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		FileDeleted    bool     `json:"fileDeleted"`
+		DeletedReplies []string `json:"deletedReplies"`
+	}{
+		DeletedReplies: []string{},
+	}
+
+	botID := p.botService.GetBotID()
+	if deleteReply {
+		response.DeletedReplies = p.deleteArchiveReplies(post, removed.AttachedFileIDs(), botID)
+	}
+
+	// Remove the files once nothing references them anymore. Files without a reference count
+	// were archived before counts existed and may be shared, so they're kept.
+	var released []string
+	for _, fileID := range removed.FileIDs() {
+		remaining, err := storage.ReleaseFileReference(fileID)
+		if err != nil {
+			p.API.LogWarn("Failed to release file reference", "fileID", fileID, "error", err.Error())
+		} else if remaining == 0 {
+			released = append(released, fileID)
+		}
+	}
+	deletedPosts := slices.Clone(response.DeletedReplies)
+	for _, fileID := range released {
+		postID, deleted := p.deleteArchivedFile(fileID, botID, deletedPosts, released)
+		if postID != "" {
+			deletedPosts = append(deletedPosts, postID)
+		}
+		if fileID == removed.FileID {
+			response.FileDeleted = deleted
+		}
+	}
+
+	if slices.Contains(released, removed.FileID) {
+		// Don't let deduplication reuse the deleted file
+		config := p.getConfiguration()
+		scope := config.getDedupScope()
+		if scope != DedupScopeNone {
+			if scopeID, scopeErr := p.archiveProcessor.getDedupScopeID(postID, scope); scopeErr == nil {
+				if err = storage.DeleteGlobalArchiveMetadataForFile(archiveURL, scopeID, removed.FileID); err != nil {
+					p.API.LogWarn("Failed to delete global archive metadata", "url", redactURL(archiveURL), "error", err.Error())
+				}
+			}
+		}
+	}
+
+	p.API.LogInfo("Archive deleted", "postID", postID, "url", redactURL(archiveURL), "userID", userID, "fileDeleted", response.FileDeleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode delete archive response", "error", err)
+	}
+}
+
+// PurgeArchives deletes every archived file and all archive metadata (admin only). Purging is
+// confirmed twice: a request without a token returns a confirmation token, usable once by the same
+// admin for a few minutes, and a second request with the token and purgeConfirmation starts the
+// purge in the background, see GetPurgeStatus.
+func (p *Plugin) PurgeArchives(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		ConfirmationToken string `json:"confirmationToken"`
+		Confirm           string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		http.Error(w, "Archive processor not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var response any
+	statusCode := http.StatusOK
+	if request.ConfirmationToken == "" {
+		token, err := p.newPurgeToken(userID)
+		if err != nil {
+			p.API.LogError("Failed to create purge confirmation token", "error", err.Error())
+			http.Error(w, "Failed to create confirmation token", http.StatusInternalServerError)
+			return
+		}
+		p.API.LogWarn("Purge of all archives requested, waiting for confirmation", "userID", userID)
+		response = struct {
+			ConfirmationToken string `json:"confirmationToken"`
+			ExpiresInSeconds  int    `json:"expiresInSeconds"`
+			Confirm           string `json:"confirm"`
+		}{token, purgeTokenTTLSeconds, purgeConfirmation}
+	} else {
+		if request.Confirm != purgeConfirmation {
+			http.Error(w, fmt.Sprintf("confirm must be %q", purgeConfirmation), http.StatusBadRequest)
+			return
+		}
+		confirmed, err := p.consumePurgeToken(userID, request.ConfirmationToken)
+		if err != nil {
+			p.API.LogError("Failed to check purge confirmation token", "error", err.Error())
+			http.Error(w, "Failed to check confirmation token", http.StatusInternalServerError)
+			return
+		}
+		if !confirmed {
+			http.Error(w, "Invalid or expired confirmation token", http.StatusForbidden)
+			return
+		}
+
+		status, started := p.startPurge(userID)
+		if !started {
+			http.Error(w, "A purge is already running", http.StatusConflict)
+			return
+		}
+		p.API.LogWarn("Purging all archives and their files", "userID", userID)
+		response = status
+		statusCode = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to encode purge response", "error", err)
+	}
+}
+
+// GetPurgeStatus returns the progress of the running purge, or the outcome of the last one (admin only)
+func (p *Plugin) GetPurgeStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is system admin
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	status, err := p.getPurgeStatus()
+	if err != nil {
+		p.API.LogError("Failed to get purge status", "error", err.Error())
+		http.Error(w, "Failed to get purge status", http.StatusInternalServerError)
+		return
+	}
+	if status == nil {
+		http.Error(w, "No purge has run", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		p.API.LogError("Failed to encode purge status response", "error", err)
+	}
+}
+
+// deleteArchiveReplies deletes the bot replies in the post's thread that only attach files of the
+// given ones, like the main file of an archive and its additional outputs.
+// Returns the IDs of the deleted replies
+func (p *Plugin) deleteArchiveReplies(post *model.Post, fileIDs []string, botID string) []string {
+	deleted := []string{}
+
+	thread, appErr := p.API.GetPostThread(post.Id)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get post thread", "postID", post.Id, "error", appErr.Error())
+		return deleted
+	}
+
+	for _, reply := range thread.Posts {
+		// Summary replies attaching other files are kept so the other archives stay available
+		if reply.UserId != botID || !attachesOnly(reply, fileIDs) {
+			continue
+		}
+		if appErr := p.API.DeletePost(reply.Id); appErr != nil {
+			p.API.LogWarn("Failed to delete archive reply", "postID", reply.Id, "error", appErr.Error())
+			continue
+		}
+		deleted = append(deleted, reply.Id)
+	}
+
+	return deleted
+}
+
+// attachesOnly reports whether the post attaches files, all of them among the given ones
+func attachesOnly(post *model.Post, fileIDs []string) bool {
+	if len(post.FileIds) == 0 {
+		return false
+	}
+	for _, fileID := range post.FileIds {
+		if !slices.Contains(fileIDs, fileID) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteArchivedFile deletes an archived file nothing references anymore, like evicted captures
+func (p *Plugin) DeleteArchivedFile(fileID string) bool {
+	_, deleted := p.deleteArchivedFile(fileID, p.botService.GetBotID(), nil, []string{fileID})
+	return deleted
+}
+
+// deleteArchivedFile deletes an archived file. The plugin API can't delete files directly,
+// so the bot reply the file was uploaded with is deleted, which deletes its attachments. The reply
+// is only deleted if all the files it attaches are deletable ones. Returns the ID of the reply
+// deleted, empty if it already was, and whether the file was deleted.
+func (p *Plugin) deleteArchivedFile(fileID, botID string, alreadyDeleted, deletable []string) (string, bool) {
+	fileInfo, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil || fileInfo.PostId == "" {
+		return "", false
+	}
+	if slices.Contains(alreadyDeleted, fileInfo.PostId) {
+		return "", true
+	}
+
+	holder, appErr := p.API.GetPost(fileInfo.PostId)
+	if appErr != nil || holder.UserId != botID || !attachesOnly(holder, deletable) {
+		p.API.LogInfo("Archived file is attached to a post that can't be deleted, keeping it", "fileID", fileID, "postID", fileInfo.PostId)
+		return "", false
+	}
+
+	if appErr := p.API.DeletePost(holder.Id); appErr != nil {
+		p.API.LogWarn("Failed to delete post holding archived file", "postID", holder.Id, "error", appErr.Error())
+		return "", false
+	}
+	return holder.Id, true
+}
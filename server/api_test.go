@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestDeleteArchive(t *testing.T) {
+	const archivedURL = "https://example.com/a.pdf"
+
+	// setup stores an archive of the main file file1 and the additional files, all attached to reply1
+	setup := func(t *testing.T, additionalFiles ...AdditionalFile) (*Plugin, *plugintest.API) {
+		metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: archivedURL, FileID: "file1", AdditionalFiles: additionalFiles}
+		api := &plugintest.API{}
+		setupMemoryKV(api)
+		api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+		mockLogs(api)
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+		api.On("GetPost", "reply1").Return(&model.Post{Id: "reply1", ChannelId: "channel1", UserId: "bot1", RootId: "post1", FileIds: metadata.AttachedFileIDs()}, nil)
+		api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Type: model.ChannelTypeOpen}, nil)
+		api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+		api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+		api.On("GetChannelMember", "channel1", "user").Return(&model.ChannelMember{ChannelId: "channel1", UserId: "user", Roles: model.ChannelUserRoleId}, nil)
+
+		storage := NewStorageService(api)
+		require.NoError(t, storage.StoreArchiveMetadata(metadata))
+
+		p := &Plugin{
+			archiveProcessor: &ArchiveProcessor{api: api, storageService: storage},
+			botService:       &BotService{botID: "bot1"},
+			configuration:    &configuration{},
+		}
+		p.SetAPI(api)
+		return p, api
+	}
+
+	request := func(userID string, deleteReply bool) *http.Request {
+		query := url.Values{"url": {archivedURL}}
+		if deleteReply {
+			query.Set("deleteReply", "true")
+		}
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/archives/post1?"+query.Encode(), http.NoBody)
+		r.Header.Set("Mattermost-User-ID", userID)
+		return r
+	}
+
+	t.Run("regular members can't delete", func(t *testing.T) {
+		p, _ := setup(t)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("user", false))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("admin deletes the archive and its unused file", func(t *testing.T) {
+		p, api := setup(t)
+		api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", PostId: "reply1"}, nil)
+		api.On("DeletePost", "reply1").Return(nil)
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("admin", false))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			FileDeleted bool `json:"fileDeleted"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.True(t, response.FileDeleted)
+		api.AssertCalled(t, "DeletePost", "reply1")
+
+		// Deleting again reports the archive as missing
+		w = httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("admin", false))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("delete reply removes the bot reply in the thread", func(t *testing.T) {
+		p, api := setup(t)
+		thread := model.NewPostList()
+		thread.AddPost(&model.Post{Id: "post1", ChannelId: "channel1"})
+		thread.AddPost(&model.Post{Id: "reply1", UserId: "bot1", RootId: "post1", FileIds: []string{"file1"}})
+		thread.AddPost(&model.Post{Id: "summary1", UserId: "bot1", RootId: "post1", FileIds: []string{"file1", "file2"}})
+		api.On("GetPostThread", "post1").Return(thread, nil)
+		api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", PostId: "reply1"}, nil)
+		api.On("DeletePost", "reply1").Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("admin", true))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"deletedReplies":["reply1"]`)
+		api.AssertNotCalled(t, "DeletePost", "summary1")
+	})
+
+	t.Run("archives with several files delete the replies attaching them", func(t *testing.T) {
+		for _, deleteReply := range []bool{false, true} {
+			p, api := setup(t, AdditionalFile{FileID: "thumb1", Filename: "a.jpg"})
+			thread := model.NewPostList()
+			thread.AddPost(&model.Post{Id: "post1", ChannelId: "channel1"})
+			thread.AddPost(&model.Post{Id: "reply1", UserId: "bot1", RootId: "post1", FileIds: []string{"file1", "thumb1"}})
+			thread.AddPost(&model.Post{Id: "summary1", UserId: "bot1", RootId: "post1", FileIds: []string{"file1", "thumb1", "file2"}})
+			api.On("GetPostThread", "post1").Return(thread, nil)
+			api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", PostId: "reply1"}, nil)
+			api.On("GetFileInfo", "thumb1").Return(&model.FileInfo{Id: "thumb1", PostId: "reply1"}, nil)
+			api.On("DeletePost", "reply1").Return(nil).Once()
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, request("admin", deleteReply))
+			require.Equal(t, http.StatusOK, w.Code)
+			var response struct {
+				FileDeleted    bool     `json:"fileDeleted"`
+				DeletedReplies []string `json:"deletedReplies"`
+			}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.True(t, response.FileDeleted, "deleteReply=%v", deleteReply)
+			if deleteReply {
+				assert.Equal(t, []string{"reply1"}, response.DeletedReplies)
+			}
+			api.AssertNumberOfCalls(t, "DeletePost", 1)
+			api.AssertNotCalled(t, "DeletePost", "summary1")
+		}
+	})
+}
+
+func TestGetArchivalTools(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	directDownload := archiver.NewDirectDownload(0)
+	directDownload.SetTimeouts(45*time.Second, 0)
+	p := &Plugin{
+		archiveProcessor: &ArchiveProcessor{api: api, archivalTools: map[string]archiver.ArchivalTool{
+			archiver.DirectDownloadToolName: directDownload,
+			archiver.HTMLToPDFToolName:      archiver.NewHTMLToPDF(0),
+			archiver.FeedExpandToolName:     archiver.NewFeedExpand(0),
+			"video_thumbnail":               &multiFileTool{},
+		}},
+		configuration: &configuration{},
+	}
+	p.SetAPI(api)
+
+	request := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archival-tools", nil)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("user").Code)
+	})
+
+	t.Run("describes the tools", func(t *testing.T) {
+		w := request("admin")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Tools []archiver.ToolDescriptor `json:"tools"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		require.Len(t, response.Tools, 4)
+
+		tools := make(map[string]archiver.ToolDescriptor)
+		var names []string
+		for _, tool := range response.Tools {
+			tools[tool.Name] = tool
+			names = append(names, tool.Name)
+		}
+		assert.Equal(t, []string{"direct_download", "feed_expand", "html_to_pdf", "video_thumbnail"}, names)
+
+		assert.Equal(t, 45, tools["direct_download"].TimeoutSeconds, "the configured timeout is reported")
+		assert.Equal(t, int64(archiver.MaxFileSize), tools["direct_download"].MaxBytes)
+		assert.NotEmpty(t, tools["direct_download"].Description)
+		assert.True(t, tools["html_to_pdf"].RequiresExternalService)
+		assert.Equal(t, []string{"text/html", "application/xhtml+xml"}, tools["html_to_pdf"].MimeTypes)
+		assert.Empty(t, tools["direct_download"].MimeTypes, "direct downloads archive any content")
+		assert.True(t, tools["direct_download"].RequiresNetwork)
+		assert.True(t, tools["feed_expand"].Expands)
+		assert.Equal(t, archiver.ToolDescriptor{Name: "video_thumbnail", MultiFile: true}, tools["video_thumbnail"], "tools not describing themselves only have a name")
+	})
+}
+
+func TestGetArchiveDetail(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1", Message: "See https://example.com/a"}, nil)
+	api.On("GetPost", "missing").Return(nil, model.NewAppError("GetPost", "not found", nil, "", http.StatusNotFound))
+	api.On("HasPermissionToChannel", "member", "channel1", model.PermissionReadChannelContent).Return(true)
+	api.On("HasPermissionToChannel", "outsider", "channel1", model.PermissionReadChannelContent).Return(false)
+
+	storage := NewStorageService(api)
+	archivedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{
+		PostID: "post1", OriginalURL: "https://example.com/a", FileID: "file1", Filename: "a.html", MimeType: "text/html",
+		ToolUsed: archiver.ObeliskToolName, Size: 2048, ETag: `"v1"`, ContentHash: "abc123", ArchivedAt: archivedAt,
+	}))
+	p := &Plugin{
+		archiveProcessor: &ArchiveProcessor{api: api, linkExtractor: NewLinkExtractor(), storageService: storage},
+		configuration:    &configuration{},
+	}
+	p.SetAPI(api)
+
+	request := func(userID, postID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archives/"+postID+"/detail", http.NoBody)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires access to the channel", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("", "post1").Code)
+		assert.Equal(t, http.StatusForbidden, request("outsider", "post1").Code)
+		assert.Equal(t, http.StatusNotFound, request("member", "missing").Code)
+	})
+
+	t.Run("returns the archive metadata of the post", func(t *testing.T) {
+		w := request("member", "post1")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			PostID   string             `json:"postId"`
+			Archives []*ArchiveMetadata `json:"archives"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "post1", response.PostID)
+		require.Len(t, response.Archives, 1)
+		archive := response.Archives[0]
+		assert.Equal(t, archiver.ObeliskToolName, archive.ToolUsed)
+		assert.Equal(t, "abc123", archive.ContentHash)
+		assert.Equal(t, `"v1"`, archive.ETag)
+		assert.Equal(t, int64(2048), archive.Size)
+		assert.True(t, archive.ArchivedAt.Equal(archivedAt))
+	})
+}
+
+func TestGetToolMetrics(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	metrics := newToolMetrics()
+	metrics.record(archiver.DirectDownloadToolName, 250*time.Millisecond, 1024, false)
+	p := &Plugin{
+		archiveProcessor: &ArchiveProcessor{api: api, toolMetrics: metrics},
+		configuration:    &configuration{},
+	}
+	p.SetAPI(api)
+
+	request := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archival-tools/metrics", nil)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("user").Code)
+	})
+
+	t.Run("returns the metrics of each tool", func(t *testing.T) {
+		w := request("admin")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Since time.Time            `json:"since"`
+			Tools []toolMetricsSummary `json:"tools"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.False(t, response.Since.IsZero())
+		assert.Equal(t, []toolMetricsSummary{
+			{Tool: archiver.DirectDownloadToolName, Successes: 1, AverageMilliseconds: 250, MaxMilliseconds: 250, Bytes: 1024},
+		}, response.Tools)
+	})
+}
+
+func TestMimeDefaults(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	processor := &ArchiveProcessor{api: api, archivalTools: map[string]archiver.ArchivalTool{}, toolDescriptors: map[string]archiver.ToolDescriptor{}}
+	processor.registerTool(archiver.NewDirectDownload(0))
+	processor.registerTool(archiver.NewObelisk(0))
+	p := &Plugin{archiveProcessor: processor, configuration: &configuration{}}
+	p.SetAPI(api)
+
+	request := func(method, userID, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/mime-defaults", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "user", "").Code)
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "user", `{"categoryDefaults":{}}`).Code)
+	})
+
+	t.Run("rejects unknown tools", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"categoryDefaults":{"image":"missing_tool"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unknown archival tool")
+	})
+
+	t.Run("rejects tools not archiving the category", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"categoryDefaults":{"image":"obelisk"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "doesn't archive 'image/*' content")
+
+		assert.Equal(t, http.StatusOK, request(http.MethodPost, "admin", `{"categoryDefaults":{"text":"obelisk"}}`).Code)
+	})
+
+	t.Run("rejects categories with a subtype", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"categoryDefaults":{"image/png":"direct_download"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("saves and reads the mapping", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"categoryDefaults":{"image":"direct_download","video":"do_nothing"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = request(http.MethodGet, "admin", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			CategoryDefaults map[string]string `json:"categoryDefaults"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, map[string]string{"image": "direct_download", "video": "do_nothing"}, response.CategoryDefaults)
+
+		// The mapping is stored separately from the rules and applied by the configuration
+		assert.Equal(t, "direct_download", p.getConfiguration().CategoryDefaults["image"])
+	})
+}
+
+func TestMimeToolMap(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	processor := &ArchiveProcessor{api: api, archivalTools: map[string]archiver.ArchivalTool{}, toolDescriptors: map[string]archiver.ToolDescriptor{}}
+	processor.registerTool(archiver.NewDirectDownload(0))
+	processor.registerTool(archiver.NewObelisk(0))
+	p := &Plugin{archiveProcessor: processor, configuration: &configuration{}}
+	p.SetAPI(api)
+
+	request := func(method, userID, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/mime-tools", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "user", "").Code)
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "user", `{"mimeToolMap":{}}`).Code)
+	})
+
+	t.Run("rejects unknown tools", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"mimeToolMap":{"application/pdf":"missing_tool"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unknown archival tool")
+	})
+
+	t.Run("rejects tools not archiving the MIME type", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"mimeToolMap":{"application/pdf":"obelisk"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "doesn't archive 'application/pdf' content")
+	})
+
+	t.Run("rejects invalid MIME types", func(t *testing.T) {
+		for _, mimeType := range []string{"image", "*/*", "image/p*", "text/html; charset=utf-8"} {
+			w := request(http.MethodPost, "admin", `{"mimeToolMap":{"`+mimeType+`":"direct_download"}}`)
+			assert.Equal(t, http.StatusBadRequest, w.Code, mimeType)
+		}
+	})
+
+	t.Run("saves and reads the mapping", func(t *testing.T) {
+		w := request(http.MethodPost, "admin", `{"mimeToolMap":{"application/pdf":"direct_download","video/*":"do_nothing"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = request(http.MethodGet, "admin", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			MimeToolMap map[string]string `json:"mimeToolMap"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, map[string]string{"application/pdf": "direct_download", "video/*": "do_nothing"}, response.MimeToolMap)
+
+		// The mapping is stored separately from the rules and applied by the configuration
+		assert.Equal(t, "do_nothing", p.getConfiguration().MimeToolMap["video/*"])
+	})
+}
+
+func TestLookupArchive(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	storage := NewStorageService(api)
+	const archivedURL = "https://status.example.com/"
+	require.NoError(t, storage.StoreGlobalArchiveCapture(&ArchiveMetadata{PostID: "post1", OriginalURL: archivedURL, FileID: "file1"}, "", 5))
+	require.NoError(t, storage.StoreGlobalArchiveCapture(&ArchiveMetadata{PostID: "post2", OriginalURL: archivedURL, FileID: "file2"}, "", 5))
+
+	p := &Plugin{archiveProcessor: &ArchiveProcessor{api: api, storageService: storage}}
+	p.SetAPI(api)
+
+	request := func(userID, archivedURL string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archives?"+url.Values{"url": {archivedURL}}.Encode(), http.NoBody)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("user", archivedURL).Code)
+	assert.Equal(t, http.StatusNotFound, request("admin", "https://example.com/missing").Code)
+
+	w := request("admin", archivedURL)
+	require.Equal(t, http.StatusOK, w.Code)
+	var metadata ArchiveMetadata
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&metadata))
+	assert.Equal(t, "file2", metadata.FileID)
+	require.Len(t, metadata.History, 1)
+	assert.Equal(t, "file1", metadata.History[0].FileID)
+}
+
+func TestListArchives(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	storage := NewStorageService(api)
+	archivedAt := time.Now().Add(-time.Hour)
+	for i, archivedURL := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+		require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{OriginalURL: archivedURL, FileID: fmt.Sprintf("file%d", i+1), ArchivedAt: archivedAt.Add(time.Duration(i) * time.Minute)}, ""))
+	}
+	// Archives of other deduplication scopes are listed too, and archiving a URL again moves it first
+	require.NoError(t, storage.StoreGlobalArchiveCapture(&ArchiveMetadata{OriginalURL: "https://example.com/4", FileID: "file4", ArchivedAt: archivedAt.Add(10 * time.Minute)}, "team1", 5))
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{OriginalURL: "https://example.com/1", FileID: "file5", ArchivedAt: archivedAt.Add(20 * time.Minute)}, ""))
+
+	p := &Plugin{archiveProcessor: &ArchiveProcessor{api: api, storageService: storage}}
+	p.SetAPI(api)
+
+	type listResponse struct {
+		Archives []*ArchiveMetadata `json:"archives"`
+		Total    int                `json:"total"`
+		Page     int                `json:"page"`
+		PerPage  int                `json:"perPage"`
+		HasMore  bool               `json:"hasMore"`
+	}
+	request := func(userID, query string) (*httptest.ResponseRecorder, listResponse) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archives?"+query, http.NoBody)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		var response listResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w, response
+	}
+	fileIDs := func(archives []*ArchiveMetadata) []string {
+		ids := make([]string, 0, len(archives))
+		for _, archive := range archives {
+			ids = append(ids, archive.FileID)
+		}
+		return ids
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		w, _ := request("user", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("invalid pagination", func(t *testing.T) {
+		w, _ := request("admin", "page=-1")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = request("admin", "perPage=zero")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		w, response := request("admin", "page=0&perPage=3")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"file5", "file4", "file3"}, fileIDs(response.Archives))
+		assert.Equal(t, 4, response.Total)
+		assert.True(t, response.HasMore)
+
+		w, response = request("admin", "page=1&perPage=3")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"file2"}, fileIDs(response.Archives))
+		assert.False(t, response.HasMore)
+
+		w, response = request("admin", "page=5")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, response.Archives)
+		assert.Equal(t, defaultArchivesPerPage, response.PerPage)
+	})
+
+	t.Run("deleted archives are removed from the index", func(t *testing.T) {
+		require.NoError(t, storage.DeleteGlobalArchiveMetadataForFile("https://example.com/2", "", "file2"))
+
+		w, response := request("admin", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"file5", "file4", "file3"}, fileIDs(response.Archives))
+		assert.Equal(t, 3, response.Total)
+	})
+
+	t.Run("url lookups are still served", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archives?url=", http.NoBody)
+		r.Header.Set("Mattermost-User-ID", "admin")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestUpdateConfigValidatesRules(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+
+	p := &Plugin{configuration: &configuration{}}
+	p.SetAPI(api)
+	p.archiveProcessor = NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), NewStorageService(api), nil)
+
+	request := func(rules []ArchivalRule) *httptest.ResponseRecorder {
+		body, err := json.Marshal(map[string]any{"archivalRules": rules, "defaultArchivalTool": "obelisk"})
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/config", bytes.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "admin")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("rules never breaking the configuration load are saved", func(t *testing.T) {
+		rules := []ArchivalRule{{Kind: "mimetype", Pattern: "application/pdf", ArchivalTool: "direct_download"}}
+		require.Equal(t, http.StatusOK, request(rules).Code)
+
+		saved, err := p.loadArchivalRules()
+		require.NoError(t, err)
+		assert.Equal(t, rules, saved)
+		assert.NoError(t, p.validateArchivalRules(saved))
+	})
+
+	t.Run("tools must archive the content of their MIME type rule", func(t *testing.T) {
+		before := kv.data[archivalRulesKey]
+		w := request([]ArchivalRule{{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "obelisk"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "doesn't archive that content")
+		assert.Equal(t, before, kv.data[archivalRulesKey], "invalid rules aren't saved")
+	})
+
+	t.Run("labels and descriptions are limited in length", func(t *testing.T) {
+		w := request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", Label: strings.Repeat("a", maxRuleLabelLength+1)}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "has a label longer than")
+
+		w = request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", Description: strings.Repeat("a", maxRuleDescriptionLength+1)}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "has a description longer than")
+	})
+
+	t.Run("reply modes and templates are checked", func(t *testing.T) {
+		w := request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", ReplyMode: "loud"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid reply mode 'loud'")
+
+		w = request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", ReplyTemplate: "Archived {link}"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid reply template")
+	})
+
+	t.Run("accept languages must be header values", func(t *testing.T) {
+		w := request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", AcceptLanguage: "en\r\nX-Injected: 1"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid accept language")
+	})
+
+	t.Run("refresh intervals can't be negative", func(t *testing.T) {
+		w := request([]ArchivalRule{{Kind: "hostname", Pattern: "example.com", ArchivalTool: "obelisk", RefreshIntervalSeconds: -60}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid refresh interval -60")
+	})
+}
+
+func TestMigrateConfig(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+	api.On("LoadPluginConfiguration", mock.Anything).Return(nil)
+
+	p := &Plugin{configuration: &configuration{}}
+	p.SetAPI(api)
+
+	request := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/config/migrate", http.NoBody)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("user").Code)
+	})
+
+	t.Run("migrates legacy rules from the KV store", func(t *testing.T) {
+		require.Nil(t, api.KVSet(archivalRulesKey, []byte(`[{"mimeType":"application/pdf","archivalTool":"direct_download"},{"kind":"default","archivalTool":"obelisk"}]`)))
+
+		w := request("admin")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Source              string         `json:"source"`
+			MigratedMappings    int            `json:"migratedMappings"`
+			ArchivalRules       []ArchivalRule `json:"archivalRules"`
+			DefaultArchivalTool string         `json:"defaultArchivalTool"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, migrationSourceKVStore, response.Source)
+		assert.Equal(t, 2, response.MigratedMappings)
+		assert.Equal(t, "obelisk", response.DefaultArchivalTool)
+
+		// The rules are rewritten in the current format
+		rules, err := p.loadArchivalRules()
+		require.NoError(t, err)
+		assert.Equal(t, []ArchivalRule{{Kind: "mimetype", Pattern: "application/pdf", ArchivalTool: "direct_download"}}, rules)
+		assert.Equal(t, "obelisk", p.getConfiguration().DefaultArchivalTool)
+
+		// Running it again has nothing left to migrate
+		w = request("admin")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Zero(t, response.MigratedMappings)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// archiveLookupCache is a bounded, least recently used cache of the global archive metadata read
+// from the KV store, so a link posted repeatedly doesn't read its archive every time. Entries expire
+// after a short TTL, as the other servers of a cluster store archives without invalidating it.
+type archiveLookupCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	// order lists the entries, most recently used first
+	order *list.List
+	// version changes on every invalidation, so lookups started before a write don't cache stale values
+	version uint64
+}
+
+// archiveLookupEntry is the cached value of a KV key
+type archiveLookupEntry struct {
+	key      string
+	data     []byte // nil when the URL has no archive
+	storedAt time.Time
+}
+
+// newArchiveLookupCache creates a disabled cache, see configure
+func newArchiveLookupCache() *archiveLookupCache {
+	return &archiveLookupCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// configure sets the number of entries kept and how long they're used, zero entries disables the
+// cache. Cached entries are dropped when the settings change.
+func (c *archiveLookupCache) configure(maxEntries int, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.maxEntries == maxEntries && c.ttl == ttl {
+		return
+	}
+	c.maxEntries = maxEntries
+	c.ttl = ttl
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.version++
+}
+
+// get returns the cached value of a key, if cached and not expired. The version is passed to set
+// the value read from the KV store on misses.
+func (c *archiveLookupCache) get(key string, now time.Time) (data []byte, ok bool, version uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, c.version
+	}
+	entry := element.Value.(*archiveLookupEntry)
+	if now.Sub(entry.storedAt) >= c.ttl {
+		c.remove(element)
+		return nil, false, c.version
+	}
+	c.order.MoveToFront(element)
+	return entry.data, true, c.version
+}
+
+// set caches the value of a key read at the given version, unless an archive was written since.
+// The least recently used entries are dropped past the maximum number of entries.
+func (c *archiveLookupCache) set(key string, data []byte, version uint64, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.maxEntries <= 0 || version != c.version {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&archiveLookupEntry{key: key, data: data, storedAt: now})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops the cached value of a key, once it is written
+func (c *archiveLookupCache) invalidate(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.version++
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// invalidateAll drops every cached value, once the archives are purged
+func (c *archiveLookupCache) invalidateAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.version++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// remove drops an entry, the lock must be held
+func (c *archiveLookupCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*archiveLookupEntry).key)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveLookupCache(t *testing.T) {
+	now := time.Now()
+
+	t.Run("disabled by default", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		_, _, version := cache.get("key", now)
+		cache.set("key", []byte("value"), version, now)
+
+		_, ok, _ := cache.get("key", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("caches values and missing keys", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		cache.configure(10, time.Minute)
+		cache.set("key", []byte("value"), 1, now)
+		cache.set("missing", nil, 1, now)
+
+		data, ok, _ := cache.get("key", now)
+		assert.True(t, ok)
+		assert.Equal(t, "value", string(data))
+
+		data, ok, _ = cache.get("missing", now)
+		assert.True(t, ok)
+		assert.Nil(t, data)
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		cache.configure(10, time.Minute)
+		cache.set("key", []byte("value"), 1, now)
+
+		_, ok, _ := cache.get("key", now.Add(59*time.Second))
+		assert.True(t, ok)
+		_, ok, _ = cache.get("key", now.Add(time.Minute))
+		assert.False(t, ok)
+	})
+
+	t.Run("least recently used entries are evicted", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		cache.configure(2, time.Minute)
+		cache.set("a", []byte("a"), 1, now)
+		cache.set("b", []byte("b"), 1, now)
+		cache.get("a", now)
+		cache.set("c", []byte("c"), 1, now)
+
+		for key, cached := range map[string]bool{"a": true, "b": false, "c": true} {
+			_, ok, _ := cache.get(key, now)
+			assert.Equal(t, cached, ok, key)
+		}
+	})
+
+	t.Run("invalidation drops the key and values read before it", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		cache.configure(10, time.Minute)
+		cache.set("key", []byte("old"), 1, now)
+
+		_, _, version := cache.get("other", now)
+		cache.invalidate("key")
+		_, ok, _ := cache.get("key", now)
+		assert.False(t, ok)
+
+		cache.set("other", []byte("stale"), version, now)
+		_, ok, _ = cache.get("other", now)
+		assert.False(t, ok, "values read before a write aren't cached")
+	})
+
+	t.Run("reconfiguring drops the entries", func(t *testing.T) {
+		cache := newArchiveLookupCache()
+		cache.configure(10, time.Minute)
+		for i := range 5 {
+			cache.set(fmt.Sprintf("key%d", i), nil, 1, now)
+		}
+
+		cache.configure(10, time.Minute)
+		_, ok, _ := cache.get("key0", now)
+		assert.True(t, ok, "unchanged settings keep the entries")
+
+		cache.configure(20, time.Minute)
+		_, ok, _ = cache.get("key0", now)
+		assert.False(t, ok)
+	})
+}
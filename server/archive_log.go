@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// archiveIDLength is the length of the correlation IDs of archivals
+const archiveIDLength = 8
+
+// logger is the part of the plugin API used to log
+type logger interface {
+	LogDebug(msg string, keyValuePairs ...any)
+	LogInfo(msg string, keyValuePairs ...any)
+	LogWarn(msg string, keyValuePairs ...any)
+	LogError(msg string, keyValuePairs ...any)
+}
+
+// archiveLog adds the correlation ID of an archival and its post ID to every log line, so the
+// full lifecycle of a URL can be found in the server log among the lines of concurrent archivals
+type archiveLog struct {
+	api       plugin.API
+	archiveID string
+	postID    string
+}
+
+// newArchiveLog creates the logger of a new archival, with a new correlation ID
+func newArchiveLog(api plugin.API, postID string) *archiveLog {
+	return &archiveLog{
+		api:       api,
+		archiveID: model.NewId()[:archiveIDLength],
+		postID:    postID,
+	}
+}
+
+// fields prepends the archival's fields to the key-value pairs of a log line
+func (l *archiveLog) fields(keyValuePairs []any) []any {
+	return append([]any{"archiveID", l.archiveID, "postID", l.postID}, keyValuePairs...)
+}
+
+// LogDebug logs a debug message with the archival's fields
+func (l *archiveLog) LogDebug(msg string, keyValuePairs ...any) {
+	l.api.LogDebug(msg, l.fields(keyValuePairs)...)
+}
+
+// LogInfo logs an info message with the archival's fields
+func (l *archiveLog) LogInfo(msg string, keyValuePairs ...any) {
+	l.api.LogInfo(msg, l.fields(keyValuePairs)...)
+}
+
+// LogWarn logs a warning with the archival's fields
+func (l *archiveLog) LogWarn(msg string, keyValuePairs ...any) {
+	l.api.LogWarn(msg, l.fields(keyValuePairs)...)
+}
+
+// LogError logs an error with the archival's fields
+func (l *archiveLog) LogError(msg string, keyValuePairs ...any) {
+	l.api.LogError(msg, l.fields(keyValuePairs)...)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveLog(t *testing.T) {
+	api := &plugintest.API{}
+	log := newArchiveLog(api, "post1")
+	assert.Len(t, log.archiveID, archiveIDLength)
+	assert.NotEqual(t, log.archiveID, newArchiveLog(api, "post1").archiveID)
+
+	api.On("LogInfo", "Archived", "archiveID", log.archiveID, "postID", "post1", "url", "https://example.com").Once()
+	log.LogInfo("Archived", "url", "https://example.com")
+	api.AssertExpectations(t)
+}
+
+func TestArchiveURLSetsArchiveID(t *testing.T) {
+	processor := setupTestProcessor()
+
+	result := processor.archiveURL("post1", "https://example.com/setup.exe", &configuration{AllowedExtensions: "pdf"})
+	assert.True(t, result.Skipped)
+	assert.Len(t, result.ArchiveID, archiveIDLength)
+}
@@ -0,0 +1,51 @@
+package archiver
+
+import (
+	"net/http"
+	"sync"
+)
+
+// AcceptLanguage holds the Accept-Language header sent with requests, so localized sites are
+// archived in the intended language instead of the server's default
+type AcceptLanguage struct {
+	lock  sync.RWMutex
+	value string
+}
+
+// Set replaces the configured Accept-Language, empty to not send the header
+func (a *AcceptLanguage) Set(value string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.value = value
+}
+
+// Get returns the Accept-Language for a request, the override of the archival rule if set
+func (a *AcceptLanguage) Get(override string) string {
+	if override != "" {
+		return override
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.value
+}
+
+// Apply sets the Accept-Language header of the request, if any is configured or overridden
+func (a *AcceptLanguage) Apply(req *http.Request, override string) {
+	if value := a.Get(override); value != "" {
+		req.Header.Set("Accept-Language", value)
+	}
+}
+
+// acceptLanguageTransport sets the Accept-Language header of the requests of tools that don't set
+// request headers themselves, like obelisk
+type acceptLanguageTransport struct {
+	next  http.RoundTripper
+	value string
+}
+
+// RoundTrip implements http.RoundTripper, on a copy of the request as round trippers must not modify it
+func (t *acceptLanguageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Language", t.value)
+	return t.next.RoundTrip(req)
+}
@@ -1,11 +1,24 @@
 package archiver
 
+import "time"
+
+// MaxFileSizeHardLimit is the absolute maximum size of an archived file (1GB).
+// Per-rule size limits can raise a tool's default limit, but never above this one.
+const MaxFileSizeHardLimit = 1024 * 1024 * 1024
+
 // ArchivedFile represents a file that has been archived
 type ArchivedFile struct {
 	Filename string
 	Data     []byte
 	MimeType string
 	Size     int64
+	// ResponseHeaders holds the response headers recorded by the tool, see CapturedResponseHeaders
+	ResponseHeaders map[string]string
+	// StatusCode is the HTTP status the URL answered with, after redirects. Zero if the tool doesn't
+	// report it.
+	StatusCode int
+	// Duration is the time the tool took to fetch the URL. Zero if the tool doesn't report it.
+	Duration time.Duration
 }
 
 // ArchivalTool is the interface for archival tools
@@ -13,3 +26,85 @@ type ArchivalTool interface {
 	Archive(url string, mimeType string) (*ArchivedFile, error)
 	Name() string
 }
+
+// MultiArchivalTool is implemented by archival tools producing several files for a URL, like a video
+// and its thumbnail. The first file is the main archive of the URL, compared with previous captures
+// for deduplication, and the others are stored and attached along with it.
+type MultiArchivalTool interface {
+	ArchivalTool
+	ArchiveMulti(url string, mimeType string) ([]*ArchivedFile, error)
+}
+
+// MultiOptionsArchivalTool is implemented by tools producing several files that honor per-archive
+// options, like OptionsArchivalTool for single files. The size limit applies to each file.
+type MultiOptionsArchivalTool interface {
+	MultiArchivalTool
+	ArchiveMultiWithOptions(url string, mimeType string, options ArchiveOptions) ([]*ArchivedFile, error)
+}
+
+// ToolDescriptor describes an archival tool, its capabilities and its current limits, for
+// administration and validation of the tools chosen by rules
+type ToolDescriptor struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+	// MimeTypes are the patterns of the MIME types the tool archives, like "image/*", empty if it
+	// archives any content
+	MimeTypes []string `json:"mimeTypes,omitempty"`
+	// TimeoutSeconds is the time the tool has to archive a URL, zero if it has no timeout of its own
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// MaxBytes is the default size limit of the content the tool downloads or produces, zero if none
+	MaxBytes int64 `json:"maxBytes"`
+	// MultiFile is set for tools producing several files for a URL, see MultiArchivalTool
+	MultiFile bool `json:"multiFile"`
+	// Expands is set for tools archiving the links found in a URL, see ExpandingArchivalTool
+	Expands bool `json:"expands"`
+	// RequiresNetwork is set for tools downloading the URL, as opposed to archiving content already
+	// in the message
+	RequiresNetwork bool `json:"requiresNetwork"`
+	// RequiresExternalService is set for tools relying on a program or service outside the plugin,
+	// like a headless browser, that must be installed and configured
+	RequiresExternalService bool `json:"requiresExternalService"`
+}
+
+// DescribedArchivalTool is implemented by archival tools describing themselves and their limits
+type DescribedArchivalTool interface {
+	ArchivalTool
+	Describe() ToolDescriptor
+}
+
+// ArchiveOptions holds per-archive settings taken from the archival rule that selected the tool,
+// and from the host of the URL
+type ArchiveOptions struct {
+	// MaxBytes overrides the tool's maximum file size when positive
+	MaxBytes int64
+	// AcceptLanguage overrides the tool's Accept-Language header when set
+	AcceptLanguage string
+	// Timeout overrides the tool's timeout when positive
+	Timeout time.Duration
+}
+
+// ArchiveTimeout returns the timeout to enforce, given the tool's timeout
+func (o ArchiveOptions) ArchiveTimeout(toolTimeout time.Duration) time.Duration {
+	if o.Timeout <= 0 {
+		return toolTimeout
+	}
+	return o.Timeout
+}
+
+// MaxFileSize returns the size limit to enforce, given the tool's default limit
+func (o ArchiveOptions) MaxFileSize(toolDefault int64) int64 {
+	if o.MaxBytes <= 0 {
+		return toolDefault
+	}
+	if o.MaxBytes > MaxFileSizeHardLimit {
+		return MaxFileSizeHardLimit
+	}
+	return o.MaxBytes
+}
+
+// OptionsArchivalTool is implemented by archival tools that honor per-archive options while archiving
+type OptionsArchivalTool interface {
+	ArchivalTool
+	ArchiveWithOptions(url string, mimeType string, options ArchiveOptions) (*ArchivedFile, error)
+}
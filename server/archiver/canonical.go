@@ -0,0 +1,86 @@
+package archiver
+
+import (
+	"bytes"
+	nurl "net/url"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// ExtractCanonicalURL returns the absolute URL of the page's <link rel="canonical">, resolved
+// against the URL the page was fetched from. Returns an empty string if there's none or it isn't a web URL.
+func ExtractCanonicalURL(page []byte, pageURL string) string {
+	return extractHeadLink(page, pageURL, "canonical")
+}
+
+// extractHeadLink returns the absolute URL of the first <link> of the page's head whose rel holds
+// the token, resolved against the URL the page was fetched from. Returns an empty string if there's
+// none or it isn't a web URL.
+func extractHeadLink(page []byte, pageURL, rel string) string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				// Links must be in the head
+				return ""
+			case "link":
+				linkRel, href := "", ""
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "rel":
+						linkRel = strings.ToLower(attr.Val)
+					case "href":
+						href = strings.TrimSpace(attr.Val)
+					}
+				}
+				if href == "" || !containsToken(linkRel, rel) {
+					continue
+				}
+
+				linkURL, err := resolveReference(pageURL, href)
+				if err != nil {
+					return ""
+				}
+				if parsedURL, err := nurl.Parse(linkURL); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+					return ""
+				}
+				return linkURL
+			}
+		case xhtml.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return ""
+			}
+		}
+	}
+}
+
+// SameURL checks if two URLs point to the same resource, ignoring the fragment,
+// the case of the scheme and host, and a trailing slash in the path
+func SameURL(a, b string) bool {
+	urlA, errA := nurl.Parse(a)
+	urlB, errB := nurl.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return strings.EqualFold(urlA.Scheme, urlB.Scheme) &&
+		strings.EqualFold(urlA.Host, urlB.Host) &&
+		strings.TrimSuffix(urlA.EscapedPath(), "/") == strings.TrimSuffix(urlB.EscapedPath(), "/") &&
+		urlA.RawQuery == urlB.RawQuery
+}
+
+// containsToken checks if a space-separated list, like the rel attribute, contains a token
+func containsToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
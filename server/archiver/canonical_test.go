@@ -0,0 +1,56 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     string
+		expected string
+	}{
+		{
+			name:     "absolute canonical",
+			page:     `<html><head><link rel="canonical" href="https://example.com/article"></head><body></body></html>`,
+			expected: "https://example.com/article",
+		},
+		{
+			name:     "relative canonical",
+			page:     `<html><head><link rel="amphtml" href="/amp/article"><LINK REL="Canonical" HREF="../article" /></head></html>`,
+			expected: "https://m.example.com/news/article",
+		},
+		{
+			name:     "rel with several tokens",
+			page:     `<head><link rel="alternate canonical" href="https://example.com/a"></head>`,
+			expected: "https://example.com/a",
+		},
+		{
+			name: "canonical outside the head is ignored",
+			page: `<html><head></head><body><link rel="canonical" href="https://example.com/a"></body></html>`,
+		},
+		{
+			name: "non web canonical is ignored",
+			page: `<head><link rel="canonical" href="javascript:alert(1)"></head>`,
+		},
+		{
+			name: "no canonical",
+			page: `<html><head><title>Page</title></head></html>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ExtractCanonicalURL([]byte(tt.page), "https://m.example.com/news/amp/page"))
+		})
+	}
+}
+
+func TestSameURL(t *testing.T) {
+	assert.True(t, SameURL("https://example.com/a", "https://EXAMPLE.com/a/"))
+	assert.True(t, SameURL("https://example.com/a#section", "https://example.com/a"))
+	assert.False(t, SameURL("https://example.com/a", "https://example.com/a?amp=1"))
+	assert.False(t, SameURL("https://example.com/a", "https://www.example.com/a"))
+}
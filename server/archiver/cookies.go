@@ -0,0 +1,51 @@
+package archiver
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HostCookie is a cookie string sent to the hosts matching a pattern
+type HostCookie struct {
+	// Pattern is a hostname, or a wildcard like *.example.com also matching example.com
+	Pattern string
+	// Cookie is the value of the Cookie header, e.g. "session=abc; csrf=def"
+	Cookie string
+}
+
+// HostCookies holds the cookies attached to requests by host, for sites requiring a session.
+// Cookie values are secrets and must never be logged.
+type HostCookies struct {
+	lock    sync.RWMutex
+	cookies []HostCookie
+}
+
+// Set replaces the configured cookies
+func (h *HostCookies) Set(cookies []HostCookie) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.cookies = cookies
+}
+
+// CookieFor returns the cookie string for a hostname, the first matching pattern wins
+func (h *HostCookies) CookieFor(hostname string) string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	hostname = strings.ToLower(hostname)
+	for _, cookie := range h.cookies {
+		if HostnameMatches(hostname, cookie.Pattern) {
+			return cookie.Cookie
+		}
+	}
+	return ""
+}
+
+// Apply sets the Cookie header of the request if cookies are configured for its host.
+// The HTTP client only forwards the header on redirects to the same domain or its subdomains.
+func (h *HostCookies) Apply(req *http.Request) {
+	if cookie := h.CookieFor(req.URL.Hostname()); cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+}
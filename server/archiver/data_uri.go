@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"encoding/base64"
+	"mime"
+	nurl "net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DataURIToolName is the tool recorded for files decoded from data URIs
+	DataURIToolName = "data_uri"
+	// DataURIMaxSize is the maximum size of a file decoded from a data URI (1MB)
+	DataURIMaxSize = 1024 * 1024
+)
+
+// IsDataURI checks if a link is a data URI, like data:image/png;base64,...
+func IsDataURI(link string) bool {
+	return len(link) > len("data:") && strings.EqualFold(link[:len("data:")], "data:")
+}
+
+// DecodeDataURI decodes the file held by a data URI, without any network request. The MIME type
+// is taken from the URI, text/plain if it has none, and the data is base64 or percent-encoded.
+// Fails if the decoded data is larger than maxSize.
+func DecodeDataURI(uri string, maxSize int64) (*ArchivedFile, error) {
+	if !IsDataURI(uri) {
+		return nil, errors.New("not a data URI")
+	}
+	header, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return nil, errors.New("invalid data URI: missing data")
+	}
+
+	params := strings.Split(header, ";")
+	isBase64 := len(params) > 1 && strings.EqualFold(strings.TrimSpace(params[len(params)-1]), "base64")
+	if isBase64 {
+		params = params[:len(params)-1]
+	}
+	mimeType := "text/plain"
+	if strings.TrimSpace(params[0]) != "" {
+		mediaType, _, err := mime.ParseMediaType(strings.Join(params, ";"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid data URI media type")
+		}
+		mimeType = mediaType
+	}
+
+	var data []byte
+	var err error
+	if isBase64 {
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			// Padding is often left out
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+	} else {
+		var text string
+		text, err = nurl.PathUnescape(payload)
+		data = []byte(text)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode data URI")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("data URI is empty")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errors.Errorf("data URI size %d exceeds maximum allowed size %d", len(data), maxSize)
+	}
+
+	return &ArchivedFile{
+		Filename: "data" + GetFileExtension(mimeType),
+		Data:     data,
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+	}, nil
+}
+
+// DataURIHeader returns the data URI without its data, like data:image/png;base64, for logs and
+// replies. Returns the link unchanged if it isn't a data URI.
+func DataURIHeader(link string) string {
+	if !IsDataURI(link) {
+		return link
+	}
+	header, _, _ := strings.Cut(link, ",")
+	return header + ",…"
+}
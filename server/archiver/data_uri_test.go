@@ -0,0 +1,56 @@
+package archiver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	t.Run("base64 data", func(t *testing.T) {
+		file, err := DecodeDataURI("data:image/png;base64,iVBORw0KGgo=", DataURIMaxSize)
+		require.NoError(t, err)
+		assert.Equal(t, "data.png", file.Filename)
+		assert.Equal(t, "image/png", file.MimeType)
+		assert.Equal(t, "\x89PNG\r\n\x1a\n", string(file.Data))
+		assert.Equal(t, int64(8), file.Size)
+	})
+
+	t.Run("unpadded base64 data", func(t *testing.T) {
+		file, err := DecodeDataURI("DATA:text/plain;charset=utf-8;BASE64,aGVsbG8", DataURIMaxSize)
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", file.MimeType)
+		assert.Equal(t, "hello", string(file.Data))
+	})
+
+	t.Run("percent-encoded data without media type", func(t *testing.T) {
+		file, err := DecodeDataURI("data:,Hello%2C%20World!", DataURIMaxSize)
+		require.NoError(t, err)
+		assert.Equal(t, "data.txt", file.Filename)
+		assert.Equal(t, "text/plain", file.MimeType)
+		assert.Equal(t, "Hello, World!", string(file.Data))
+	})
+
+	t.Run("size is capped on the decoded data", func(t *testing.T) {
+		_, err := DecodeDataURI("data:text/plain,"+strings.Repeat("a", 11), 10)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size 10")
+
+		_, err = DecodeDataURI("data:text/plain;base64,YWFhYWFhYWFhYQ==", 10)
+		assert.NoError(t, err, "the base64 encoding doesn't count")
+	})
+
+	t.Run("invalid URIs are rejected", func(t *testing.T) {
+		for _, uri := range []string{"https://example.com/", "data:image/png;base64", "data:image/png;base64,!!!", "data:text/plain,", "data:a b/c,x"} {
+			_, err := DecodeDataURI(uri, DataURIMaxSize)
+			assert.Error(t, err, uri)
+		}
+	})
+}
+
+func TestDataURIHeader(t *testing.T) {
+	assert.Equal(t, "data:image/png;base64,…", DataURIHeader("data:image/png;base64,iVBORw0KGgo="))
+	assert.Equal(t, "https://example.com/a,b", DataURIHeader("https://example.com/a,b"))
+}
@@ -1,9 +1,15 @@
 package archiver
 
 import (
+	"context"
 	"io"
 	"net/http"
+	nurl "net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -12,16 +18,50 @@ import (
 const (
 	// DirectDownloadToolName is the name of the direct download archival tool
 	DirectDownloadToolName = "direct_download"
-	// DefaultTimeout is the default timeout for downloads
+	// DefaultTimeout is the default timeout for connecting and receiving the response headers of downloads
 	DefaultTimeout = 30 * time.Second
+	// DefaultStallTimeout is the default time a download can go without receiving data before it's aborted
+	DefaultStallTimeout = 30 * time.Second
 	// MaxFileSize is the maximum file size to download (100MB)
 	MaxFileSize = 100 * 1024 * 1024
 )
 
+// ResponseStatusHeader is the key of the final status code of the response in the captured headers
+const ResponseStatusHeader = "Status"
+
+// CapturedResponseHeaders are the response headers recorded with downloads when capturing headers,
+// for provenance. Headers holding credentials, like Set-Cookie, are never recorded.
+var CapturedResponseHeaders = []string{"Content-Type", "Content-Length", "Last-Modified", "ETag", "Server"}
+
+// filenameQueryKeys are the query parameters commonly holding the filename of a download
+var filenameQueryKeys = []string{"file", "filename", "name"}
+
 // DirectDownload implements the ArchivalTool interface for direct file downloads
 type DirectDownload struct {
-	client  *http.Client
-	timeout time.Duration
+	client *http.Client
+
+	// The fetch timeout covers connecting and receiving the headers, and the stall timeout the time
+	// between reads of the body, so large downloads can take as long as they keep making progress
+	timeoutsLock sync.RWMutex
+	timeout      time.Duration
+	stallTimeout time.Duration
+
+	queryFilenameLock sync.RWMutex
+	queryFilename     bool
+
+	// captureHeaders records the response headers with the downloaded files
+	captureHeaders atomic.Bool
+
+	// cookies are attached to downloads from hosts requiring a session
+	cookies HostCookies
+	// userAgents selects the User-Agent sent to each host
+	userAgents UserAgents
+	// statusActions selects the unsuccessful status codes that are retried
+	statusActions StatusActions
+	// redirects bounds the redirects followed by downloads
+	redirects *RedirectLimit
+	// acceptLanguage is sent with downloads, unless overridden by the archival rule
+	acceptLanguage AcceptLanguage
 }
 
 // NewDirectDownload creates a new direct download archival tool
@@ -30,65 +70,182 @@ func NewDirectDownload(timeout time.Duration) *DirectDownload {
 		timeout = DefaultTimeout
 	}
 
+	redirects := &RedirectLimit{}
 	return &DirectDownload{
 		client: &http.Client{
-			Timeout: timeout,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				// Follow redirects
-				return nil
-			},
+			CheckRedirect: redirects.CheckRedirect,
 		},
-		timeout: timeout,
+		timeout:      timeout,
+		stallTimeout: DefaultStallTimeout,
+		redirects:    redirects,
 	}
 }
 
+// SetTimeouts sets the timeout for connecting and receiving the response headers, and the
+// time a download can go without receiving data. Zero values use the defaults.
+func (d *DirectDownload) SetTimeouts(timeout, stallTimeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if stallTimeout <= 0 {
+		stallTimeout = DefaultStallTimeout
+	}
+
+	d.timeoutsLock.Lock()
+	defer d.timeoutsLock.Unlock()
+	d.timeout = timeout
+	d.stallTimeout = stallTimeout
+}
+
+// getTimeouts returns the fetch and stall timeouts
+func (d *DirectDownload) getTimeouts() (timeout, stallTimeout time.Duration) {
+	d.timeoutsLock.RLock()
+	defer d.timeoutsLock.RUnlock()
+	return d.timeout, d.stallTimeout
+}
+
 // Name returns the name of this archival tool
 func (d *DirectDownload) Name() string {
 	return DirectDownloadToolName
 }
 
+// Describe describes the tool and its current timeout
+func (d *DirectDownload) Describe() ToolDescriptor {
+	timeout, _ := d.getTimeouts()
+	return ToolDescriptor{
+		Name:            DirectDownloadToolName,
+		DisplayName:     "Direct download",
+		Description:     "Downloads the file served at the URL as is",
+		TimeoutSeconds:  int(timeout.Seconds()),
+		MaxBytes:        MaxFileSize,
+		RequiresNetwork: true,
+	}
+}
+
+// SetQueryFilename sets whether the filename is looked up in the query string
+// when the last path segment of the URL has no extension
+func (d *DirectDownload) SetQueryFilename(enabled bool) {
+	d.queryFilenameLock.Lock()
+	defer d.queryFilenameLock.Unlock()
+	d.queryFilename = enabled
+}
+
+// getQueryFilename returns whether the filename is looked up in the query string
+func (d *DirectDownload) getQueryFilename() bool {
+	d.queryFilenameLock.RLock()
+	defer d.queryFilenameLock.RUnlock()
+	return d.queryFilename
+}
+
+// SetCaptureHeaders sets whether the response headers are recorded with downloaded files
+func (d *DirectDownload) SetCaptureHeaders(enabled bool) {
+	d.captureHeaders.Store(enabled)
+}
+
+// SetHostCookies sets the cookies attached to downloads by host
+func (d *DirectDownload) SetHostCookies(cookies []HostCookie) {
+	d.cookies.Set(cookies)
+}
+
+// SetAcceptLanguage sets the Accept-Language header sent with downloads, empty to not send it
+func (d *DirectDownload) SetAcceptLanguage(value string) {
+	d.acceptLanguage.Set(value)
+}
+
+// SetMaxRedirects sets the number of redirects followed by downloads, zero or less uses DefaultMaxRedirects
+func (d *DirectDownload) SetMaxRedirects(maxRedirects int) {
+	d.redirects.Set(maxRedirects)
+}
+
+// SetUserAgents sets the default User-Agent of downloads and its per-host overrides
+func (d *DirectDownload) SetUserAgents(defaultUserAgent string, overrides []HostUserAgent) {
+	d.userAgents.Set(defaultUserAgent, overrides)
+}
+
+// SetStatusActions sets the actions taken for unsuccessful HTTP status codes
+func (d *DirectDownload) SetStatusActions(rules []StatusRule) {
+	d.statusActions.Set(rules)
+}
+
 // Archive downloads a file from the given URL
 func (d *DirectDownload) Archive(url, mimeType string) (*ArchivedFile, error) {
-	req, err := http.NewRequest("GET", url, http.NoBody)
+	return d.ArchiveWithOptions(url, mimeType, ArchiveOptions{})
+}
+
+// ArchiveWithOptions downloads a file from the given URL, enforcing the size limit and sending the
+// Accept-Language of the options. The timeout of the options replaces both the response and the
+// stall timeouts.
+func (d *DirectDownload) ArchiveWithOptions(url, mimeType string, options ArchiveOptions) (*ArchivedFile, error) {
+	maxFileSize := options.MaxFileSize(MaxFileSize)
+	start := time.Now()
+
+	timeout, stallTimeout := d.getTimeouts()
+	if options.Timeout > 0 {
+		timeout, stallTimeout = options.Timeout, options.Timeout
+	}
+
+	// Abort the request if the headers don't arrive in time, the deadline is lifted once they do
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, http.NoBody)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create GET request")
 	}
 
-	// Set a reasonable User-Agent
-	req.Header.Set("User-Agent", "Mattermost-Link-Archiver-Plugin/1.0")
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, options.AcceptLanguage)
 
-	resp, err := d.client.Do(req)
+	// Retries of status codes configured to be retried count towards the timeout
+	resp, err := d.statusActions.Do(d.client, req)
 	if err != nil {
+		if timedOut.Load() {
+			return nil, errors.Errorf("timeout while waiting for a response after %s", timeout)
+		}
 		return nil, errors.Wrap(err, "failed to download file")
 	}
 	defer resp.Body.Close()
 
+	// From now on only abort if the download stalls, the timer is reset every time data is received
+	if !timer.Stop() {
+		return nil, errors.Errorf("timeout while waiting for a response after %s", timeout)
+	}
+	timer.Reset(stallTimeout)
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.Errorf("download failed with status %d", resp.StatusCode)
+		return nil, NewStatusError(resp.StatusCode, "download failed with status %d")
 	}
 
 	// Check Content-Length if available
-	if resp.ContentLength > MaxFileSize {
-		return nil, errors.Errorf("file size %d exceeds maximum allowed size %d", resp.ContentLength, MaxFileSize)
+	if resp.ContentLength > maxFileSize {
+		return nil, errors.Errorf("file size %d exceeds maximum allowed size %d", resp.ContentLength, maxFileSize)
 	}
 
 	// Limit reader to prevent downloading files that are too large
-	limitedReader := io.LimitReader(resp.Body, MaxFileSize+1)
+	limitedReader := io.LimitReader(&progressReader{reader: resp.Body, timer: timer, timeout: stallTimeout}, maxFileSize+1)
 
 	// Read the file data
 	data, err := io.ReadAll(limitedReader)
 	if err != nil {
+		if timedOut.Load() {
+			return nil, errors.Errorf("timeout while downloading file, no data received for %s", stallTimeout)
+		}
 		return nil, errors.Wrap(err, "failed to read file data")
 	}
 
 	// Check if we hit the limit
-	if int64(len(data)) > MaxFileSize {
-		return nil, errors.Errorf("file size exceeds maximum allowed size %d", MaxFileSize)
+	if int64(len(data)) > maxFileSize {
+		return nil, errors.Errorf("file size exceeds maximum allowed size %d", maxFileSize)
 	}
 
-	// Determine filename from URL or Content-Disposition header
-	filename := d.extractFilename(url, resp.Header.Get("Content-Disposition"))
-
 	// Use MIME type from response if available, otherwise use the provided one
 	if respMimeType := resp.Header.Get("Content-Type"); respMimeType != "" {
 		// Remove charset and other parameters
@@ -96,16 +253,75 @@ func (d *DirectDownload) Archive(url, mimeType string) (*ArchivedFile, error) {
 		mimeType = strings.TrimSpace(parts[0])
 	}
 
-	return &ArchivedFile{
-		Filename: filename,
-		Data:     data,
-		MimeType: mimeType,
-		Size:     int64(len(data)),
-	}, nil
+	// Servers often label files as generic binary data, sniff the actual type from the file signature
+	if isGenericMimeType(mimeType) {
+		mimeType = detectMimeType(data)
+	}
+
+	// Determine filename from URL or Content-Disposition header
+	filename := d.extractFilename(url, resp.Header.Get("Content-Disposition"), mimeType)
+
+	archivedFile := &ArchivedFile{
+		Filename:   filename,
+		Data:       data,
+		MimeType:   mimeType,
+		Size:       int64(len(data)),
+		StatusCode: resp.StatusCode,
+		Duration:   time.Since(start),
+	}
+	if d.captureHeaders.Load() {
+		archivedFile.ResponseHeaders = captureResponseHeaders(resp)
+	}
+	return archivedFile, nil
+}
+
+// captureResponseHeaders returns the captured headers of a response along with its status code
+func captureResponseHeaders(resp *http.Response) map[string]string {
+	headers := map[string]string{ResponseStatusHeader: strconv.Itoa(resp.StatusCode)}
+	for _, name := range CapturedResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
 }
 
-// extractFilename extracts filename from URL or Content-Disposition header
-func (d *DirectDownload) extractFilename(url, contentDisposition string) string {
+// isGenericMimeType checks if a MIME type doesn't tell what the content is
+func isGenericMimeType(mimeType string) bool {
+	switch strings.ToLower(mimeType) {
+	case "", "application/octet-stream", "binary/octet-stream", "application/binary", "application/unknown":
+		return true
+	default:
+		return false
+	}
+}
+
+// detectMimeType detects the MIME type of data from its first bytes, without parameters.
+// Unknown content is detected as application/octet-stream.
+func detectMimeType(data []byte) string {
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return strings.TrimSpace(mimeType)
+}
+
+// progressReader pushes back a timer's deadline every time data is read
+type progressReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// Read reads from the underlying reader, resetting the timer if data was received
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// extractFilename extracts filename from URL or Content-Disposition header.
+// Filenames taken from the URL without an extension get one inferred from the MIME type.
+func (d *DirectDownload) extractFilename(url, contentDisposition, mimeType string) string {
 	// Try Content-Disposition header first
 	if contentDisposition != "" {
 		// Parse "attachment; filename=example.pdf" or "attachment; filename*=UTF-8''example.pdf"
@@ -115,7 +331,7 @@ func (d *DirectDownload) extractFilename(url, contentDisposition string) string
 			if strings.HasPrefix(part, "filename=") {
 				filename := strings.TrimPrefix(part, "filename=")
 				filename = strings.Trim(filename, `"`)
-				if filename != "" {
+				if filename = sanitizeDownloadFilename(filename); filename != "" {
 					return filename
 				}
 			} else if strings.HasPrefix(part, "filename*=") {
@@ -123,27 +339,84 @@ func (d *DirectDownload) extractFilename(url, contentDisposition string) string
 				filename := strings.TrimPrefix(part, "filename*=")
 				parts := strings.SplitN(filename, "''", 2)
 				if len(parts) == 2 {
-					return parts[1]
+					if filename = sanitizeDownloadFilename(parts[1]); filename != "" {
+						return filename
+					}
 				}
 			}
 		}
 	}
 
 	// Fallback to extracting from URL
-	urlParts := strings.Split(url, "/")
-	if len(urlParts) > 0 {
-		lastPart := urlParts[len(urlParts)-1]
-		// Remove query parameters
-		if idx := strings.Index(lastPart, "?"); idx != -1 {
-			lastPart = lastPart[:idx]
+	filename := ""
+	if parsedURL, err := nurl.Parse(url); err == nil {
+		filename = path.Base(parsedURL.Path)
+		if filename == "." || filename == "/" {
+			filename = ""
 		}
-		if lastPart != "" {
-			return lastPart
+
+		// Download endpoints often take the filename as a query parameter, e.g. download?file=report.pdf
+		if path.Ext(filename) == "" && d.getQueryFilename() {
+			if queryFilename := extractQueryFilename(parsedURL.Query()); queryFilename != "" {
+				filename = queryFilename
+			}
 		}
 	}
 
-	// Default filename based on extension or generic name
-	return "downloaded_file"
+	if filename = sanitizeDownloadFilename(filename); filename == "" {
+		// Default filename based on extension or generic name
+		filename = "downloaded_file"
+	}
+	if path.Ext(filename) == "" {
+		filename += GetFileExtension(mimeType)
+	}
+
+	return filename
+}
+
+// extractQueryFilename returns the first filename with an extension found in the common filename query parameters
+func extractQueryFilename(query nurl.Values) string {
+	for _, key := range filenameQueryKeys {
+		for _, value := range query[key] {
+			// Values may be paths, only keep the last element
+			name := path.Base(strings.ReplaceAll(value, "\\", "/"))
+			if name != "." && name != "/" && path.Ext(name) != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// sanitizeDownloadFilename sanitizes a filename and bounds its length, keeping its extension
+func sanitizeDownloadFilename(filename string) string {
+	ext := path.Ext(filename)
+	if !isPlainExtension(ext) {
+		ext = ""
+	}
+
+	name := sanitizeFilename(strings.TrimSuffix(filename, ext))
+	if name == "" {
+		return ""
+	}
+	if runes := []rune(name); len(runes)+len(ext) > maxFilenameLength {
+		name = strings.TrimSpace(string(runes[:maxFilenameLength-len(ext)]))
+	}
+
+	return name + ext
+}
+
+// isPlainExtension checks if an extension is short and only made of letters and digits
+func isPlainExtension(ext string) bool {
+	if len(ext) < 2 || len(ext) > 10 {
+		return false
+	}
+	for _, r := range ext[1:] {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
 }
 
 // GetFileExtension returns the file extension for a given MIME type
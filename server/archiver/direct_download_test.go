@@ -0,0 +1,510 @@
+package archiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectDownloadMaxBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte(strings.Repeat("a", 1024)))
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	t.Run("within the rule limit", func(t *testing.T) {
+		file, err := tool.ArchiveWithOptions(server.URL+"/file.bin", "", ArchiveOptions{MaxBytes: 2048})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1024), file.Size)
+	})
+
+	t.Run("over the rule limit", func(t *testing.T) {
+		_, err := tool.ArchiveWithOptions(server.URL+"/file.bin", "", ArchiveOptions{MaxBytes: 512})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size 512")
+	})
+
+	t.Run("no options uses the tool default", func(t *testing.T) {
+		_, err := tool.Archive(server.URL+"/file.bin", "")
+		require.NoError(t, err)
+	})
+}
+
+func TestDirectDownloadStatusAndDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	file, err := NewDirectDownload(0).Archive(server.URL+"/hello.txt", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNonAuthoritativeInfo, file.StatusCode)
+	assert.Positive(t, file.Duration)
+}
+
+func TestDirectDownloadDetectsGenericMimeType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/generic":
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case "/missing":
+			// Keep the server from sniffing the type itself
+			w.Header()["Content-Type"] = nil
+		case "/labeled":
+			w.Header().Set("Content-Type", "image/x-custom")
+		}
+		_, _ = w.Write(png)
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	tests := []struct {
+		path     string
+		mimeType string
+		filename string
+	}{
+		{"/generic", "image/png", "generic.png"},
+		{"/missing", "image/png", "missing.png"},
+		{"/labeled", "image/x-custom", "labeled.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			file, err := tool.Archive(server.URL+tt.path, "")
+			require.NoError(t, err)
+			assert.Equal(t, tt.mimeType, file.MimeType)
+			assert.Equal(t, tt.filename, file.Filename)
+		})
+	}
+
+	t.Run("unknown content stays generic", func(t *testing.T) {
+		assert.Equal(t, "application/octet-stream", detectMimeType([]byte{0x00, 0x01, 0x02, 0xff}))
+		assert.Equal(t, "text/plain", detectMimeType([]byte("plain text")))
+	})
+}
+
+func TestArchiveOptionsMaxFileSize(t *testing.T) {
+	assert.Equal(t, int64(100), ArchiveOptions{}.MaxFileSize(100))
+	assert.Equal(t, int64(100), ArchiveOptions{MaxBytes: -1}.MaxFileSize(100))
+	assert.Equal(t, int64(500), ArchiveOptions{MaxBytes: 500}.MaxFileSize(100), "rule limit can raise the tool default")
+	assert.Equal(t, int64(MaxFileSizeHardLimit), ArchiveOptions{MaxBytes: MaxFileSizeHardLimit * 2}.MaxFileSize(100), "rule limit is capped by the hard limit")
+}
+
+func TestArchiveOptionsArchiveTimeout(t *testing.T) {
+	assert.Equal(t, time.Minute, ArchiveOptions{}.ArchiveTimeout(time.Minute))
+	assert.Equal(t, time.Minute, ArchiveOptions{Timeout: -time.Second}.ArchiveTimeout(time.Minute))
+	assert.Equal(t, 10*time.Minute, ArchiveOptions{Timeout: 10 * time.Minute}.ArchiveTimeout(time.Minute))
+}
+
+func TestDirectDownloadExtractFilename(t *testing.T) {
+	tool := NewDirectDownload(0)
+
+	tests := []struct {
+		name               string
+		url                string
+		contentDisposition string
+		mimeType           string
+		queryFilename      bool
+		expected           string
+	}{
+		{
+			name:     "path filename",
+			url:      "https://example.com/files/report.pdf?token=abc",
+			expected: "report.pdf",
+		},
+		{
+			name:               "content disposition takes precedence",
+			url:                "https://example.com/download?file=report.pdf",
+			contentDisposition: `attachment; filename="invoice.pdf"`,
+			queryFilename:      true,
+			expected:           "invoice.pdf",
+		},
+		{
+			name:               "content disposition is sanitized",
+			url:                "https://example.com/download",
+			contentDisposition: `attachment; filename="../../etc/passwd.txt"`,
+			expected:           "_.._etc_passwd.txt",
+		},
+		{
+			name:          "query filename",
+			url:           "https://example.com/download?file=report.pdf",
+			queryFilename: true,
+			expected:      "report.pdf",
+		},
+		{
+			name:          "query filename from path value",
+			url:           "https://example.com/get?filename=docs%2F2024%2Fslides.pptx",
+			queryFilename: true,
+			expected:      "slides.pptx",
+		},
+		{
+			name:          "query name key",
+			url:           "https://example.com/get?id=1&name=photo.png",
+			queryFilename: true,
+			expected:      "photo.png",
+		},
+		{
+			name:          "query value without extension is ignored",
+			url:           "https://example.com/download?name=report",
+			mimeType:      "application/pdf",
+			queryFilename: true,
+			expected:      "download.pdf",
+		},
+		{
+			name:          "path with extension wins over query",
+			url:           "https://example.com/archive.zip?file=other.pdf",
+			queryFilename: true,
+			expected:      "archive.zip",
+		},
+		{
+			name:     "query disabled infers extension from MIME type",
+			url:      "https://example.com/download?file=report.pdf",
+			mimeType: "application/pdf",
+			expected: "download.pdf",
+		},
+		{
+			name:          "query filename is sanitized",
+			url:           "https://example.com/download?file=" + "a%3Cb%3E.pdf",
+			queryFilename: true,
+			expected:      "a_b_.pdf",
+		},
+		{
+			name:          "long query filename keeps its extension",
+			url:           "https://example.com/download?file=" + strings.Repeat("x", 200) + ".pdf",
+			queryFilename: true,
+			expected:      strings.Repeat("x", maxFilenameLength-4) + ".pdf",
+		},
+		{
+			name:     "no path uses default filename",
+			url:      "https://example.com/",
+			mimeType: "image/png",
+			expected: "downloaded_file.png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool.SetQueryFilename(tt.queryFilename)
+			assert.Equal(t, tt.expected, tool.extractFilename(tt.url, tt.contentDisposition, tt.mimeType))
+		})
+	}
+}
+
+func TestDirectDownloadHostCookies(t *testing.T) {
+	var receivedCookie string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedCookie = r.Header.Get("Cookie")
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	tool.SetHostCookies([]HostCookie{{Pattern: "other.example.com", Cookie: "session=other"}, {Pattern: "127.0.0.1", Cookie: "session=abc"}})
+	_, err := tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc", receivedCookie)
+
+	tool.SetHostCookies(nil)
+	_, err = tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Empty(t, receivedCookie)
+}
+
+func TestDirectDownloadCaptureHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Set("Server", "test")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Internal", "value")
+		_, _ = w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	// Headers aren't recorded by default
+	archivedFile, err := tool.Archive(server.URL+"/file.pdf", "")
+	require.NoError(t, err)
+	assert.Nil(t, archivedFile.ResponseHeaders)
+
+	tool.SetCaptureHeaders(true)
+	archivedFile, err = tool.Archive(server.URL+"/file.pdf", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"Status":         "200",
+		"Content-Type":   "application/pdf",
+		"Content-Length": "8",
+		"ETag":           `"v1"`,
+		"Last-Modified":  "Wed, 21 Oct 2026 07:28:00 GMT",
+		"Server":         "test",
+	}, archivedFile.ResponseHeaders, "only the known headers are recorded, never cookies")
+}
+
+func TestHostCookiesCookieFor(t *testing.T) {
+	var cookies HostCookies
+	cookies.Set([]HostCookie{
+		{Pattern: "wiki.example.com", Cookie: "wiki"},
+		{Pattern: "*.example.com", Cookie: "wildcard"},
+	})
+
+	assert.Equal(t, "wiki", cookies.CookieFor("wiki.example.com"))
+	assert.Equal(t, "wiki", cookies.CookieFor("WIKI.example.com"))
+	assert.Equal(t, "wildcard", cookies.CookieFor("docs.example.com"))
+	assert.Equal(t, "wildcard", cookies.CookieFor("example.com"))
+	assert.Empty(t, cookies.CookieFor("example.org"))
+}
+
+func TestDirectDownloadTimeouts(t *testing.T) {
+	t.Run("slow but steady downloads complete", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 5; i++ {
+				_, _ = w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+				time.Sleep(40 * time.Millisecond)
+			}
+		}))
+		defer server.Close()
+
+		tool := NewDirectDownload(0)
+		tool.SetTimeouts(100*time.Millisecond, 100*time.Millisecond)
+
+		file, err := tool.Archive(server.URL+"/file.bin", "")
+		require.NoError(t, err, "the whole download takes longer than both timeouts but keeps making progress")
+		assert.Equal(t, int64(25), file.Size)
+	})
+
+	t.Run("stalled downloads are aborted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}))
+		defer server.Close()
+
+		tool := NewDirectDownload(0)
+		tool.SetTimeouts(time.Second, 100*time.Millisecond)
+
+		_, err := tool.Archive(server.URL+"/file.bin", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no data received for 100ms")
+	})
+
+	t.Run("slow responses are aborted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		}))
+		defer server.Close()
+
+		tool := NewDirectDownload(0)
+		tool.SetTimeouts(100*time.Millisecond, time.Second)
+
+		_, err := tool.Archive(server.URL+"/file.bin", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timeout while waiting for a response after 100ms")
+	})
+
+	t.Run("the timeout of the options replaces both timeouts", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(150 * time.Millisecond)
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(150 * time.Millisecond)
+			_, _ = w.Write([]byte("chunk"))
+		}))
+		defer server.Close()
+
+		tool := NewDirectDownload(0)
+		tool.SetTimeouts(50*time.Millisecond, 50*time.Millisecond)
+
+		_, err := tool.Archive(server.URL+"/file.bin", "")
+		require.Error(t, err)
+
+		file, err := tool.ArchiveWithOptions(server.URL+"/file.bin", "", ArchiveOptions{Timeout: time.Second})
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), file.Size)
+	})
+}
+
+func TestDirectDownloadUserAgents(t *testing.T) {
+	var receivedUserAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedUserAgent = r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	_, err := tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultUserAgent, receivedUserAgent)
+
+	tool.SetUserAgents("Custom/1.0", nil)
+	_, err = tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Custom/1.0", receivedUserAgent)
+
+	tool.SetUserAgents("Custom/1.0", []HostUserAgent{{Pattern: "127.0.0.1", UserAgent: "Mozilla/5.0"}})
+	_, err = tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Mozilla/5.0", receivedUserAgent)
+}
+
+func TestUserAgentsFor(t *testing.T) {
+	var userAgents UserAgents
+	assert.Equal(t, DefaultUserAgent, userAgents.For("example.com"))
+
+	userAgents.Set("", []HostUserAgent{{Pattern: "*.example.com", UserAgent: "Mozilla/5.0"}})
+	assert.Equal(t, "Mozilla/5.0", userAgents.For("cdn.Example.com"))
+	assert.Equal(t, DefaultUserAgent, userAgents.For("example.org"), "the default applies when no override matches")
+}
+
+func TestDirectDownloadStatusActions(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			// Rate limited until the third request
+			if requests.Add(1) < StatusRetryAttempts {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte("content"))
+		default:
+			requests.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+	tool.statusActions.retryDelay = time.Millisecond
+
+	t.Run("status codes fail without actions", func(t *testing.T) {
+		requests.Store(0)
+		_, err := tool.Archive(server.URL+"/busy", "")
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+		assert.Equal(t, "download failed with status 429", err.Error())
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	tool.SetStatusActions([]StatusRule{
+		{StatusCode: http.StatusTooManyRequests, Action: StatusActionRetry},
+		{StatusCode: http.StatusForbidden, Action: StatusActionSkip},
+	})
+
+	t.Run("retried status codes", func(t *testing.T) {
+		requests.Store(0)
+		file, err := tool.Archive(server.URL+"/busy", "")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("content"), file.Data)
+		assert.Equal(t, int32(StatusRetryAttempts), requests.Load())
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		tool.SetStatusActions([]StatusRule{{StatusCode: http.StatusForbidden, Action: StatusActionRetry}})
+		defer tool.SetStatusActions(nil)
+
+		requests.Store(0)
+		_, err := tool.Archive(server.URL+"/forbidden", "")
+		require.Error(t, err)
+		assert.Equal(t, int32(StatusRetryAttempts), requests.Load())
+	})
+
+	t.Run("skipped status codes aren't retried", func(t *testing.T) {
+		tool.SetStatusActions([]StatusRule{{StatusCode: http.StatusForbidden, Action: StatusActionSkip}})
+
+		requests.Store(0)
+		_, err := tool.Archive(server.URL+"/forbidden", "")
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func TestDirectDownloadMaxRedirects(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/hop1":
+			http.Redirect(w, r, "/hop2", http.StatusFound)
+		case "/hop2":
+			http.Redirect(w, r, "/file.bin", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("data"))
+		}
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	_, err := tool.Archive(server.URL+"/loop", "")
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+	assert.Equal(t, int32(DefaultMaxRedirects+1), requests.Load())
+
+	tool.SetMaxRedirects(1)
+	requests.Store(0)
+	_, err = tool.Archive(server.URL+"/loop", "")
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+	assert.Equal(t, int32(2), requests.Load())
+
+	_, err = tool.Archive(server.URL+"/hop1", "")
+	require.ErrorIs(t, err, ErrTooManyRedirects, "two redirects are above the limit")
+
+	tool.SetMaxRedirects(2)
+	file, err := tool.Archive(server.URL+"/hop1", "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), file.Data)
+}
+
+func TestDirectDownloadAcceptLanguage(t *testing.T) {
+	var receivedLanguage atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedLanguage.Store(r.Header.Get("Accept-Language"))
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	tool := NewDirectDownload(0)
+
+	_, err := tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Empty(t, receivedLanguage.Load(), "no Accept-Language by default")
+
+	tool.SetAcceptLanguage("fr-FR,fr;q=0.9")
+	_, err = tool.Archive(server.URL+"/file.bin", "")
+	require.NoError(t, err)
+	assert.Equal(t, "fr-FR,fr;q=0.9", receivedLanguage.Load())
+
+	_, err = tool.ArchiveWithOptions(server.URL+"/file.bin", "", ArchiveOptions{AcceptLanguage: "de-DE"})
+	require.NoError(t, err)
+	assert.Equal(t, "de-DE", receivedLanguage.Load(), "rules override the configured language")
+}
@@ -0,0 +1,110 @@
+package archiver
+
+import (
+	"bytes"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// ExcerptMaxLength is the default maximum length of page excerpts, in characters
+	ExcerptMaxLength = 300
+	// excerptMaxPageSize is how much of a page is read to find its excerpt (2MB), self-contained
+	// archives can embed large assets after the content
+	excerptMaxPageSize = 2 * 1024 * 1024
+	// excerptMinParagraphLength skips short paragraphs like bylines and cookie notices
+	excerptMinParagraphLength = 40
+)
+
+// ExtractExcerpt returns a short plain text excerpt of an HTML page: its title and description, or
+// its first paragraph when it has no description. The excerpt is bounded to maxLength characters.
+func ExtractExcerpt(page []byte, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = ExcerptMaxLength
+	}
+	if len(page) > excerptMaxPageSize {
+		page = page[:excerptMaxPageSize]
+	}
+
+	metadata := parsePageMetadata(page)
+	title := collapseWhitespace(metadata.Title)
+	text := collapseWhitespace(metadata.Description)
+	if text == "" {
+		text = firstParagraph(page)
+	}
+
+	excerpt := title
+	if text != "" && text != title {
+		if excerpt != "" {
+			excerpt += " — "
+		}
+		excerpt += text
+	}
+
+	return truncateText(excerpt, maxLength)
+}
+
+// firstParagraph returns the text of the first paragraph long enough to describe the page, without tags
+func firstParagraph(page []byte) string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(page))
+	depth := 0 // Nesting of the current paragraph, 0 when outside one
+	skip := 0  // Nesting of script and style elements, their text isn't content
+	var paragraph strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "p":
+				depth++
+			case "script", "style", "noscript":
+				skip++
+			}
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "p":
+				if depth == 0 {
+					continue
+				}
+				if depth--; depth == 0 {
+					if text := collapseWhitespace(paragraph.String()); len([]rune(text)) >= excerptMinParagraphLength {
+						return text
+					}
+					paragraph.Reset()
+				}
+			case "script", "style", "noscript":
+				if skip > 0 {
+					skip--
+				}
+			}
+		case xhtml.TextToken:
+			if depth > 0 && skip == 0 {
+				paragraph.Write(tokenizer.Text())
+				paragraph.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// collapseWhitespace trims a text and replaces runs of whitespace with single spaces
+func collapseWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// truncateText shortens a text to maxLength characters, cutting at a word boundary when possible
+func truncateText(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+
+	truncated := string(runes[:maxLength-1])
+	if i := strings.LastIndex(truncated, " "); i > len(truncated)/2 {
+		truncated = truncated[:i]
+	}
+	return strings.TrimRight(truncated, " ,.;:—-") + "…"
+}
@@ -0,0 +1,35 @@
+package archiver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractExcerpt(t *testing.T) {
+	t.Run("title and description", func(t *testing.T) {
+		page := `<html><head><title>Release   notes</title><meta name="description" content="What changed in <b>this</b> release."></head></html>`
+		assert.Equal(t, "Release notes — What changed in <b>this</b> release.", ExtractExcerpt([]byte(page), 0))
+	})
+
+	t.Run("first paragraph without description", func(t *testing.T) {
+		page := `<html><head><title>Blog</title><style>p { color: red; }</style></head><body>
+			<p>By Jane</p>
+			<p>This is the <em>first</em> real paragraph &amp; it is long enough to describe the page.</p>
+			<p>Second paragraph.</p>
+		</body></html>`
+		assert.Equal(t, "Blog — This is the first real paragraph & it is long enough to describe the page.", ExtractExcerpt([]byte(page), 0))
+	})
+
+	t.Run("bounded length", func(t *testing.T) {
+		page := `<title>` + strings.Repeat("word ", 200) + `</title>`
+		excerpt := ExtractExcerpt([]byte(page), 50)
+		assert.LessOrEqual(t, len([]rune(excerpt)), 50)
+		assert.True(t, strings.HasSuffix(excerpt, "word…"), excerpt)
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		assert.Empty(t, ExtractExcerpt([]byte(`<html><body><script>var x = 1;</script></body></html>`), 0))
+	})
+}
@@ -0,0 +1,337 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExternalCommandToolName is the name of the external command archival tool
+	ExternalCommandToolName = "external_command"
+	// ExternalCommandDefaultTimeout is the default time the command has to archive a URL
+	ExternalCommandDefaultTimeout = 120 * time.Second
+	// ExternalCommandMaxFileSize is the default maximum size of the produced file (100MB)
+	ExternalCommandMaxFileSize = 100 * 1024 * 1024
+
+	// ExternalCommandURLPlaceholder is replaced by the URL in the command arguments
+	ExternalCommandURLPlaceholder = "{url}"
+	// ExternalCommandOutputPlaceholder is replaced by the path of the file the command must write
+	ExternalCommandOutputPlaceholder = "{output}"
+
+	// externalCommandOutputName is the name of the file given to commands using the output placeholder
+	externalCommandOutputName = "archive"
+	// externalCommandWaitDelay is how long to wait for the output of a killed command to be closed
+	externalCommandWaitDelay = 5 * time.Second
+	// externalCommandMaxStderr bounds the error output kept to report failures
+	externalCommandMaxStderr = 64 * 1024
+)
+
+// ExternalCommandOptions configures the command run by the external command tool
+type ExternalCommandOptions struct {
+	// Command is the executable and its arguments separated by spaces, like "monolith {url}".
+	// The placeholders are replaced in the arguments, and the URL is added last if no argument holds it.
+	Command string
+	// Output is the name or glob pattern of the file produced by the command in its working
+	// directory, like "*.html". When empty, the file written to {output} or the command's standard
+	// output is archived.
+	Output string
+	// AdditionalOutputs are the names or glob patterns of other files produced by the command, like a
+	// thumbnail, attached along with the archive when the command wrote them. Their MIME type is
+	// detected from their content.
+	AdditionalOutputs []string
+	// MimeType is the MIME type of the produced file, detected from its content if empty
+	MimeType string
+	// Timeout is the time the command has to archive a URL before it is killed, the default if zero
+	Timeout time.Duration
+}
+
+// ExternalCommand implements the MultiArchivalTool interface by running a configured command-line
+// tool, like SingleFile, monolith, wget or yt-dlp, in a temporary directory and archiving the files it produces.
+// The command is run directly, without a shell, so URLs can't inject shell syntax.
+type ExternalCommand struct {
+	optionsLock sync.RWMutex
+	options     ExternalCommandOptions
+}
+
+// NewExternalCommand creates a new external command archival tool
+func NewExternalCommand() *ExternalCommand {
+	return &ExternalCommand{}
+}
+
+// Name returns the name of this archival tool
+func (e *ExternalCommand) Name() string {
+	return ExternalCommandToolName
+}
+
+// Describe describes the tool and the timeout of the configured command
+func (e *ExternalCommand) Describe() ToolDescriptor {
+	return ToolDescriptor{
+		Name:                    ExternalCommandToolName,
+		DisplayName:             "External command",
+		Description:             "Archives the file produced by the configured command-line tool",
+		TimeoutSeconds:          int(e.getOptions().Timeout.Seconds()),
+		MaxBytes:                ExternalCommandMaxFileSize,
+		RequiresNetwork:         true,
+		RequiresExternalService: true,
+	}
+}
+
+// SetOptions sets the command run to archive URLs
+func (e *ExternalCommand) SetOptions(options ExternalCommandOptions) {
+	if options.Timeout <= 0 {
+		options.Timeout = ExternalCommandDefaultTimeout
+	}
+	e.optionsLock.Lock()
+	defer e.optionsLock.Unlock()
+	e.options = options
+}
+
+// getOptions returns the configured command options
+func (e *ExternalCommand) getOptions() ExternalCommandOptions {
+	e.optionsLock.RLock()
+	defer e.optionsLock.RUnlock()
+	options := e.options
+	if options.Timeout <= 0 {
+		options.Timeout = ExternalCommandDefaultTimeout
+	}
+	return options
+}
+
+// Archive archives the URL with the configured command
+func (e *ExternalCommand) Archive(url, mimeType string) (*ArchivedFile, error) {
+	return e.ArchiveWithOptions(url, mimeType, ArchiveOptions{})
+}
+
+// ArchiveWithOptions archives the URL with the configured command, honoring the rule's size limit
+// and the timeout of the options. Additional outputs are ignored.
+func (e *ExternalCommand) ArchiveWithOptions(url, mimeType string, archiveOptions ArchiveOptions) (*ArchivedFile, error) {
+	files, err := e.archive(url, archiveOptions, false)
+	if err != nil {
+		return nil, err
+	}
+	return files[0], nil
+}
+
+// ArchiveMulti archives the URL with the configured command, along with its additional outputs
+func (e *ExternalCommand) ArchiveMulti(url, mimeType string) ([]*ArchivedFile, error) {
+	return e.ArchiveMultiWithOptions(url, mimeType, ArchiveOptions{})
+}
+
+// ArchiveMultiWithOptions archives the URL with the configured command, along with its additional
+// outputs, honoring the rule's size limit for each file and the timeout of the options
+func (e *ExternalCommand) ArchiveMultiWithOptions(url, mimeType string, archiveOptions ArchiveOptions) ([]*ArchivedFile, error) {
+	return e.archive(url, archiveOptions, true)
+}
+
+// archive runs the configured command on the URL and returns the file it produced, followed by its
+// additional outputs if requested
+func (e *ExternalCommand) archive(url string, archiveOptions ArchiveOptions, additionalOutputs bool) ([]*ArchivedFile, error) {
+	maxFileSize := archiveOptions.MaxFileSize(ExternalCommandMaxFileSize)
+	options := e.getOptions()
+	options.Timeout = archiveOptions.ArchiveTimeout(options.Timeout)
+
+	// Only pass web URLs to the command, anything else could be read as a flag or a local file
+	parsedURL, err := nurl.Parse(url)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return nil, errors.Errorf("invalid URL for external command: %s", url)
+	}
+
+	args := strings.Fields(options.Command)
+	if len(args) == 0 {
+		return nil, errors.New("no external command configured")
+	}
+
+	workDir, err := os.MkdirTemp("", "link-archiver-command-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	outputPath := filepath.Join(workDir, externalCommandOutputName)
+	usesOutput, usesURL := false, false
+	for i, arg := range args[1:] {
+		usesOutput = usesOutput || strings.Contains(arg, ExternalCommandOutputPlaceholder)
+		usesURL = usesURL || strings.Contains(arg, ExternalCommandURLPlaceholder)
+		arg = strings.ReplaceAll(arg, ExternalCommandOutputPlaceholder, outputPath)
+		args[i+1] = strings.ReplaceAll(arg, ExternalCommandURLPlaceholder, url)
+	}
+	if !usesURL {
+		args = append(args, url)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxFileSize}
+	stderr := &limitedBuffer{limit: externalCommandMaxStderr}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = workDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Children keeping the output open don't block the archive once the command is killed
+	cmd.WaitDelay = externalCommandWaitDelay
+
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Errorf("timeout while running external command after %s", options.Timeout)
+		}
+		if stdout.exceeded {
+			return nil, errors.Errorf("external command output exceeds maximum allowed size %d", maxFileSize)
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, errors.Wrapf(err, "failed to launch external command %s", args[0])
+		}
+		return nil, errors.Wrapf(err, "external command failed: %s", lastLine(stderr.buffer.String()))
+	}
+
+	var filename string
+	var data []byte
+	switch {
+	case options.Output != "":
+		filename, data, err = readRequiredCommandOutput(workDir, options.Output, maxFileSize)
+	case usesOutput:
+		_, data, err = readRequiredCommandOutput(workDir, externalCommandOutputName, maxFileSize)
+	default:
+		if stdout.exceeded {
+			return nil, errors.Errorf("external command output exceeds maximum allowed size %d", maxFileSize)
+		}
+		data = stdout.buffer.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("external command produced an empty file")
+	}
+
+	fileMimeType := options.MimeType
+	if fileMimeType == "" {
+		fileMimeType = http.DetectContentType(data)
+	}
+	if filename == "" {
+		filename = externalCommandFilename(parsedURL, fileMimeType)
+	}
+
+	files := []*ArchivedFile{{
+		Filename: filename,
+		Data:     data,
+		MimeType: fileMimeType,
+		Size:     int64(len(data)),
+	}}
+	if !additionalOutputs {
+		return files, nil
+	}
+
+	for _, pattern := range options.AdditionalOutputs {
+		name, data, err := readCommandOutput(workDir, pattern, maxFileSize)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" || len(data) == 0 || name == filename {
+			continue
+		}
+		files = append(files, &ArchivedFile{
+			Filename: name,
+			Data:     data,
+			MimeType: http.DetectContentType(data),
+			Size:     int64(len(data)),
+		})
+	}
+	return files, nil
+}
+
+// readRequiredCommandOutput reads the first file of the working directory matching the pattern,
+// failing if the command didn't produce one
+func readRequiredCommandOutput(workDir, pattern string, maxFileSize int64) (string, []byte, error) {
+	name, data, err := readCommandOutput(workDir, pattern, maxFileSize)
+	if err == nil && name == "" {
+		return "", nil, errors.Errorf("external command did not produce a file matching %s", pattern)
+	}
+	return name, data, err
+}
+
+// readCommandOutput reads the first file of the working directory matching the pattern, in name
+// order, returning an empty name if none matches. Patterns can't reach outside of the working directory.
+func readCommandOutput(workDir, pattern string, maxFileSize int64) (string, []byte, error) {
+	if filepath.IsAbs(pattern) || strings.Contains(filepath.ToSlash(pattern), "../") || pattern == ".." {
+		return "", nil, errors.Errorf("invalid external command output pattern %s, must be relative to the working directory", pattern)
+	}
+	matches, err := filepath.Glob(filepath.Join(workDir, pattern))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "invalid external command output pattern")
+	}
+	sort.Strings(matches)
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() > maxFileSize {
+			return "", nil, errors.Errorf("external command output size %d exceeds maximum allowed size %d", info.Size(), maxFileSize)
+		}
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "failed to read external command output")
+		}
+		return filepath.Base(match), data, nil
+	}
+	return "", nil, nil
+}
+
+// externalCommandFilename generates the filename of a command's output from the URL, with an
+// extension for common MIME types
+func externalCommandFilename(url *nurl.URL, mimeType string) string {
+	name := url.Hostname()
+	segments := strings.Split(strings.Trim(url.Path, "/"), "/")
+	if last := segments[len(segments)-1]; last != "" {
+		name = last
+	}
+
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	extensions := map[string]string{
+		"text/html":       ".html",
+		"application/pdf": ".pdf",
+		"image/png":       ".png",
+		"application/zip": ".zip",
+		"text/plain":      ".txt",
+	}
+	if extension, ok := extensions[strings.TrimSpace(mediaType)]; ok && !hasExtension(name, extension) {
+		name += extension
+	}
+	return name
+}
+
+// limitedBuffer buffers the output of a command up to a limit, dropping the rest
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+// Write buffers the data until the limit is reached. Writes never fail, so commands aren't
+// interrupted when their output is dropped.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - int64(b.buffer.Len())
+	if int64(len(p)) > remaining {
+		b.exceeded = true
+		b.buffer.Write(p[:max(remaining, 0)])
+		return len(p), nil
+	}
+	return b.buffer.Write(p)
+}
+
+var _ io.Writer = (*limitedBuffer)(nil)
@@ -0,0 +1,157 @@
+package archiver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalCommandArchive(t *testing.T) {
+	newTool := func(options ExternalCommandOptions) *ExternalCommand {
+		tool := NewExternalCommand()
+		tool.SetOptions(options)
+		return tool
+	}
+
+	t.Run("archives the file written to the output placeholder", func(t *testing.T) {
+		command := writeFakeBrowser(t, `[ "$1" = "-o" ] && printf '<html>%s</html>' "$3" > "$2"`+"\n")
+		tool := newTool(ExternalCommandOptions{Command: command + " -o {output} {url}"})
+
+		file, err := tool.Archive("https://example.com/docs/guide", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "guide.html", file.Filename)
+		assert.Equal(t, "text/html; charset=utf-8", file.MimeType)
+		assert.Equal(t, "<html>https://example.com/docs/guide</html>", string(file.Data))
+		assert.Equal(t, int64(len(file.Data)), file.Size)
+	})
+
+	t.Run("archives the file matching the output pattern", func(t *testing.T) {
+		command := writeFakeBrowser(t, "echo ignored\nmkdir -p example.com\nprintf 'page' > example.com/index.html\nprintf 'log' > wget.log\n")
+		tool := newTool(ExternalCommandOptions{Command: command, Output: "*/*.html", MimeType: "text/html"})
+
+		file, err := tool.Archive("https://example.com/", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "index.html", file.Filename)
+		assert.Equal(t, "text/html", file.MimeType)
+		assert.Equal(t, "page", string(file.Data))
+	})
+
+	t.Run("archives the additional outputs with the file", func(t *testing.T) {
+		command := writeFakeBrowser(t, "printf 'video' > clip.mp4\nprintf '\\377\\330\\377thumb' > clip.jpg\n")
+		tool := newTool(ExternalCommandOptions{Command: command, Output: "*.mp4", AdditionalOutputs: []string{"*.jpg", "*.vtt", "*.mp4"}, MimeType: "video/mp4"})
+
+		files, err := tool.ArchiveMulti("https://example.com/watch", "text/html")
+		require.NoError(t, err)
+		require.Len(t, files, 2, "missing and duplicate outputs are skipped")
+		assert.Equal(t, "clip.mp4", files[0].Filename)
+		assert.Equal(t, "video/mp4", files[0].MimeType)
+		assert.Equal(t, "clip.jpg", files[1].Filename)
+		assert.Equal(t, "image/jpeg", files[1].MimeType)
+		assert.Equal(t, int64(8), files[1].Size)
+
+		file, err := tool.Archive("https://example.com/watch", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "clip.mp4", file.Filename, "single file archives ignore the additional outputs")
+
+		_, err = tool.ArchiveMultiWithOptions("https://example.com/watch", "text/html", ArchiveOptions{MaxBytes: 6})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size", "the size limit applies to every file")
+	})
+
+	t.Run("archives the standard output without output file", func(t *testing.T) {
+		command := writeFakeBrowser(t, `printf '%s' "$1"`+"\n")
+		tool := newTool(ExternalCommandOptions{Command: command, MimeType: "text/plain"})
+
+		file, err := tool.Archive("https://example.com/notes", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "notes.txt", file.Filename)
+		assert.Equal(t, "https://example.com/notes", string(file.Data))
+	})
+
+	t.Run("URL is passed as a single argument", func(t *testing.T) {
+		command := writeFakeBrowser(t, `printf '%s|' "$#" "$@"`+"\n")
+		tool := newTool(ExternalCommandOptions{Command: command + " --url={url}"})
+
+		file, err := tool.Archive("https://example.com/a;b?q=$(touch%20pwned)&x=`id`", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "1|--url=https://example.com/a;b?q=$(touch%20pwned)&x=`id`|", string(file.Data))
+	})
+
+	t.Run("command failure is reported", func(t *testing.T) {
+		tool := newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "echo 'starting' >&2\necho 'unable to fetch page' >&2\nexit 2\n")})
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to fetch page")
+		assert.NotContains(t, err.Error(), "starting")
+	})
+
+	t.Run("missing output file is reported", func(t *testing.T) {
+		tool := newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "exit 0\n"), Output: "*.html"})
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "did not produce a file matching *.html")
+	})
+
+	t.Run("output patterns can't leave the working directory", func(t *testing.T) {
+		for _, pattern := range []string{"../*", "/etc/passwd", "a/../../b"} {
+			tool := newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "exit 0\n"), Output: pattern})
+
+			_, err := tool.Archive("https://example.com/", "text/html")
+			require.Error(t, err, pattern)
+			assert.Contains(t, err.Error(), "invalid external command output pattern", pattern)
+		}
+	})
+
+	t.Run("output size is capped", func(t *testing.T) {
+		command := writeFakeBrowser(t, `head -c 2048 /dev/zero > "$1"`+"\n")
+		tool := newTool(ExternalCommandOptions{Command: command + " {output}"})
+
+		_, err := tool.ArchiveWithOptions("https://example.com/", "text/html", ArchiveOptions{MaxBytes: 1024})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size")
+
+		tool = newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "head -c 2048 /dev/zero\n")})
+		_, err = tool.ArchiveWithOptions("https://example.com/", "text/html", ArchiveOptions{MaxBytes: 1024})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size")
+	})
+
+	t.Run("command is killed on timeout", func(t *testing.T) {
+		tool := newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "exec sleep 5\n"), Timeout: 100 * time.Millisecond})
+
+		start := time.Now()
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timeout while running external command")
+		assert.Less(t, time.Since(start), 3*time.Second)
+	})
+
+	t.Run("missing command fails to launch", func(t *testing.T) {
+		tool := newTool(ExternalCommandOptions{Command: filepath.Join(t.TempDir(), "missing")})
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to launch external command")
+	})
+
+	t.Run("unconfigured command fails", func(t *testing.T) {
+		_, err := NewExternalCommand().Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no external command configured")
+	})
+
+	t.Run("non web URLs are rejected", func(t *testing.T) {
+		tool := newTool(ExternalCommandOptions{Command: writeFakeBrowser(t, "exit 0\n")})
+
+		for _, url := range []string{"file:///etc/passwd", "--output=/tmp/x", "javascript:alert(1)"} {
+			_, err := tool.Archive(url, "text/html")
+			require.Error(t, err, url)
+			assert.Contains(t, err.Error(), "invalid URL for external command", url)
+		}
+	})
+}
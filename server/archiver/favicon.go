@@ -0,0 +1,23 @@
+package archiver
+
+import (
+	nurl "net/url"
+)
+
+// FaviconMaxSize is the maximum size of a favicon archived along with a page (100KB)
+const FaviconMaxSize = 100 * 1024
+
+// ExtractFaviconURL returns the absolute URL of the page's <link rel="icon">, resolved against the
+// URL the page was fetched from, falling back to /favicon.ico on the page's host. Returns an empty
+// string if the page URL isn't a web URL.
+func ExtractFaviconURL(page []byte, pageURL string) string {
+	if iconURL := extractHeadLink(page, pageURL, "icon"); iconURL != "" {
+		return iconURL
+	}
+
+	parsedURL, err := nurl.Parse(pageURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return ""
+	}
+	return (&nurl.URL{Scheme: parsedURL.Scheme, Host: parsedURL.Host, Path: "/favicon.ico"}).String()
+}
@@ -0,0 +1,212 @@
+package archiver
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FeedExpandToolName is the name of the feed expansion archival tool
+	FeedExpandToolName = "feed_expand"
+	// FeedExpandDefaultTimeout is the default timeout for fetching feeds
+	FeedExpandDefaultTimeout = 30 * time.Second
+	// FeedExpandMaxFeedSize is the maximum size of a feed document (5MB)
+	FeedExpandMaxFeedSize = 5 * 1024 * 1024
+	// FeedExpandMaxEntries is the maximum number of entry links returned, whatever the configured limit
+	FeedExpandMaxEntries = 50
+)
+
+// ExpandingArchivalTool is implemented by archival tools that don't archive the URL itself, but
+// return the links it contains so each one is archived through the archival rules
+type ExpandingArchivalTool interface {
+	ArchivalTool
+	Expand(url string, mimeType string, maxLinks int) ([]string, error)
+}
+
+// FeedExpand implements the ExpandingArchivalTool interface for RSS and Atom feeds,
+// expanding a feed into the links of its entries
+type FeedExpand struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewFeedExpand creates a new feed expansion archival tool
+func NewFeedExpand(timeout time.Duration) *FeedExpand {
+	if timeout == 0 {
+		timeout = FeedExpandDefaultTimeout
+	}
+
+	return &FeedExpand{
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		timeout: timeout,
+	}
+}
+
+// Name returns the name of this archival tool
+func (f *FeedExpand) Name() string {
+	return FeedExpandToolName
+}
+
+// Describe describes the tool, its size limit is the one of fetched feeds
+func (f *FeedExpand) Describe() ToolDescriptor {
+	return ToolDescriptor{
+		Name:            FeedExpandToolName,
+		DisplayName:     "Feed entries",
+		Description:     "Archives the links of the latest entries of RSS and Atom feeds",
+		MimeTypes:       []string{"application/rss+xml", "application/atom+xml", "application/xml", "text/xml"},
+		TimeoutSeconds:  int(f.timeout.Seconds()),
+		MaxBytes:        FeedExpandMaxFeedSize,
+		RequiresNetwork: true,
+	}
+}
+
+// Archive isn't supported, the entries of the feed are archived instead
+func (f *FeedExpand) Archive(url, mimeType string) (*ArchivedFile, error) {
+	return nil, errors.New("feeds are expanded into their entries instead of being archived")
+}
+
+// Expand fetches the feed at the URL and returns the links of its entries in feed order,
+// without duplicates and links back to the feed itself, up to maxLinks
+func (f *FeedExpand) Expand(url, mimeType string, maxLinks int) ([]string, error) {
+	if maxLinks <= 0 || maxLinks > FeedExpandMaxEntries {
+		maxLinks = FeedExpandMaxEntries
+	}
+
+	req, err := http.NewRequest("GET", url, http.NoBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GET request")
+	}
+
+	// Set a reasonable User-Agent
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download feed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > FeedExpandMaxFeedSize {
+		return nil, errors.Errorf("feed size %d exceeds maximum allowed size %d", resp.ContentLength, FeedExpandMaxFeedSize)
+	}
+
+	links, err := parseFeedLinks(io.LimitReader(resp.Body, FeedExpandMaxFeedSize), resp.Request.URL.String(), maxLinks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries linking back to the feed would expand it again
+	filtered := links[:0]
+	for _, link := range links {
+		if !SameURL(link, url) && !SameURL(link, resp.Request.URL.String()) {
+			filtered = append(filtered, link)
+		}
+	}
+
+	return filtered, nil
+}
+
+// parseFeedLinks extracts the entry links of an RSS 1.0, RSS 2.0 or Atom feed, resolved against
+// the feed URL. Parsing stops once maxLinks unique web links are found.
+func parseFeedLinks(r io.Reader, feedURL string, maxLinks int) ([]string, error) {
+	decoder := xml.NewDecoder(r)
+	// Feeds in the wild declare all sorts of encodings, entry links are ASCII anyway
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var links []string
+	seen := make(map[string]bool)
+	isFeed := false
+	inEntry := false
+
+	for len(links) < maxLinks {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(links) > 0 {
+				// Keep the entries parsed before the error, e.g. a feed truncated by the size limit
+				break
+			}
+			return nil, errors.Wrap(err, "failed to parse feed")
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "rss", "feed", "RDF":
+				isFeed = true
+			case "item", "entry":
+				inEntry = true
+			case "link":
+				if !inEntry {
+					continue
+				}
+
+				// Atom links are in the href attribute, only the alternate link is the entry's page
+				href, rel := "", "alternate"
+				for _, attr := range element.Attr {
+					switch attr.Name.Local {
+					case "href":
+						href = attr.Value
+					case "rel":
+						rel = attr.Value
+					}
+				}
+				if href == "" {
+					// RSS links are the element's text
+					var text string
+					if err := decoder.DecodeElement(&text, &element); err != nil {
+						continue
+					}
+					href = text
+				} else if rel != "alternate" {
+					continue
+				}
+
+				link, ok := resolveFeedLink(feedURL, href)
+				if ok && !seen[link] {
+					seen[link] = true
+					links = append(links, link)
+				}
+			}
+		case xml.EndElement:
+			if element.Name.Local == "item" || element.Name.Local == "entry" {
+				inEntry = false
+			}
+		}
+	}
+
+	if !isFeed {
+		return nil, errors.New("document is not an RSS or Atom feed")
+	}
+
+	return links, nil
+}
+
+// resolveFeedLink resolves an entry link against the feed URL, only accepting web URLs
+func resolveFeedLink(feedURL, href string) (string, bool) {
+	link, err := resolveReference(feedURL, strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	parsedURL, err := nurl.Parse(link)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return "", false
+	}
+	return link, true
+}
@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFeedLinks(t *testing.T) {
+	t.Run("rss", func(t *testing.T) {
+		feed := `<?xml version="1.0" encoding="ISO-8859-1"?>
+			<rss version="2.0"><channel>
+				<title>Example</title>
+				<link>https://example.com/</link>
+				<item><title>First</title><link>https://example.com/posts/1</link></item>
+				<item><title>Second</title><link> /posts/2 </link></item>
+				<item><title>Duplicate</title><link>https://example.com/posts/1</link></item>
+				<item><title>Mail</title><link>mailto:someone@example.com</link></item>
+			</channel></rss>`
+
+		links, err := parseFeedLinks(strings.NewReader(feed), "https://example.com/feed.xml", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/posts/1", "https://example.com/posts/2"}, links)
+	})
+
+	t.Run("atom", func(t *testing.T) {
+		feed := `<feed xmlns="http://www.w3.org/2005/Atom">
+				<link rel="self" href="https://example.com/atom.xml"/>
+				<entry>
+					<link rel="edit" href="https://example.com/edit/1"/>
+					<link rel="alternate" href="https://example.com/posts/1"/>
+				</entry>
+				<entry><link href="posts/2"/></entry>
+			</feed>`
+
+		links, err := parseFeedLinks(strings.NewReader(feed), "https://example.com/blog/atom.xml", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/posts/1", "https://example.com/blog/posts/2"}, links)
+	})
+
+	t.Run("max links", func(t *testing.T) {
+		var items strings.Builder
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(&items, "<item><link>https://example.com/posts/%d</link></item>", i)
+		}
+		feed := "<rss><channel>" + items.String() + "</channel></rss>"
+
+		links, err := parseFeedLinks(strings.NewReader(feed), "https://example.com/feed.xml", 3)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/posts/0", "https://example.com/posts/1", "https://example.com/posts/2"}, links)
+	})
+
+	t.Run("not a feed", func(t *testing.T) {
+		_, err := parseFeedLinks(strings.NewReader("<html><body><a href=\"/x\">x</a></body></html>"), "https://example.com/", 10)
+		assert.Error(t, err)
+
+		_, err = parseFeedLinks(strings.NewReader("not xml at all"), "https://example.com/", 10)
+		assert.Error(t, err)
+	})
+}
+
+func TestFeedExpandExpand(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<rss><channel>
+			<item><link>%[1]s/feed.xml</link></item>
+			<item><link>%[1]s/posts/1</link></item>
+			<item><link>%[1]s/posts/2</link></item>
+		</channel></rss>`, server.URL)
+	}))
+	defer server.Close()
+
+	tool := NewFeedExpand(0)
+	assert.Equal(t, FeedExpandToolName, tool.Name())
+
+	links, err := tool.Expand(server.URL+"/feed.xml", "application/rss+xml", 10)
+	require.NoError(t, err)
+	// The link back to the feed is dropped so it isn't expanded again
+	assert.Equal(t, []string{server.URL + "/posts/1", server.URL + "/posts/2"}, links)
+
+	_, err = tool.Archive(server.URL+"/feed.xml", "application/rss+xml")
+	assert.Error(t, err)
+}
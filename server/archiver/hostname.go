@@ -0,0 +1,43 @@
+package archiver
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// HostnameMatches checks if a hostname matches a pattern, either exactly or
+// with a wildcard like *.example.com matching example.com and its subdomains.
+// Internationalized domain names match whether written in Unicode or punycode.
+func HostnameMatches(hostname, pattern string) bool {
+	hostname = toPunycode(hostname)
+
+	// Exact match
+	if hostname == toPunycode(pattern) {
+		return true
+	}
+
+	// Wildcard match: *.example.com
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := toPunycode(strings.TrimPrefix(pattern, "*."))
+		if suffix == "" {
+			return false
+		}
+		// Match if hostname ends with .suffix or equals suffix
+		if hostname == suffix || strings.HasSuffix(hostname, "."+suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toPunycode converts the Unicode labels of a hostname to punycode, e.g. münchen.de to xn--mnchen-3ya.de.
+// ASCII labels are left as they are, and hostnames that can't be converted are returned unchanged.
+func toPunycode(hostname string) string {
+	ascii, err := idna.Punycode.ToASCII(hostname)
+	if err != nil {
+		return hostname
+	}
+	return ascii
+}
@@ -0,0 +1,206 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HTMLToPDFToolName is the name of the HTML to PDF archival tool
+	HTMLToPDFToolName = "html_to_pdf"
+	// HTMLToPDFDefaultTimeout is the default timeout for rendering a page to PDF
+	HTMLToPDFDefaultTimeout = 60 * time.Second
+	// HTMLToPDFMaxFileSize is the maximum size of a rendered PDF (50MB)
+	HTMLToPDFMaxFileSize = 50 * 1024 * 1024
+)
+
+// htmlToPDFBrowserCandidates are the executables looked up in PATH when no browser path is configured
+var htmlToPDFBrowserCandidates = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"headless-shell",
+}
+
+// HTMLToPDF implements the ArchivalTool interface by rendering pages to PDF
+// with the print-to-PDF feature of a headless Chromium-based browser
+type HTMLToPDF struct {
+	timeout time.Duration
+
+	browserPathLock sync.RWMutex
+	browserPath     string
+}
+
+// NewHTMLToPDF creates a new HTML to PDF archival tool
+func NewHTMLToPDF(timeout time.Duration) *HTMLToPDF {
+	if timeout == 0 {
+		timeout = HTMLToPDFDefaultTimeout
+	}
+
+	return &HTMLToPDF{
+		timeout: timeout,
+	}
+}
+
+// Name returns the name of this archival tool
+func (h *HTMLToPDF) Name() string {
+	return HTMLToPDFToolName
+}
+
+// Describe describes the tool and its timeout
+func (h *HTMLToPDF) Describe() ToolDescriptor {
+	return ToolDescriptor{
+		Name:                    HTMLToPDFToolName,
+		DisplayName:             "HTML to PDF",
+		Description:             "Renders pages to PDF with a headless Chromium browser",
+		MimeTypes:               []string{"text/html", "application/xhtml+xml"},
+		TimeoutSeconds:          int(h.timeout.Seconds()),
+		MaxBytes:                HTMLToPDFMaxFileSize,
+		RequiresNetwork:         true,
+		RequiresExternalService: true,
+	}
+}
+
+// SetBrowserPath sets the browser executable used to render pages.
+// An empty path looks up a known Chromium-based browser in PATH.
+func (h *HTMLToPDF) SetBrowserPath(path string) {
+	h.browserPathLock.Lock()
+	defer h.browserPathLock.Unlock()
+	h.browserPath = path
+}
+
+// findBrowser returns the browser executable to use
+func (h *HTMLToPDF) findBrowser() (string, error) {
+	h.browserPathLock.RLock()
+	browserPath := h.browserPath
+	h.browserPathLock.RUnlock()
+
+	if browserPath != "" {
+		return browserPath, nil
+	}
+
+	for _, candidate := range htmlToPDFBrowserCandidates {
+		if path, err := exec.LookPath(candidate); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", errors.New("no headless browser found, install Chromium or configure the browser path")
+}
+
+// Archive renders the page at the given URL to a PDF document
+func (h *HTMLToPDF) Archive(url, mimeType string) (*ArchivedFile, error) {
+	// Only pass web URLs to the browser, anything else could be read as a command line flag or local file
+	parsedURL, err := nurl.Parse(url)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return nil, errors.Errorf("invalid URL for PDF rendering: %s", url)
+	}
+
+	browser, err := h.findBrowser()
+	if err != nil {
+		return nil, err
+	}
+
+	// The browser profile and output go in a temporary directory removed afterwards
+	workDir, err := os.MkdirTemp("", "link-archiver-pdf-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	outputPath := filepath.Join(workDir, "page.pdf")
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, browser,
+		"--headless",
+		"--disable-gpu",
+		"--no-sandbox",
+		"--no-first-run",
+		"--no-pdf-header-footer",
+		"--user-data-dir="+filepath.Join(workDir, "profile"),
+		"--print-to-pdf="+outputPath,
+		parsedURL.String(),
+	)
+	cmd.Stderr = &stderr
+
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Errorf("timeout while rendering page to PDF after %s", h.timeout)
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, errors.Wrapf(err, "failed to launch headless browser %s", browser)
+		}
+		return nil, errors.Wrapf(err, "headless browser failed to render page: %s", lastLine(stderr.String()))
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "headless browser did not produce a PDF")
+	}
+	if info.Size() > HTMLToPDFMaxFileSize {
+		return nil, errors.Errorf("rendered PDF size %d exceeds maximum allowed size %d", info.Size(), HTMLToPDFMaxFileSize)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read rendered PDF")
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return nil, errors.New("headless browser produced an invalid PDF")
+	}
+
+	return &ArchivedFile{
+		Filename: pdfFilename(url),
+		Data:     data,
+		MimeType: "application/pdf",
+		Size:     int64(len(data)),
+	}, nil
+}
+
+// pdfFilename generates the filename for a rendered page from its URL
+func pdfFilename(url string) string {
+	return pageName(url) + ".pdf"
+}
+
+// pageName returns the name of a page for the file it's converted to, from the last segment of its
+// URL path or its hostname, without its .html or .htm extension
+func pageName(url string) string {
+	name := "page"
+	if parsedURL, err := nurl.Parse(url); err == nil {
+		segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+		if last := segments[len(segments)-1]; last != "" {
+			name = last
+		} else if parsedURL.Hostname() != "" {
+			name = parsedURL.Hostname()
+		}
+	}
+
+	// Remove existing .html or .htm extension if present
+	if hasExtension(name, ".html") {
+		name = name[:len(name)-5]
+	} else if hasExtension(name, ".htm") {
+		name = name[:len(name)-4]
+	}
+
+	return name
+}
+
+// lastLine returns the last non-empty line of the output, which usually holds the error
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
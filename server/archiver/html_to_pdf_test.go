@@ -0,0 +1,80 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFakeBrowser writes a shell script standing in for a headless browser
+func writeFakeBrowser(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fake-browser")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755))
+	return path
+}
+
+func TestHTMLToPDFArchive(t *testing.T) {
+	t.Run("renders the page with the browser", func(t *testing.T) {
+		browser := writeFakeBrowser(t, `for arg in "$@"; do
+  case "$arg" in
+    --print-to-pdf=*) printf '%%PDF-1.4 fake' > "${arg#--print-to-pdf=}" ;;
+  esac
+done
+`)
+		tool := NewHTMLToPDF(5 * time.Second)
+		tool.SetBrowserPath(browser)
+
+		file, err := tool.Archive("https://example.com/docs/guide.html", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "guide.pdf", file.Filename)
+		assert.Equal(t, "application/pdf", file.MimeType)
+		assert.Equal(t, "%PDF-1.4 fake", string(file.Data))
+		assert.Equal(t, int64(len(file.Data)), file.Size)
+	})
+
+	t.Run("browser failure is reported", func(t *testing.T) {
+		tool := NewHTMLToPDF(5 * time.Second)
+		tool.SetBrowserPath(writeFakeBrowser(t, "echo 'net::ERR_NAME_NOT_RESOLVED' >&2\nexit 1\n"))
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ERR_NAME_NOT_RESOLVED")
+	})
+
+	t.Run("missing browser fails to launch", func(t *testing.T) {
+		tool := NewHTMLToPDF(5 * time.Second)
+		tool.SetBrowserPath(filepath.Join(t.TempDir(), "missing"))
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to launch headless browser")
+	})
+
+	t.Run("timeout is honored", func(t *testing.T) {
+		tool := NewHTMLToPDF(100 * time.Millisecond)
+		tool.SetBrowserPath(writeFakeBrowser(t, "exec sleep 5\n"))
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timeout")
+	})
+
+	t.Run("non web URLs are rejected", func(t *testing.T) {
+		tool := NewHTMLToPDF(5 * time.Second)
+		tool.SetBrowserPath(writeFakeBrowser(t, "exit 0\n"))
+
+		_, err := tool.Archive("file:///etc/passwd", "text/html")
+		require.Error(t, err)
+	})
+}
+
+func TestPDFFilename(t *testing.T) {
+	assert.Equal(t, "guide.pdf", pdfFilename("https://example.com/docs/guide.html"))
+	assert.Equal(t, "article.pdf", pdfFilename("https://example.com/blog/article?id=1"))
+	assert.Equal(t, "example.com.pdf", pdfFilename("https://example.com/"))
+}
@@ -0,0 +1,100 @@
+package archiver
+
+import (
+	"bytes"
+	nurl "net/url"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// MetaRefreshMaxDelay is the longest delay, in seconds, of the meta refreshes followed as redirects.
+// Pages refreshing later, like news pages going back to the home page after a few minutes, show
+// their own content meanwhile.
+const MetaRefreshMaxDelay = 10
+
+// ExtractMetaRefreshURL returns the absolute URL a page redirects to with a
+// <meta http-equiv="refresh" content="0; url=...">, resolved against the URL the page was fetched
+// from. Returns an empty string if there's none, it refreshes the page itself, its delay is longer
+// than MetaRefreshMaxDelay, or the target isn't a web URL.
+func ExtractMetaRefreshURL(page []byte, pageURL string) string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data != "meta" {
+				continue
+			}
+			httpEquiv, content := "", ""
+			for _, attr := range token.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "http-equiv":
+					httpEquiv = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "content":
+					content = attr.Val
+				}
+			}
+			if httpEquiv != "refresh" {
+				continue
+			}
+
+			// Browsers only honor the first meta refresh of a page
+			delay, target, ok := parseMetaRefresh(content)
+			if !ok || target == "" || delay > MetaRefreshMaxDelay {
+				return ""
+			}
+			targetURL, err := resolveReference(pageURL, target)
+			if err != nil {
+				return ""
+			}
+			if parsedURL, err := nurl.Parse(targetURL); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+				return ""
+			}
+			if SameURL(targetURL, pageURL) {
+				return ""
+			}
+			return targetURL
+		}
+	}
+}
+
+// parseMetaRefresh parses the content of a meta refresh, like "5; url=/next", into its delay in
+// seconds and its target, empty when the page refreshes itself
+func parseMetaRefresh(content string) (int, string, bool) {
+	content = strings.TrimSpace(content)
+	end := strings.IndexFunc(content, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end == -1 {
+		end = len(content)
+	}
+	// Fractions of seconds are ignored, like browsers do
+	seconds, _, _ := strings.Cut(content[:end], ".")
+	if seconds == "" {
+		return 0, "", false
+	}
+	delay, err := strconv.Atoi(seconds)
+	if err != nil {
+		return 0, "", false
+	}
+
+	rest := strings.TrimLeft(content[end:], " \t\n\r\f;,")
+	if rest == "" {
+		return delay, "", true
+	}
+	if len(rest) >= 4 && strings.EqualFold(rest[:3], "url") {
+		if after := strings.TrimLeft(rest[3:], " \t\n\r\f"); strings.HasPrefix(after, "=") {
+			rest = strings.TrimLeft(after[1:], " \t\n\r\f")
+		}
+	}
+	if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+		quote := rest[0]
+		rest = rest[1:]
+		if i := strings.IndexByte(rest, quote); i != -1 {
+			rest = rest[:i]
+		}
+	}
+	return delay, strings.TrimSpace(rest), true
+}
@@ -0,0 +1,66 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractMetaRefreshURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     string
+		expected string
+	}{
+		{
+			name:     "immediate refresh",
+			page:     `<html><head><meta http-equiv="refresh" content="0; url=https://example.com/article"></head></html>`,
+			expected: "https://example.com/article",
+		},
+		{
+			name:     "relative target with quotes and uppercase",
+			page:     `<HEAD><META HTTP-EQUIV="Refresh" CONTENT="3;URL='article?id=1'"></HEAD>`,
+			expected: "https://m.example.com/news/article?id=1",
+		},
+		{
+			name:     "target without url=",
+			page:     `<meta http-equiv="refresh" content="0, /article">`,
+			expected: "https://m.example.com/article",
+		},
+		{
+			name:     "refresh in the body",
+			page:     `<html><body><p>Redirecting…</p><meta http-equiv="refresh" content="1.5;url=/moved"></body></html>`,
+			expected: "https://m.example.com/moved",
+		},
+		{
+			name: "reload of the page itself",
+			page: `<meta http-equiv="refresh" content="30">`,
+		},
+		{
+			name: "target is the page itself",
+			page: `<meta http-equiv="refresh" content="0; url=https://m.example.com/news/page/">`,
+		},
+		{
+			name: "long delays aren't redirects",
+			page: `<meta http-equiv="refresh" content="600; url=/">`,
+		},
+		{
+			name: "non web target is ignored",
+			page: `<meta http-equiv="refresh" content="0; url=javascript:alert(1)">`,
+		},
+		{
+			name: "invalid delay",
+			page: `<meta http-equiv="refresh" content="soon; url=/article">`,
+		},
+		{
+			name: "other meta tags",
+			page: `<meta name="refresh" content="0; url=/article"><meta charset="utf-8">`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ExtractMetaRefreshURL([]byte(tt.page), "https://m.example.com/news/page"))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package archiver
+
+import (
+	"context"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MonolithToolName is the name of the monolith archival tool
+	MonolithToolName = "monolith"
+	// MonolithDefaultTimeout is the default time monolith has to bundle a page
+	MonolithDefaultTimeout = 60 * time.Second
+	// MonolithMaxFileSize is the default maximum size of a bundled page (50MB)
+	MonolithMaxFileSize = 50 * 1024 * 1024
+
+	// monolithBinary is the executable looked up in PATH when no binary path is configured
+	monolithBinary = "monolith"
+)
+
+// Monolith implements the ArchivalTool interface by bundling pages and their resources into a
+// single HTML file with the monolith command-line tool. The binary is run directly, without a
+// shell, and only receives web URLs, so links can't inject arguments.
+type Monolith struct {
+	timeout time.Duration
+
+	binaryPathLock sync.RWMutex
+	binaryPath     string
+}
+
+// NewMonolith creates a new monolith archival tool
+func NewMonolith(timeout time.Duration) *Monolith {
+	if timeout == 0 {
+		timeout = MonolithDefaultTimeout
+	}
+
+	return &Monolith{
+		timeout: timeout,
+	}
+}
+
+// Name returns the name of this archival tool
+func (m *Monolith) Name() string {
+	return MonolithToolName
+}
+
+// Describe describes the tool and its timeout
+func (m *Monolith) Describe() ToolDescriptor {
+	return ToolDescriptor{
+		Name:                    MonolithToolName,
+		DisplayName:             "Monolith",
+		Description:             "Bundles pages into a single HTML file with the monolith command-line tool",
+		MimeTypes:               []string{"text/html", "application/xhtml+xml"},
+		TimeoutSeconds:          int(m.timeout.Seconds()),
+		MaxBytes:                MonolithMaxFileSize,
+		RequiresNetwork:         true,
+		RequiresExternalService: true,
+	}
+}
+
+// SetBinaryPath sets the monolith executable used to bundle pages.
+// An empty path looks up monolith in PATH.
+func (m *Monolith) SetBinaryPath(path string) {
+	m.binaryPathLock.Lock()
+	defer m.binaryPathLock.Unlock()
+	m.binaryPath = path
+}
+
+// findBinary returns the monolith executable to use
+func (m *Monolith) findBinary() (string, error) {
+	m.binaryPathLock.RLock()
+	binaryPath := m.binaryPath
+	m.binaryPathLock.RUnlock()
+
+	if binaryPath == "" {
+		binaryPath = monolithBinary
+	}
+	path, err := exec.LookPath(binaryPath)
+	if err != nil {
+		return "", errors.Errorf("monolith binary %s not found, install monolith or configure its path", binaryPath)
+	}
+	return path, nil
+}
+
+// Archive bundles the page at the given URL into a single HTML file
+func (m *Monolith) Archive(url, mimeType string) (*ArchivedFile, error) {
+	return m.ArchiveWithOptions(url, mimeType, ArchiveOptions{})
+}
+
+// ArchiveWithOptions bundles the page at the given URL, honoring the rule's size limit and the
+// timeout of the options
+func (m *Monolith) ArchiveWithOptions(url, mimeType string, options ArchiveOptions) (*ArchivedFile, error) {
+	maxFileSize := options.MaxFileSize(MonolithMaxFileSize)
+	timeout := options.ArchiveTimeout(m.timeout)
+
+	// Only pass web URLs to monolith, anything else could be read as a flag or a local file
+	parsedURL, err := nurl.Parse(url)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return nil, errors.Errorf("invalid URL for monolith: %s", url)
+	}
+
+	binary, err := m.findBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "link-archiver-monolith-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	outputPath := filepath.Join(workDir, "page.html")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stderr := &limitedBuffer{limit: externalCommandMaxStderr}
+	cmd := exec.CommandContext(ctx, binary,
+		"--silent",
+		"--timeout", strconv.Itoa(max(int(timeout.Seconds()), 1)),
+		"--output", outputPath,
+		"--",
+		parsedURL.String(),
+	)
+	cmd.Dir = workDir
+	cmd.Stderr = stderr
+	// Children keeping the output open don't block the archive once monolith is killed
+	cmd.WaitDelay = externalCommandWaitDelay
+
+	start := time.Now()
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Errorf("timeout while bundling page with monolith after %s", timeout)
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, errors.Wrapf(err, "failed to launch monolith %s", binary)
+		}
+		return nil, errors.Wrapf(err, "monolith failed to bundle page: %s", lastLine(stderr.buffer.String()))
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "monolith did not produce a file")
+	}
+	if info.Size() > maxFileSize {
+		return nil, errors.Errorf("bundled page size %d exceeds maximum allowed size %d", info.Size(), maxFileSize)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read bundled page")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("monolith produced an empty file")
+	}
+
+	return &ArchivedFile{
+		Filename: pageName(url) + ".html",
+		Data:     data,
+		MimeType: "text/html",
+		Size:     int64(len(data)),
+		Duration: time.Since(start),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package archiver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonolithArchive(t *testing.T) {
+	// The fake monolith writes its arguments to the output file, one per line
+	writeArgs := `while [ $# -gt 0 ]; do
+  case "$1" in
+    --output) output="$2"; shift ;;
+  esac
+  args="$args$1
+"
+  shift
+done
+printf '<html>%s</html>' "$args" > "$output"
+`
+
+	t.Run("bundles the page with monolith", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(writeFakeBrowser(t, writeArgs))
+
+		file, err := tool.Archive("https://example.com/docs/guide.html?a=1&b=2", "text/html")
+		require.NoError(t, err)
+		assert.Equal(t, "guide.html", file.Filename)
+		assert.Equal(t, "text/html", file.MimeType)
+		assert.Equal(t, int64(len(file.Data)), file.Size)
+		assert.Contains(t, string(file.Data), "--timeout\n5\n")
+		assert.Contains(t, string(file.Data), "--\nhttps://example.com/docs/guide.html?a=1&b=2\n</html>", "the URL is passed last, after the end of the flags")
+	})
+
+	t.Run("options override the timeout", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(writeFakeBrowser(t, writeArgs))
+
+		file, err := tool.ArchiveWithOptions("https://example.com/", "text/html", ArchiveOptions{Timeout: 30 * time.Second})
+		require.NoError(t, err)
+		assert.Equal(t, "example.com.html", file.Filename)
+		assert.Contains(t, string(file.Data), "--timeout\n30\n")
+
+		tool.SetBinaryPath(writeFakeBrowser(t, "exec sleep 5\n"))
+		_, err = tool.ArchiveWithOptions("https://example.com/", "text/html", ArchiveOptions{Timeout: 100 * time.Millisecond})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timeout")
+	})
+
+	t.Run("size limit is honored", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(writeFakeBrowser(t, writeArgs))
+
+		_, err := tool.ArchiveWithOptions("https://example.com/", "text/html", ArchiveOptions{MaxBytes: 10})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size 10")
+	})
+
+	t.Run("monolith failure is reported", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(writeFakeBrowser(t, "echo 'could not retrieve target document' >&2\nexit 1\n"))
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not retrieve target document")
+	})
+
+	t.Run("missing binary is reported", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(filepath.Join(t.TempDir(), "missing"))
+
+		_, err := tool.Archive("https://example.com/", "text/html")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not found, install monolith")
+	})
+
+	t.Run("non web URLs are rejected", func(t *testing.T) {
+		tool := NewMonolith(5 * time.Second)
+		tool.SetBinaryPath(writeFakeBrowser(t, writeArgs))
+
+		for _, url := range []string{"file:///etc/passwd", "--base-url=https://evil.example.com", "https:///no-host"} {
+			_, err := tool.Archive(url, "text/html")
+			require.Error(t, err, url)
+			assert.Contains(t, err.Error(), "invalid URL for monolith")
+		}
+	})
+}
@@ -1,12 +1,22 @@
 package archiver
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/http"
+	nurl "net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-shiori/obelisk"
 	"github.com/pkg/errors"
+	xhtml "golang.org/x/net/html"
 )
 
 const (
@@ -16,11 +26,62 @@ const (
 	ObeliskDefaultTimeout = 60 * time.Second
 	// ObeliskMaxFileSize is the maximum file size for archived HTML (50MB)
 	ObeliskMaxFileSize = 50 * 1024 * 1024
+	// ObeliskFirstPartyToolName is the name of the obelisk variant that only embeds first-party resources
+	ObeliskFirstPartyToolName = "obelisk_first_party"
+	// ObeliskGentleToolName is the name of the obelisk variant that downloads resources one at a time
+	ObeliskGentleToolName = "obelisk_gentle"
+	// ObeliskGentleRequestDelay is the minimum delay between the requests of the gentle variant
+	ObeliskGentleRequestDelay = time.Second
+	// ObeliskMaxRequestDelay bounds the configurable delay between requests
+	ObeliskMaxRequestDelay = 10 * time.Second
+	// obeliskMaxConcurrentDownloads is the number of resources downloaded at the same time
+	obeliskMaxConcurrentDownloads = 5
+
+	// ObeliskResourcePolicyAll embeds every resource referenced by the page
+	ObeliskResourcePolicyAll = "all"
+	// ObeliskResourcePolicyFirstPartyOnly embeds resources from the page's own site and
+	// replaces cross-origin resources with empty placeholders
+	ObeliskResourcePolicyFirstPartyOnly = "first-party-only"
+	// ObeliskExtensionObelisk is the default output extension, recognized by the inline preview
+	ObeliskExtensionObelisk = ".obelisk.html"
+	// ObeliskExtensionHTML saves archives as plain .html files
+	ObeliskExtensionHTML = ".html"
+	// ObeliskExtensionMHTML saves archives as single-part MHTML web archives
+	ObeliskExtensionMHTML = ".mhtml"
+	// maxFilenameLength is the maximum length of a filename derived from a page title or URL
+	maxFilenameLength = 100
 )
 
+// ObeliskOptions controls which resources obelisk embeds in archived pages
+type ObeliskOptions struct {
+	DisableJS      bool
+	DisableCSS     bool
+	DisableEmbeds  bool
+	DisableMedias  bool
+	ResourcePolicy string
+	// Extension is the output file extension, one of the ObeliskExtension constants
+	Extension string
+	// TitleFilename names archives after the page <title> instead of the URL
+	TitleFilename bool
+	// RequestDelay is the minimum time between the starts of two requests to the archived site
+	RequestDelay time.Duration
+	// MaxResources bounds the resources downloaded for a page, zero for no limit. Resources past
+	// the limit are replaced with empty placeholders.
+	MaxResources int
+}
+
 // Obelisk implements the ArchivalTool interface for archiving HTML pages
 type Obelisk struct {
+	name    string
 	timeout time.Duration
+	// maxConcurrentDownloads is the number of resources downloaded at the same time
+	maxConcurrentDownloads int64
+
+	optionsLock sync.RWMutex
+	options     ObeliskOptions
+
+	// acceptLanguage is sent with the requests of the page and its resources
+	acceptLanguage AcceptLanguage
 }
 
 // NewObelisk creates a new obelisk archival tool
@@ -30,35 +91,140 @@ func NewObelisk(timeout time.Duration) *Obelisk {
 	}
 
 	return &Obelisk{
-		timeout: timeout,
+		name:                   ObeliskToolName,
+		timeout:                timeout,
+		maxConcurrentDownloads: obeliskMaxConcurrentDownloads,
+		options: ObeliskOptions{
+			ResourcePolicy: ObeliskResourcePolicyAll,
+		},
+	}
+}
+
+// NewObeliskFirstParty creates an obelisk archival tool that always uses the
+// first-party-only resource policy, regardless of the configured policy
+func NewObeliskFirstParty(timeout time.Duration) *Obelisk {
+	o := NewObelisk(timeout)
+	o.name = ObeliskFirstPartyToolName
+	o.options.ResourcePolicy = ObeliskResourcePolicyFirstPartyOnly
+	return o
+}
+
+// NewObeliskGentle creates an obelisk archival tool for fragile sites: resources are downloaded one
+// at a time, with at least ObeliskGentleRequestDelay between requests
+func NewObeliskGentle(timeout time.Duration) *Obelisk {
+	o := NewObelisk(timeout)
+	o.name = ObeliskGentleToolName
+	o.maxConcurrentDownloads = 1
+	o.options.RequestDelay = ObeliskGentleRequestDelay
+	return o
+}
+
+// SetAcceptLanguage sets the Accept-Language header sent when archiving pages, empty to not send it
+func (o *Obelisk) SetAcceptLanguage(value string) {
+	o.acceptLanguage.Set(value)
+}
+
+// SetOptions replaces the options used for subsequent archives
+func (o *Obelisk) SetOptions(options ObeliskOptions) {
+	if options.ResourcePolicy == "" {
+		options.ResourcePolicy = ObeliskResourcePolicyAll
+	}
+	// The first-party variant keeps its policy so rules selecting it stay privacy-preserving
+	if o.name == ObeliskFirstPartyToolName {
+		options.ResourcePolicy = ObeliskResourcePolicyFirstPartyOnly
 	}
+	if options.RequestDelay > ObeliskMaxRequestDelay {
+		options.RequestDelay = ObeliskMaxRequestDelay
+	}
+	// The gentle variant never goes below its own delay so rules selecting it stay gentle
+	if o.name == ObeliskGentleToolName && options.RequestDelay < ObeliskGentleRequestDelay {
+		options.RequestDelay = ObeliskGentleRequestDelay
+	}
+
+	o.optionsLock.Lock()
+	defer o.optionsLock.Unlock()
+	o.options = options
+}
+
+// getOptions returns a copy of the current options
+func (o *Obelisk) getOptions() ObeliskOptions {
+	o.optionsLock.RLock()
+	defer o.optionsLock.RUnlock()
+	return o.options
 }
 
 // Name returns the name of this archival tool
 func (o *Obelisk) Name() string {
-	return ObeliskToolName
+	return o.name
+}
+
+// Describe describes the variant of the tool and its timeout
+func (o *Obelisk) Describe() ToolDescriptor {
+	displayName := "Obelisk"
+	description := "Saves pages as a single HTML file with their resources embedded"
+	switch o.name {
+	case ObeliskFirstPartyToolName:
+		displayName = "Obelisk (first-party resources)"
+		description = "Saves pages as a single HTML file, only embedding resources of the page's own site"
+	case ObeliskGentleToolName:
+		displayName = "Obelisk (gentle)"
+		description = "Saves pages as a single HTML file, downloading their resources one at a time for fragile sites"
+	}
+	return ToolDescriptor{
+		Name:            o.name,
+		DisplayName:     displayName,
+		Description:     description,
+		MimeTypes:       []string{"text/html", "application/xhtml+xml"},
+		TimeoutSeconds:  int(o.timeout.Seconds()),
+		MaxBytes:        ObeliskMaxFileSize,
+		RequiresNetwork: true,
+	}
 }
 
 // Archive archives an HTML page from the given URL using obelisk
 func (o *Obelisk) Archive(url, mimeType string) (*ArchivedFile, error) {
+	return o.ArchiveWithOptions(url, mimeType, ArchiveOptions{})
+}
+
+// ArchiveWithOptions archives an HTML page like Archive, in the language of the options if set
+func (o *Obelisk) ArchiveWithOptions(url, mimeType string, archiveOptions ArchiveOptions) (*ArchivedFile, error) {
+	options := o.getOptions()
+	acceptLanguage := o.acceptLanguage.Get(archiveOptions.AcceptLanguage)
+	timeout := archiveOptions.ArchiveTimeout(o.timeout)
+	start := time.Now()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Create a new archiver instance
 	archiver := &obelisk.Archiver{
-		RequestTimeout:        o.timeout,
-		MaxConcurrentDownload: 5,
-		DisableJS:             false,
-		DisableCSS:            false,
-		DisableEmbeds:         false,
-		DisableMedias:         false,
+		RequestTimeout:        timeout,
+		MaxConcurrentDownload: o.maxConcurrentDownloads,
+		DisableJS:             options.DisableJS,
+		DisableCSS:            options.DisableCSS,
+		DisableEmbeds:         options.DisableEmbeds,
+		DisableMedias:         options.DisableMedias,
 		SkipResourceURLError:  true, // Ignore DNS errors and other resource URL errors
 	}
 
+	// Space out requests and bound the resources downloaded. The requests are bound to the archive's
+	// context, obelisk doesn't cancel them itself, so the timeout also bounds slow throttled archives.
+	var transport http.RoundTripper = newThrottledTransport(ctx, http.DefaultTransport, options.RequestDelay, options.MaxResources)
+	if acceptLanguage != "" {
+		transport = &acceptLanguageTransport{next: transport, value: acceptLanguage}
+	}
+
+	// Restrict resource fetching to the page's own site if requested
+	if options.ResourcePolicy == ObeliskResourcePolicyFirstPartyOnly {
+		transport = newFirstPartyTransport(transport, url, o.resolveFinalURL(url, acceptLanguage, timeout))
+	}
+	pageStatus := &pageStatusTransport{next: transport}
+	archiver.Transport = pageStatus
+
 	// Validate the archiver configuration
 	archiver.Validate()
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
-	defer cancel()
-
 	// Create request
 	req := obelisk.Request{
 		URL: url,
@@ -80,10 +246,17 @@ func (o *Obelisk) Archive(url, mimeType string) (*ArchivedFile, error) {
 		return nil, errors.Errorf("archived page size %d exceeds maximum allowed size %d", len(data), ObeliskMaxFileSize)
 	}
 
-	// Generate filename from URL
-	filename := o.extractFilename(url)
+	// Generate filename from the page title if requested, falling back to the URL
+	title := HTMLTitle(data)
+	filename := ""
+	if options.TitleFilename {
+		filename = sanitizeFilename(title)
+	}
 	if filename == "" {
-		filename = "archived_page.obelisk.html"
+		filename = o.extractFilename(url)
+	}
+	if filename == "" {
+		filename = "archived_page"
 	} else {
 		// Remove existing .html or .htm extension if present
 		if hasExtension(filename, ".html") {
@@ -91,8 +264,6 @@ func (o *Obelisk) Archive(url, mimeType string) (*ArchivedFile, error) {
 		} else if hasExtension(filename, ".htm") {
 			filename = filename[:len(filename)-4]
 		}
-		// Add .obelisk.html extension
-		filename += ".obelisk.html"
 	}
 
 	// Use content type from obelisk if available, otherwise default to text/html
@@ -101,11 +272,25 @@ func (o *Obelisk) Archive(url, mimeType string) (*ArchivedFile, error) {
 		resultMimeType = contentType
 	}
 
+	// Add the configured extension
+	switch options.Extension {
+	case ObeliskExtensionHTML:
+		filename += ObeliskExtensionHTML
+	case ObeliskExtensionMHTML:
+		filename += ObeliskExtensionMHTML
+		data = wrapMHTML(data, resultMimeType, url, title, time.Now())
+		resultMimeType = "multipart/related"
+	default:
+		filename += ObeliskExtensionObelisk
+	}
+
 	return &ArchivedFile{
-		Filename: filename,
-		Data:     data,
-		MimeType: resultMimeType,
-		Size:     int64(len(data)),
+		Filename:   filename,
+		Data:       data,
+		MimeType:   resultMimeType,
+		Size:       int64(len(data)),
+		StatusCode: int(pageStatus.status.Load()),
+		Duration:   time.Since(start),
 	}, nil
 }
 
@@ -145,7 +330,266 @@ func (o *Obelisk) extractFilename(url string) string {
 	return "index.html"
 }
 
+// HTMLTitle returns the text of the first <title> element of an HTML document, empty if it has none
+func HTMLTitle(data []byte) string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(data))
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "title" {
+				inTitle = true
+			}
+		case xhtml.TextToken:
+			if inTitle {
+				return strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			}
+		case xhtml.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "title" {
+				return ""
+			}
+		}
+	}
+}
+
+// sanitizeFilename makes a page title or URL part usable as a filename by replacing characters
+// that aren't allowed in filenames on common systems and bounding its length
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		default:
+			return r
+		}
+	}, name)
+	name = strings.Trim(strings.Join(strings.Fields(name), " "), ". ")
+
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = strings.TrimSpace(string(runes[:maxFilenameLength]))
+	}
+	return name
+}
+
+// wrapMHTML packages an HTML document with its resources already inlined as a single-part MHTML archive
+func wrapMHTML(data []byte, contentType, url, title string, date time.Time) []byte {
+	boundary := "----MultipartBoundary--" + strings.ReplaceAll(date.UTC().Format("20060102150405.000000000"), ".", "")
+
+	var b bytes.Buffer
+	b.WriteString("From: <Saved by Mattermost Link Archiver>\r\n")
+	fmt.Fprintf(&b, "Snapshot-Content-Location: %s\r\n", url)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/related;\r\n\ttype=\"text/html\";\r\n\tboundary=\"%s\"\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	fmt.Fprintf(&b, "Content-Location: %s\r\n\r\n", url)
+
+	writer := quotedprintable.NewWriter(&b)
+	_, _ = writer.Write(data)
+	_ = writer.Close()
+
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
 // hasExtension checks if a filename has a specific extension
 func hasExtension(filename, ext string) bool {
 	return len(filename) >= len(ext) && filename[len(filename)-len(ext):] == ext
 }
+
+// resolveFinalURL follows redirects for the given URL and returns the URL the page is served from.
+// Returns the original URL if it can't be resolved.
+func (o *Obelisk) resolveFinalURL(url, acceptLanguage string, timeout time.Duration) string {
+	client := &http.Client{Timeout: timeout}
+	req, err := http.NewRequest(http.MethodHead, url, http.NoBody)
+	if err != nil {
+		return url
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	// Localized sites may redirect to the page of the language
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return url
+	}
+	defer resp.Body.Close()
+
+	return resp.Request.URL.String()
+}
+
+// firstPartyTransport is an http.RoundTripper that only performs requests to the sites
+// of the archived page. Cross-origin requests are answered with an empty response so
+// obelisk embeds an empty placeholder instead of the third-party resource.
+type firstPartyTransport struct {
+	next  http.RoundTripper
+	sites []string
+}
+
+// newFirstPartyTransport creates a transport allowing the sites of the given page URLs
+func newFirstPartyTransport(next http.RoundTripper, pageURLs ...string) *firstPartyTransport {
+	t := &firstPartyTransport{next: next}
+	for _, pageURL := range pageURLs {
+		parsedURL, err := nurl.Parse(pageURL)
+		if err != nil || parsedURL.Hostname() == "" {
+			continue
+		}
+		t.sites = append(t.sites, siteForHost(parsedURL.Hostname()))
+	}
+	return t
+}
+
+// RoundTrip performs first-party requests and short-circuits cross-origin ones
+func (t *firstPartyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.isFirstParty(req.URL.Hostname()) {
+		return t.next.RoundTrip(req)
+	}
+
+	return emptyResourceResponse(req), nil
+}
+
+// emptyResourceResponse returns an empty response, embedded by obelisk as an empty placeholder
+func emptyResourceResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		Body:          io.NopCloser(strings.NewReader("")),
+		ContentLength: 0,
+		Request:       req,
+	}
+}
+
+// isFirstParty checks if a host belongs to one of the allowed sites (including subdomains)
+func (t *firstPartyTransport) isFirstParty(host string) bool {
+	host = strings.ToLower(host)
+	for _, site := range t.sites {
+		if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+	}
+	return false
+}
+
+// siteForHost returns the site a host belongs to, ignoring a leading "www." label
+func siteForHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// pageStatusTransport is an http.RoundTripper recording the status of the archived page. Obelisk
+// fetches the page before its resources, so the first response that isn't a redirect is the page's.
+type pageStatusTransport struct {
+	next   http.RoundTripper
+	status atomic.Int32
+}
+
+// RoundTrip performs the request and records the status of the page's response
+func (t *pageStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && (resp.StatusCode < 300 || resp.StatusCode >= 400 || resp.Header.Get("Location") == "") {
+		t.status.CompareAndSwap(0, int32(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// throttledTransport is an http.RoundTripper spacing out the requests of an archive and bounding
+// the number of resources it downloads. Requests are bound to the context of the archive.
+type throttledTransport struct {
+	next         http.RoundTripper
+	ctx          context.Context
+	delay        time.Duration
+	maxResources int
+
+	lock      sync.Mutex
+	nextStart time.Time // Earliest start of the next request
+	downloads int       // GET requests performed, including the page itself
+}
+
+// newThrottledTransport creates a transport waiting delay between requests and downloading up to
+// maxResources resources besides the page, zero for no limit
+func newThrottledTransport(ctx context.Context, next http.RoundTripper, delay time.Duration, maxResources int) *throttledTransport {
+	return &throttledTransport{
+		next:         next,
+		ctx:          ctx,
+		delay:        delay,
+		maxResources: maxResources,
+	}
+}
+
+// RoundTrip waits for the request's turn and performs it, or short-circuits it once the resource
+// limit has been reached
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	if req.Method == http.MethodGet {
+		// The first download is the page itself
+		if t.maxResources > 0 && t.downloads > t.maxResources {
+			t.lock.Unlock()
+			return emptyResourceResponse(req), nil
+		}
+		t.downloads++
+	}
+	now := time.Now()
+	wait := t.nextStart.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	t.nextStart = now.Add(wait + t.delay)
+	t.lock.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			timer.Stop()
+			return nil, t.ctx.Err()
+		}
+	}
+
+	// Keep the request's own context, it carries the per-request timeout of the client. The context
+	// is released once the response is read, so finished requests don't last until the archive ends.
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.ctx, cancel)
+	release := func() {
+		stop()
+		cancel()
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody is a response body releasing the context of its request when closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes the body and releases the context of the request
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,283 @@
+package archiver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstPartyTransportIsFirstParty(t *testing.T) {
+	transport := newFirstPartyTransport(http.DefaultTransport, "https://www.example.com/article", "https://news.example.org/a")
+
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"www.example.com", true},
+		{"example.com", true},
+		{"static.example.com", true},
+		{"WWW.EXAMPLE.COM", true},
+		{"news.example.org", true},
+		{"cdn.news.example.org", true},
+		{"example.org", false},
+		{"tracker.com", false},
+		{"example.com.evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			assert.Equal(t, tt.expected, transport.isFirstParty(tt.host))
+		})
+	}
+}
+
+func TestFirstPartyTransportRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = w.Write([]byte("body{}"))
+	}))
+	defer server.Close()
+
+	transport := newFirstPartyTransport(http.DefaultTransport, server.URL)
+	client := &http.Client{Transport: transport}
+
+	t.Run("first-party request is performed", func(t *testing.T) {
+		resp, err := client.Get(server.URL + "/style.css")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "body{}", string(body))
+	})
+
+	t.Run("cross-origin request returns empty placeholder", func(t *testing.T) {
+		resp, err := client.Get("http://tracker.invalid/pixel.gif")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, body)
+	})
+}
+
+func TestObeliskFirstPartyKeepsPolicy(t *testing.T) {
+	tool := NewObeliskFirstParty(0)
+	tool.SetOptions(ObeliskOptions{ResourcePolicy: ObeliskResourcePolicyAll, DisableJS: true})
+
+	options := tool.getOptions()
+	assert.Equal(t, ObeliskFirstPartyToolName, tool.Name())
+	assert.Equal(t, ObeliskResourcePolicyFirstPartyOnly, options.ResourcePolicy)
+	assert.True(t, options.DisableJS)
+}
+
+func TestObeliskGentleKeepsDelay(t *testing.T) {
+	tool := NewObeliskGentle(0)
+	assert.Equal(t, ObeliskGentleToolName, tool.Name())
+	assert.Equal(t, int64(1), tool.maxConcurrentDownloads)
+
+	tool.SetOptions(ObeliskOptions{RequestDelay: 100 * time.Millisecond, MaxResources: 20})
+	options := tool.getOptions()
+	assert.Equal(t, ObeliskGentleRequestDelay, options.RequestDelay)
+	assert.Equal(t, 20, options.MaxResources)
+
+	tool.SetOptions(ObeliskOptions{RequestDelay: time.Hour})
+	assert.Equal(t, ObeliskMaxRequestDelay, tool.getOptions().RequestDelay)
+}
+
+// roundTripFunc is an http.RoundTripper calling a function
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestThrottledTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = w.Write([]byte("body{}"))
+	}))
+	defer server.Close()
+
+	get := func(t *testing.T, client *http.Client) (string, error) {
+		resp, err := client.Get(server.URL + "/style.css")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body), nil
+	}
+
+	t.Run("requests are spaced out", func(t *testing.T) {
+		delay := 50 * time.Millisecond
+		client := &http.Client{Transport: newThrottledTransport(context.Background(), http.DefaultTransport, delay, 0)}
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			_, err := get(t, client)
+			require.NoError(t, err)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 2*delay)
+	})
+
+	t.Run("resources past the limit are placeholders", func(t *testing.T) {
+		client := &http.Client{Transport: newThrottledTransport(context.Background(), http.DefaultTransport, 0, 2)}
+
+		// The page and two resources are downloaded
+		for i := 0; i < 3; i++ {
+			body, err := get(t, client)
+			require.NoError(t, err)
+			assert.Equal(t, "body{}", body)
+		}
+		body, err := get(t, client)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+	})
+
+	t.Run("request contexts are released once responses are closed", func(t *testing.T) {
+		var requestCtx context.Context
+		next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requestCtx = req.Context()
+			return http.DefaultTransport.RoundTrip(req)
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := &http.Client{Transport: newThrottledTransport(ctx, next, 0, 0)}
+
+		resp, err := client.Get(server.URL + "/style.css")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "body{}", string(body))
+		assert.NoError(t, requestCtx.Err(), "the context lasts while the body is read")
+
+		require.NoError(t, resp.Body.Close())
+		assert.ErrorIs(t, requestCtx.Err(), context.Canceled)
+		assert.NoError(t, ctx.Err(), "the archive goes on")
+	})
+
+	t.Run("the archive timeout bounds waiting requests", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		client := &http.Client{Transport: newThrottledTransport(ctx, http.DefaultTransport, time.Hour, 0)}
+
+		_, err := get(t, client)
+		require.NoError(t, err)
+
+		start := time.Now()
+		_, err = get(t, client)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+
+		// Requests fail right away once the archive timed out
+		_, err = get(t, client)
+		assert.Error(t, err)
+	})
+}
+
+func TestObeliskOutputNaming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><head><title>Quarterly Report: Q1/Q2</title></head><body><p>Hello</p></body></html>`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		options  ObeliskOptions
+		filename string
+	}{
+		{"default extension", ObeliskOptions{}, "report.obelisk.html"},
+		{"html extension", ObeliskOptions{Extension: ObeliskExtensionHTML}, "report.html"},
+		{"title filename", ObeliskOptions{TitleFilename: true}, "Quarterly Report_ Q1_Q2.obelisk.html"},
+		{"mhtml extension", ObeliskOptions{Extension: ObeliskExtensionMHTML}, "report.mhtml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := NewObelisk(0)
+			tool.SetOptions(tt.options)
+
+			file, err := tool.Archive(server.URL+"/docs/report.html", "text/html")
+			require.NoError(t, err)
+			assert.Equal(t, tt.filename, file.Filename)
+			if tt.options.Extension == ObeliskExtensionMHTML {
+				assert.Equal(t, "multipart/related", file.MimeType)
+				assert.Contains(t, string(file.Data), "Snapshot-Content-Location: "+server.URL+"/docs/report.html")
+				assert.Contains(t, string(file.Data), "Content-Transfer-Encoding: quoted-printable")
+			} else {
+				assert.Contains(t, string(file.Data), "<p>Hello</p>")
+			}
+		})
+	}
+}
+
+func TestObeliskAcceptLanguage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			_, _ = w.Write([]byte("/* " + r.Header.Get("Accept-Language") + " */"))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css"></head><body><p>lang:` + r.Header.Get("Accept-Language") + `</p></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	tool := NewObelisk(0)
+	tool.SetAcceptLanguage("es-ES")
+
+	file, err := tool.Archive(server.URL+"/page", "text/html")
+	require.NoError(t, err)
+	assert.Contains(t, string(file.Data), "lang:es-ES")
+	assert.Contains(t, string(file.Data), "/* es-ES */", "resources are requested in the language too")
+
+	file, err = tool.ArchiveWithOptions(server.URL+"/page", "text/html", ArchiveOptions{AcceptLanguage: "ja"})
+	require.NoError(t, err)
+	assert.Contains(t, string(file.Data), "lang:ja")
+}
+
+func TestHTMLTitle(t *testing.T) {
+	assert.Equal(t, "My Page", HTMLTitle([]byte("<html><head><title>\n  My   Page \n</title></head></html>")))
+	assert.Equal(t, "", HTMLTitle([]byte("<html><head></head><body>No title</body></html>")))
+	assert.Equal(t, "", HTMLTitle([]byte("<title></title>")))
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "a_b_c", sanitizeFilename("a/b\\c"))
+	assert.Equal(t, "Title", sanitizeFilename("  ..Title.. "))
+	assert.Equal(t, "", sanitizeFilename("\x00\x1f"))
+	assert.Len(t, []rune(sanitizeFilename(strings.Repeat("é", 300))), maxFilenameLength)
+}
+
+func TestObeliskStatusAndDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+		case "/style.css":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css"></head><body><p>Hello</p></body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	file, err := NewObelisk(0).Archive(server.URL+"/old", "text/html")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, file.StatusCode, "the status of the page, not of the redirect or its resources")
+	assert.Positive(t, file.Duration)
+}
@@ -0,0 +1,284 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// OGSnapshotToolName is the name of the OpenGraph snapshot archival tool
+	OGSnapshotToolName = "og_snapshot"
+	// OGSnapshotDefaultTimeout is the default timeout for OpenGraph snapshots
+	OGSnapshotDefaultTimeout = 20 * time.Second
+	// OGSnapshotMaxHTMLSize is the maximum size of the page fetched to read its metadata (2MB)
+	OGSnapshotMaxHTMLSize = 2 * 1024 * 1024
+	// OGSnapshotMaxImageSize is the maximum size of the embedded preview image (5MB)
+	OGSnapshotMaxImageSize = 5 * 1024 * 1024
+)
+
+// pageMetadata holds the link preview metadata of a page
+type pageMetadata struct {
+	Title       string
+	Description string
+	SiteName    string
+	ImageURL    string
+	URL         string
+}
+
+// OGSnapshot implements the ArchivalTool interface by storing a small HTML card
+// built from the OpenGraph/Twitter Card metadata of a page
+type OGSnapshot struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewOGSnapshot creates a new OpenGraph snapshot archival tool
+func NewOGSnapshot(timeout time.Duration) *OGSnapshot {
+	if timeout == 0 {
+		timeout = OGSnapshotDefaultTimeout
+	}
+
+	return &OGSnapshot{
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		timeout: timeout,
+	}
+}
+
+// Name returns the name of this archival tool
+func (o *OGSnapshot) Name() string {
+	return OGSnapshotToolName
+}
+
+// Describe describes the tool, its size limit is the one of the preview image
+func (o *OGSnapshot) Describe() ToolDescriptor {
+	return ToolDescriptor{
+		Name:            OGSnapshotToolName,
+		DisplayName:     "OpenGraph snapshot",
+		Description:     "Saves a lightweight preview of pages from their OpenGraph title, description and image",
+		MimeTypes:       []string{"text/html", "application/xhtml+xml"},
+		TimeoutSeconds:  int(o.timeout.Seconds()),
+		MaxBytes:        OGSnapshotMaxImageSize,
+		RequiresNetwork: true,
+	}
+}
+
+// Archive fetches the page metadata and stores it as a self-contained HTML card
+func (o *OGSnapshot) Archive(url, mimeType string) (*ArchivedFile, error) {
+	page, err := o.fetch(url, OGSnapshotMaxHTMLSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download page")
+	}
+
+	metadata := parsePageMetadata(page)
+	if metadata.Title == "" && metadata.Description == "" && metadata.ImageURL == "" {
+		return nil, errors.New("page has no link preview metadata")
+	}
+
+	// Embed the preview image so the card works offline. A missing image doesn't fail the snapshot.
+	imageDataURL := ""
+	if metadata.ImageURL != "" {
+		if imageURL, resolveErr := resolveReference(url, metadata.ImageURL); resolveErr == nil {
+			if image, fetchErr := o.fetch(imageURL, OGSnapshotMaxImageSize); fetchErr == nil && len(image) > 0 {
+				imageType := http.DetectContentType(image)
+				if strings.HasPrefix(imageType, "image/") {
+					imageDataURL = "data:" + imageType + ";base64," + base64.StdEncoding.EncodeToString(image)
+				}
+			}
+		}
+	}
+
+	data := []byte(renderPageCard(url, metadata, imageDataURL))
+
+	return &ArchivedFile{
+		Filename: snapshotFilename(url),
+		Data:     data,
+		MimeType: "text/html",
+		Size:     int64(len(data)),
+	}, nil
+}
+
+// fetch downloads a URL, failing if the body exceeds maxSize
+func (o *OGSnapshot) fetch(url string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, http.NoBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GET request")
+	}
+
+	// Set a reasonable User-Agent
+	req.Header.Set("User-Agent", DefaultUserAgent)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "GET request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, errors.Errorf("file size %d exceeds maximum allowed size %d", resp.ContentLength, maxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read response body")
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, errors.Errorf("file size exceeds maximum allowed size %d", maxSize)
+	}
+
+	return data, nil
+}
+
+// parsePageMetadata extracts OpenGraph and Twitter Card metadata from an HTML page,
+// falling back to the <title> and description meta tags
+func parsePageMetadata(page []byte) pageMetadata {
+	var metadata pageMetadata
+	var fallbackTitle, fallbackDescription, twitterTitle, twitterDescription, twitterImage string
+
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(page))
+	inTitle := false
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case xhtml.ErrorToken:
+			metadata.Title = firstNonEmpty(metadata.Title, twitterTitle, fallbackTitle)
+			metadata.Description = firstNonEmpty(metadata.Description, twitterDescription, fallbackDescription)
+			metadata.ImageURL = firstNonEmpty(metadata.ImageURL, twitterImage)
+			return metadata
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = tokenType == xhtml.StartTagToken
+			case "meta":
+				key, content := "", ""
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property", "name":
+						key = strings.ToLower(strings.TrimSpace(attr.Val))
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					metadata.Title = firstNonEmpty(metadata.Title, content)
+				case "og:description":
+					metadata.Description = firstNonEmpty(metadata.Description, content)
+				case "og:image", "og:image:url", "og:image:secure_url":
+					metadata.ImageURL = firstNonEmpty(metadata.ImageURL, content)
+				case "og:site_name":
+					metadata.SiteName = firstNonEmpty(metadata.SiteName, content)
+				case "og:url":
+					metadata.URL = firstNonEmpty(metadata.URL, content)
+				case "twitter:title":
+					twitterTitle = firstNonEmpty(twitterTitle, content)
+				case "twitter:description":
+					twitterDescription = firstNonEmpty(twitterDescription, content)
+				case "twitter:image", "twitter:image:src":
+					twitterImage = firstNonEmpty(twitterImage, content)
+				case "description":
+					fallbackDescription = firstNonEmpty(fallbackDescription, content)
+				}
+			}
+		case xhtml.TextToken:
+			if inTitle && fallbackTitle == "" {
+				fallbackTitle = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case xhtml.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+// renderPageCard renders the metadata as a standalone HTML document
+func renderPageCard(url string, metadata pageMetadata, imageDataURL string) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(firstNonEmpty(metadata.Title, url)))
+	b.WriteString("<style>body{font-family:sans-serif;max-width:640px;margin:40px auto;padding:0 16px;color:#3d3c40}" +
+		".card{border:1px solid #ddd;border-radius:8px;overflow:hidden}.card img{width:100%;display:block}" +
+		".content{padding:16px}.site{color:#888;font-size:13px;text-transform:uppercase}" +
+		"h1{font-size:20px;margin:8px 0}p{line-height:1.5}a{word-break:break-all}</style>\n")
+	b.WriteString("</head>\n<body>\n<div class=\"card\">\n")
+	if imageDataURL != "" {
+		fmt.Fprintf(&b, "<img src=\"%s\" alt=\"\">\n", imageDataURL)
+	}
+	b.WriteString("<div class=\"content\">\n")
+	if metadata.SiteName != "" {
+		fmt.Fprintf(&b, "<div class=\"site\">%s</div>\n", html.EscapeString(metadata.SiteName))
+	}
+	if metadata.Title != "" {
+		fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(metadata.Title))
+	}
+	if metadata.Description != "" {
+		fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(metadata.Description))
+	}
+	fmt.Fprintf(&b, "<p><a href=\"%s\">%s</a></p>\n", html.EscapeString(url), html.EscapeString(url))
+	fmt.Fprintf(&b, "<p class=\"site\">Captured %s</p>\n", time.Now().UTC().Format(time.RFC1123))
+	b.WriteString("</div>\n</div>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// snapshotFilename generates the filename for a snapshot from the page URL
+func snapshotFilename(url string) string {
+	name := "snapshot"
+	if parsedURL, err := nurl.Parse(url); err == nil {
+		segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+		if last := segments[len(segments)-1]; last != "" {
+			name = last
+		} else if parsedURL.Hostname() != "" {
+			name = parsedURL.Hostname()
+		}
+	}
+
+	// Remove existing .html or .htm extension if present
+	if hasExtension(name, ".html") {
+		name = name[:len(name)-5]
+	} else if hasExtension(name, ".htm") {
+		name = name[:len(name)-4]
+	}
+
+	return name + ".og.html"
+}
+
+// resolveReference resolves a possibly relative reference against a base URL
+func resolveReference(base, ref string) (string, error) {
+	baseURL, err := nurl.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := nurl.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
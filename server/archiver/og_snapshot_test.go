@@ -0,0 +1,82 @@
+package archiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePageMetadata(t *testing.T) {
+	t.Run("opengraph tags", func(t *testing.T) {
+		page := `<html><head><title>Fallback</title>
+			<meta property="og:title" content="OG Title">
+			<meta property="og:description" content="OG description">
+			<meta property="og:image" content="/img/cover.png">
+			<meta property="og:site_name" content="Example News">
+			<meta name="twitter:title" content="Twitter Title">
+		</head></html>`
+
+		metadata := parsePageMetadata([]byte(page))
+		assert.Equal(t, "OG Title", metadata.Title)
+		assert.Equal(t, "OG description", metadata.Description)
+		assert.Equal(t, "/img/cover.png", metadata.ImageURL)
+		assert.Equal(t, "Example News", metadata.SiteName)
+	})
+
+	t.Run("twitter card tags", func(t *testing.T) {
+		page := `<head><meta name="twitter:title" content="Twitter Title"><meta name="twitter:image" content="https://cdn.example.com/a.jpg"></head>`
+
+		metadata := parsePageMetadata([]byte(page))
+		assert.Equal(t, "Twitter Title", metadata.Title)
+		assert.Equal(t, "https://cdn.example.com/a.jpg", metadata.ImageURL)
+	})
+
+	t.Run("title and description fallback", func(t *testing.T) {
+		page := `<head><title> Plain Title </title><meta name="description" content="Plain description"></head>`
+
+		metadata := parsePageMetadata([]byte(page))
+		assert.Equal(t, "Plain Title", metadata.Title)
+		assert.Equal(t, "Plain description", metadata.Description)
+	})
+}
+
+func TestOGSnapshotArchive(t *testing.T) {
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(pngHeader)
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(strings.Repeat("a", OGSnapshotMaxHTMLSize+1)))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<head><meta property="og:title" content="A &lt;b&gt; title"><meta property="og:image" content="/cover.png"></head>`))
+		}
+	}))
+	defer server.Close()
+
+	tool := NewOGSnapshot(0)
+
+	t.Run("builds an HTML card with the embedded image", func(t *testing.T) {
+		archived, err := tool.Archive(server.URL+"/news/story.html", "text/html")
+		require.NoError(t, err)
+
+		assert.Equal(t, "story.og.html", archived.Filename)
+		assert.Equal(t, "text/html", archived.MimeType)
+		assert.Equal(t, int64(len(archived.Data)), archived.Size)
+		assert.Contains(t, string(archived.Data), "A &lt;b&gt; title")
+		assert.Contains(t, string(archived.Data), "data:image/png;base64,")
+	})
+
+	t.Run("rejects pages exceeding the size limit", func(t *testing.T) {
+		_, err := tool.Archive(server.URL+"/large", "text/html")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,42 @@
+package archiver
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxRedirects is the number of redirects followed by a request when no limit is configured
+const DefaultMaxRedirects = 10
+
+// ErrTooManyRedirects is returned by requests redirected more times than allowed, like links
+// redirecting in a loop
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// RedirectLimit bounds the redirects followed by the requests of an HTTP client, so a server
+// redirecting in a loop fails the request right away instead of holding it until it times out
+type RedirectLimit struct {
+	max atomic.Int64
+}
+
+// Set sets the number of redirects followed by a request, zero or less uses DefaultMaxRedirects
+func (r *RedirectLimit) Set(maxRedirects int) {
+	r.max.Store(int64(maxRedirects))
+}
+
+// Max returns the number of redirects followed by a request
+func (r *RedirectLimit) Max() int {
+	if limit := int(r.max.Load()); limit > 0 {
+		return limit
+	}
+	return DefaultMaxRedirects
+}
+
+// CheckRedirect is the CheckRedirect policy of HTTP clients, following redirects up to the limit
+func (r *RedirectLimit) CheckRedirect(_ *http.Request, via []*http.Request) error {
+	if limit := r.Max(); len(via) > limit {
+		return errors.Wrapf(ErrTooManyRedirects, "stopped after %d redirects", limit)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package archiver
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RequestHeader is a header sent with requests
+type RequestHeader struct {
+	Name  string
+	Value string
+}
+
+// RequestHeaders holds extra headers sent with requests, like an Accept header steering servers
+// away from their heavier responses. Header values can be secrets and must never be logged.
+type RequestHeaders struct {
+	lock    sync.RWMutex
+	headers []RequestHeader
+}
+
+// Set replaces the configured headers
+func (h *RequestHeaders) Set(headers []RequestHeader) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.headers = headers
+}
+
+// Apply sets the configured headers on the request, replacing the values it already has.
+// A header configured several times is sent with the last value.
+func (h *RequestHeaders) Apply(req *http.Request) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for _, header := range h.headers {
+		req.Header.Set(header.Name, header.Value)
+	}
+}
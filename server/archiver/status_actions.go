@@ -0,0 +1,137 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatusAction is what is done when a server answers with an unsuccessful HTTP status code
+type StatusAction string
+
+const (
+	// StatusActionFail reports the link as failed, the default for every status code
+	StatusActionFail StatusAction = "fail"
+	// StatusActionRetry repeats the request a few times before failing
+	StatusActionRetry StatusAction = "retry"
+	// StatusActionSkip doesn't archive the link and replies with a note instead of an error
+	StatusActionSkip StatusAction = "skip"
+)
+
+const (
+	// StatusRetryAttempts is the number of requests made for status codes that are retried
+	StatusRetryAttempts = 3
+	// statusRetryDelay is the wait before the first retry, doubled on every retry
+	statusRetryDelay = time.Second
+	// statusMaxRetryDelay caps the wait between retries, including the one asked by Retry-After headers
+	statusMaxRetryDelay = 10 * time.Second
+)
+
+// StatusRule is the action taken for an HTTP status code
+type StatusRule struct {
+	StatusCode int
+	Action     StatusAction
+	// Note replaces the default reply explaining why the link wasn't archived, if set
+	Note string
+}
+
+// StatusActions holds the actions taken for unsuccessful HTTP status codes, for sites using
+// status codes in unusual ways, like answering 429 to bursts of requests or 403 to bots
+type StatusActions struct {
+	lock  sync.RWMutex
+	rules []StatusRule
+	// retryDelay overrides statusRetryDelay when positive
+	retryDelay time.Duration
+}
+
+// Set replaces the configured actions
+func (s *StatusActions) Set(rules []StatusRule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rules = rules
+}
+
+// For returns the rule of a status code, failing if none is configured
+func (s *StatusActions) For(statusCode int) StatusRule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, rule := range s.rules {
+		if rule.StatusCode == statusCode {
+			return rule
+		}
+	}
+	return StatusRule{StatusCode: statusCode, Action: StatusActionFail}
+}
+
+// Do sends the request, sending it again when the server answers with a status code configured
+// to be retried. The response of the last attempt is returned, its status must still be checked.
+// Only requests without a body can be retried.
+func (s *StatusActions) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	delay := s.getRetryDelay()
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode < 400 || attempt == StatusRetryAttempts || s.For(resp.StatusCode).Action != StatusActionRetry {
+			return resp, err
+		}
+
+		wait := min(retryAfter(resp, delay), statusMaxRetryDelay)
+		resp.Body.Close()
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		delay *= 2
+		req = req.Clone(req.Context())
+	}
+}
+
+// getRetryDelay returns the wait before the first retry
+func (s *StatusActions) getRetryDelay() time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.retryDelay > 0 {
+		return s.retryDelay
+	}
+	return statusRetryDelay
+}
+
+// retryAfter returns the wait asked by the Retry-After header of a response in seconds, or the
+// default delay. HTTP dates aren't supported, they're rare on 429 and 503 responses.
+func retryAfter(resp *http.Response, defaultDelay time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultDelay
+}
+
+// sleepContext waits for the duration, or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StatusError is returned when a server answers with an unsuccessful HTTP status code
+type StatusError struct {
+	StatusCode int
+	message    string
+}
+
+// NewStatusError creates an error for a status code, its message is formatted with the status
+// code, like "download failed with status %d"
+func NewStatusError(statusCode int, format string) *StatusError {
+	return &StatusError{StatusCode: statusCode, message: fmt.Sprintf(format, statusCode)}
+}
+
+// Error returns the message of the error
+func (e *StatusError) Error() string {
+	return e.message
+}
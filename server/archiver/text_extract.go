@@ -0,0 +1,266 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// ExtractedTextMaxLength is the maximum length of the text extracted from archived files, in characters
+	ExtractedTextMaxLength = 100000
+	// pdfMaxStreamSize bounds the size of decompressed PDF content streams (10MB)
+	pdfMaxStreamSize = 10 * 1024 * 1024
+)
+
+// htmlBlockElements end a line of the text extracted from HTML pages
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "figcaption": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true, "th": true, "title": true, "tr": true, "ul": true,
+}
+
+// SupportsTextExtraction checks if plain text can be extracted from files of a MIME type
+func SupportsTextExtraction(mimeType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0])) {
+	case "text/html", "application/xhtml+xml", "application/pdf":
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtractText returns the plain text of an archived HTML page or PDF document, bounded to maxLength
+// characters, as a file named after the archived one. Returns nil when the file holds no text or
+// its type isn't supported, see SupportsTextExtraction.
+func ExtractText(file *ArchivedFile, maxLength int) *ArchivedFile {
+	if file == nil || !SupportsTextExtraction(file.MimeType) {
+		return nil
+	}
+	if maxLength <= 0 {
+		maxLength = ExtractedTextMaxLength
+	}
+
+	var text string
+	if strings.Contains(strings.ToLower(file.MimeType), "pdf") {
+		text = extractPDFText(file.Data)
+	} else {
+		text = HTMLText(file.Data)
+	}
+	if text == "" {
+		return nil
+	}
+	data := []byte(truncateText(text, maxLength))
+
+	filename := strings.TrimSuffix(file.Filename, path.Ext(file.Filename))
+	if filename == "" {
+		filename = "archive"
+	}
+	return &ArchivedFile{
+		Filename: filename + ".txt",
+		Data:     data,
+		MimeType: "text/plain",
+		Size:     int64(len(data)),
+	}
+}
+
+// HTMLText returns the visible text of an HTML page, a line per block element
+func HTMLText(page []byte) string {
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(page))
+	skip := 0 // Nesting of elements whose text isn't content
+	var text strings.Builder
+	for {
+		switch tokenType := tokenizer.Next(); tokenType {
+		case xhtml.ErrorToken:
+			return joinTextLines(text.String())
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken, xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "noscript" || tag == "template":
+				if tokenType == xhtml.StartTagToken {
+					skip++
+				} else if tokenType == xhtml.EndTagToken && skip > 0 {
+					skip--
+				}
+			case htmlBlockElements[tag]:
+				text.WriteByte('\n')
+			}
+		case xhtml.TextToken:
+			if skip == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// pdfStreamPattern finds the dictionary and the start of the data of PDF streams
+var pdfStreamPattern = regexp.MustCompile(`(?s)<<((?:[^<>]|<[^<>]*>|<<(?:[^<>]|<[^<>]*>)*>>)*)>>\s*stream\r?\n`)
+
+// extractPDFText returns the text shown by the content streams of a PDF document. It's best effort:
+// only uncompressed and Flate compressed streams are read, and text in fonts with custom encodings,
+// shown as hex strings, is skipped.
+func extractPDFText(document []byte) string {
+	var text strings.Builder
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(document, -1) {
+		dictionary := document[match[2]:match[3]]
+		end := bytes.Index(document[match[1]:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := document[match[1] : match[1]+end]
+
+		switch {
+		case bytes.Contains(dictionary, []byte("/FlateDecode")):
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still yield their text up to the error
+			stream, _ = io.ReadAll(io.LimitReader(reader, pdfMaxStreamSize))
+			reader.Close()
+		case bytes.Contains(dictionary, []byte("/Filter")):
+			// Other filters are used by images and fonts
+			continue
+		}
+
+		if bytes.Contains(stream, []byte("BT")) {
+			writePDFContentText(&text, stream)
+		}
+	}
+	return joinTextLines(text.String())
+}
+
+// writePDFContentText writes the strings shown by the text operators of a PDF content stream
+func writePDFContentText(text *strings.Builder, content []byte) {
+	var operands []string // Strings shown by the next text showing operator
+	inArray := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			value, next := readPDFString(content, i)
+			operands = append(operands, value)
+			i = next
+			continue
+		case c == '[':
+			inArray = true
+			operands = operands[:0]
+		case c == ']':
+			inArray = false
+		case c == '%':
+			// Comments run to the end of the line
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+			continue
+		case c == '<' || c == '>' || c == '{' || c == '}' || c == '/' || isPDFWhitespace(c):
+		default:
+			start := i
+			for i < len(content) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			word := string(content[start:i])
+			if word[0] == '-' && inArray && len(operands) > 0 {
+				// Large negative kerning in TJ arrays separates words
+				if len(word) > 3 {
+					operands[len(operands)-1] += " "
+				}
+			}
+			switch word {
+			case "Tj", "TJ", "'", "\"":
+				if word == "'" || word == "\"" {
+					text.WriteByte('\n')
+				}
+				text.WriteString(strings.Join(operands, ""))
+				operands = operands[:0]
+			case "Td", "TD", "T*", "ET":
+				text.WriteByte('\n')
+				operands = operands[:0]
+			default:
+				if !inArray && !strings.ContainsAny(word[:1], "-.0123456789") {
+					operands = operands[:0]
+				}
+			}
+			continue
+		}
+		i++
+	}
+}
+
+// readPDFString reads the literal string starting at the opening parenthesis at start. Returns its
+// value, with bytes mapped to Latin-1 characters, and the index following it.
+func readPDFString(content []byte, start int) (string, int) {
+	var value []rune
+	depth := 0
+	for i := start; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			if depth++; depth == 1 {
+				continue
+			}
+		case ')':
+			if depth--; depth == 0 {
+				return string(value), i + 1
+			}
+		case '\\':
+			if i++; i >= len(content) {
+				return string(value), i
+			}
+			switch escaped := content[i]; escaped {
+			case 'n', 'r':
+				c = ' '
+			case 't':
+				c = '\t'
+			case 'b', 'f':
+				continue
+			case '\r', '\n':
+				// Escaped line breaks continue the string on the next line
+				continue
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					code := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						code = code*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					c = byte(code)
+				} else {
+					c = escaped
+				}
+			}
+		}
+		if c >= 0x20 || c == '\t' {
+			value = append(value, rune(c))
+		}
+	}
+	return string(value), len(content)
+}
+
+// isPDFWhitespace checks if a byte is whitespace in PDF syntax
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// isPDFDelimiter checks if a byte ends a PDF keyword or number
+func isPDFDelimiter(c byte) bool {
+	return isPDFWhitespace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// joinTextLines collapses the whitespace of each line of a text and drops empty lines
+func joinTextLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = collapseWhitespace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
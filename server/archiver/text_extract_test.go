@@ -0,0 +1,80 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildPDF returns a minimal PDF document with a content stream per page, compressed if requested
+func buildPDF(t *testing.T, compress bool, contents ...string) []byte {
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	for i, content := range contents {
+		data := []byte(content)
+		filter := ""
+		if compress {
+			var compressed bytes.Buffer
+			writer := zlib.NewWriter(&compressed)
+			_, err := writer.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, writer.Close())
+			data = compressed.Bytes()
+			filter = " /Filter /FlateDecode"
+		}
+		fmt.Fprintf(&document, "%d 0 obj\n<< /Length %d%s >>\nstream\n", i+1, len(data), filter)
+		document.Write(data)
+		document.WriteString("\nendstream\nendobj\n")
+	}
+	document.WriteString("%%EOF\n")
+	return document.Bytes()
+}
+
+func TestExtractText(t *testing.T) {
+	t.Run("HTML pages", func(t *testing.T) {
+		page := `<html><head><title>Release notes</title><style>p { color: red; }</style>
+			<script>var secret = "not content";</script></head>
+			<body><h1>Version   2.0</h1><p>Faster <b>archives</b>,<br>fewer bugs.</p>
+			<ul><li>One</li><li>Two</li></ul><noscript>Enable JavaScript</noscript></body></html>`
+		file := ExtractText(&ArchivedFile{Filename: "notes.html", Data: []byte(page), MimeType: "text/html"}, 0)
+		require.NotNil(t, file)
+		assert.Equal(t, "notes.txt", file.Filename)
+		assert.Equal(t, "text/plain", file.MimeType)
+		assert.Equal(t, "Release notes\nVersion 2.0\nFaster archives,\nfewer bugs.\nOne\nTwo", string(file.Data))
+		assert.Equal(t, int64(len(file.Data)), file.Size)
+	})
+
+	t.Run("PDF documents", func(t *testing.T) {
+		content := "BT /F1 12 Tf 72 720 Td (Quarterly report) Tj 0 -14 Td [(Rev)-20(enue) -300(grew \\(a lot\\))] TJ T* (caf\\351) ' ET"
+		for _, compress := range []bool{false, true} {
+			document := buildPDF(t, compress, content, "BT (Second page) Tj ET")
+			file := ExtractText(&ArchivedFile{Filename: "report.pdf", Data: document, MimeType: "application/pdf"}, 0)
+			require.NotNil(t, file, "compressed: %v", compress)
+			assert.Equal(t, "Quarterly report\nRevenue grew (a lot)\ncafé\nSecond page", string(file.Data), "compressed: %v", compress)
+		}
+	})
+
+	t.Run("PDF streams without text are skipped", func(t *testing.T) {
+		document := buildPDF(t, false, "0 0 1 rg 0 0 100 100 re f")
+		assert.Nil(t, ExtractText(&ArchivedFile{Filename: "shapes.pdf", Data: document, MimeType: "application/pdf"}, 0))
+	})
+
+	t.Run("text is bounded", func(t *testing.T) {
+		page := "<p>" + strings.Repeat("word ", 100) + "</p>"
+		file := ExtractText(&ArchivedFile{Filename: "page.html", Data: []byte(page), MimeType: "text/html; charset=utf-8"}, 50)
+		require.NotNil(t, file)
+		assert.LessOrEqual(t, len([]rune(string(file.Data))), 50)
+		assert.True(t, strings.HasSuffix(string(file.Data), "…"))
+	})
+
+	t.Run("unsupported types", func(t *testing.T) {
+		assert.Nil(t, ExtractText(&ArchivedFile{Filename: "photo.png", Data: []byte("PNG"), MimeType: "image/png"}, 0))
+		assert.Nil(t, ExtractText(&ArchivedFile{Filename: "empty.html", Data: []byte("<html></html>"), MimeType: "text/html"}, 0))
+		assert.Nil(t, ExtractText(nil, 0))
+	})
+}
@@ -0,0 +1,57 @@
+package archiver
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultUserAgent is the User-Agent sent when none is configured
+const DefaultUserAgent = "Mattermost-Link-Archiver-Plugin/1.0"
+
+// HostUserAgent is a User-Agent sent to the hosts matching a pattern
+type HostUserAgent struct {
+	// Pattern is a hostname, or a wildcard like *.example.com also matching example.com
+	Pattern string
+	// UserAgent is the value of the User-Agent header
+	UserAgent string
+}
+
+// UserAgents selects the User-Agent sent with requests, so sites requiring a browser-like
+// User-Agent can be archived while keeping the identifying default elsewhere
+type UserAgents struct {
+	lock             sync.RWMutex
+	defaultUserAgent string
+	overrides        []HostUserAgent
+}
+
+// Set replaces the default User-Agent and the per-host overrides.
+// An empty default uses DefaultUserAgent.
+func (u *UserAgents) Set(defaultUserAgent string, overrides []HostUserAgent) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.defaultUserAgent = defaultUserAgent
+	u.overrides = overrides
+}
+
+// For returns the User-Agent for a hostname, the first matching override wins
+func (u *UserAgents) For(hostname string) string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	hostname = strings.ToLower(hostname)
+	for _, override := range u.overrides {
+		if HostnameMatches(hostname, override.Pattern) {
+			return override.UserAgent
+		}
+	}
+	if u.defaultUserAgent != "" {
+		return u.defaultUserAgent
+	}
+	return DefaultUserAgent
+}
+
+// Apply sets the User-Agent header of the request for its host
+func (u *UserAgents) Apply(req *http.Request) {
+	req.Header.Set("User-Agent", u.For(req.URL.Hostname()))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBackfillTimeout is the time a backfill can run before it stops, when not configured
+	defaultBackfillTimeout = time.Hour
+	// backfillProgressInterval is the minimum time between updates of a backfill's progress message
+	backfillProgressInterval = 5 * time.Second
+)
+
+// backfillSummary counts the outcomes of a backfill
+type backfillSummary struct {
+	Posts    int
+	Archived int
+	Skipped  int
+	Failed   int
+}
+
+// backfillRun is a backfill in progress in a channel
+type backfillRun struct {
+	cancel context.CancelFunc
+}
+
+// getBackfillConcurrency returns the number of posts a backfill archives at the same time. Their
+// links still wait for the slots shared with regular archival, so more can't be archived at once.
+func (c *configuration) getBackfillConcurrency() int {
+	return min(max(c.BackfillConcurrency, 1), maxConcurrentArchives)
+}
+
+// getBackfillTimeout returns the time a backfill can run before it stops
+func (c *configuration) getBackfillTimeout() time.Duration {
+	if c.BackfillTimeoutSeconds <= 0 {
+		return defaultBackfillTimeout
+	}
+	return time.Duration(c.BackfillTimeoutSeconds) * time.Second
+}
+
+// Backfill archives the links in the last count posts of a channel in the background, showing
+// the user its progress in an ephemeral message edited as it goes. URLs already archived are
+// skipped. A single backfill runs per channel, until done, cancelled or timed out.
+func (p *Plugin) Backfill(channelID, userID string, count int) error {
+	if p.archiveProcessor == nil {
+		return errors.New("archive processor is not initialized")
+	}
+
+	postList, appErr := p.API.GetPostsForChannel(channelID, 0, count)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get posts for channel")
+	}
+
+	config := p.getConfiguration()
+	ctx, cancel := context.WithTimeout(context.Background(), config.getBackfillTimeout())
+	if !p.startBackfill(channelID, cancel) {
+		cancel()
+		return errors.New("a backfill is already running in this channel, cancel it with /archive backfill cancel")
+	}
+
+	go func() {
+		defer p.finishBackfill(channelID)
+		defer cancel()
+
+		total := len(postList.Order)
+		progress := p.API.SendEphemeralPost(userID, &model.Post{
+			UserId:    p.botService.GetBotID(),
+			ChannelId: channelID,
+			Message:   fmt.Sprintf("Backfill started: scanning %d post(s).", total),
+		})
+		updateProgress := func(message string) {
+			if progress == nil {
+				return
+			}
+			progress.Message = message
+			if updated := p.API.UpdateEphemeralPost(userID, progress); updated != nil {
+				progress = updated
+			}
+		}
+
+		lastUpdate := time.Now()
+		summary := p.backfillPosts(ctx, postList, config, func(scanned int, summary backfillSummary) {
+			if time.Since(lastUpdate) < backfillProgressInterval {
+				return
+			}
+			lastUpdate = time.Now()
+			updateProgress(fmt.Sprintf("Backfilling: scanned %d/%d post(s), archived %d link(s), skipped %d, failed %d.",
+				scanned, total, summary.Archived, summary.Skipped, summary.Failed))
+		})
+
+		status := "complete"
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			status = "cancelled"
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			status = fmt.Sprintf("timed out after %s", config.getBackfillTimeout())
+		}
+		p.API.LogInfo("Backfill finished", "channelID", channelID, "userID", userID, "status", status,
+			"posts", summary.Posts, "archived", summary.Archived, "skipped", summary.Skipped, "failed", summary.Failed)
+
+		message := fmt.Sprintf("Backfill %s: scanned %d post(s), archived %d link(s), skipped %d, failed %d.",
+			status, summary.Posts, summary.Archived, summary.Skipped, summary.Failed)
+		if progress == nil {
+			p.API.SendEphemeralPost(userID, &model.Post{
+				UserId:    p.botService.GetBotID(),
+				ChannelId: channelID,
+				Message:   message,
+			})
+			return
+		}
+		updateProgress(message)
+	}()
+
+	return nil
+}
+
+// CancelBackfill stops the backfill running in a channel, once the posts being archived are done.
+// Returns false if no backfill is running in the channel on this server.
+func (p *Plugin) CancelBackfill(channelID string) bool {
+	p.backfillsLock.Lock()
+	defer p.backfillsLock.Unlock()
+	run, ok := p.backfills[channelID]
+	if ok {
+		run.cancel()
+	}
+	return ok
+}
+
+// startBackfill registers the backfill of a channel, false if one is already running there
+func (p *Plugin) startBackfill(channelID string, cancel context.CancelFunc) bool {
+	p.backfillsLock.Lock()
+	defer p.backfillsLock.Unlock()
+	if _, running := p.backfills[channelID]; running {
+		return false
+	}
+	if p.backfills == nil {
+		p.backfills = make(map[string]*backfillRun)
+	}
+	p.backfills[channelID] = &backfillRun{cancel: cancel}
+	return true
+}
+
+// finishBackfill unregisters the backfill of a channel once it stopped
+func (p *Plugin) finishBackfill(channelID string) {
+	p.backfillsLock.Lock()
+	defer p.backfillsLock.Unlock()
+	delete(p.backfills, channelID)
+}
+
+// cancelBackfills stops every backfill running on this server, on deactivation
+func (p *Plugin) cancelBackfills() {
+	p.backfillsLock.Lock()
+	defer p.backfillsLock.Unlock()
+	for _, run := range p.backfills {
+		run.cancel()
+	}
+}
+
+// backfillPosts archives the links of the posts, oldest first, and counts the outcomes. Up to the
+// configured concurrency posts are archived at once. No posts are started once ctx is done or
+// archiving is paused, the ones being archived finish. progress is called after each post, with the number of posts scanned.
+func (p *Plugin) backfillPosts(ctx context.Context, postList *model.PostList, config *configuration, progress func(scanned int, summary backfillSummary)) backfillSummary {
+	var (
+		lock    sync.Mutex
+		summary backfillSummary
+		scanned int
+	)
+
+	posts := make(chan *model.Post)
+	var wg sync.WaitGroup
+	for range config.getBackfillConcurrency() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for post := range posts {
+				results := p.archiveProcessor.ArchivePostAndWait(post, post.Message, config)
+
+				lock.Lock()
+				scanned++
+				summary.Posts++
+				for _, result := range results {
+					switch {
+					case result.Err != nil:
+						summary.Failed++
+					case result.Skipped || result.Notice != "":
+						summary.Skipped++
+					default:
+						summary.Archived++
+					}
+				}
+				if progress != nil {
+					progress(scanned, summary)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// Posts are ordered newest first, archive in the order they were posted
+feed:
+	for i := len(postList.Order) - 1; i >= 0; i-- {
+		post, ok := postList.Posts[postList.Order[i]]
+		if !ok || post.UserId == p.botService.GetBotID() || p.isIgnoredPost(post, config) {
+			lock.Lock()
+			scanned++
+			lock.Unlock()
+			continue
+		}
+		if ctx.Err() != nil || !p.ArchivingEnabled() {
+			break
+		}
+		select {
+		case posts <- post:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(posts)
+	wg.Wait()
+
+	return summary
+}
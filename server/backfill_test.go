@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackfillPosts(t *testing.T) {
+	processor := setupTestProcessor()
+	processor.linkExtractor = NewLinkExtractor()
+	processor.api.(*plugintest.API).On("GetConfig").Return(&model.Config{})
+
+	p := &Plugin{
+		archiveProcessor: processor,
+		botService:       &BotService{botID: "bot1"},
+	}
+
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{Id: "post1", UserId: "user1", Message: "no links here"})
+	postList.AddPost(&model.Post{Id: "post2", UserId: "bot1", Message: "bot reply https://example.com/a.exe"})
+	postList.AddPost(&model.Post{Id: "post3", UserId: "user1", Type: model.PostTypeJoinChannel, Message: "joined"})
+	// Disallowed extensions are skipped without touching the network
+	postList.AddPost(&model.Post{Id: "post4", UserId: "user1", Message: "https://example.com/a.exe https://example.com/b.exe"})
+	postList.AddOrder("post4")
+	postList.AddOrder("post3")
+	postList.AddOrder("post2")
+	postList.AddOrder("post1")
+
+	summary := p.backfillPosts(context.Background(), postList, &configuration{AllowedExtensions: "pdf"}, nil)
+	assert.Equal(t, backfillSummary{Posts: 2, Skipped: 2}, summary, "bot and system posts are ignored")
+}
+
+func TestBackfillPostsIgnoresBots(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetUser", "ci-bot").Return(&model.User{Id: "ci-bot", IsBot: true}, nil)
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1"}, nil)
+
+	p := &Plugin{
+		archiveProcessor: &ArchiveProcessor{api: api, linkExtractor: NewLinkExtractor()},
+		botService:       &BotService{botID: "bot1"},
+	}
+	p.SetAPI(api)
+
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{Id: "post1", UserId: "ci-bot", Message: "Build https://ci.example.com/build.exe"})
+	postList.AddPost(&model.Post{Id: "post2", UserId: "integration1", Message: "https://example.com/a.exe"})
+	postList.AddPost(&model.Post{Id: "post3", UserId: "user1", Message: "https://example.com/b.exe"})
+	postList.AddOrder("post3")
+	postList.AddOrder("post2")
+	postList.AddOrder("post1")
+
+	summary := p.backfillPosts(context.Background(), postList, &configuration{AllowedExtensions: "pdf", IgnoreBotPosts: true, IgnoredUserIDs: "integration1"}, nil)
+	assert.Equal(t, backfillSummary{Posts: 1, Skipped: 1}, summary, "posts of bots and ignored users are skipped")
+}
+
+func TestBackfillPostsProgress(t *testing.T) {
+	processor := setupTestProcessor()
+	processor.linkExtractor = NewLinkExtractor()
+	processor.api.(*plugintest.API).On("GetConfig").Return(&model.Config{})
+
+	p := &Plugin{
+		archiveProcessor: processor,
+		botService:       &BotService{botID: "bot1"},
+	}
+
+	postList := model.NewPostList()
+	for _, id := range []string{"post3", "post2", "post1"} {
+		postList.AddPost(&model.Post{Id: id, UserId: "user1", Message: "https://example.com/a.exe"})
+		postList.AddOrder(id)
+	}
+	config := &configuration{AllowedExtensions: "pdf", BackfillConcurrency: 3}
+
+	t.Run("reports the posts scanned", func(t *testing.T) {
+		var scanned []int
+		summary := p.backfillPosts(context.Background(), postList, config, func(n int, _ backfillSummary) {
+			scanned = append(scanned, n)
+		})
+		assert.Equal(t, backfillSummary{Posts: 3, Skipped: 3}, summary)
+		assert.Equal(t, []int{1, 2, 3}, scanned)
+	})
+
+	t.Run("stops once cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, backfillSummary{}, p.backfillPosts(ctx, postList, config, nil))
+	})
+
+	t.Run("stops once archiving is paused", func(t *testing.T) {
+		// The backfill runs with the configuration it started with, pausing applies to the current one
+		p.setConfiguration(&configuration{Enabled: model.NewPointer(false)})
+		defer p.setConfiguration(nil)
+		assert.Equal(t, backfillSummary{}, p.backfillPosts(context.Background(), postList, config, nil))
+	})
+}
+
+func TestCancelBackfill(t *testing.T) {
+	p := &Plugin{}
+	assert.False(t, p.CancelBackfill("channel1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.True(t, p.startBackfill("channel1", cancel))
+	assert.False(t, p.startBackfill("channel1", func() {}), "a single backfill runs per channel")
+	assert.True(t, p.startBackfill("channel2", func() {}))
+
+	assert.True(t, p.CancelBackfill("channel1"))
+	assert.Error(t, ctx.Err())
+
+	p.finishBackfill("channel1")
+	assert.False(t, p.CancelBackfill("channel1"))
+	assert.True(t, p.startBackfill("channel1", func() {}))
+}
+
+func TestGetBackfillSettings(t *testing.T) {
+	assert.Equal(t, 1, (&configuration{}).getBackfillConcurrency())
+	assert.Equal(t, 3, (&configuration{BackfillConcurrency: 3}).getBackfillConcurrency())
+	assert.Equal(t, maxConcurrentArchives, (&configuration{BackfillConcurrency: 100}).getBackfillConcurrency())
+
+	assert.Equal(t, defaultBackfillTimeout, (&configuration{}).getBackfillTimeout())
+	assert.Equal(t, time.Minute, (&configuration{BackfillTimeoutSeconds: 60}).getBackfillTimeout())
+}
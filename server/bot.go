@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"regexp"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -102,6 +103,35 @@ func (b *BotService) setBotProfileImage() error {
 	return nil
 }
 
+// EnsureChannelMember adds the bot to a channel, and to its team first if needed, so it can post
+// there. joined is false if the bot already was a member. Direct and group messages can't be joined.
+func (b *BotService) EnsureChannelMember(channelID string) (joined bool, err error) {
+	if _, appErr := b.api.GetChannelMember(channelID, b.botID); appErr == nil {
+		return false, nil
+	}
+
+	channel, appErr := b.api.GetChannel(channelID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get channel")
+	}
+	if channel.TeamId == "" {
+		return false, errors.New("the bot can't join direct or group messages")
+	}
+
+	if _, appErr = b.api.GetTeamMember(channel.TeamId, b.botID); appErr != nil {
+		if _, appErr = b.api.CreateTeamMember(channel.TeamId, b.botID); appErr != nil {
+			return false, errors.Wrap(appErr, "failed to add the bot to the team")
+		}
+	}
+
+	if _, appErr = b.api.AddChannelMember(channelID, b.botID); appErr != nil {
+		return false, errors.Wrap(appErr, "failed to add the bot to the channel")
+	}
+
+	b.api.LogInfo("Added the bot to a channel it couldn't post in", "channelID", channelID, "teamID", channel.TeamId)
+	return true, nil
+}
+
 // GetBotUser returns the bot user
 func (b *BotService) GetBotUser() *model.User {
 	return b.botUser
@@ -111,3 +141,22 @@ func (b *BotService) GetBotUser() *model.User {
 func (b *BotService) GetBotID() string {
 	return b.botID
 }
+
+// mentionPattern returns a pattern matching mentions of the bot in a message
+func (b *BotService) mentionPattern() *regexp.Regexp {
+	username := BotUsername
+	if b.botUser != nil && b.botUser.Username != "" {
+		username = b.botUser.Username
+	}
+	return regexp.MustCompile(`(?i)(^|[^\w@])@` + regexp.QuoteMeta(username) + `([^\w-]|$)`)
+}
+
+// IsMentioned checks if the bot is mentioned in a message
+func (b *BotService) IsMentioned(message string) bool {
+	return b.mentionPattern().MatchString(message)
+}
+
+// StripMention removes mentions of the bot from a message
+func (b *BotService) StripMention(message string) string {
+	return b.mentionPattern().ReplaceAllString(message, "$1$2")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBotMention(t *testing.T) {
+	bot := &BotService{botUser: &model.User{Id: "bot1", Username: BotUsername}}
+
+	tests := []struct {
+		name      string
+		message   string
+		mentioned bool
+		stripped  string
+	}{
+		{"mention with URL", "@link-archiver https://example.com", true, " https://example.com"},
+		{"mention at the end", "please archive https://example.com @link-archiver", true, "please archive https://example.com "},
+		{"mention with punctuation", "hey @link-archiver, https://example.com", true, "hey , https://example.com"},
+		{"case insensitive", "@Link-Archiver https://example.com", true, " https://example.com"},
+		{"no mention", "https://example.com", false, "https://example.com"},
+		{"other user with same prefix", "@link-archiver-two https://example.com", false, "@link-archiver-two https://example.com"},
+		{"email address", "me@link-archiver.example.com", false, "me@link-archiver.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.mentioned, bot.IsMentioned(tt.message))
+			assert.Equal(t, tt.stripped, bot.StripMention(tt.message))
+		})
+	}
+}
+
+func TestEnsureChannelMember(t *testing.T) {
+	notFound := model.NewAppError("test", "not_found", nil, "", http.StatusNotFound)
+
+	t.Run("already a member", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetChannelMember", "channel1", "bot1").Return(&model.ChannelMember{}, nil)
+		bot := &BotService{api: api, botID: "bot1"}
+
+		joined, err := bot.EnsureChannelMember("channel1")
+		require.NoError(t, err)
+		assert.False(t, joined)
+		api.AssertNotCalled(t, "AddChannelMember", mock.Anything, mock.Anything)
+	})
+
+	t.Run("joins the team and the channel", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+		api.On("GetChannelMember", "channel1", "bot1").Return(nil, notFound)
+		api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", TeamId: "team1"}, nil)
+		api.On("GetTeamMember", "team1", "bot1").Return(nil, notFound)
+		api.On("CreateTeamMember", "team1", "bot1").Return(&model.TeamMember{}, nil).Once()
+		api.On("AddChannelMember", "channel1", "bot1").Return(&model.ChannelMember{}, nil).Once()
+		bot := &BotService{api: api, botID: "bot1"}
+
+		joined, err := bot.EnsureChannelMember("channel1")
+		require.NoError(t, err)
+		assert.True(t, joined)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("direct messages can't be joined", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetChannelMember", "dm1", "bot1").Return(nil, notFound)
+		api.On("GetChannel", "dm1").Return(&model.Channel{Id: "dm1", Type: model.ChannelTypeDirect}, nil)
+		bot := &BotService{api: api, botID: "bot1"}
+
+		_, err := bot.EnsureChannelMember("dm1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "direct or group messages")
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+// bundleFilename is the name of the zip of a post's archived files
+const bundleFilename = "archived-links.zip"
+
+// archiveBundle is the zip of the files archived for a post, attached to its summary reply
+type archiveBundle struct {
+	FileID   string
+	Filename string
+	Size     int64
+	// Files is the number of archived files in the bundle
+	Files int
+}
+
+// bundleFile is an archived file to add to a bundle
+type bundleFile struct {
+	fileID   string
+	filename string
+}
+
+// errBundleTooLarge is returned when a bundle would exceed the maximum file size
+var errBundleTooLarge = errors.New("bundle exceeds maximum allowed size")
+
+// bundleResults packages the files archived for a post into a single zip uploaded to the post's
+// channel. Returns nil without error when the results have fewer than two files, there's nothing to
+// bundle. Fails with errBundleTooLarge when the bundle exceeds the server's maximum file size.
+func (p *ArchiveProcessor) bundleResults(postID string, results []*archiveResult) (*archiveBundle, error) {
+	var files []bundleFile
+	seen := make(map[string]bool)
+	add := func(fileID, filename string) {
+		if fileID != "" && !seen[fileID] {
+			seen[fileID] = true
+			files = append(files, bundleFile{fileID: fileID, filename: filename})
+		}
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Notice != "" || result.Metadata == nil {
+			continue
+		}
+		add(result.Metadata.FileID, result.Metadata.Filename)
+		for _, file := range result.Metadata.AttachedFiles() {
+			add(file.FileID, file.Filename)
+		}
+	}
+	if len(files) < 2 {
+		return nil, nil
+	}
+
+	post, appErr := p.api.GetPost(postID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get post")
+	}
+
+	buffer := &limitedBuffer{limit: p.maxUploadSize()}
+	writer := zip.NewWriter(buffer)
+	names := make(map[string]bool)
+	for _, file := range files {
+		data, appErr := p.api.GetFile(file.fileID)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to read archived file %s", file.fileID)
+		}
+		entry, err := writer.Create(uniqueBundleName(file.filename, names))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add file to bundle")
+		}
+		if _, err = entry.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to add file to bundle")
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to write bundle")
+	}
+
+	fileInfo, appErr := p.api.UploadFile(buffer.Bytes(), post.ChannelId, bundleFilename)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to upload bundle")
+	}
+
+	return &archiveBundle{
+		FileID:   fileInfo.Id,
+		Filename: bundleFilename,
+		Size:     int64(buffer.Len()),
+		Files:    len(files),
+	}, nil
+}
+
+// maxUploadSize returns the largest file the server accepts, the default download limit if unknown
+func (p *ArchiveProcessor) maxUploadSize() int64 {
+	if serverConfig := p.api.GetConfig(); serverConfig != nil && serverConfig.FileSettings.MaxFileSize != nil && *serverConfig.FileSettings.MaxFileSize > 0 {
+		return *serverConfig.FileSettings.MaxFileSize
+	}
+	return archiver.MaxFileSize
+}
+
+// uniqueBundleName returns the name of a file in a bundle, numbering files with the same name
+// like report (2).pdf
+func uniqueBundleName(filename string, names map[string]bool) string {
+	filename = strings.NewReplacer("/", "_", "\\", "_").Replace(filename)
+	if filename == "" {
+		filename = "file"
+	}
+	name := filename
+	ext := path.Ext(filename)
+	for i := 2; names[name]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(filename, ext), i, ext)
+	}
+	names[name] = true
+	return name
+}
+
+// limitedBuffer buffers written data up to a limit, failing writes past it with errBundleTooLarge
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+// Write buffers the data, failing once the limit would be exceeded
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		return 0, errBundleTooLarge
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestBundleResults(t *testing.T) {
+	results := []*archiveResult{
+		{URL: "https://example.com/a", Metadata: &ArchiveMetadata{FileID: "file1", Filename: "report.pdf", AdditionalFiles: []AdditionalFile{
+			{FileID: "thumb1", Filename: "report.png"},
+			{FileID: "icon1", Filename: "favicon.ico", Favicon: true},
+		}}},
+		{URL: "https://example.com/b", Err: assert.AnError},
+		{URL: "https://example.com/c", Metadata: &ArchiveMetadata{FileID: "file2", Filename: "report.pdf"}},
+		{URL: "https://example.com/d", Metadata: &ArchiveMetadata{FileID: "file1", Filename: "report.pdf"}},
+	}
+
+	setup := func(maxFileSize int64) (*ArchiveProcessor, *[]byte) {
+		api := &plugintest.API{}
+		config := &model.Config{}
+		config.SetDefaults()
+		config.FileSettings.MaxFileSize = model.NewPointer(maxFileSize)
+		api.On("GetConfig").Return(config)
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+		for _, fileID := range []string{"file1", "thumb1", "file2"} {
+			api.On("GetFile", fileID).Return([]byte("content of "+fileID), nil)
+		}
+
+		var uploaded []byte
+		api.On("UploadFile", mock.Anything, "channel1", bundleFilename).Run(func(args mock.Arguments) {
+			uploaded = args.Get(0).([]byte)
+		}).Return(&model.FileInfo{Id: "bundle1"}, nil)
+
+		return NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), NewStorageService(api), nil), &uploaded
+	}
+
+	t.Run("archived files are zipped once each", func(t *testing.T) {
+		processor, uploaded := setup(1024 * 1024)
+		bundle, err := processor.bundleResults("post1", results)
+		require.NoError(t, err)
+		require.NotNil(t, bundle)
+		assert.Equal(t, "bundle1", bundle.FileID)
+		assert.Equal(t, 3, bundle.Files)
+		assert.Equal(t, int64(len(*uploaded)), bundle.Size)
+
+		reader, err := zip.NewReader(bytes.NewReader(*uploaded), int64(len(*uploaded)))
+		require.NoError(t, err)
+		contents := make(map[string]string)
+		for _, file := range reader.File {
+			opened, err := file.Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(opened)
+			require.NoError(t, err)
+			contents[file.Name] = string(data)
+		}
+		assert.Equal(t, map[string]string{
+			"report.pdf":     "content of file1",
+			"report.png":     "content of thumb1",
+			"report (2).pdf": "content of file2",
+		}, contents)
+	})
+
+	t.Run("bundles over the maximum file size fail", func(t *testing.T) {
+		processor, uploaded := setup(100)
+		_, err := processor.bundleResults("post1", results)
+		assert.ErrorIs(t, err, errBundleTooLarge)
+		assert.Nil(t, *uploaded)
+	})
+
+	t.Run("single files aren't bundled", func(t *testing.T) {
+		processor, uploaded := setup(1024 * 1024)
+		bundle, err := processor.bundleResults("post1", results[1:3])
+		require.NoError(t, err)
+		assert.Nil(t, bundle)
+		assert.Nil(t, *uploaded)
+	})
+}
+
+func TestArchivePostAndWaitBundles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	api := &plugintest.API{}
+	mockLogs(api)
+	setupMemoryKV(api)
+	serverConfig := &model.Config{}
+	serverConfig.SetDefaults()
+	api.On("GetConfig").Return(serverConfig)
+	post := &model.Post{Id: "post1", ChannelId: "channel1", Message: server.URL + "/a.txt " + server.URL + "/b.txt"}
+	api.On("GetPost", "post1").Return(post, nil)
+	api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(func(data []byte, channelID, filename string) *model.FileInfo {
+		return &model.FileInfo{Id: "id-" + filename}
+	}, nil)
+	api.On("GetFile", mock.Anything).Return([]byte("archived"), nil)
+	var created []*model.Post
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		created = append(created, args.Get(0).(*model.Post))
+	}).Return(&model.Post{Id: "reply1"}, nil)
+
+	processor := NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), NewStorageService(api), NewThreadReplyService(api, "bot1"))
+	config := &configuration{ArchivalRules: []ArchivalRule{{Kind: "default", ArchivalTool: archiver.DirectDownloadToolName}}, BundlePerPost: true}
+
+	results := processor.ArchivePostAndWait(post, post.Message, config)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+
+	require.Len(t, created, 1, "backfilled posts get a single summary reply")
+	assert.Equal(t, model.StringArray{"id-" + bundleFilename}, created[0].FileIds)
+	assert.Contains(t, created[0].Message, "📦 **Bundle:**")
+}
+
+func TestUniqueBundleName(t *testing.T) {
+	names := make(map[string]bool)
+	assert.Equal(t, "a.pdf", uniqueBundleName("a.pdf", names))
+	assert.Equal(t, "a (2).pdf", uniqueBundleName("a.pdf", names))
+	assert.Equal(t, "a (3).pdf", uniqueBundleName("a.pdf", names))
+	assert.Equal(t, ".._etc_passwd", uniqueBundleName("../etc/passwd", names))
+	assert.Equal(t, "file", uniqueBundleName("", names))
+}
@@ -0,0 +1,47 @@
+package main
+
+import "sync"
+
+// channelLimiter bounds the number of URLs archived concurrently per channel, so a single busy
+// channel can't take all the archive slots during a burst of links. URLs over the limit wait
+// until one of the channel's archives finishes.
+type channelLimiter struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	active map[string]int
+}
+
+// newChannelLimiter creates a new channel limiter
+func newChannelLimiter() *channelLimiter {
+	l := &channelLimiter{active: make(map[string]int)}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// acquire waits until the channel has fewer than limit active archives and returns the function
+// releasing the slot. The release function can be called more than once.
+func (l *channelLimiter) acquire(channelID string, limit int) func() {
+	l.lock.Lock()
+	for l.active[channelID] >= limit {
+		l.cond.Wait()
+	}
+	l.active[channelID]++
+	l.lock.Unlock()
+
+	return sync.OnceFunc(func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.active[channelID]--; l.active[channelID] <= 0 {
+			delete(l.active, channelID)
+		}
+		// The limit is per call, wake everyone up so waiters re-check against their own
+		l.cond.Broadcast()
+	})
+}
+
+// activeCount returns the number of active archives of a channel
+func (l *channelLimiter) activeCount(channelID string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.active[channelID]
+}
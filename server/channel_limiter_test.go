@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelLimiter(t *testing.T) {
+	limiter := newChannelLimiter()
+
+	// Channel A uses its only slot
+	releaseA := limiter.acquire("channelA", 1)
+
+	queued := make(chan struct{})
+	go func() {
+		release := limiter.acquire("channelA", 1)
+		close(queued)
+		release()
+	}()
+
+	// Channel B isn't held back by channel A
+	done := make(chan struct{})
+	go func() {
+		release := limiter.acquire("channelB", 1)
+		release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("channel B waited for channel A")
+	}
+
+	// The queued URL of channel A only starts once the running one finishes
+	select {
+	case <-queued:
+		t.Fatal("channel A exceeded its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 1, limiter.activeCount("channelA"))
+
+	releaseA()
+	releaseA() // releasing twice has no effect
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("queued URL of channel A never started")
+	}
+	assert.Eventually(t, func() bool { return limiter.activeCount("channelA") == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestAcquireChannelSlot(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+	api.On("GetPost", "post2").Return(&model.Post{Id: "post2", ChannelId: "channel1"}, nil)
+
+	processor := &ArchiveProcessor{api: api, channelLimiter: newChannelLimiter()}
+
+	// No limit configured
+	release := processor.acquireChannelSlot(api, "post1", 0)
+	assert.Equal(t, 0, processor.channelLimiter.activeCount("channel1"))
+	release()
+
+	// Posts of the same channel share its slots
+	release1 := processor.acquireChannelSlot(api, "post1", 2)
+	release2 := processor.acquireChannelSlot(api, "post2", 2)
+	assert.Equal(t, 2, processor.channelLimiter.activeCount("channel1"))
+	release1()
+	release2()
+	assert.Equal(t, 0, processor.channelLimiter.activeCount("channel1"))
+}
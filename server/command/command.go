@@ -2,25 +2,73 @@ package command
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 )
 
 type Handler struct {
-	client *pluginapi.Client
+	client   *pluginapi.Client
+	archiver Archiver
 }
 
 type Command interface {
 	Handle(args *model.CommandArgs) (*model.CommandResponse, error)
 	executeHelloCommand(args *model.CommandArgs) *model.CommandResponse
+	executeArchiveCommand(args *model.CommandArgs) *model.CommandResponse
 }
 
-const helloCommandTrigger = "hello"
+// Archiver runs the archival operations triggered by slash commands
+type Archiver interface {
+	// Backfill archives the links in the last count posts of the channel in the background,
+	// showing its progress to the user in an ephemeral message
+	Backfill(channelID, userID string, count int) error
+	// CancelBackfill stops the backfill running in the channel, false if none is
+	CancelBackfill(channelID string) bool
+	// ArchivingEnabled reports whether archiving is enabled in the plugin settings
+	ArchivingEnabled() bool
+	// JoinChannel adds the bot to the channel and its team, joined is false if it already was a member
+	JoinChannel(channelID string) (joined bool, err error)
+	// StorageUsage reports the storage used by archived files, possibly computed a few minutes ago
+	StorageUsage() (*StorageUsage, error)
+}
+
+// StorageUsage is the storage used by archived files
+type StorageUsage struct {
+	TotalBytes int64
+	Files      int
+	// TopDomains are the domains using the most storage, largest first
+	TopDomains []DomainUsage
+	// Scanned is the number of archived URLs counted, the most recent ones, out of Total
+	Scanned int
+	Total   int
+	// ComputedAt is when the usage was computed
+	ComputedAt time.Time
+}
+
+// DomainUsage is the storage used by the files archived from a domain
+type DomainUsage struct {
+	Domain string
+	Bytes  int64
+	Files  int
+}
+
+const (
+	helloCommandTrigger   = "hello"
+	archiveCommandTrigger = "archive"
+
+	// maxBackfillPosts is the maximum number of posts a single backfill can archive
+	maxBackfillPosts = 200
+
+	// archiveSubcommands lists the subcommands of /archive for help messages
+	archiveSubcommands = "backfill, join, usage"
+)
 
 // Register all your slash commands in the NewCommandHandler function.
-func NewCommandHandler(client *pluginapi.Client) Command {
+func NewCommandHandler(client *pluginapi.Client, archiver Archiver) Command {
 	err := client.SlashCommand.Register(&model.Command{
 		Trigger:          helloCommandTrigger,
 		AutoComplete:     true,
@@ -31,8 +79,30 @@ func NewCommandHandler(client *pluginapi.Client) Command {
 	if err != nil {
 		client.Log.Error("Failed to register command", "error", err)
 	}
+
+	archiveData := model.NewAutocompleteData(archiveCommandTrigger, "[command]", "Available commands: "+archiveSubcommands)
+	backfill := model.NewAutocompleteData("backfill", "[number of posts | cancel]", "Archive the links in the last posts of this channel (system admins only)")
+	backfill.AddTextArgument(fmt.Sprintf("Number of posts, up to %d, or cancel to stop the running backfill", maxBackfillPosts), "[number of posts | cancel]", "")
+	archiveData.AddCommand(backfill)
+	join := model.NewAutocompleteData("join", "", "Add the bot to this channel so it can reply to links (system admins only)")
+	archiveData.AddCommand(join)
+	usage := model.NewAutocompleteData("usage", "", "Show the storage used by archived files (system admins only)")
+	archiveData.AddCommand(usage)
+
+	err = client.SlashCommand.Register(&model.Command{
+		Trigger:          archiveCommandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Manage link archival",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: archiveData,
+	})
+	if err != nil {
+		client.Log.Error("Failed to register command", "error", err)
+	}
+
 	return &Handler{
-		client: client,
+		client:   client,
+		archiver: archiver,
 	}
 }
 
@@ -42,6 +112,8 @@ func (c *Handler) Handle(args *model.CommandArgs) (*model.CommandResponse, error
 	switch trigger {
 	case helloCommandTrigger:
 		return c.executeHelloCommand(args), nil
+	case archiveCommandTrigger:
+		return c.executeArchiveCommand(args), nil
 	default:
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
@@ -62,3 +134,133 @@ func (c *Handler) executeHelloCommand(args *model.CommandArgs) *model.CommandRes
 		Text: "Hello, " + username,
 	}
 }
+
+func (c *Handler) executeArchiveCommand(args *model.CommandArgs) *model.CommandResponse {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return ephemeralResponse("Please specify a command. Available commands: " + archiveSubcommands)
+	}
+	// Backfills started before archiving was paused can still be cancelled
+	cancelsBackfill := fields[1] == "backfill" && len(fields) == 3 && fields[2] == "cancel"
+	if !c.archiver.ArchivingEnabled() && !cancelsBackfill {
+		return ephemeralResponse("Archiving is disabled. A system admin can enable it in the plugin settings.")
+	}
+
+	switch fields[1] {
+	case "backfill":
+		return c.executeBackfillCommand(args, fields[2:])
+	case "join":
+		return c.executeJoinCommand(args)
+	case "usage":
+		return c.executeUsageCommand(args)
+	default:
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s. Available commands: %s", fields[1], archiveSubcommands))
+	}
+}
+
+func (c *Handler) executeBackfillCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	user, err := c.client.User.Get(args.UserId)
+	if err != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		return ephemeralResponse("Only system admins can backfill archives.")
+	}
+
+	if len(params) != 1 {
+		return ephemeralResponse(fmt.Sprintf("Please specify the number of posts to backfill, up to %d. Usage: /archive backfill <number of posts|cancel>", maxBackfillPosts))
+	}
+
+	if params[0] == "cancel" {
+		if !c.archiver.CancelBackfill(args.ChannelId) {
+			return ephemeralResponse("No backfill is running in this channel.")
+		}
+		return ephemeralResponse("Cancelling the backfill of this channel. Posts being archived finish first, then you'll get a summary.")
+	}
+
+	count, err := strconv.Atoi(params[0])
+	if err != nil || count <= 0 {
+		return ephemeralResponse(fmt.Sprintf("Invalid number of posts: %s", params[0]))
+	}
+	if count > maxBackfillPosts {
+		count = maxBackfillPosts
+	}
+
+	if err := c.archiver.Backfill(args.ChannelId, args.UserId, count); err != nil {
+		c.client.Log.Error("Failed to start backfill", "channelID", args.ChannelId, "error", err.Error())
+		return ephemeralResponse("Failed to start backfill: " + err.Error())
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Archiving the links in the last %d post(s) of this channel. You'll see the progress here, and can stop it with /archive backfill cancel.", count))
+}
+
+func (c *Handler) executeJoinCommand(args *model.CommandArgs) *model.CommandResponse {
+	user, err := c.client.User.Get(args.UserId)
+	if err != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		return ephemeralResponse("Only system admins can add the bot to channels.")
+	}
+
+	joined, err := c.archiver.JoinChannel(args.ChannelId)
+	if err != nil {
+		c.client.Log.Error("Failed to add the bot to the channel", "channelID", args.ChannelId, "error", err.Error())
+		return ephemeralResponse("Failed to add the bot to this channel: " + err.Error())
+	}
+	if !joined {
+		return ephemeralResponse("The bot is already a member of this channel.")
+	}
+
+	return ephemeralResponse("The bot was added to this channel and can now reply to links.")
+}
+
+func (c *Handler) executeUsageCommand(args *model.CommandArgs) *model.CommandResponse {
+	user, err := c.client.User.Get(args.UserId)
+	if err != nil || !user.IsInRole(model.SystemAdminRoleId) {
+		return ephemeralResponse("Only system admins can see the storage usage.")
+	}
+
+	usage, err := c.archiver.StorageUsage()
+	if err != nil {
+		c.client.Log.Error("Failed to compute storage usage", "error", err.Error())
+		return ephemeralResponse("Failed to compute storage usage: " + err.Error())
+	}
+
+	return ephemeralResponse(formatStorageUsage(usage))
+}
+
+// formatStorageUsage builds the message reporting the storage usage
+func formatStorageUsage(usage *StorageUsage) string {
+	var message strings.Builder
+	message.WriteString("#### Archive storage usage\n\n")
+	fmt.Fprintf(&message, "**Total:** %s in %d file(s)\n", formatBytes(usage.TotalBytes), usage.Files)
+	if usage.Scanned < usage.Total {
+		fmt.Fprintf(&message, "Counted from the %d most recently archived URLs out of %d.\n", usage.Scanned, usage.Total)
+	}
+
+	if len(usage.TopDomains) > 0 {
+		message.WriteString("\n| Domain | Storage | Files |\n|:--|--:|--:|\n")
+		for _, domain := range usage.TopDomains {
+			fmt.Fprintf(&message, "| %s | %s | %d |\n", domain.Domain, formatBytes(domain.Bytes), domain.Files)
+		}
+	}
+
+	fmt.Fprintf(&message, "\n_Computed at %s._", usage.ComputedAt.UTC().Format("2006-01-02 15:04 MST"))
+	return message.String()
+}
+
+// formatBytes formats a number of bytes with binary units, like 1.5 MiB
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
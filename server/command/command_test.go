@@ -2,16 +2,60 @@ package command
 
 import (
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type env struct {
-	client *pluginapi.Client
-	api    *plugintest.API
+	client   *pluginapi.Client
+	api      *plugintest.API
+	archiver *fakeArchiver
+}
+
+// fakeArchiver records the backfills and joins requested by commands
+type fakeArchiver struct {
+	channelID string
+	userID    string
+	count     int
+	disabled  bool
+
+	cancelledChannelID string
+	notRunning         bool
+
+	joinedChannelID string
+	alreadyMember   bool
+
+	usage *StorageUsage
+}
+
+func (f *fakeArchiver) Backfill(channelID, userID string, count int) error {
+	f.channelID = channelID
+	f.userID = userID
+	f.count = count
+	return nil
+}
+
+func (f *fakeArchiver) CancelBackfill(channelID string) bool {
+	f.cancelledChannelID = channelID
+	return !f.notRunning
+}
+
+func (f *fakeArchiver) ArchivingEnabled() bool {
+	return !f.disabled
+}
+
+func (f *fakeArchiver) JoinChannel(channelID string) (bool, error) {
+	f.joinedChannelID = channelID
+	return !f.alreadyMember, nil
+}
+
+func (f *fakeArchiver) StorageUsage() (*StorageUsage, error) {
+	return f.usage, nil
 }
 
 func setupTest() *env {
@@ -20,8 +64,9 @@ func setupTest() *env {
 	client := pluginapi.NewClient(api, driver)
 
 	return &env{
-		client: client,
-		api:    api,
+		client:   client,
+		api:      api,
+		archiver: &fakeArchiver{},
 	}
 }
 
@@ -36,7 +81,8 @@ func TestHelloCommand(t *testing.T) {
 		AutoCompleteHint: "[@username]",
 		AutocompleteData: model.NewAutocompleteData("hello", "[@username]", "Username to say hello to"),
 	}).Return(nil)
-	cmdHandler := NewCommandHandler(env.client)
+	env.api.On("RegisterCommand", mock.MatchedBy(func(c *model.Command) bool { return c.Trigger == archiveCommandTrigger })).Return(nil)
+	cmdHandler := NewCommandHandler(env.client, env.archiver)
 
 	args := &model.CommandArgs{
 		Command: "/hello world",
@@ -45,3 +91,144 @@ func TestHelloCommand(t *testing.T) {
 	a.Nil(err)
 	a.Equal("Hello, world", response.Text)
 }
+
+func TestBackfillCommand(t *testing.T) {
+	env := setupTest()
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId}, nil)
+	env.api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+	cmdHandler := NewCommandHandler(env.client, env.archiver)
+
+	t.Run("non admins are rejected", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill 10", UserId: "user", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Only system admins")
+		assert.Zero(t, env.archiver.count)
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill many", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Invalid number of posts")
+		assert.Zero(t, env.archiver.count)
+	})
+
+	t.Run("starts the backfill", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill 25", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+		assert.Equal(t, "channel1", env.archiver.channelID)
+		assert.Equal(t, "admin", env.archiver.userID)
+		assert.Equal(t, 25, env.archiver.count)
+	})
+
+	t.Run("count is bounded", func(t *testing.T) {
+		_, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill 100000", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Equal(t, maxBackfillPosts, env.archiver.count)
+	})
+
+	t.Run("cancels the running backfill", func(t *testing.T) {
+		env.archiver.count = 0
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill cancel", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Cancelling the backfill")
+		assert.Equal(t, "channel1", env.archiver.cancelledChannelID)
+		assert.Zero(t, env.archiver.count)
+
+		env.archiver.notRunning = true
+		defer func() { env.archiver.notRunning = false }()
+		response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill cancel", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "No backfill is running")
+	})
+
+	t.Run("non admins can't cancel", func(t *testing.T) {
+		env.archiver.cancelledChannelID = ""
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill cancel", UserId: "user", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Only system admins")
+		assert.Empty(t, env.archiver.cancelledChannelID)
+	})
+
+	t.Run("archiving disabled", func(t *testing.T) {
+		env.archiver.count = 0
+		env.archiver.disabled = true
+		defer func() { env.archiver.disabled = false }()
+
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill 10", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Archiving is disabled")
+		assert.Zero(t, env.archiver.count)
+
+		// Backfills started before archiving was paused can be cancelled
+		env.archiver.cancelledChannelID = ""
+		response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/archive backfill cancel", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Cancelling the backfill")
+		assert.Equal(t, "channel1", env.archiver.cancelledChannelID)
+	})
+}
+
+func TestJoinCommand(t *testing.T) {
+	env := setupTest()
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId}, nil)
+	env.api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+	cmdHandler := NewCommandHandler(env.client, env.archiver)
+
+	t.Run("non admins are rejected", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive join", UserId: "user", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Only system admins")
+		assert.Empty(t, env.archiver.joinedChannelID)
+	})
+
+	t.Run("adds the bot to the channel", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive join", UserId: "admin", ChannelId: "channel1"})
+		assert.NoError(t, err)
+		assert.Equal(t, "channel1", env.archiver.joinedChannelID)
+		assert.Contains(t, response.Text, "was added to this channel")
+	})
+
+	t.Run("bot already a member", func(t *testing.T) {
+		env.archiver.alreadyMember = true
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive join", UserId: "admin", ChannelId: "channel2"})
+		assert.NoError(t, err)
+		assert.Equal(t, "channel2", env.archiver.joinedChannelID)
+		assert.Contains(t, response.Text, "already a member")
+	})
+}
+
+func TestUsageCommand(t *testing.T) {
+	env := setupTest()
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId}, nil)
+	env.api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+	env.archiver.usage = &StorageUsage{
+		TotalBytes: 3*1024*1024 + 512,
+		Files:      3,
+		TopDomains: []DomainUsage{{Domain: "files.example.com", Bytes: 3 * 1024 * 1024, Files: 1}, {Domain: "example.com", Bytes: 512, Files: 2}},
+		Scanned:    5000,
+		Total:      6000,
+		ComputedAt: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+	}
+	cmdHandler := NewCommandHandler(env.client, env.archiver)
+
+	t.Run("non admins are rejected", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive usage", UserId: "user"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Text, "Only system admins")
+	})
+
+	t.Run("reports the usage", func(t *testing.T) {
+		response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/archive usage", UserId: "admin"})
+		assert.NoError(t, err)
+		assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+		assert.Contains(t, response.Text, "**Total:** 3.0 MiB in 3 file(s)")
+		assert.Contains(t, response.Text, "| files.example.com | 3.0 MiB | 1 |")
+		assert.Contains(t, response.Text, "| example.com | 512 B | 2 |")
+		assert.Contains(t, response.Text, "5000 most recently archived URLs out of 6000")
+		assert.Contains(t, response.Text, "2024-05-01 12:30 UTC")
+	})
+}
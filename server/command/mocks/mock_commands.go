@@ -49,6 +49,20 @@ func (mr *MockCommandMockRecorder) Handle(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handle", reflect.TypeOf((*MockCommand)(nil).Handle), arg0)
 }
 
+// executeArchiveCommand mocks base method.
+func (m *MockCommand) executeArchiveCommand(arg0 *model.CommandArgs) *model.CommandResponse {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "executeArchiveCommand", arg0)
+	ret0, _ := ret[0].(*model.CommandResponse)
+	return ret0
+}
+
+// executeArchiveCommand indicates an expected call of executeArchiveCommand.
+func (mr *MockCommandMockRecorder) executeArchiveCommand(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "executeArchiveCommand", reflect.TypeOf((*MockCommand)(nil).executeArchiveCommand), arg0)
+}
+
 // executeHelloCommand mocks base method.
 func (m *MockCommand) executeHelloCommand(arg0 *model.CommandArgs) *model.CommandResponse {
 	m.ctrl.T.Helper()
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// migrationSourceSetting is reported when the rules were migrated from the MimeTypeMappings setting
+	migrationSourceSetting = "setting"
+	// migrationSourceKVStore is reported when the rules were migrated from the KV store
+	migrationSourceKVStore = "kvstore"
+)
+
+// storedRule is an archival rule as stored by any version of the plugin. Older versions only
+// mapped MIME types to archival tools, without a kind, and stored the fallback as a rule too.
+type storedRule struct {
+	Kind         string `json:"kind"`
+	Pattern      string `json:"pattern"`
+	MimeType     string `json:"mimeType"`
+	ArchivalTool string `json:"archivalTool"`
+	MaxBytes     int64  `json:"maxBytes"`
+	KeepHistory  bool   `json:"keepHistory"`
+	Priority     int    `json:"priority"`
+	Exclude      bool   `json:"exclude"`
+	Label        string `json:"label"`
+	Description  string `json:"description"`
+}
+
+// storedArchivalConfig is the MimeTypeMappings setting value, in the current format or the older
+// one holding the MIME type mappings
+type storedArchivalConfig struct {
+	ArchivalRules       []storedRule `json:"archivalRules"`
+	DefaultArchivalTool string       `json:"defaultArchivalTool"`
+	MimeTypeMappings    []storedRule `json:"mimeTypeMappings"`
+}
+
+// migratedArchivalConfig holds archival rules converted to the current format
+type migratedArchivalConfig struct {
+	ArchivalRules []ArchivalRule
+	// DefaultArchivalTool is empty if the stored value didn't set it
+	DefaultArchivalTool string
+	// Migrated is the number of rules that were in a legacy format
+	Migrated int
+}
+
+// migrateStoredRules converts stored rules to the current ArchivalRule format. Legacy MIME type
+// mappings become mimetype rules, and legacy default rules set the default archival tool.
+func migrateStoredRules(stored []storedRule) *migratedArchivalConfig {
+	migrated := &migratedArchivalConfig{ArchivalRules: make([]ArchivalRule, 0, len(stored))}
+	for _, rule := range stored {
+		switch {
+		case rule.Kind == "" && rule.MimeType != "":
+			migrated.ArchivalRules = append(migrated.ArchivalRules, ArchivalRule{
+				Kind:         "mimetype",
+				Pattern:      rule.MimeType,
+				ArchivalTool: rule.ArchivalTool,
+				MaxBytes:     rule.MaxBytes,
+				KeepHistory:  rule.KeepHistory,
+				Priority:     rule.Priority,
+				Exclude:      rule.Exclude,
+				Label:        rule.Label,
+				Description:  rule.Description,
+			})
+			migrated.Migrated++
+		case rule.Kind == "default" || (rule.Pattern == "" && rule.MimeType == ""):
+			// Old default rule format, the fallback is a separate setting now
+			if rule.ArchivalTool != "" {
+				migrated.DefaultArchivalTool = rule.ArchivalTool
+			}
+			migrated.Migrated++
+		default:
+			migrated.ArchivalRules = append(migrated.ArchivalRules, ArchivalRule{
+				Kind:         rule.Kind,
+				Pattern:      rule.Pattern,
+				ArchivalTool: rule.ArchivalTool,
+				MaxBytes:     rule.MaxBytes,
+				KeepHistory:  rule.KeepHistory,
+				Priority:     rule.Priority,
+				Exclude:      rule.Exclude,
+				Label:        rule.Label,
+				Description:  rule.Description,
+			})
+		}
+	}
+	return migrated
+}
+
+// parseArchivalConfig parses archival rules stored in any known format: the current setting value,
+// a list of rules or legacy MIME type mappings, or a legacy object mapping MIME types to tools
+// where the "default" key holds the default archival tool
+func parseArchivalConfig(data []byte) (*migratedArchivalConfig, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return &migratedArchivalConfig{ArchivalRules: []ArchivalRule{}}, nil
+	}
+
+	if data[0] == '[' {
+		var stored []storedRule
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, errors.Wrap(err, "failed to parse archival rules")
+		}
+		return migrateStoredRules(stored), nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to parse archival configuration")
+	}
+
+	_, hasRules := fields["archivalRules"]
+	_, hasDefault := fields["defaultArchivalTool"]
+	_, hasMappings := fields["mimeTypeMappings"]
+	if hasRules || hasDefault || hasMappings {
+		var stored storedArchivalConfig
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, errors.Wrap(err, "failed to parse archival configuration")
+		}
+		migrated := migrateStoredRules(append(stored.ArchivalRules, stored.MimeTypeMappings...))
+		if stored.DefaultArchivalTool != "" {
+			migrated.DefaultArchivalTool = stored.DefaultArchivalTool
+		}
+		return migrated, nil
+	}
+
+	// Legacy object mapping MIME types to archival tools
+	var mappings map[string]string
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, errors.Wrap(err, "failed to parse MIME type mappings")
+	}
+	stored := make([]storedRule, 0, len(mappings))
+	for mimeType, tool := range mappings {
+		if mimeType == "default" {
+			stored = append(stored, storedRule{Kind: "default", ArchivalTool: tool})
+			continue
+		}
+		stored = append(stored, storedRule{MimeType: mimeType, ArchivalTool: tool})
+	}
+	migrated := migrateStoredRules(stored)
+	// Map iteration order is random, keep the more specific patterns first so wildcards don't shadow them
+	sortRulesBySpecificity(migrated.ArchivalRules)
+	return migrated, nil
+}
+
+// sortRulesBySpecificity orders rules so exact patterns come before wildcards, and alphabetically
+// otherwise for a deterministic result
+func sortRulesBySpecificity(rules []ArchivalRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		iWildcard, jWildcard := strings.Contains(rules[i].Pattern, "*"), strings.Contains(rules[j].Pattern, "*")
+		if iWildcard != jWildcard {
+			return jWildcard
+		}
+		return rules[i].Pattern < rules[j].Pattern
+	})
+}
+
+// migrateArchivalConfig rewrites the archival rules into the current format and persists them.
+// Like on configuration changes, the MimeTypeMappings setting takes precedence over the KV store.
+// Returns the migrated configuration and the source it was read from.
+func (p *Plugin) migrateArchivalConfig() (*migratedArchivalConfig, string, error) {
+	var rawConfig = new(rawConfiguration)
+	if err := p.API.LoadPluginConfiguration(rawConfig); err != nil {
+		return nil, "", errors.Wrap(err, "failed to load plugin configuration")
+	}
+
+	var migrated *migratedArchivalConfig
+	source := migrationSourceSetting
+	if rawConfig.MimeTypeMappings != "" {
+		var err error
+		migrated, err = parseArchivalConfig([]byte(rawConfig.MimeTypeMappings))
+		if err != nil {
+			p.API.LogWarn("Failed to parse custom setting value, will migrate KV store", "error", err.Error())
+		}
+	}
+	if migrated == nil {
+		source = migrationSourceKVStore
+		data, appErr := p.API.KVGet(archivalRulesKey)
+		if appErr != nil {
+			return nil, "", errors.Wrap(appErr, "failed to load archival rules from KV store")
+		}
+		var err error
+		if migrated, err = parseArchivalConfig(data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := p.validateArchivalRules(migrated.ArchivalRules); err != nil {
+		return nil, "", errors.Wrap(err, "invalid archival rules")
+	}
+
+	if migrated.DefaultArchivalTool == "" {
+		defaultTool, err := p.loadDefaultArchivalTool()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to load default archival tool from KV store")
+		}
+		migrated.DefaultArchivalTool = defaultTool
+	}
+	if migrated.DefaultArchivalTool == "" {
+		migrated.DefaultArchivalTool = "do_nothing"
+	}
+
+	if err := p.saveArchivalRules(migrated.ArchivalRules); err != nil {
+		return nil, "", errors.Wrap(err, "failed to save archival rules to KV store")
+	}
+	if err := p.saveDefaultArchivalTool(migrated.DefaultArchivalTool); err != nil {
+		return nil, "", errors.Wrap(err, "failed to save default archival tool to KV store")
+	}
+
+	// Update in-memory configuration
+	p.configurationLock.Lock()
+	if p.configuration == nil {
+		p.configuration = &configuration{}
+	}
+	p.configuration.DefaultArchivalTool = migrated.DefaultArchivalTool
+	p.configuration.ArchivalRules = migrated.ArchivalRules
+	p.configurationLock.Unlock()
+
+	return migrated, source, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArchivalConfig(t *testing.T) {
+	t.Run("current format is unchanged", func(t *testing.T) {
+		migrated, err := parseArchivalConfig([]byte(`{"archivalRules":[{"kind":"hostname","pattern":"*.example.com","archivalTool":"obelisk","maxBytes":1024}],"defaultArchivalTool":"og_snapshot"}`))
+		require.NoError(t, err)
+		assert.Equal(t, []ArchivalRule{{Kind: "hostname", Pattern: "*.example.com", ArchivalTool: "obelisk", MaxBytes: 1024}}, migrated.ArchivalRules)
+		assert.Equal(t, "og_snapshot", migrated.DefaultArchivalTool)
+		assert.Zero(t, migrated.Migrated)
+	})
+
+	t.Run("legacy mapping list with default rule", func(t *testing.T) {
+		migrated, err := parseArchivalConfig([]byte(`[
+			{"mimeType":"application/pdf","archivalTool":"direct_download"},
+			{"kind":"hostname","pattern":"docs.example.com","archivalTool":"obelisk"},
+			{"kind":"default","pattern":"","archivalTool":"og_snapshot"}
+		]`))
+		require.NoError(t, err)
+		assert.Equal(t, []ArchivalRule{
+			{Kind: "mimetype", Pattern: "application/pdf", ArchivalTool: "direct_download"},
+			{Kind: "hostname", Pattern: "docs.example.com", ArchivalTool: "obelisk"},
+		}, migrated.ArchivalRules)
+		assert.Equal(t, "og_snapshot", migrated.DefaultArchivalTool)
+		assert.Equal(t, 2, migrated.Migrated)
+	})
+
+	t.Run("legacy mappings in the setting value", func(t *testing.T) {
+		migrated, err := parseArchivalConfig([]byte(`{"mimeTypeMappings":[{"mimeType":"image/*","archivalTool":"direct_download"}],"defaultArchivalTool":"do_nothing"}`))
+		require.NoError(t, err)
+		assert.Equal(t, []ArchivalRule{{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "direct_download"}}, migrated.ArchivalRules)
+		assert.Equal(t, "do_nothing", migrated.DefaultArchivalTool)
+		assert.Equal(t, 1, migrated.Migrated)
+	})
+
+	t.Run("legacy mapping object", func(t *testing.T) {
+		migrated, err := parseArchivalConfig([]byte(`{"image/*":"direct_download","text/html":"obelisk","image/png":"do_nothing","default":"og_snapshot"}`))
+		require.NoError(t, err)
+		// Exact patterns come first so wildcards don't shadow them
+		assert.Equal(t, []ArchivalRule{
+			{Kind: "mimetype", Pattern: "image/png", ArchivalTool: "do_nothing"},
+			{Kind: "mimetype", Pattern: "text/html", ArchivalTool: "obelisk"},
+			{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "direct_download"},
+		}, migrated.ArchivalRules)
+		assert.Equal(t, "og_snapshot", migrated.DefaultArchivalTool)
+		assert.Equal(t, 4, migrated.Migrated)
+	})
+
+	t.Run("empty and invalid values", func(t *testing.T) {
+		migrated, err := parseArchivalConfig(nil)
+		require.NoError(t, err)
+		assert.Empty(t, migrated.ArchivalRules)
+
+		_, err = parseArchivalConfig([]byte(`{not json`))
+		assert.Error(t, err)
+	})
+}
@@ -2,9 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"net/textproto"
+	"net/url"
 	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -19,30 +31,368 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type ArchivalRule struct {
-	Kind         string `json:"kind"`         // "hostname" or "mimetype"
-	Pattern      string `json:"pattern"`      // Pattern value (e.g., "*.example.com" or "image/*")
-	ArchivalTool string `json:"archivalTool"` // e.g., "direct_download"
+	Kind         string `json:"kind"`                  // "hostname" or "mimetype"
+	Pattern      string `json:"pattern"`               // Pattern value (e.g., "*.example.com" or "image/*")
+	ArchivalTool string `json:"archivalTool"`          // e.g., "direct_download"
+	MaxBytes     int64  `json:"maxBytes,omitempty"`    // Optional file size limit overriding the tool's default
+	KeepHistory  bool   `json:"keepHistory,omitempty"` // Keep previous captures when the content changes
+	Priority     int    `json:"priority,omitempty"`    // Lower numbers are evaluated first, ties keep the array order
+	Exclude      bool   `json:"exclude,omitempty"`     // Matching URLs are never archived, the archival tool is ignored
+	Label        string `json:"label,omitempty"`       // Optional short name identifying the rule in logs
+	Description  string `json:"description,omitempty"` // Optional note documenting why the rule exists
+	// ReplyTemplate and ReplyMode override the success replies of the URLs archived by the rule, see
+	// replyTemplateTokens and the ReplyMode constants. Empty values keep the global reply settings.
+	ReplyTemplate string `json:"replyTemplate,omitempty"`
+	ReplyMode     string `json:"replyMode,omitempty"`
+	// AcceptLanguage overrides the Accept-Language of the archives of the rule, like de-DE for a German site
+	AcceptLanguage string `json:"acceptLanguage,omitempty"`
+	// RefreshIntervalSeconds archives the URLs of the rule again this often from the background job,
+	// creating a new capture when their content changed. Zero doesn't refresh them.
+	RefreshIntervalSeconds int `json:"refreshIntervalSeconds,omitempty"`
+}
+
+// replyOptions returns the reply settings of the URLs archived by the rule
+func (r ArchivalRule) replyOptions() ReplyOptions {
+	label := r.Label
+	if label == "" {
+		label = r.Pattern
+	}
+	return ReplyOptions{
+		Template: r.ReplyTemplate,
+		Mode:     strings.ToLower(strings.TrimSpace(r.ReplyMode)),
+		Rule:     label,
+	}
+}
+
+// validateReplyTemplate checks that a reply template only uses known tokens, see replyTemplateTokens
+func validateReplyTemplate(template string) error {
+	for _, token := range replyTemplateTokenPattern.FindAllString(template, -1) {
+		if !slices.Contains(replyTemplateTokens, token) {
+			return errors.Errorf("unknown token %s, must be one of %s", token, strings.Join(replyTemplateTokens, ", "))
+		}
+	}
+	return nil
 }
 
+// replyTemplateTokenPattern finds the tokens of reply templates
+var replyTemplateTokenPattern = regexp.MustCompile(`\{[^{}\s]*\}`)
+
+const (
+	// maxRuleLabelLength is the maximum number of characters of rule labels
+	maxRuleLabelLength = 64
+	// maxRuleDescriptionLength is the maximum number of characters of rule descriptions
+	maxRuleDescriptionLength = 500
+)
+
 type configuration struct {
 	ArchivalRules       []ArchivalRule `json:"archivalRules"`
 	DefaultArchivalTool string         `json:"defaultArchivalTool"`
+	// CategoryDefaults maps MIME type categories (e.g., "image") to the tool used when no rule matches
+	CategoryDefaults map[string]string `json:"categoryDefaults"`
+	// MimeToolMap maps MIME types (e.g., "application/pdf" or "image/*") to the tool used when no rule
+	// matches, checked before the category defaults
+	MimeToolMap map[string]string `json:"mimeToolMap"`
+
+	// Obelisk settings, keys must match plugin.json
+	ObeliskResourcePolicy  string // "all" or "first-party-only"
+	ObeliskDisableJS       bool
+	ObeliskDisableCSS      bool
+	ObeliskDisableEmbeds   bool
+	ObeliskDisableMedias   bool
+	ObeliskOutputExtension string // ".obelisk.html", ".html" or ".mhtml"
+	ObeliskTitleFilename   bool
+	// ObeliskRequestDelayMs spaces out the requests of an archive, ObeliskMaxResources bounds its
+	// downloaded resources. Zero disables them.
+	ObeliskRequestDelayMs int
+	ObeliskMaxResources   int
+
+	// Enabled pauses all archiving when false. Nil when unset, which means enabled.
+	Enabled *bool
+
+	// ConsolidateReplies posts a single summary reply for posts with multiple URLs
+	ConsolidateReplies bool
+	// BundlePerPost attaches a single zip of the files archived for posts with multiple URLs to their
+	// summary reply, instead of the files, when it fits the server's maximum file size
+	BundlePerPost bool
+	// GroupRepliesByDomain groups the links of summary replies by domain
+	GroupRepliesByDomain bool
+	// CountSummaryThreshold replies to posts with at least this many links with the counts of their
+	// outcomes, instead of a line per link. Zero disables it.
+	CountSummaryThreshold int
+	// CountSummaryFormat is the message of count summaries, see countSummaryTokens. Empty for the default.
+	CountSummaryFormat string
+	// DisplayNameTemplate renders the names of archived files shown in replies, see displayNameTokens.
+	// Files are stored with their own filename. Empty shows the filenames.
+	DisplayNameTemplate string
+	// DownloadHTMLArchives uploads HTML archives as application/octet-stream, so Mattermost offers
+	// them as downloads instead of previewing them
+	DownloadHTMLArchives bool
+	// IncludeDownloadLink adds the download URL of archived files to replies
+	IncludeDownloadLink bool
+	// LinkSourceReply links replies to the thread reply their links were posted in, if not the root post
+	LinkSourceReply bool
+	// MaxPostLength is the maximum number of characters of the bot's posts, Mattermost's limit if zero
+	MaxPostLength int
+
+	// DisableAutoArchive only archives links of posts mentioning the bot
+	DisableAutoArchive bool
+	// ArchiveOnPin archives the links of posts when they're pinned, if they weren't archived yet
+	ArchiveOnPin bool
+	// IgnoreBotPosts doesn't archive the links of posts by bots and webhooks
+	IgnoreBotPosts bool
+	// ArchiveCustomPostTypes is a comma-separated list of the custom post types of other plugins whose
+	// links are archived, besides regular posts. System messages are never archived.
+	ArchiveCustomPostTypes string
+	// PendingPosts is how posts marked pending by PendingPostProps are archived: archive (right away),
+	// defer (once PendingPostDelaySeconds pass without edits) or skip
+	PendingPosts string
+	// PendingPostProps is a comma-separated list of the post props marking pending posts, from_webhook and pending if empty
+	PendingPostProps string
+	// PendingPostDelaySeconds is the time deferred pending posts must go unedited to be archived, 15 if unset
+	PendingPostDelaySeconds int
+	// IgnoredUserIDs is a comma-separated list of users whose links are never archived, like integration accounts
+	IgnoredUserIDs string
+	// ArchiveIn* archive the links of posts in each type of channel when true. Nil when unset, which
+	// means archived.
+	ArchiveInDirectMessages  *bool
+	ArchiveInGroupMessages   *bool
+	ArchiveInPrivateChannels *bool
+	ArchiveInPublicChannels  *bool
+
+	// ArchiveAttachmentLinks also archives the links of message attachments and link embeds
+	ArchiveAttachmentLinks bool
+	// SkipIfPreviewExists doesn't archive the links Mattermost already shows a preview image for
+	SkipIfPreviewExists bool
+	// SkipSelfLinks doesn't archive links to the Mattermost server itself, based on its site URL and
+	// InternalHosts. Nil when unset, which means skipped.
+	SkipSelfLinks *bool
+	// IgnoreCodeBlocks doesn't archive the links of the fenced code blocks and inline code spans of
+	// messages. Enabled unless explicitly disabled.
+	IgnoreCodeBlocks *bool
+	// InternalHosts are other hostnames of the Mattermost server, comma or newline separated, with
+	// wildcards like *.chat.example.com
+	InternalHosts string
+
+	// ResolveRelativeURLs archives relative markdown links, resolved against the closest preceding
+	// absolute link of the message or BaseURL
+	ResolveRelativeURLs bool
+	BaseURL             string
+
+	// AdminAlertChannelID receives an alert when a host fails AdminAlertThreshold times within
+	// AdminAlertWindowMinutes. Empty disables the alerts.
+	AdminAlertChannelID     string
+	AdminAlertThreshold     int
+	AdminAlertWindowMinutes int
+
+	// ArchiveChannelID posts archive replies to this channel instead of the post's thread
+	ArchiveChannelID string
+
+	// MaxConcurrentPerChannel bounds the URLs of a channel archived at the same time, zero for no limit
+	MaxConcurrentPerChannel int
+	// BackfillConcurrency is the number of posts a backfill archives at the same time, one if unset
+	BackfillConcurrency int
+	// BackfillTimeoutSeconds stops backfills running longer than this, an hour if unset
+	BackfillTimeoutSeconds int
+
+	// ReplyDisplayName and ReplyIconURL override the author shown on replies, without changing the bot
+	ReplyDisplayName string
+	ReplyIconURL     string
+
+	// ContentTypeReactions holds one "MIME type=emoji" per line, the reaction added to posts whose
+	// links were archived with a matching MIME type. Empty disables the reactions.
+	ContentTypeReactions string
+
+	// RetryEmoji is the emoji retrying the archive of a link when reacting to its error reply.
+	// Empty disables retries by reaction.
+	RetryEmoji string
+
+	// DetectionTimeoutSeconds is the timeout for content detection requests (HEAD/GET headers)
+	DetectionTimeoutSeconds int
+	// DownloadTimeoutSeconds is the timeout for connecting and receiving the headers of direct downloads
+	DownloadTimeoutSeconds int
+	// DownloadStallTimeoutSeconds aborts direct downloads receiving no data for this long
+	DownloadStallTimeoutSeconds int
+	// HostTimeouts holds one "hostname=seconds" per line, the timeout of the archival tools for URLs of
+	// matching hosts instead of their own
+	HostTimeouts string
+	// MaxRedirects is the number of redirects followed by content detection and direct downloads, 10 if unset
+	MaxRedirects int
+
+	// S3-compatible object storage archived files are also mirrored to
+	S3MirrorEnabled   bool
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3PublicBaseURL   string
+
+	// AllowedExtensions is a comma-separated list of file extensions to archive, empty allows all
+	AllowedExtensions string
+	// NotifySkippedExtensions replies in the thread when a link is skipped due to its extension
+	NotifySkippedExtensions bool
+
+	// DedupScope is the scope archives are reused in: global, team, channel or none
+	DedupScope string
+	// DedupMaxAgeSeconds only reuses archives captured within this many seconds, zero for no limit
+	DedupMaxAgeSeconds int
+	// CanonicalizeDedupURLs ignores fragments and default ports when matching the URLs of archives
+	CanonicalizeDedupURLs bool
+	// DedupIgnoreQuery ignores the query string when matching the URLs of archives
+	DedupIgnoreQuery bool
+	// DedupIgnoreParams holds one "hostname=param,param" per line, the query parameters ignored when
+	// matching the URLs of archives of the hosts, like the resizing parameters of image CDNs
+	DedupIgnoreParams string
+	// HashAlgorithm is the algorithm of the content hashes of new archives: sha256 or sha512
+	HashAlgorithm string
+	// DedupCacheSize is the number of recent archive lookups cached in memory, zero disables the cache
+	DedupCacheSize int
+	// DedupCacheTTLSeconds is how long archive lookups are cached, the default if zero
+	DedupCacheTTLSeconds int
+	// SampleChangeDetection compares large files without ETag to their archive by fetching their
+	// first and last bytes with range requests, instead of downloading them in full
+	SampleChangeDetection bool
+	// SuppressDuplicateInThread handles URLs already archived in the same thread: off, link or skip
+	SuppressDuplicateInThread string
+
+	// HTMLToPDFBrowserPath is the headless browser executable used by html_to_pdf, looked up in PATH if empty
+	HTMLToPDFBrowserPath string
+	// MonolithPath is the monolith executable used by the monolith tool, looked up in PATH if empty
+	MonolithPath string
+
+	// ExternalCommand is the command run by external_command, with {url} and {output} placeholders
+	ExternalCommand string
+	// ExternalCommandOutput is the name or glob pattern of the file produced by external_command
+	ExternalCommandOutput string
+	// ExternalCommandAdditionalOutputs are comma or newline separated patterns of other files
+	// produced by external_command, attached along with the archive
+	ExternalCommandAdditionalOutputs string
+	// ExternalCommandMimeType is the MIME type of the files produced by external_command, detected if empty
+	ExternalCommandMimeType string
+	// ExternalCommandTimeoutSeconds kills external_command after this many seconds, the tool default if zero
+	ExternalCommandTimeoutSeconds int
+
+	// DirectDownloadQueryFilename looks up direct download filenames in query parameters such as ?file=
+	DirectDownloadQueryFilename bool
+	// CaptureResponseHeaders records the main response headers of direct downloads in the archive metadata
+	CaptureResponseHeaders bool
+
+	// LoginRedirectDetection skips links redirecting to what looks like a login page
+	LoginRedirectDetection bool
+	// LoginRedirectPatterns is a comma-separated list of host prefixes and paths identifying login pages, defaults if empty
+	LoginRedirectPatterns string
+	// LoginRedirectMaxHTMLBytes flags off-host redirects to HTML pages smaller than this, 0 disables the check
+	LoginRedirectMaxHTMLBytes int
+	// DetectSoft404 skips archived HTML pages that look like the error page of a missing page served
+	// with a success status, see detectSoft404
+	DetectSoft404 bool
+	// Soft404TitlePatterns is a comma-separated list of texts of the titles of error pages, defaults if empty
+	Soft404TitlePatterns string
+	// Soft404MaxTextLength is the length of the visible text of pages under which they can be flagged, default if zero
+	Soft404MaxTextLength int
+
+	// UserAgent is the User-Agent sent when fetching links, the plugin's identifying User-Agent if empty
+	UserAgent string
+	// UserAgentOverrides holds one "hostname=user agent" per line, sent instead of UserAgent to matching hosts
+	UserAgentOverrides string
+	// AcceptLanguage is the Accept-Language header sent when fetching links, none if empty
+	AcceptLanguage string
+	// DetectionHeaders holds one "Name: value" header per line, sent with content detection requests only
+	DetectionHeaders string
+
+	// HTTPStatusActions holds one "status code=action" per line, the action taken when a server answers
+	// with the status code: retry, skip or fail. Skip can be followed by ": note" replied instead of the default.
+	HTTPStatusActions string
+
+	// StrictMimeTypeMatching matches MIME type rules exactly instead of ignoring case and parameters
+	StrictMimeTypeMatching bool
+	// MimeTypeOverrides holds one "hostname=MIME type" per line, forced for content from matching hosts
+	MimeTypeOverrides string
+
+	// KeepHistory keeps previous captures of URLs whose content changed, in addition to the rule option
+	KeepHistory bool
+	// MaxHistoryEntries is the number of previous captures kept per URL
+	MaxHistoryEntries int
+	// MaxArchivesPerURL evicts the oldest captures of a URL beyond this many, the latest included, zero for no limit
+	MaxArchivesPerURL int
+
+	// RefreshURLs are the watched URLs archived again by the background job, comma or newline
+	// separated. URLs starting with one of them are watched, in addition to the rule option.
+	RefreshURLs string
+	// RefreshIntervalSeconds is the time between refreshes of the watched URLs, default if zero
+	RefreshIntervalSeconds int
+	// MaxRefreshesPerRun bounds the watched URLs refreshed by each hourly run of the background job
+	MaxRefreshesPerRun int
+	// MaxRefreshesPerHost bounds the watched URLs of a host refreshed by each run of the background job
+	MaxRefreshesPerHost int
+
+	// FollowMetaRefresh archives the page HTML pages redirect to with a <meta http-equiv="refresh"> instead
+	FollowMetaRefresh bool
+	// FollowCanonical archives the page's canonical URL instead, when an HTML page links to one elsewhere
+	FollowCanonical bool
+	// CaptureFavicon stores the site icon of archived HTML pages along with their archive
+	CaptureFavicon bool
+	// IndexExtractedText attaches the plain text of archived HTML pages and PDF documents, for search
+	IndexExtractedText bool
+	// ArchiveDataURIs archives the files held by data URIs of messages, like data:image/png;base64,...
+	ArchiveDataURIs bool
+
+	// FeedMaxEntries is the number of entries archived from feeds by the feed_expand tool
+	FeedMaxEntries int
+
+	// HostCookies holds one "hostname=cookie string" per line, sent when fetching from matching hosts.
+	// Cookie values are secrets and must never be logged.
+	HostCookies string
 }
 
+const (
+	// DedupScopeGlobal reuses archives of a URL across the whole server
+	DedupScopeGlobal = "global"
+	// DedupScopeTeam reuses archives of a URL within the same team
+	DedupScopeTeam = "team"
+	// DedupScopeChannel reuses archives of a URL within the same channel
+	DedupScopeChannel = "channel"
+	// DedupScopeNone never reuses archives, every post gets its own copy
+	DedupScopeNone = "none"
+)
+
+const (
+	// DuplicateInThreadOff replies with the archived file for every post linking the URL
+	DuplicateInThreadOff = "off"
+	// DuplicateInThreadLink replies with a note linking to the post of the thread the URL was archived in
+	DuplicateInThreadLink = "link"
+	// DuplicateInThreadSkip doesn't reply to URLs already archived in the thread
+	DuplicateInThreadSkip = "skip"
+)
+
 // rawConfiguration is used to load the raw config from Mattermost
 // ArchivalRules is stored as a JSON string for custom settings
 // The JSON tag must match the key in plugin.json exactly
+// Regular settings are loaded directly into the embedded configuration
 type rawConfiguration struct {
 	MimeTypeMappings string `json:"MimeTypeMappings"` // Custom setting stored as JSON string containing both rules and default tool
+	configuration
 }
 
-// Clone deep copies the configuration to handle the slice field.
+// Clone deep copies the configuration to handle the slice and map fields.
 func (c *configuration) Clone() *configuration {
 	var clone = *c
 	if c.ArchivalRules != nil {
 		clone.ArchivalRules = make([]ArchivalRule, len(c.ArchivalRules))
 		copy(clone.ArchivalRules, c.ArchivalRules)
 	}
+	if c.CategoryDefaults != nil {
+		clone.CategoryDefaults = make(map[string]string, len(c.CategoryDefaults))
+		for category, tool := range c.CategoryDefaults {
+			clone.CategoryDefaults[category] = tool
+		}
+	}
+	if c.MimeToolMap != nil {
+		clone.MimeToolMap = make(map[string]string, len(c.MimeToolMap))
+		for mimeType, tool := range c.MimeToolMap {
+			clone.MimeToolMap[mimeType] = tool
+		}
+	}
 	return &clone
 }
 
@@ -56,8 +406,7 @@ func (p *Plugin) getConfiguration() *configuration {
 
 	config := &configuration{}
 	if p.configuration != nil {
-		config.DefaultArchivalTool = p.configuration.DefaultArchivalTool
-		config.ArchivalRules = p.configuration.ArchivalRules
+		config = p.configuration.Clone()
 	}
 
 	// Load archival rules from KV store (always use latest from KV store)
@@ -94,6 +443,22 @@ func (p *Plugin) getConfiguration() *configuration {
 		config.DefaultArchivalTool = "do_nothing"
 	}
 
+	// Load MIME category defaults from KV store (always use latest from KV store)
+	categoryDefaults, err := p.loadCategoryDefaults()
+	if err != nil {
+		p.API.LogError("Failed to load MIME category defaults from KV store", "error", err.Error())
+	} else {
+		config.CategoryDefaults = categoryDefaults
+	}
+
+	// Load the MIME type to tool mapping from KV store (always use latest from KV store)
+	mimeToolMap, err := p.loadMimeToolMap()
+	if err != nil {
+		p.API.LogError("Failed to load MIME type to tool mapping from KV store", "error", err.Error())
+	} else {
+		config.MimeToolMap = mimeToolMap
+	}
+
 	// Append synthetic default rule with kind "default" (system-generated)
 	// This ensures there's always a fallback rule that matches everything
 	config.ArchivalRules = append(config.ArchivalRules, ArchivalRule{
@@ -146,12 +511,10 @@ func (p *Plugin) OnConfigurationChange() error {
 	defaultArchivalTool := "do_nothing" // Default fallback
 
 	if rawConfig.MimeTypeMappings != "" {
-		// The custom setting value is a JSON string containing the full config
-		var customConfig struct {
-			ArchivalRules       []ArchivalRule `json:"archivalRules"`
-			DefaultArchivalTool string         `json:"defaultArchivalTool"`
-		}
-		if err := json.Unmarshal([]byte(rawConfig.MimeTypeMappings), &customConfig); err != nil {
+		// The custom setting value is a JSON string containing the full config,
+		// legacy MIME type mappings are converted to archival rules
+		customConfig, err := parseArchivalConfig([]byte(rawConfig.MimeTypeMappings))
+		if err != nil {
 			p.API.LogWarn("Failed to parse custom setting value, will use KV store", "error", err.Error())
 			// If parsing fails, try loading from KV store instead
 			var loadErr error
@@ -167,6 +530,9 @@ func (p *Plugin) OnConfigurationChange() error {
 			}
 		} else {
 			// Successfully parsed from custom setting
+			if customConfig.Migrated > 0 {
+				p.API.LogInfo("Migrated legacy MIME type mappings to archival rules", "count", customConfig.Migrated)
+			}
 			archivalRules = customConfig.ArchivalRules
 			if customConfig.DefaultArchivalTool != "" {
 				defaultArchivalTool = customConfig.DefaultArchivalTool
@@ -215,19 +581,592 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "invalid archival rules")
 	}
 
-	// Create the configuration struct
-	config := &configuration{
-		DefaultArchivalTool: defaultArchivalTool,
-		ArchivalRules:       archivalRules,
-	}
+	// Create the configuration struct from the regular settings
+	config := rawConfig.configuration.Clone()
+	config.DefaultArchivalTool = defaultArchivalTool
+	config.ArchivalRules = archivalRules
 
 	p.setConfiguration(config)
 
+	// Propagate settings to the already running archive processor
+	if p.archiveProcessor != nil {
+		p.archiveProcessor.ApplyConfiguration(config)
+	}
+
 	return nil
 }
 
+// getAllowedExtensions returns the normalized (lowercase, with leading dot) allowed file extensions
+func (c *configuration) getAllowedExtensions() []string {
+	var extensions []string
+	for _, ext := range parseListSetting(c.AllowedExtensions) {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
+}
+
+// getArchiveCustomPostTypes returns the custom post types whose links are archived, besides regular posts
+func (c *configuration) getArchiveCustomPostTypes() []string {
+	return parseListSetting(c.ArchiveCustomPostTypes)
+}
+
+// archivesPostType reports whether the links of posts of a type are archived: regular posts and the
+// configured custom types. System messages, like users joining a channel, are never archived.
+func (c *configuration) archivesPostType(postType string) bool {
+	if postType == model.PostTypeDefault {
+		return true
+	}
+	if strings.HasPrefix(postType, model.PostSystemMessagePrefix) {
+		return false
+	}
+	return slices.Contains(c.getArchiveCustomPostTypes(), postType)
+}
+
+// getIgnoredUserIDs returns the IDs of the users whose links are never archived
+func (c *configuration) getIgnoredUserIDs() []string {
+	return parseListSetting(c.IgnoredUserIDs)
+}
+
+// getInternalHosts returns the lowercase hostname patterns of the Mattermost server besides its site URL
+func (c *configuration) getInternalHosts() []string {
+	var hosts []string
+	for _, host := range parseListSetting(c.InternalHosts) {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	return hosts
+}
+
+// parseListSetting splits a comma or newline separated setting into its non-empty values
+func parseListSetting(value string) []string {
+	var values []string
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// hostValue is a value configured for the hosts matching a pattern
+type hostValue struct {
+	pattern string
+	value   string
+	// line is the line number of the value in the setting
+	line int
+}
+
+// parseHostValues parses a setting with one "hostname=value" per line, skipping empty lines and # comments.
+// Hostnames can use wildcards like *.example.com. Valid lines are returned along with the
+// numbers of the invalid ones, so errors never need to include the values.
+func parseHostValues(setting string) ([]hostValue, []string) {
+	var values []hostValue
+	var invalidLines []string
+	for i, line := range strings.Split(setting, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hostname, value, found := strings.Cut(line, "=")
+		hostname = strings.ToLower(strings.TrimSpace(hostname))
+		value = strings.TrimSpace(value)
+		if !found || hostname == "" || value == "" || strings.ContainsAny(hostname, "/: ") {
+			invalidLines = append(invalidLines, strconv.Itoa(i+1))
+			continue
+		}
+		values = append(values, hostValue{pattern: hostname, value: value, line: i + 1})
+	}
+	return values, invalidLines
+}
+
+// getHostCookies parses the host cookies setting, one "hostname=cookie string" per line.
+// Valid lines are returned even if others are invalid, and the error only
+// references line numbers so cookie values never end up in logs.
+func (c *configuration) getHostCookies() ([]archiver.HostCookie, error) {
+	values, invalidLines := parseHostValues(c.HostCookies)
+
+	cookies := make([]archiver.HostCookie, 0, len(values))
+	for _, v := range values {
+		cookies = append(cookies, archiver.HostCookie{Pattern: v.pattern, Cookie: v.value})
+	}
+
+	if len(invalidLines) > 0 {
+		return cookies, errors.Errorf("host cookies must be in the format hostname=cookie, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return cookies, nil
+}
+
+// detectionHeadersReserved are headers set by content detection itself, which can't be configured
+var detectionHeadersReserved = []string{"Cookie", "Host", "Range"}
+
+// getDetectionHeaders parses the detection headers setting, one "Name: value" per line, skipping empty
+// lines and # comments. Valid lines are returned even if others are invalid, and the error only
+// references line numbers as values can be secrets.
+func (c *configuration) getDetectionHeaders() ([]archiver.RequestHeader, error) {
+	var headers []archiver.RequestHeader
+	var invalidLines []string
+	for i, line := range strings.Split(c.DetectionHeaders, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !found || value == "" || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) ||
+			slices.Contains(detectionHeadersReserved, name) {
+			invalidLines = append(invalidLines, strconv.Itoa(i+1))
+			continue
+		}
+		headers = append(headers, archiver.RequestHeader{Name: name, Value: value})
+	}
+
+	if len(invalidLines) > 0 {
+		return headers, errors.Errorf("detection headers must be in the format Name: value, and can't set %s, invalid lines: %s",
+			strings.Join(detectionHeadersReserved, ", "), strings.Join(invalidLines, ", "))
+	}
+	return headers, nil
+}
+
+// getAcceptLanguage returns the Accept-Language header sent when fetching links, empty if unset or invalid
+func (c *configuration) getAcceptLanguage() (string, error) {
+	value := strings.TrimSpace(c.AcceptLanguage)
+	if err := validateAcceptLanguage(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// validateAcceptLanguage checks that an Accept-Language value can be sent as a header
+func validateAcceptLanguage(value string) error {
+	if !httpguts.ValidHeaderFieldValue(value) || strings.ContainsAny(value, "\r\n") {
+		return errors.New("accept language must be a valid header value, like en-US,en;q=0.9")
+	}
+	return nil
+}
+
+// getUserAgentOverrides parses the User-Agent overrides setting, one "hostname=user agent" per line.
+// Valid lines are returned even if others are invalid.
+func (c *configuration) getUserAgentOverrides() ([]archiver.HostUserAgent, error) {
+	values, invalidLines := parseHostValues(c.UserAgentOverrides)
+
+	overrides := make([]archiver.HostUserAgent, 0, len(values))
+	for _, v := range values {
+		overrides = append(overrides, archiver.HostUserAgent{Pattern: v.pattern, UserAgent: v.value})
+	}
+
+	if len(invalidLines) > 0 {
+		return overrides, errors.Errorf("user agent overrides must be in the format hostname=user agent, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return overrides, nil
+}
+
+// mimeTypeOverride is the MIME type forced for content from the hosts matching a pattern
+type mimeTypeOverride struct {
+	Pattern  string
+	MimeType string
+}
+
+// hostTimeout is the timeout of the archival tools for the URLs of the hosts matching a pattern
+type hostTimeout struct {
+	Pattern string
+	Timeout time.Duration
+}
+
+// getHostTimeouts parses the host timeouts setting, one "hostname=seconds" per line, with a positive
+// number of seconds. Valid lines are returned even if others are invalid.
+func (c *configuration) getHostTimeouts() ([]hostTimeout, error) {
+	values, invalidLines := parseHostValues(c.HostTimeouts)
+
+	timeouts := make([]hostTimeout, 0, len(values))
+	for _, v := range values {
+		seconds, err := strconv.Atoi(v.value)
+		if err != nil || seconds <= 0 {
+			invalidLines = append(invalidLines, strconv.Itoa(v.line))
+			continue
+		}
+		timeouts = append(timeouts, hostTimeout{Pattern: v.pattern, Timeout: time.Duration(seconds) * time.Second})
+	}
+
+	if len(invalidLines) > 0 {
+		return timeouts, errors.Errorf("host timeouts must be in the format hostname=seconds, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return timeouts, nil
+}
+
+// getDedupIgnoreParams parses the ignored query parameters setting, one "hostname=param,param" per
+// line, where * ignores the whole query string. Valid lines are returned even if others are invalid.
+func (c *configuration) getDedupIgnoreParams() ([]dedupIgnoredParams, error) {
+	values, invalidLines := parseHostValues(c.DedupIgnoreParams)
+
+	rules := make([]dedupIgnoredParams, 0, len(values))
+	for _, v := range values {
+		var params []string
+		for _, param := range strings.Split(v.value, ",") {
+			if param = strings.TrimSpace(param); param != "" {
+				params = append(params, param)
+			}
+		}
+		if len(params) == 0 {
+			invalidLines = append(invalidLines, strconv.Itoa(v.line))
+			continue
+		}
+		rules = append(rules, dedupIgnoredParams{Pattern: v.pattern, Params: params})
+	}
+
+	if len(invalidLines) > 0 {
+		return rules, errors.Errorf("ignored query parameters must be in the format hostname=param,param, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return rules, nil
+}
+
+// getStatusActions parses the HTTP status actions setting, one "status code=action" per line, where
+// skip can be followed by ": note". Only client and server error codes can be configured.
+// Valid lines are returned even if others are invalid.
+func (c *configuration) getStatusActions() ([]archiver.StatusRule, error) {
+	var rules []archiver.StatusRule
+	var invalidLines []string
+	for i, line := range strings.Split(c.HTTPStatusActions, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		code, action, _ := strings.Cut(line, "=")
+		action, note, hasNote := strings.Cut(action, ":")
+		statusCode, err := strconv.Atoi(strings.TrimSpace(code))
+		rule := archiver.StatusRule{
+			StatusCode: statusCode,
+			Action:     archiver.StatusAction(strings.ToLower(strings.TrimSpace(action))),
+			Note:       strings.TrimSpace(note),
+		}
+		validAction := rule.Action == archiver.StatusActionRetry || rule.Action == archiver.StatusActionSkip || rule.Action == archiver.StatusActionFail
+		if err != nil || statusCode < 400 || statusCode > 599 || !validAction || (hasNote && (rule.Action != archiver.StatusActionSkip || rule.Note == "")) {
+			invalidLines = append(invalidLines, strconv.Itoa(i+1))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(invalidLines) > 0 {
+		return rules, errors.Errorf("HTTP status actions must be in the format status code=retry, skip or fail, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return rules, nil
+}
+
+// getContentTypeReactions parses the content type reactions setting, one "MIME type=emoji" per line.
+// MIME types can use wildcards like image/*, and emoji names can be wrapped in colons like :globe_with_meridians:.
+// Valid lines are returned in order even if others are invalid.
+func (c *configuration) getContentTypeReactions() ([]contentTypeReaction, error) {
+	var reactions []contentTypeReaction
+	var invalidLines []string
+	for i, line := range strings.Split(c.ContentTypeReactions, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		mimeType, emoji, _ := strings.Cut(line, "=")
+		mimeType = strings.TrimSpace(mimeType)
+		emoji = strings.Trim(strings.ToLower(strings.TrimSpace(emoji)), ":")
+		if mediaType, subtype, found := strings.Cut(mimeType, "/"); !found || mediaType == "" || subtype == "" ||
+			emoji == "" || len(emoji) > model.EmojiNameMaxLength || !model.IsValidAlphaNumHyphenUnderscorePlus(emoji) {
+			invalidLines = append(invalidLines, strconv.Itoa(i+1))
+			continue
+		}
+		reactions = append(reactions, contentTypeReaction{Pattern: mimeType, EmojiName: emoji})
+	}
+
+	if len(invalidLines) > 0 {
+		return reactions, errors.Errorf("content type reactions must be in the format type/subtype=emoji name, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return reactions, nil
+}
+
+// getMimeTypeOverrides parses the MIME type overrides setting, one "hostname=MIME type" per line.
+// Valid lines are returned even if others are invalid.
+func (c *configuration) getMimeTypeOverrides() ([]mimeTypeOverride, error) {
+	values, invalidLines := parseHostValues(c.MimeTypeOverrides)
+
+	overrides := make([]mimeTypeOverride, 0, len(values))
+	for _, v := range values {
+		mimeType := normalizeMimeType(v.value)
+		if mediaType, subtype, found := strings.Cut(mimeType, "/"); !found || mediaType == "" || subtype == "" || strings.Contains(mimeType, "*") {
+			invalidLines = append(invalidLines, strconv.Itoa(v.line))
+			continue
+		}
+		overrides = append(overrides, mimeTypeOverride{Pattern: v.pattern, MimeType: mimeType})
+	}
+
+	if len(invalidLines) > 0 {
+		return overrides, errors.Errorf("MIME type overrides must be in the format hostname=type/subtype, invalid lines: %s", strings.Join(invalidLines, ", "))
+	}
+	return overrides, nil
+}
+
+// isEnabled reports whether archiving is enabled, it is unless explicitly disabled
+func (c *configuration) isEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// archivesChannelType reports whether the links of posts in a type of channel are archived, they are
+// unless explicitly disabled
+func (c *configuration) archivesChannelType(channelType model.ChannelType) bool {
+	var enabled *bool
+	switch channelType {
+	case model.ChannelTypeDirect:
+		enabled = c.ArchiveInDirectMessages
+	case model.ChannelTypeGroup:
+		enabled = c.ArchiveInGroupMessages
+	case model.ChannelTypePrivate:
+		enabled = c.ArchiveInPrivateChannels
+	case model.ChannelTypeOpen:
+		enabled = c.ArchiveInPublicChannels
+	}
+	return enabled == nil || *enabled
+}
+
+// summarizesCounts reports whether a post with a number of links gets a count summary reply
+func (c *configuration) summarizesCounts(links int) bool {
+	return c.CountSummaryThreshold > 0 && links >= c.CountSummaryThreshold
+}
+
+// getCountSummaryFormat returns the message of count summaries, the default one if unset or invalid
+func (c *configuration) getCountSummaryFormat() (string, error) {
+	format := strings.TrimSpace(c.CountSummaryFormat)
+	if format == "" {
+		return defaultCountSummaryFormat, nil
+	}
+	for _, token := range replyTemplateTokenPattern.FindAllString(format, -1) {
+		if !slices.Contains(countSummaryTokens, token) {
+			return defaultCountSummaryFormat, errors.Errorf("unknown token %s in count summary format, must be one of %s", token, strings.Join(countSummaryTokens, ", "))
+		}
+	}
+	return format, nil
+}
+
+// getDisplayNameTemplate returns the template of the names of archived files shown in replies, see
+// displayNameTokens. Empty if unset or invalid, showing the filenames.
+func (c *configuration) getDisplayNameTemplate() (string, error) {
+	template := strings.TrimSpace(c.DisplayNameTemplate)
+	for _, token := range replyTemplateTokenPattern.FindAllString(template, -1) {
+		if !slices.Contains(displayNameTokens, token) {
+			return "", errors.Errorf("unknown token %s in display name template, must be one of %s", token, strings.Join(displayNameTokens, ", "))
+		}
+	}
+	return template, nil
+}
+
+// getDisplayName returns the display name of an archived file, empty if the display name template
+// is unset or invalid
+func (c *configuration) getDisplayName(metadata *ArchiveMetadata) string {
+	template, err := c.getDisplayNameTemplate()
+	if err != nil {
+		return ""
+	}
+	return renderDisplayName(template, metadata)
+}
+
+// skipsSelfLinks reports whether links to the Mattermost server itself are skipped, they are unless
+// explicitly disabled
+func (c *configuration) skipsSelfLinks() bool {
+	return c.SkipSelfLinks == nil || *c.SkipSelfLinks
+}
+
+// ignoresCodeBlocks reports whether the links of code blocks are ignored, they are unless explicitly disabled
+func (c *configuration) ignoresCodeBlocks() bool {
+	return c.IgnoreCodeBlocks == nil || *c.IgnoreCodeBlocks
+}
+
+// archivesAllChannelTypes reports whether the links of posts are archived whatever their channel
+func (c *configuration) archivesAllChannelTypes() bool {
+	for _, channelType := range []model.ChannelType{model.ChannelTypeDirect, model.ChannelTypeGroup, model.ChannelTypePrivate, model.ChannelTypeOpen} {
+		if !c.archivesChannelType(channelType) {
+			return false
+		}
+	}
+	return true
+}
+
+// getDedupScope returns the deduplication scope, falling back to global for unset or unknown values
+func (c *configuration) getDedupScope() string {
+	switch scope := strings.ToLower(strings.TrimSpace(c.DedupScope)); scope {
+	case DedupScopeTeam, DedupScopeChannel, DedupScopeNone:
+		return scope
+	default:
+		return DedupScopeGlobal
+	}
+}
+
+// getHashAlgorithm returns the algorithm of the content hashes of new archives, falling back to
+// SHA-256 for unset or unknown values
+func (c *configuration) getHashAlgorithm() string {
+	if algorithm := strings.ToLower(strings.TrimSpace(c.HashAlgorithm)); algorithm == HashAlgorithmSHA512 {
+		return algorithm
+	}
+	return HashAlgorithmSHA256
+}
+
+// getDuplicateInThread returns how URLs already archived in the thread are handled, falling back to off
+func (c *configuration) getDuplicateInThread() string {
+	switch mode := strings.ToLower(strings.TrimSpace(c.SuppressDuplicateInThread)); mode {
+	case DuplicateInThreadLink, DuplicateInThreadSkip:
+		return mode
+	default:
+		return DuplicateInThreadOff
+	}
+}
+
+const (
+	// defaultAdminAlertThreshold is the number of failures of a host triggering an alert when not configured
+	defaultAdminAlertThreshold = 5
+	// defaultAdminAlertWindow is the window failures are counted in when not configured
+	defaultAdminAlertWindow = time.Hour
+)
+
+// getAdminAlertThreshold returns the number of failures of a host triggering an alert, falling back to the default if unset
+func (c *configuration) getAdminAlertThreshold() int {
+	if c.AdminAlertThreshold <= 0 {
+		return defaultAdminAlertThreshold
+	}
+	return c.AdminAlertThreshold
+}
+
+// getAdminAlertWindow returns the window the failures of a host are counted in, falling back to the default if unset
+func (c *configuration) getAdminAlertWindow() time.Duration {
+	if c.AdminAlertWindowMinutes <= 0 {
+		return defaultAdminAlertWindow
+	}
+	return time.Duration(c.AdminAlertWindowMinutes) * time.Minute
+}
+
+// getDedupMaxAge returns the maximum age of reused archives, zero for no limit
+func (c *configuration) getDedupMaxAge() time.Duration {
+	if c.DedupMaxAgeSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.DedupMaxAgeSeconds) * time.Second
+}
+
+// defaultDedupCacheTTL is how long archive lookups are cached when not configured
+const defaultDedupCacheTTL = 30 * time.Second
+
+// getDedupCacheSize returns the number of archive lookups cached, zero when the cache is disabled
+func (c *configuration) getDedupCacheSize() int {
+	return max(c.DedupCacheSize, 0)
+}
+
+// getDedupCacheTTL returns how long archive lookups are cached, falling back to the default if unset
+func (c *configuration) getDedupCacheTTL() time.Duration {
+	if c.DedupCacheTTLSeconds <= 0 {
+		return defaultDedupCacheTTL
+	}
+	return time.Duration(c.DedupCacheTTLSeconds) * time.Second
+}
+
+// getDetectionTimeout returns the content detection timeout, falling back to the default if unset
+func (c *configuration) getDetectionTimeout() time.Duration {
+	if c.DetectionTimeoutSeconds <= 0 {
+		return DefaultDetectionTimeout
+	}
+	return time.Duration(c.DetectionTimeoutSeconds) * time.Second
+}
+
+// defaultMaxHistoryEntries is the number of previous captures kept per URL when not configured
+const defaultMaxHistoryEntries = 10
+
+// getMaxHistoryEntries returns the number of previous captures kept per URL, falling back to the default if unset
+func (c *configuration) getMaxHistoryEntries() int {
+	if c.MaxHistoryEntries <= 0 {
+		return defaultMaxHistoryEntries
+	}
+	return c.MaxHistoryEntries
+}
+
+// defaultFeedMaxEntries is the number of entries archived from a feed when not configured
+const defaultFeedMaxEntries = 10
+
+// getFeedMaxEntries returns the number of entries archived from feeds, falling back to the default if unset
+// and never above the tool's limit
+func (c *configuration) getFeedMaxEntries() int {
+	if c.FeedMaxEntries <= 0 {
+		return defaultFeedMaxEntries
+	}
+	return min(c.FeedMaxEntries, archiver.FeedExpandMaxEntries)
+}
+
+// getBaseURL returns the base relative links are resolved against when the message has no
+// absolute link before them, which must be an absolute http(s) URL
+func (c *configuration) getBaseURL() (string, error) {
+	baseURL := strings.TrimSpace(c.BaseURL)
+	if baseURL == "" {
+		return "", nil
+	}
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil || !isHTTPURL(parsedURL) {
+		return "", errors.Errorf("base URL %q must be an absolute http or https URL", baseURL)
+	}
+	return baseURL, nil
+}
+
+// getReplyIconURL returns the icon URL shown on replies, which must be an absolute http(s) URL
+func (c *configuration) getReplyIconURL() (string, error) {
+	iconURL := strings.TrimSpace(c.ReplyIconURL)
+	if iconURL == "" {
+		return "", nil
+	}
+	parsedURL, err := url.Parse(iconURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return "", errors.Errorf("reply icon URL %q must be an absolute http or https URL", iconURL)
+	}
+	return iconURL, nil
+}
+
+// getDownloadTimeouts returns the direct download fetch and stall timeouts, zero values use the tool defaults
+func (c *configuration) getDownloadTimeouts() (timeout, stallTimeout time.Duration) {
+	return time.Duration(max(c.DownloadTimeoutSeconds, 0)) * time.Second,
+		time.Duration(max(c.DownloadStallTimeoutSeconds, 0)) * time.Second
+}
+
+// getObeliskOptions returns the obelisk options selected in the configuration
+func (c *configuration) getObeliskOptions() archiver.ObeliskOptions {
+	policy := c.ObeliskResourcePolicy
+	if policy == "" {
+		policy = archiver.ObeliskResourcePolicyAll
+	}
+	return archiver.ObeliskOptions{
+		DisableJS:      c.ObeliskDisableJS,
+		DisableCSS:     c.ObeliskDisableCSS,
+		DisableEmbeds:  c.ObeliskDisableEmbeds,
+		DisableMedias:  c.ObeliskDisableMedias,
+		ResourcePolicy: policy,
+		Extension:      c.ObeliskOutputExtension,
+		TitleFilename:  c.ObeliskTitleFilename,
+		RequestDelay:   time.Duration(max(c.ObeliskRequestDelayMs, 0)) * time.Millisecond,
+		MaxResources:   max(c.ObeliskMaxResources, 0),
+	}
+}
+
+// getExternalCommandOptions returns the command run by the external command tool
+func (c *configuration) getExternalCommandOptions() archiver.ExternalCommandOptions {
+	return archiver.ExternalCommandOptions{
+		Command:           strings.TrimSpace(c.ExternalCommand),
+		Output:            strings.TrimSpace(c.ExternalCommandOutput),
+		AdditionalOutputs: parseListSetting(c.ExternalCommandAdditionalOutputs),
+		MimeType:          strings.TrimSpace(c.ExternalCommandMimeType),
+		Timeout:           time.Duration(max(c.ExternalCommandTimeoutSeconds, 0)) * time.Second,
+	}
+}
+
 const archivalRulesKey = "archival_rules"
 const defaultArchivalToolKey = "default_archival_tool"
+const categoryDefaultsKey = "category_defaults"
+const mimeToolMapKey = "mime_tool_map"
 
 // saveArchivalRules saves archival rules to KV store
 func (p *Plugin) saveArchivalRules(rules []ArchivalRule) error {
@@ -296,10 +1235,42 @@ func (p *Plugin) validateArchivalRules(rules []ArchivalRule) error {
 		if rule.Pattern == "" {
 			return errors.Errorf("rule at index %d (kind: %s) must have a pattern", i, rule.Kind)
 		}
-		// Check that archival tool is specified
-		if rule.ArchivalTool == "" {
+		// Check that archival tool is specified, exclusion rules don't use one
+		if rule.ArchivalTool == "" && !rule.Exclude {
 			return errors.Errorf("rule at index %d must have an archival tool", i)
 		}
+		// Size limit is optional but can't be negative
+		if rule.MaxBytes < 0 {
+			return errors.Errorf("rule at index %d has invalid max bytes %d. Must be zero (tool default) or positive", i, rule.MaxBytes)
+		}
+		if rule.RefreshIntervalSeconds < 0 {
+			return errors.Errorf("rule at index %d has invalid refresh interval %d. Must be zero (not refreshed) or positive", i, rule.RefreshIntervalSeconds)
+		}
+		// Labels and descriptions are free text, only their length is limited
+		if utf8.RuneCountInString(rule.Label) > maxRuleLabelLength {
+			return errors.Errorf("rule at index %d has a label longer than %d characters", i, maxRuleLabelLength)
+		}
+		if utf8.RuneCountInString(rule.Description) > maxRuleDescriptionLength {
+			return errors.Errorf("rule at index %d has a description longer than %d characters", i, maxRuleDescriptionLength)
+		}
+		// Replies can be customized by rules archiving URLs, in a known mode with known tokens
+		switch rule.replyOptions().Mode {
+		case "", ReplyModeThread, ReplyModeEphemeral, ReplyModeNone:
+		default:
+			return errors.Errorf("rule at index %d has invalid reply mode '%s'. Must be '%s', '%s' or '%s'", i, rule.ReplyMode, ReplyModeThread, ReplyModeEphemeral, ReplyModeNone)
+		}
+		if err := validateReplyTemplate(rule.ReplyTemplate); err != nil {
+			return errors.Wrapf(err, "rule at index %d has an invalid reply template", i)
+		}
+		if err := validateAcceptLanguage(strings.TrimSpace(rule.AcceptLanguage)); err != nil {
+			return errors.Wrapf(err, "rule at index %d has an invalid accept language", i)
+		}
+		// The tool of a MIME type rule must archive that content. Hostname rules match any content,
+		// and tools are only known once the plugin is activated.
+		if rule.Kind == "mimetype" && !rule.Exclude && p.archiveProcessor != nil &&
+			!p.archiveProcessor.toolHandlesMimeType(rule.ArchivalTool, rule.Pattern) {
+			return errors.Errorf("rule at index %d (mimetype '%s') uses archival tool '%s', which doesn't archive that content", i, rule.Pattern, rule.ArchivalTool)
+		}
 	}
 	return nil
 }
@@ -329,3 +1300,121 @@ func (p *Plugin) loadDefaultArchivalTool() (string, error) {
 
 	return string(data), nil
 }
+
+// saveCategoryDefaults saves the MIME category defaults to KV store
+func (p *Plugin) saveCategoryDefaults(defaults map[string]string) error {
+	data, err := json.Marshal(defaults)
+	if err != nil {
+		return err
+	}
+
+	appErr := p.API.KVSet(categoryDefaultsKey, data)
+	if appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// loadCategoryDefaults loads the MIME category defaults from KV store
+func (p *Plugin) loadCategoryDefaults() (map[string]string, error) {
+	data, appErr := p.API.KVGet(categoryDefaultsKey)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if data != nil {
+		var defaults map[string]string
+		if err := json.Unmarshal(data, &defaults); err != nil {
+			return nil, err
+		}
+		return defaults, nil
+	}
+
+	// No defaults stored yet, return empty map
+	return map[string]string{}, nil
+}
+
+// validateCategoryDefaults validates that all categories are bare MIME types without subtype
+// and that they map to one of the available tools or do_nothing
+// Returns an error if any mapping is invalid
+func validateCategoryDefaults(defaults map[string]string, availableTools []string) error {
+	for category, tool := range defaults {
+		if category == "" || category != strings.ToLower(strings.TrimSpace(category)) || strings.ContainsAny(category, "/*; ") {
+			return errors.Errorf("invalid MIME category '%s'. Must be a lowercase MIME type without subtype, e.g. 'image'", category)
+		}
+		if tool == "" {
+			return errors.Errorf("MIME category '%s' must have an archival tool", category)
+		}
+		if tool != "do_nothing" && !slices.Contains(availableTools, tool) {
+			return errors.Errorf("MIME category '%s' has unknown archival tool '%s'", category, tool)
+		}
+	}
+	return nil
+}
+
+// saveMimeToolMap saves the MIME type to tool mapping to KV store
+func (p *Plugin) saveMimeToolMap(mimeToolMap map[string]string) error {
+	data, err := json.Marshal(mimeToolMap)
+	if err != nil {
+		return err
+	}
+
+	appErr := p.API.KVSet(mimeToolMapKey, data)
+	if appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// loadMimeToolMap loads the MIME type to tool mapping from KV store
+func (p *Plugin) loadMimeToolMap() (map[string]string, error) {
+	data, appErr := p.API.KVGet(mimeToolMapKey)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if data != nil {
+		var mimeToolMap map[string]string
+		if err := json.Unmarshal(data, &mimeToolMap); err != nil {
+			return nil, err
+		}
+		return mimeToolMap, nil
+	}
+
+	// No mapping stored yet, return empty map
+	return map[string]string{}, nil
+}
+
+// validateMimeToolMap validates that all keys are MIME types with a subtype or a wildcard subtype,
+// like "application/pdf" or "image/*", and that they map to one of the available tools or do_nothing
+// Returns an error if any mapping is invalid
+func validateMimeToolMap(mimeToolMap map[string]string, availableTools []string) error {
+	for mimeType, tool := range mimeToolMap {
+		mainType, subtype, ok := strings.Cut(mimeType, "/")
+		if !ok || mainType == "" || subtype == "" || strings.Contains(mainType, "*") ||
+			(subtype != "*" && strings.Contains(subtype, "*")) || strings.ContainsAny(mimeType, "; ") || strings.Count(mimeType, "/") != 1 {
+			return errors.Errorf("invalid MIME type '%s'. Must be a MIME type like 'application/pdf', or a wildcard like 'image/*'", mimeType)
+		}
+		if tool == "" {
+			return errors.Errorf("MIME type '%s' must have an archival tool", mimeType)
+		}
+		if tool != "do_nothing" && !slices.Contains(availableTools, tool) {
+			return errors.Errorf("MIME type '%s' has unknown archival tool '%s'", mimeType, tool)
+		}
+	}
+	return nil
+}
+
+// getCategoryDefaultTool returns the tool mapped to the category of the MIME type, if any
+func (c *configuration) getCategoryDefaultTool(mimeType string) (category, tool string) {
+	// Remove parameters and keep the type before the subtype, e.g. "image/png; q=1" -> "image"
+	category, _, _ = strings.Cut(mimeType, ";")
+	category, _, _ = strings.Cut(category, "/")
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return "", ""
+	}
+	return category, c.CategoryDefaults[category]
+}
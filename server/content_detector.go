@@ -1,12 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
 )
 
 // URLMetadata contains metadata about a URL including ETag and content hash
@@ -14,28 +21,116 @@ type URLMetadata struct {
 	MimeType string
 	ETag     string
 	Size     int64
+	// FinalURL is the URL the request ended at after following redirects
+	FinalURL string
 }
 
+// DefaultDetectionTimeout is the default timeout for content detection requests
+const DefaultDetectionTimeout = 10 * time.Second
+
+// sampleSize is the number of bytes at each end of a file hashed to detect changes (64KB)
+const sampleSize = 64 * 1024
+
+// maxCanonicalPageSize is the maximum amount of a page read looking for its canonical link or favicon (1MB)
+const maxCanonicalPageSize = 1024 * 1024
+
 // ContentDetector detects MIME types of URLs
 type ContentDetector struct {
-	client  *http.Client
-	timeout time.Duration
+	// clientLock guards client and timeout, which can be changed on configuration updates
+	clientLock sync.RWMutex
+	client     *http.Client
+	timeout    time.Duration
+
+	// cookies are attached to requests to hosts requiring a session
+	cookies archiver.HostCookies
+	// userAgents selects the User-Agent sent to each host
+	userAgents archiver.UserAgents
+	// acceptLanguage is sent with detection requests, before rules can override it
+	acceptLanguage archiver.AcceptLanguage
+	// headers are sent with detection requests only, after the User-Agent which they can override
+	headers archiver.RequestHeaders
+	// statusActions selects the unsuccessful status codes that are retried
+	statusActions archiver.StatusActions
+	// redirects bounds the redirects followed by detection requests, shared by the clients replaced on timeout changes
+	redirects *archiver.RedirectLimit
 }
 
 // NewContentDetector creates a new content detector
 func NewContentDetector(timeout time.Duration) *ContentDetector {
+	if timeout <= 0 {
+		timeout = DefaultDetectionTimeout
+	}
+
+	redirects := &archiver.RedirectLimit{}
 	return &ContentDetector{
-		client: &http.Client{
-			Timeout: timeout,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				// Follow redirects
-				return nil
-			},
-		},
-		timeout: timeout,
+		client:    newDetectionClient(timeout, redirects),
+		timeout:   timeout,
+		redirects: redirects,
 	}
 }
 
+// newDetectionClient creates the HTTP client used for detection requests
+func newDetectionClient(timeout time.Duration, redirects *archiver.RedirectLimit) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		CheckRedirect: redirects.CheckRedirect,
+	}
+}
+
+// SetTimeout changes the timeout used for subsequent detection requests
+func (d *ContentDetector) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultDetectionTimeout
+	}
+
+	d.clientLock.Lock()
+	defer d.clientLock.Unlock()
+	if timeout == d.timeout {
+		return
+	}
+	// Replace the client instead of mutating it, requests in flight keep using the old one
+	d.client = newDetectionClient(timeout, d.redirects)
+	d.timeout = timeout
+}
+
+// SetHostCookies sets the cookies attached to detection requests by host
+func (d *ContentDetector) SetHostCookies(cookies []archiver.HostCookie) {
+	d.cookies.Set(cookies)
+}
+
+// SetUserAgents sets the default User-Agent of detection requests and its per-host overrides
+func (d *ContentDetector) SetUserAgents(defaultUserAgent string, overrides []archiver.HostUserAgent) {
+	d.userAgents.Set(defaultUserAgent, overrides)
+}
+
+// SetAcceptLanguage sets the Accept-Language header of detection requests, empty to not send it
+func (d *ContentDetector) SetAcceptLanguage(value string) {
+	d.acceptLanguage.Set(value)
+}
+
+// SetHeaders sets the extra headers of detection requests
+func (d *ContentDetector) SetHeaders(headers []archiver.RequestHeader) {
+	d.headers.Set(headers)
+}
+
+// SetMaxRedirects sets the number of redirects followed by detection requests, zero or less uses
+// archiver.DefaultMaxRedirects
+func (d *ContentDetector) SetMaxRedirects(maxRedirects int) {
+	d.redirects.Set(maxRedirects)
+}
+
+// SetStatusActions sets the actions taken for unsuccessful HTTP status codes
+func (d *ContentDetector) SetStatusActions(rules []archiver.StatusRule) {
+	d.statusActions.Set(rules)
+}
+
+// httpClient returns the HTTP client for detection requests
+func (d *ContentDetector) httpClient() *http.Client {
+	d.clientLock.RLock()
+	defer d.clientLock.RUnlock()
+	return d.client
+}
+
 // DetectMimeType detects the MIME type of a URL
 // First tries HEAD request, falls back to GET if HEAD is not supported
 func (d *ContentDetector) DetectMimeType(url string) (string, error) {
@@ -44,6 +139,10 @@ func (d *ContentDetector) DetectMimeType(url string) (string, error) {
 	if err == nil && mimeType != "" {
 		return mimeType, nil
 	}
+	if errors.Is(err, archiver.ErrTooManyRedirects) {
+		// A GET request would follow the same redirects
+		return "", errors.Wrapf(err, "failed to detect MIME type for URL: %s", url)
+	}
 
 	// Fallback to GET request
 	mimeType, err = d.detectWithGET(url)
@@ -61,10 +160,16 @@ func (d *ContentDetector) GetURLMetadata(url string) (*URLMetadata, error) {
 		return nil, errors.Wrap(err, "failed to create HEAD request")
 	}
 
-	// Set a reasonable User-Agent
-	req.Header.Set("User-Agent", "Mattermost-Link-Archiver-Plugin/1.0")
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
 
-	resp, err := d.client.Do(req)
+	resp, err := d.statusActions.Do(d.httpClient(), req)
+	if errors.Is(err, archiver.ErrTooManyRedirects) {
+		return nil, errors.Wrap(err, "failed to get URL metadata")
+	}
 	if err != nil {
 		// Fallback to GET if HEAD fails
 		return d.getMetadataWithGET(url)
@@ -91,9 +196,208 @@ func (d *ContentDetector) GetURLMetadata(url string) (*URLMetadata, error) {
 		MimeType: mimeType,
 		ETag:     etag,
 		Size:     resp.ContentLength,
+		FinalURL: resp.Request.URL.String(),
+	}, nil
+}
+
+// GetCanonicalURL fetches an HTML page and returns the URL of its <link rel="canonical">, if any
+func (d *ContentDetector) GetCanonicalURL(url string) (string, error) {
+	page, pageURL, err := d.getPageHead(url)
+	if err != nil {
+		return "", err
+	}
+	return archiver.ExtractCanonicalURL(page, pageURL), nil
+}
+
+// GetMetaRefreshURL returns the URL an HTML page redirects to with a <meta http-equiv="refresh">,
+// or an empty string if it has none
+func (d *ContentDetector) GetMetaRefreshURL(url string) (string, error) {
+	page, pageURL, err := d.getPageHead(url)
+	if err != nil {
+		return "", err
+	}
+	return archiver.ExtractMetaRefreshURL(page, pageURL), nil
+}
+
+// GetFavicon fetches the favicon of an HTML page: the icon of its <link rel="icon">, or
+// /favicon.ico on its host. Fails if the icon isn't an image or is larger than archiver.FaviconMaxSize.
+func (d *ContentDetector) GetFavicon(url string) (*archiver.ArchivedFile, error) {
+	page, pageURL, err := d.getPageHead(url)
+	if err != nil {
+		return nil, err
+	}
+	iconURL := archiver.ExtractFaviconURL(page, pageURL)
+	if iconURL == "" {
+		return nil, errors.Errorf("no favicon URL for %s", pageURL)
+	}
+
+	req, err := http.NewRequest("GET", iconURL, http.NoBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GET request")
+	}
+
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
+
+	resp, err := d.statusActions.Do(d.httpClient(), req)
+	if err != nil {
+		return nil, errors.Wrap(err, "GET request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, archiver.NewStatusError(resp.StatusCode, "GET request returned status %d")
+	}
+	if resp.ContentLength > archiver.FaviconMaxSize {
+		return nil, errors.Errorf("favicon size %d exceeds maximum allowed size %d", resp.ContentLength, archiver.FaviconMaxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, archiver.FaviconMaxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read favicon")
+	}
+	if len(data) > archiver.FaviconMaxSize {
+		return nil, errors.Errorf("favicon exceeds maximum allowed size %d", archiver.FaviconMaxSize)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("favicon is empty")
+	}
+
+	// Servers answer missing favicons with HTML pages, only images are kept
+	mimeType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, errors.Errorf("favicon is not an image: %s", mimeType)
+	}
+
+	filename := "favicon" + path.Ext(resp.Request.URL.Path)
+	if filename == "favicon" {
+		filename = "favicon.ico"
+	}
+	return &archiver.ArchivedFile{
+		Filename: filename,
+		Data:     data,
+		MimeType: mimeType,
+		Size:     int64(len(data)),
 	}, nil
 }
 
+// getPageHead fetches the start of an HTML page, which holds its head, and returns it along with
+// the URL the page was served from, to resolve relative links against
+func (d *ContentDetector) getPageHead(url string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, http.NoBody)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create GET request")
+	}
+
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
+
+	resp, err := d.statusActions.Do(d.httpClient(), req)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "GET request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, "", archiver.NewStatusError(resp.StatusCode, "GET request returned status %d")
+	}
+
+	// Links are in the head, there's no need to read the whole page
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxCanonicalPageSize))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to read page")
+	}
+
+	return page, resp.Request.URL.String(), nil
+}
+
+// GetSampleHash fetches the first and last bytes of a file with range requests and returns its
+// sample hash, see sampleHash. Fails if the server doesn't support range requests or the file is
+// too small to be sampled.
+func (d *ContentDetector) GetSampleHash(url string, size int64) (string, error) {
+	if size <= 2*sampleSize {
+		return "", errors.Errorf("file size %d is too small to be sampled", size)
+	}
+
+	head, err := d.getRange(url, 0, size)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch the start of the file")
+	}
+	tail, err := d.getRange(url, size-sampleSize, size)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch the end of the file")
+	}
+
+	return sampleHash(size, head, tail), nil
+}
+
+// getRange fetches sampleSize bytes of a file of the given size from the offset with a range request
+func (d *ContentDetector) getRange(url string, offset, size int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, http.NoBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GET request")
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+sampleSize-1))
+
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
+
+	resp, err := d.statusActions.Do(d.httpClient(), req)
+	if err != nil {
+		return nil, errors.Wrap(err, "GET request failed")
+	}
+	defer resp.Body.Close()
+
+	// Servers ignoring the range answer with the whole file, which is never read
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.Errorf("range request returned status %d", resp.StatusCode)
+	}
+	// The range must be of the same file, a different total size means it changed
+	expectedRange := fmt.Sprintf("bytes %d-%d/%d", offset, offset+sampleSize-1, size)
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != expectedRange {
+		return nil, errors.Errorf("unexpected content range %q", contentRange)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, sampleSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read range")
+	}
+	if len(data) != sampleSize {
+		return nil, errors.Errorf("range has %d bytes instead of %d", len(data), sampleSize)
+	}
+	return data, nil
+}
+
+// sampleHash hashes the size of a file along with its first and last bytes. It's a heuristic,
+// not an exact comparison: changes keeping the size and both ends of a file intact go unnoticed.
+func sampleHash(size int64, head, tail []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n", size)
+	hash.Write(head)
+	hash.Write(tail)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// sampleHashOfData returns the sample hash of a downloaded file, empty if it's too small to be sampled
+func sampleHashOfData(data []byte) string {
+	if len(data) <= 2*sampleSize {
+		return ""
+	}
+	return sampleHash(int64(len(data)), data[:sampleSize], data[len(data)-sampleSize:])
+}
+
 // getMetadataWithGET retrieves metadata using GET request
 func (d *ContentDetector) getMetadataWithGET(url string) (*URLMetadata, error) {
 	req, err := http.NewRequest("GET", url, http.NoBody)
@@ -101,16 +405,20 @@ func (d *ContentDetector) getMetadataWithGET(url string) (*URLMetadata, error) {
 		return nil, errors.Wrap(err, "failed to create GET request")
 	}
 
-	req.Header.Set("User-Agent", "Mattermost-Link-Archiver-Plugin/1.0")
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
 
-	resp, err := d.client.Do(req)
+	resp, err := d.statusActions.Do(d.httpClient(), req)
 	if err != nil {
 		return nil, errors.Wrap(err, "GET request failed")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return nil, errors.Errorf("GET request returned status %d", resp.StatusCode)
+		return nil, archiver.NewStatusError(resp.StatusCode, "GET request returned status %d")
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -127,6 +435,7 @@ func (d *ContentDetector) getMetadataWithGET(url string) (*URLMetadata, error) {
 		MimeType: mimeType,
 		ETag:     etag,
 		Size:     resp.ContentLength,
+		FinalURL: resp.Request.URL.String(),
 	}, nil
 }
 
@@ -137,17 +446,20 @@ func (d *ContentDetector) detectWithHEAD(url string) (string, error) {
 		return "", errors.Wrap(err, "failed to create HEAD request")
 	}
 
-	// Set a reasonable User-Agent
-	req.Header.Set("User-Agent", "Mattermost-Link-Archiver-Plugin/1.0")
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
 
-	resp, err := d.client.Do(req)
+	resp, err := d.statusActions.Do(d.httpClient(), req)
 	if err != nil {
 		return "", errors.Wrap(err, "HEAD request failed")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return "", errors.Errorf("HEAD request returned status %d", resp.StatusCode)
+		return "", archiver.NewStatusError(resp.StatusCode, "HEAD request returned status %d")
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -167,17 +479,20 @@ func (d *ContentDetector) detectWithGET(url string) (string, error) {
 		return "", errors.Wrap(err, "failed to create GET request")
 	}
 
-	// Set a reasonable User-Agent
-	req.Header.Set("User-Agent", "Mattermost-Link-Archiver-Plugin/1.0")
+	// Set the configured User-Agent for the host
+	d.userAgents.Apply(req)
+	d.cookies.Apply(req)
+	d.acceptLanguage.Apply(req, "")
+	d.headers.Apply(req)
 
-	resp, err := d.client.Do(req)
+	resp, err := d.statusActions.Do(d.httpClient(), req)
 	if err != nil {
 		return "", errors.Wrap(err, "GET request failed")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return "", errors.Errorf("GET request returned status %d", resp.StatusCode)
+		return "", archiver.NewStatusError(resp.StatusCode, "GET request returned status %d")
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestContentDetectorTimeout(t *testing.T) {
+	t.Run("timeout propagates to the HTTP client", func(t *testing.T) {
+		detector := NewContentDetector(3 * time.Second)
+		assert.Equal(t, 3*time.Second, detector.httpClient().Timeout)
+	})
+
+	t.Run("zero timeout uses the default", func(t *testing.T) {
+		detector := NewContentDetector(0)
+		assert.Equal(t, DefaultDetectionTimeout, detector.httpClient().Timeout)
+	})
+
+	t.Run("timeout can be changed on an existing detector", func(t *testing.T) {
+		detector := NewContentDetector(DefaultDetectionTimeout)
+		detector.SetTimeout(2 * time.Second)
+		assert.Equal(t, 2*time.Second, detector.httpClient().Timeout)
+
+		detector.SetTimeout(0)
+		assert.Equal(t, DefaultDetectionTimeout, detector.httpClient().Timeout)
+	})
+
+	t.Run("configuration timeout applies to detection requests", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/html")
+		}))
+		defer server.Close()
+
+		detector := NewContentDetector(DefaultDetectionTimeout)
+		detector.SetTimeout((&configuration{DetectionTimeoutSeconds: 1}).getDetectionTimeout())
+		mimeType, err := detector.DetectMimeType(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "text/html", mimeType)
+
+		detector.SetTimeout(50 * time.Millisecond)
+		_, err = detector.DetectMimeType(server.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestContentDetectorRedirectLoop(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer server.Close()
+
+	detector := NewContentDetector(DefaultDetectionTimeout)
+	detector.SetMaxRedirects(3)
+
+	_, err := detector.GetURLMetadata(server.URL + "/loop")
+	require.ErrorIs(t, err, archiver.ErrTooManyRedirects)
+	assert.Equal(t, int32(4), requests.Load(), "the request and its 3 redirects")
+
+	_, err = detector.DetectMimeType(server.URL + "/loop")
+	require.ErrorIs(t, err, archiver.ErrTooManyRedirects)
+	assert.Equal(t, "Too many redirects, the link may redirect in a loop", extractErrorReason(err))
+
+	t.Run("limit survives timeout changes", func(t *testing.T) {
+		detector.SetTimeout(5 * time.Second)
+		requests.Store(0)
+		_, err := detector.GetURLMetadata(server.URL + "/loop")
+		require.ErrorIs(t, err, archiver.ErrTooManyRedirects)
+		assert.Equal(t, int32(4), requests.Load())
+	})
+}
+
+func TestGetSampleHash(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 20*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-range":
+			_, _ = w.Write(data)
+		default:
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer server.Close()
+
+	detector := NewContentDetector(0)
+
+	t.Run("matches the hash of the downloaded file", func(t *testing.T) {
+		sample, err := detector.GetSampleHash(server.URL+"/file.bin", int64(len(data)))
+		require.NoError(t, err)
+		assert.Equal(t, sampleHashOfData(data), sample)
+	})
+
+	t.Run("size is part of the sample", func(t *testing.T) {
+		_, err := detector.GetSampleHash(server.URL+"/file.bin", int64(len(data))+1)
+		require.Error(t, err, "ranges of a file of another size are rejected")
+		assert.NotEqual(t, sampleHashOfData(data), sampleHashOfData(append(data, 'x')))
+	})
+
+	t.Run("fails if ranges aren't supported", func(t *testing.T) {
+		_, err := detector.GetSampleHash(server.URL+"/no-range", int64(len(data)))
+		assert.Error(t, err)
+	})
+
+	t.Run("small files aren't sampled", func(t *testing.T) {
+		_, err := detector.GetSampleHash(server.URL+"/file.bin", 2*sampleSize)
+		assert.Error(t, err)
+		assert.Empty(t, sampleHashOfData(data[:2*sampleSize]))
+	})
+}
+
+func TestGetFavicon(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/favicon.ico":
+			// Served as a generic binary, the icon is recognized from its content
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("\x00\x00\x01\x00icon"))
+		case "/large":
+			_, _ = w.Write([]byte(`<head><link rel="icon" href="/large.png"></head>`))
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(bytes.Repeat([]byte("x"), 200*1024))
+		case "/html-icon":
+			_, _ = w.Write([]byte(`<head><link rel="icon" href="/not-found"></head>`))
+		case "/not-found":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>Not found</html>"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><head></head></html>"))
+		}
+	}))
+	defer server.Close()
+	detector := NewContentDetector(0)
+
+	t.Run("falls back to /favicon.ico", func(t *testing.T) {
+		favicon, err := detector.GetFavicon(server.URL + "/page")
+		require.NoError(t, err)
+		assert.Equal(t, "favicon.ico", favicon.Filename)
+		assert.Equal(t, "image/x-icon", favicon.MimeType)
+		assert.Equal(t, int64(8), favicon.Size)
+	})
+
+	t.Run("large icons are rejected", func(t *testing.T) {
+		_, err := detector.GetFavicon(server.URL + "/large")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds maximum allowed size")
+	})
+
+	t.Run("non image icons are rejected", func(t *testing.T) {
+		_, err := detector.GetFavicon(server.URL + "/html-icon")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "favicon is not an image")
+	})
+}
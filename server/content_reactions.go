@@ -0,0 +1,52 @@
+package main
+
+// contentTypeReaction is the emoji added to posts whose links were archived with a matching MIME type
+type contentTypeReaction struct {
+	Pattern   string // MIME type, can be a wildcard like image/*
+	EmojiName string
+}
+
+// reactWithContentTypes reacts to the post with the emoji of each MIME type archived from its links,
+// including the links they expanded to. Reused archives count as archived, and every emoji is added
+// once, whatever the number of links and how many times the post is archived.
+func (p *ArchiveProcessor) reactWithContentTypes(postID string, results []*archiveResult, config *configuration) {
+	if p.threadReplyService == nil {
+		return
+	}
+	reactions, _ := config.getContentTypeReactions()
+	if len(reactions) == 0 {
+		return
+	}
+
+	var emojiNames []string
+	added := make(map[string]bool)
+	for _, result := range flattenResults(results) {
+		if result == nil || result.Err != nil || result.Metadata == nil {
+			continue
+		}
+		if emojiName := p.contentTypeEmoji(result.Metadata.MimeType, reactions); emojiName != "" && !added[emojiName] {
+			added[emojiName] = true
+			emojiNames = append(emojiNames, emojiName)
+		}
+	}
+	if len(emojiNames) == 0 {
+		return
+	}
+
+	if err := p.threadReplyService.AddReactions(postID, emojiNames); err != nil {
+		p.api.LogWarn("Failed to add content type reactions", "postID", postID, "error", err.Error())
+	}
+}
+
+// contentTypeEmoji returns the emoji of the first reaction matching the MIME type, if any
+func (p *ArchiveProcessor) contentTypeEmoji(mimeType string, reactions []contentTypeReaction) string {
+	if mimeType == "" {
+		return ""
+	}
+	for _, reaction := range reactions {
+		if p.mimeTypeMatches(mimeType, reaction.Pattern) {
+			return reaction.EmojiName
+		}
+	}
+	return ""
+}
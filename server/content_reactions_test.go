@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetContentTypeReactions(t *testing.T) {
+	config := &configuration{ContentTypeReactions: "# Reactions\napplication/pdf=page_facing_up\n image/* = :frame_with_picture: \n\ntext/html\nimage=camera\ntext/plain=not an emoji"}
+
+	reactions, err := config.getContentTypeReactions()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid lines: 5, 6, 7")
+	assert.Equal(t, []contentTypeReaction{
+		{Pattern: "application/pdf", EmojiName: "page_facing_up"},
+		{Pattern: "image/*", EmojiName: "frame_with_picture"},
+	}, reactions)
+
+	reactions, err = (&configuration{}).getContentTypeReactions()
+	require.NoError(t, err)
+	assert.Empty(t, reactions)
+}
+
+func TestReactWithContentTypes(t *testing.T) {
+	config := &configuration{ContentTypeReactions: "application/pdf=page_facing_up\nimage/*=frame_with_picture\ntext/html=globe_with_meridians"}
+	setup := func(existing []*model.Reaction) (*ArchiveProcessor, *plugintest.API, *[]string) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		api.On("GetReactions", "post1").Return(existing, nil)
+		var added []string
+		api.On("AddReaction", mock.AnythingOfType("*model.Reaction")).Run(func(args mock.Arguments) {
+			reaction := args.Get(0).(*model.Reaction)
+			assert.Equal(t, "bot1", reaction.UserId)
+			assert.Equal(t, "post1", reaction.PostId)
+			added = append(added, reaction.EmojiName)
+		}).Return(&model.Reaction{}, nil)
+
+		processor := setupTestProcessor()
+		processor.api = api
+		processor.threadReplyService = NewThreadReplyService(api, "bot1")
+		return processor, api, &added
+	}
+
+	t.Run("one reaction per archived type", func(t *testing.T) {
+		processor, _, added := setup(nil)
+		processor.reactWithContentTypes("post1", []*archiveResult{
+			{URL: "https://example.com/a.pdf", Metadata: &ArchiveMetadata{MimeType: "application/pdf"}},
+			{URL: "https://example.com/b.pdf", Metadata: &ArchiveMetadata{MimeType: "application/pdf"}, OriginalPostID: "post0"},
+			{URL: "https://example.com/feed", Expanded: []*archiveResult{
+				{URL: "https://example.com/c.png", Metadata: &ArchiveMetadata{MimeType: "Image/PNG"}},
+			}},
+			{URL: "https://example.com/", Err: errors.New("download failed")},
+			{URL: "https://example.com/d.zip", Metadata: &ArchiveMetadata{MimeType: "application/zip"}},
+			nil,
+		}, config)
+
+		assert.Equal(t, []string{"page_facing_up", "frame_with_picture"}, *added)
+	})
+
+	t.Run("existing reactions of the bot are kept", func(t *testing.T) {
+		processor, _, added := setup([]*model.Reaction{
+			{UserId: "bot1", PostId: "post1", EmojiName: "page_facing_up"},
+			{UserId: "user1", PostId: "post1", EmojiName: "globe_with_meridians"},
+		})
+		processor.reactWithContentTypes("post1", []*archiveResult{
+			{URL: "https://example.com/a.pdf", Metadata: &ArchiveMetadata{MimeType: "application/pdf"}},
+			{URL: "https://example.com/", Metadata: &ArchiveMetadata{MimeType: "text/html; charset=utf-8"}},
+		}, config)
+
+		assert.Equal(t, []string{"globe_with_meridians"}, *added)
+	})
+
+	t.Run("disabled when empty", func(t *testing.T) {
+		processor, api, _ := setup(nil)
+		processor.reactWithContentTypes("post1", []*archiveResult{
+			{URL: "https://example.com/a.pdf", Metadata: &ArchiveMetadata{MimeType: "application/pdf"}},
+		}, &configuration{})
+
+		api.AssertNotCalled(t, "GetReactions", mock.Anything)
+		api.AssertNotCalled(t, "AddReaction", mock.Anything)
+	})
+}
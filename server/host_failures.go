@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostFailureTracker counts the archival failures of each host within a time window, so admins get
+// a single alert when a host keeps failing instead of one message per URL. Counters are reset when
+// their window ends.
+type hostFailureTracker struct {
+	lock  sync.Mutex
+	hosts map[string]*hostFailures
+}
+
+// hostFailures are the failures of a host in the current window
+type hostFailures struct {
+	since   time.Time      // Start of the window
+	count   int            // Failures in the window
+	reasons map[string]int // Failures per reason
+	lastURL string         // Last URL that failed, redacted
+	lastErr string         // Error of the last failure
+	alerted bool           // An alert was sent for this window
+}
+
+// hostFailureAlert is the summary of the failures of a host that crossed the alert threshold
+type hostFailureAlert struct {
+	Host    string
+	Count   int
+	Window  time.Duration
+	Reasons map[string]int
+	LastURL string
+	LastErr string
+}
+
+// newHostFailureTracker creates a new host failure tracker
+func newHostFailureTracker() *hostFailureTracker {
+	return &hostFailureTracker{hosts: make(map[string]*hostFailures)}
+}
+
+// record counts a failure of a host and returns an alert the first time the host reaches threshold
+// failures within the window, nil otherwise
+func (t *hostFailureTracker) record(host, reason, failedURL, errMsg string, now time.Time, threshold int, window time.Duration) *hostFailureAlert {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Drop the counters of ended windows, so hosts that stopped failing don't accumulate
+	for h, failures := range t.hosts {
+		if now.Sub(failures.since) >= window {
+			delete(t.hosts, h)
+		}
+	}
+
+	failures, ok := t.hosts[host]
+	if !ok {
+		failures = &hostFailures{since: now, reasons: make(map[string]int)}
+		t.hosts[host] = failures
+	}
+	failures.count++
+	failures.reasons[reason]++
+	failures.lastURL = failedURL
+	failures.lastErr = errMsg
+
+	if failures.alerted || failures.count < threshold {
+		return nil
+	}
+	failures.alerted = true
+
+	reasons := make(map[string]int, len(failures.reasons))
+	for r, count := range failures.reasons {
+		reasons[r] = count
+	}
+	return &hostFailureAlert{
+		Host:    host,
+		Count:   failures.count,
+		Window:  window,
+		Reasons: reasons,
+		LastURL: failures.lastURL,
+		LastErr: failures.lastErr,
+	}
+}
+
+// trackFailures counts the failed archives of a result, including the links it expanded to, and
+// posts an alert to the admin alert channel when a host crosses the threshold
+func (p *ArchiveProcessor) trackFailures(log logger, result *archiveResult, config *configuration) {
+	channelID := strings.TrimSpace(config.AdminAlertChannelID)
+	if channelID == "" || p.hostFailures == nil || p.threadReplyService == nil {
+		return
+	}
+
+	for _, r := range flattenResults([]*archiveResult{result}) {
+		if r.Err == nil {
+			continue
+		}
+		parsedURL, err := url.Parse(r.URL)
+		if err != nil || parsedURL.Hostname() == "" {
+			continue
+		}
+
+		alert := p.hostFailures.record(strings.ToLower(parsedURL.Hostname()), extractErrorReason(r.Err), redactURL(r.URL), r.Err.Error(),
+			time.Now(), config.getAdminAlertThreshold(), config.getAdminAlertWindow())
+		if alert == nil {
+			continue
+		}
+
+		log.LogWarn("Host crossed the failure alert threshold", "host", alert.Host, "failures", alert.Count)
+		if err := p.threadReplyService.PostAdminAlert(channelID, formatHostFailureAlert(alert)); err != nil {
+			log.LogError("Failed to post admin alert", "channelID", channelID, "host", alert.Host, "error", err.Error())
+		}
+	}
+}
+
+// formatHostFailureAlert builds the message of a host failure alert
+func formatHostFailureAlert(alert *hostFailureAlert) string {
+	reasons := make([]string, 0, len(alert.Reasons))
+	for reason := range alert.Reasons {
+		reasons = append(reasons, reason)
+	}
+	// Most frequent reasons first
+	sort.Slice(reasons, func(i, j int) bool {
+		if alert.Reasons[reasons[i]] != alert.Reasons[reasons[j]] {
+			return alert.Reasons[reasons[i]] > alert.Reasons[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%s (%d)", reason, alert.Reasons[reason])
+	}
+
+	return fmt.Sprintf("⚠️ Archiving links from `%s` failed %d times in the last %d minute(s).\n\n**Reasons:** %s\n**Last URL:** %s\n**Last error:** %s\n\nNo other alert is sent for this host until the window ends.",
+		alert.Host,
+		alert.Count,
+		int(alert.Window.Minutes()),
+		strings.Join(reasons, ", "),
+		alert.LastURL,
+		alert.LastErr,
+	)
+}
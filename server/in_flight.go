@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+)
+
+// inFlightArchives coalesces the concurrent archives of a URL within a deduplication scope. A link
+// posted in many channels at once would otherwise be downloaded by every post, as none of them has
+// stored its archive yet when the others look for one.
+type inFlightArchives struct {
+	lock    sync.Mutex
+	flights map[string]*archiveFlight
+}
+
+// archiveFlight is an archive of a URL in progress, which the other archives of the URL wait for
+type archiveFlight struct {
+	done chan struct{}
+	// metadata is the archive stored by the flight, nil if it stored none. Set before done is closed.
+	metadata *ArchiveMetadata
+}
+
+// newInFlightArchives creates an empty registry of archives in progress
+func newInFlightArchives() *inFlightArchives {
+	return &inFlightArchives{flights: make(map[string]*archiveFlight)}
+}
+
+// join returns the archive in progress for the key, or nil if there's none. The caller then leads the
+// archive of the key and must call finish once done, the next callers get its flight to wait for.
+func (f *inFlightArchives) join(key string) *archiveFlight {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if flight, ok := f.flights[key]; ok {
+		return flight
+	}
+	f.flights[key] = &archiveFlight{done: make(chan struct{})}
+	return nil
+}
+
+// finish ends the archive of the key led by the caller, with the archive it stored or nil
+func (f *inFlightArchives) finish(key string, metadata *ArchiveMetadata) {
+	f.lock.Lock()
+	flight, ok := f.flights[key]
+	delete(f.flights, key)
+	f.lock.Unlock()
+
+	if ok {
+		flight.metadata = metadata
+		close(flight.done)
+	}
+}
+
+// wait waits for the archive in progress and returns the archive it stored, nil if it stored none
+func (f *archiveFlight) wait() *ArchiveMetadata {
+	<-f.done
+	return f.metadata
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInFlightArchives(t *testing.T) {
+	inFlight := newInFlightArchives()
+	require.Nil(t, inFlight.join("key"), "the first archive leads")
+
+	flight := inFlight.join("key")
+	require.NotNil(t, flight)
+	assert.Nil(t, inFlight.join("other"), "keys are independent")
+
+	metadata := &ArchiveMetadata{FileID: "file1"}
+	go inFlight.finish("key", metadata)
+	assert.Same(t, metadata, flight.wait())
+
+	assert.Nil(t, inFlight.join("key"), "finished archives can be led again")
+}
@@ -1,6 +1,15 @@
 package main
 
+import "time"
+
+// runJob runs hourly on a single server of the cluster, refreshing the watched URLs due for a refresh
 func (p *Plugin) runJob() {
-	// Include job logic here
-	p.API.LogInfo("Job is currently running")
+	if p.archiveProcessor == nil {
+		return
+	}
+
+	summary := p.archiveProcessor.RefreshArchives(p.getConfiguration(), time.Now())
+	if summary.Refreshed > 0 {
+		p.API.LogInfo("Refreshed watched URLs", "due", summary.Due, "refreshed", summary.Refreshed, "changed", summary.Changed, "failed", summary.Failed)
+	}
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
 )
 
 // LinkExtractor extracts URLs from post messages
@@ -17,6 +22,18 @@ func NewLinkExtractor() *LinkExtractor {
 // ExtractURLs extracts all URLs from a post message text
 // Handles various formats: plain URLs, markdown links, etc.
 func (e *LinkExtractor) ExtractURLs(message string) []string {
+	return e.extractURLs(message, false, "")
+}
+
+// ExtractURLsWithBase extracts all URLs from a post message text like ExtractURLs, also resolving
+// relative markdown links such as [doc](/files/report.pdf). They are resolved against the closest
+// absolute URL preceding them in the message, or baseURL if there is none. Relative links are
+// skipped when no base can be determined.
+func (e *LinkExtractor) ExtractURLsWithBase(message, baseURL string) []string {
+	return e.extractURLs(message, true, baseURL)
+}
+
+func (e *LinkExtractor) extractURLs(message string, resolveRelative bool, baseURL string) []string {
 	var urls []string
 	seen := make(map[string]bool)
 
@@ -24,21 +41,24 @@ func (e *LinkExtractor) ExtractURLs(message string) []string {
 	urlPattern := regexp.MustCompile(`(?i)(https?://[^\s<>"{}|\\^` + "`" + `\[\]]+)`)
 
 	// First, extract plain URLs
-	matches := urlPattern.FindAllString(message, -1)
+	matches := urlPattern.FindAllStringIndex(message, -1)
 	for _, match := range matches {
-		match = strings.Trim(match, ".,;:!?)")
-		if isValidURL(match) && !seen[match] {
-			urls = append(urls, match)
-			seen[match] = true
+		plainURL := strings.Trim(message[match[0]:match[1]], ".,;:!?)")
+		if isValidURL(plainURL) && !seen[plainURL] {
+			urls = append(urls, plainURL)
+			seen[plainURL] = true
 		}
 	}
 
 	// Also handle markdown links: [text](url)
 	markdownPattern := regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
-	markdownMatches := markdownPattern.FindAllStringSubmatch(message, -1)
+	markdownMatches := markdownPattern.FindAllStringSubmatchIndex(message, -1)
 	for _, match := range markdownMatches {
-		if len(match) >= 3 {
-			linkURL := match[2]
+		if len(match) >= 6 {
+			linkURL := message[match[4]:match[5]]
+			if resolveRelative && !isValidURL(linkURL) {
+				linkURL = resolveRelativeURL(linkURL, precedingURL(message, matches, match[0], baseURL))
+			}
 			if isValidURL(linkURL) && !seen[linkURL] {
 				urls = append(urls, linkURL)
 				seen[linkURL] = true
@@ -49,6 +69,316 @@ func (e *LinkExtractor) ExtractURLs(message string) []string {
 	return urls
 }
 
+// StripCodeBlocks removes the fenced code blocks and inline code spans of a message, following the
+// CommonMark rules, so example links in code snippets aren't extracted. Blocks are replaced with
+// empty lines and spans with a space, keeping the surrounding links apart. Unclosed fences run to
+// the end of the message, and unmatched backticks are kept as text.
+func StripCodeBlocks(message string) string {
+	var stripped, prose strings.Builder
+	var fenceChar byte
+	fenceLength := 0
+	for _, line := range strings.SplitAfter(message, "\n") {
+		if fenceLength > 0 {
+			if char, length, rest := codeFence(line); char == fenceChar && length >= fenceLength && strings.TrimSpace(rest) == "" {
+				fenceLength = 0
+			}
+			if strings.HasSuffix(line, "\n") {
+				stripped.WriteByte('\n')
+			}
+			continue
+		}
+
+		// Backtick fences can't have backticks in their info string, ```code``` is an inline span
+		if char, length, rest := codeFence(line); length > 0 && (char == '~' || !strings.Contains(rest, "`")) {
+			// Code spans don't cross fenced blocks
+			stripped.WriteString(stripCodeSpans(prose.String()))
+			prose.Reset()
+			fenceChar, fenceLength = char, length
+			if strings.HasSuffix(line, "\n") {
+				stripped.WriteByte('\n')
+			}
+			continue
+		}
+		prose.WriteString(line)
+	}
+	stripped.WriteString(stripCodeSpans(prose.String()))
+	return stripped.String()
+}
+
+// codeFence parses the code fence opening or closing a fenced code block at the start of a line:
+// at least three backticks or tildes, indented by up to three spaces. Returns the fence character,
+// the length of the fence, zero if the line isn't one, and the rest of the line.
+func codeFence(line string) (byte, int, string) {
+	indented := strings.TrimLeft(line, " ")
+	if len(line)-len(indented) > 3 || indented == "" || (indented[0] != '`' && indented[0] != '~') {
+		return 0, 0, ""
+	}
+	char := indented[0]
+	length := 0
+	for length < len(indented) && indented[length] == char {
+		length++
+	}
+	if length < 3 {
+		return 0, 0, ""
+	}
+	return char, length, indented[length:]
+}
+
+// stripCodeSpans replaces the inline code spans of a text with a space. A span opens with a run of
+// backticks and closes with the next run of the same length, backslash-escaped backticks don't open spans.
+func stripCodeSpans(text string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) && text[i+1] == '`' {
+				stripped.WriteString(text[i : i+2])
+				i += 2
+				continue
+			}
+		case '`':
+			length := backtickRun(text, i)
+			if end := closingBacktickRun(text, i+length, length); end >= 0 {
+				stripped.WriteByte(' ')
+				i = end + length
+			} else {
+				// Unmatched backticks are text
+				stripped.WriteString(text[i : i+length])
+				i += length
+			}
+			continue
+		}
+		stripped.WriteByte(text[i])
+		i++
+	}
+	return stripped.String()
+}
+
+// backtickRun returns the length of the run of backticks starting at start
+func backtickRun(text string, start int) int {
+	end := start
+	for end < len(text) && text[end] == '`' {
+		end++
+	}
+	return end - start
+}
+
+// closingBacktickRun returns the start of the first run of exactly length backticks from start, -1 if none
+func closingBacktickRun(text string, start, length int) int {
+	for i := start; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		run := backtickRun(text, i)
+		if run == length {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// ExtractDataURIs extracts the data URIs of a post message text, like data:image/png;base64,...
+// They hold their content instead of pointing to it, and aren't returned by ExtractURLs.
+func (e *LinkExtractor) ExtractDataURIs(message string) []string {
+	var uris []string
+	seen := make(map[string]bool)
+
+	// The media type is required, data URIs without one are too easily found in regular text
+	dataURIPattern := regexp.MustCompile(`(?i)\bdata:[a-z0-9!#$&^_.+-]+/[a-z0-9!#$&^_.+-]+(?:;[a-z0-9!#$&^_.+=-]+)*,[^\s<>"'()\[\]]+`)
+	for _, uri := range dataURIPattern.FindAllString(message, -1) {
+		if !seen[uri] {
+			uris = append(uris, uri)
+			seen[uri] = true
+		}
+	}
+
+	return uris
+}
+
+// ExtractAttachmentURLs extracts the URLs of a post's message attachments, like those posted by
+// integrations, and of its link embeds. Links in attachment texts and fields are extracted like
+// links of messages.
+func (e *LinkExtractor) ExtractAttachmentURLs(post *model.Post) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(links ...string) {
+		for _, link := range links {
+			link = strings.TrimSpace(link)
+			if isValidURL(link) && !seen[link] {
+				urls = append(urls, link)
+				seen[link] = true
+			}
+		}
+	}
+
+	for _, attachment := range post.Attachments() {
+		if attachment == nil {
+			continue
+		}
+		add(attachment.TitleLink, attachment.AuthorLink, attachment.ImageURL, attachment.ThumbURL)
+		add(e.ExtractURLs(attachment.Pretext)...)
+		add(e.ExtractURLs(attachment.Text)...)
+		for _, field := range attachment.Fields {
+			if value, ok := field.Value.(string); ok {
+				add(e.ExtractURLs(value)...)
+			}
+		}
+	}
+
+	if post.Metadata != nil {
+		for _, embed := range post.Metadata.Embeds {
+			if embed != nil {
+				add(embed.URL)
+			}
+		}
+	}
+
+	return urls
+}
+
+// ExtractPreviewedURLs extracts the URLs of a post's embeds showing a preview image: images, and
+// OpenGraph previews with an image. Empty if Mattermost didn't generate link previews for the post.
+func (e *LinkExtractor) ExtractPreviewedURLs(post *model.Post) []string {
+	if post.Metadata == nil {
+		return nil
+	}
+
+	var urls []string
+	for _, embed := range post.Metadata.Embeds {
+		if embed == nil || embed.URL == "" {
+			continue
+		}
+		switch embed.Type {
+		case model.PostEmbedImage:
+			urls = append(urls, embed.URL)
+		case model.PostEmbedOpengraph:
+			if openGraphHasImage(embed.Data) {
+				urls = append(urls, embed.URL)
+			}
+		}
+	}
+	return urls
+}
+
+// openGraphHasImage checks if the OpenGraph data of an embed has an image. The data is decoded
+// through JSON, as embeds coming from the server over RPC don't keep the OpenGraph type.
+func openGraphHasImage(data any) bool {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+	var openGraph struct {
+		Images []struct {
+			URL       string `json:"url"`
+			SecureURL string `json:"secure_url"`
+		} `json:"images"`
+	}
+	if err := json.Unmarshal(encoded, &openGraph); err != nil {
+		return false
+	}
+	for _, image := range openGraph.Images {
+		if image.URL != "" || image.SecureURL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameSite checks if a link points to the site, comparing hostnames, ports and the site's path,
+// so links to a Mattermost server installed under a subpath only match that subpath
+func isSameSite(link, siteURL string) bool {
+	linkURL, err := url.Parse(link)
+	if err != nil || !isHTTPURL(linkURL) {
+		return false
+	}
+	site, err := url.Parse(strings.TrimSpace(siteURL))
+	if err != nil || !isHTTPURL(site) {
+		return false
+	}
+
+	if !strings.EqualFold(linkURL.Hostname(), site.Hostname()) || hostPort(linkURL) != hostPort(site) {
+		return false
+	}
+	sitePath := strings.TrimSuffix(site.Path, "/")
+	return sitePath == "" || linkURL.Path == sitePath || strings.HasPrefix(linkURL.Path, sitePath+"/")
+}
+
+// isSelfLink checks if a link points to the Mattermost server, at its site URL or one of its internal
+// host patterns, like *.chat.example.com
+func isSelfLink(link, siteURL string, internalHosts []string) bool {
+	if siteURL != "" && isSameSite(link, siteURL) {
+		return true
+	}
+	if len(internalHosts) == 0 {
+		return false
+	}
+	linkURL, err := url.Parse(link)
+	if err != nil || !isHTTPURL(linkURL) {
+		return false
+	}
+	hostname := strings.ToLower(linkURL.Hostname())
+	for _, pattern := range internalHosts {
+		if archiver.HostnameMatches(hostname, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort returns the port of an http(s) URL, the scheme's default port if none is set
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "http" {
+		return "80"
+	}
+	return "443"
+}
+
+// precedingURL returns the last valid absolute URL starting before the offset, or fallback if there is none
+func precedingURL(message string, urlMatches [][]int, offset int, fallback string) string {
+	for i := len(urlMatches) - 1; i >= 0; i-- {
+		if urlMatches[i][0] >= offset {
+			continue
+		}
+		if candidate := strings.Trim(message[urlMatches[i][0]:urlMatches[i][1]], ".,;:!?)"); isValidURL(candidate) {
+			return candidate
+		}
+	}
+	return fallback
+}
+
+// resolveRelativeURL resolves a relative link against an absolute http(s) base URL. Returns an empty
+// string if the link has a scheme of its own, or the base or resolved URL isn't an http(s) URL.
+func resolveRelativeURL(link, baseURL string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || baseURL == "" {
+		return ""
+	}
+	ref, err := url.Parse(link)
+	// Links to a fragment of the same page don't point to anything new
+	if err != nil || ref.Scheme != "" || (ref.Host == "" && ref.Path == "" && ref.RawQuery == "") {
+		return ""
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil || !isHTTPURL(base) {
+		return ""
+	}
+	resolved := base.ResolveReference(ref)
+	if !isHTTPURL(resolved) {
+		return ""
+	}
+	return resolved.String()
+}
+
+// isHTTPURL checks if a parsed URL is an absolute http or https URL
+func isHTTPURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // isValidURL checks if a string is a valid URL
 func isValidURL(s string) bool {
 	u, err := url.Parse(s)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractURLs(t *testing.T) {
+	extractor := NewLinkExtractor()
+
+	t.Run("plain and markdown links", func(t *testing.T) {
+		urls := extractor.ExtractURLs("See https://example.com/a, and [the doc](https://example.com/doc.pdf).")
+		assert.Equal(t, []string{"https://example.com/a", "https://example.com/doc.pdf"}, urls)
+	})
+
+	t.Run("relative links are ignored", func(t *testing.T) {
+		urls := extractor.ExtractURLs("See https://example.com/a and [report](/files/report.pdf)")
+		assert.Equal(t, []string{"https://example.com/a"}, urls)
+	})
+}
+
+func TestStripCodeBlocks(t *testing.T) {
+	extractor := NewLinkExtractor()
+	extract := func(message string) []string {
+		return extractor.ExtractURLs(StripCodeBlocks(message))
+	}
+
+	t.Run("fenced code blocks", func(t *testing.T) {
+		message := "Deploy docs at https://docs.example.com/deploy\n```bash\ncurl https://api.example.com/v1/items\n```\nThen see https://example.com/after"
+		assert.Equal(t, []string{"https://docs.example.com/deploy", "https://example.com/after"}, extract(message))
+	})
+
+	t.Run("tilde and longer fences", func(t *testing.T) {
+		message := "~~~\nhttps://example.com/tilde\n~~~\n````\n```\nhttps://example.com/nested\n```\n````\nhttps://example.com/prose"
+		assert.Equal(t, []string{"https://example.com/prose"}, extract(message))
+	})
+
+	t.Run("unclosed fences run to the end", func(t *testing.T) {
+		assert.Equal(t, []string{"https://example.com/before"}, extract("https://example.com/before\n```\nhttps://example.com/code"))
+	})
+
+	t.Run("inline code spans", func(t *testing.T) {
+		message := "Use `https://example.com/placeholder` or ``https://example.com/`tick`/path`` instead of https://example.com/real"
+		assert.Equal(t, []string{"https://example.com/real"}, extract(message))
+	})
+
+	t.Run("single line fences are inline code", func(t *testing.T) {
+		assert.Equal(t, []string{"https://example.com/after"}, extract("```https://example.com/inline``` https://example.com/after"))
+	})
+
+	t.Run("spans keep adjacent links apart", func(t *testing.T) {
+		assert.Equal(t, []string{"https://example.com/a"}, extract("https://example.com/a`https://example.com/b`"))
+	})
+
+	t.Run("unmatched and escaped backticks are text", func(t *testing.T) {
+		assert.Equal(t, []string{"https://example.com/a"}, extract("It's a ` tick https://example.com/a"))
+		assert.Equal(t, []string{"https://example.com/b"}, extract("\\`https://example.com/b`"))
+		assert.Equal(t, "a \\`b` c", StripCodeBlocks("a \\`b` c"))
+	})
+
+	t.Run("markdown links in prose", func(t *testing.T) {
+		assert.Equal(t, []string{"https://example.com/doc.pdf"}, extract("[the doc](https://example.com/doc.pdf) and `[example](https://example.com/x)`"))
+	})
+}
+
+func TestExtractURLsWithBase(t *testing.T) {
+	extractor := NewLinkExtractor()
+
+	tests := []struct {
+		name     string
+		message  string
+		baseURL  string
+		expected []string
+	}{
+		{
+			name:     "resolved against the base URL",
+			message:  "The [report](/files/report.pdf) is out",
+			baseURL:  "https://intranet.example.com/wiki/",
+			expected: []string{"https://intranet.example.com/files/report.pdf"},
+		},
+		{
+			name:     "path relative links",
+			message:  "[report](files/report.pdf) and [parent](../index.html)",
+			baseURL:  "https://intranet.example.com/wiki/page",
+			expected: []string{"https://intranet.example.com/wiki/files/report.pdf", "https://intranet.example.com/index.html"},
+		},
+		{
+			name:     "preceding absolute link takes precedence",
+			message:  "From https://docs.example.org/guide/: [chapter](chapter2.html), [root](/) ",
+			baseURL:  "https://intranet.example.com/",
+			expected: []string{"https://docs.example.org/guide/", "https://docs.example.org/guide/chapter2.html", "https://docs.example.org/"},
+		},
+		{
+			name:     "closest preceding link is used",
+			message:  "[a](https://a.example.com/x/) [b](y.pdf) https://b.example.com/z/ [c](w.pdf)",
+			expected: []string{"https://a.example.com/x/", "https://b.example.com/z/", "https://a.example.com/x/y.pdf", "https://b.example.com/z/w.pdf"},
+		},
+		{
+			name:     "links after the relative one aren't a base",
+			message:  "[report](/report.pdf) from https://example.com/",
+			expected: []string{"https://example.com/"},
+		},
+		{
+			name:     "skipped without a base",
+			message:  "The [report](/files/report.pdf) is out",
+			expected: nil,
+		},
+		{
+			name:     "invalid base URL is ignored",
+			message:  "The [report](/files/report.pdf) is out",
+			baseURL:  "ftp://files.example.com/",
+			expected: nil,
+		},
+		{
+			name:     "other schemes and fragments are skipped",
+			message:  "[mail](mailto:someone@example.com) [top](#top) [page](?page=2)",
+			baseURL:  "https://example.com/list",
+			expected: []string{"https://example.com/list?page=2"},
+		},
+		{
+			name:     "resolved duplicates are kept once",
+			message:  "https://example.com/a.pdf [a](/a.pdf)",
+			expected: []string{"https://example.com/a.pdf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, extractor.ExtractURLsWithBase(tt.message, tt.baseURL))
+		})
+	}
+}
+
+func TestExtractDataURIs(t *testing.T) {
+	extractor := NewLinkExtractor()
+
+	t.Run("plain and markdown data URIs", func(t *testing.T) {
+		uris := extractor.ExtractDataURIs("Logo: data:image/png;base64,iVBORw0KGgo= and ![icon](data:image/svg+xml;charset=utf-8,%3Csvg%3E) or data:image/png;base64,iVBORw0KGgo=")
+		assert.Equal(t, []string{"data:image/png;base64,iVBORw0KGgo=", "data:image/svg+xml;charset=utf-8,%3Csvg%3E"}, uris)
+	})
+
+	t.Run("data URIs without media type or data are ignored", func(t *testing.T) {
+		assert.Empty(t, extractor.ExtractDataURIs("metadata:foo, data:,hello and data:text/plain, https://example.com/data:x"))
+	})
+
+	t.Run("data URIs aren't extracted as links", func(t *testing.T) {
+		assert.Empty(t, extractor.ExtractURLs("[logo](data:image/png;base64,iVBORw0KGgo=)"))
+	})
+}
+
+func TestExtractAttachmentURLs(t *testing.T) {
+	extractor := NewLinkExtractor()
+
+	post := &model.Post{Message: "See https://example.com/a"}
+	post.AddProp(model.PostPropsAttachments, []*model.SlackAttachment{
+		{
+			Pretext:   "Build https://ci.example.com/build/1 finished",
+			TitleLink: "https://ci.example.com/build/1",
+			Text:      "[Report](https://ci.example.com/report.pdf)",
+			Fields:    []*model.SlackAttachmentField{{Title: "Logs", Value: "https://ci.example.com/logs.txt"}, {Title: "Count", Value: 3}},
+			ImageURL:  "https://ci.example.com/badge.png",
+		},
+	})
+	post.Metadata = &model.PostMetadata{Embeds: []*model.PostEmbed{{Type: model.PostEmbedOpengraph, URL: "https://example.com/a"}}}
+
+	assert.Equal(t, []string{
+		"https://ci.example.com/build/1",
+		"https://ci.example.com/badge.png",
+		"https://ci.example.com/report.pdf",
+		"https://ci.example.com/logs.txt",
+		"https://example.com/a",
+	}, extractor.ExtractAttachmentURLs(post))
+
+	assert.Empty(t, extractor.ExtractAttachmentURLs(&model.Post{Message: "See https://example.com/a"}))
+}
+
+func TestExtractPreviewedURLs(t *testing.T) {
+	extractor := NewLinkExtractor()
+
+	post := &model.Post{Message: "See https://example.com/a https://example.com/b https://example.com/c.png https://example.com/d"}
+	post.Metadata = &model.PostMetadata{Embeds: []*model.PostEmbed{
+		{Type: model.PostEmbedOpengraph, URL: "https://example.com/a", Data: map[string]any{
+			"title":  "A",
+			"images": []any{map[string]any{"secure_url": "https://cdn.example.com/a.png"}},
+		}},
+		{Type: model.PostEmbedOpengraph, URL: "https://example.com/b", Data: map[string]any{"title": "No image"}},
+		{Type: model.PostEmbedImage, URL: "https://example.com/c.png"},
+		{Type: model.PostEmbedLink, URL: "https://example.com/d"},
+	}}
+
+	assert.Equal(t, []string{"https://example.com/a", "https://example.com/c.png"}, extractor.ExtractPreviewedURLs(post))
+	assert.Empty(t, extractor.ExtractPreviewedURLs(&model.Post{Message: "See https://example.com/a"}))
+}
+
+func TestIsSameSite(t *testing.T) {
+	tests := []struct {
+		link     string
+		siteURL  string
+		expected bool
+	}{
+		{"https://chat.example.com/team/pl/abc123", "https://chat.example.com", true},
+		{"https://Chat.Example.com:443/api/v4/files/abc", "https://chat.example.com/", true},
+		{"https://chat.example.com:8065/team", "https://chat.example.com", false},
+		{"http://chat.example.com:8065/team", "http://chat.example.com:8065", true},
+		{"https://example.com/mattermost/team/pl/abc", "https://example.com/mattermost", true},
+		{"https://example.com/mattermost", "https://example.com/mattermost/", true},
+		{"https://example.com/blog/post", "https://example.com/mattermost", false},
+		{"https://example.com/mattermost-docs", "https://example.com/mattermost", false},
+		{"https://other.example.com/team", "https://chat.example.com", false},
+		{"https://chat.example.com/team", "", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, isSameSite(tt.link, tt.siteURL), "%s on %s", tt.link, tt.siteURL)
+	}
+}
+
+func TestIsSelfLink(t *testing.T) {
+	internalHosts := []string{"mm.internal", "*.chat.example.com"}
+
+	assert.True(t, isSelfLink("https://chat.example.com/team/pl/abc", "https://chat.example.com", internalHosts))
+	assert.True(t, isSelfLink("http://MM.internal:8065/api/v4/files/abc", "https://chat.example.com", internalHosts))
+	assert.True(t, isSelfLink("https://eu.chat.example.com/team", "", internalHosts))
+	assert.False(t, isSelfLink("https://example.com/mm.internal", "https://chat.example.com", internalHosts))
+	assert.False(t, isSelfLink("https://other.example.com/team", "https://chat.example.com", nil))
+	assert.False(t, isSelfLink("ftp://mm.internal/file", "", internalHosts))
+}
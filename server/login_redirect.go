@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultLoginRedirectPatterns are the host prefixes and paths identifying login pages when none are configured
+var defaultLoginRedirectPatterns = []string{
+	"accounts.",
+	"auth.",
+	"login.",
+	"sso.",
+	"/login",
+	"/signin",
+	"/sign-in",
+	"/sso",
+	"/oauth",
+	"/saml",
+}
+
+// getLoginRedirectPatterns returns the lowercase login page patterns, falling back to the defaults if unset
+func (c *configuration) getLoginRedirectPatterns() []string {
+	patterns := parseListSetting(strings.ToLower(c.LoginRedirectPatterns))
+	if len(patterns) == 0 {
+		return defaultLoginRedirectPatterns
+	}
+	return patterns
+}
+
+// detectLoginRedirect checks if a URL was redirected to what looks like a login page instead of its content.
+// To avoid false positives only redirects to another host are considered, and then the final URL must
+// match a login pattern or be a very small HTML page. Returns the reason the URL was flagged, if any.
+func detectLoginRedirect(requestedURL string, metadata *URLMetadata, config *configuration) (string, bool) {
+	if !config.LoginRedirectDetection || metadata == nil || metadata.FinalURL == "" {
+		return "", false
+	}
+
+	requested, err := url.Parse(requestedURL)
+	if err != nil {
+		return "", false
+	}
+	final, err := url.Parse(metadata.FinalURL)
+	if err != nil {
+		return "", false
+	}
+
+	finalHost := strings.ToLower(final.Hostname())
+	if finalHost == "" || finalHost == strings.ToLower(requested.Hostname()) {
+		return "", false
+	}
+
+	finalPath := strings.ToLower(final.EscapedPath())
+	for _, pattern := range config.getLoginRedirectPatterns() {
+		if strings.HasPrefix(pattern, "/") {
+			// Path patterns match whole segments, so /login matches /user/login/ and /login.php but not /logins
+			if strings.HasSuffix(finalPath, pattern) || strings.Contains(finalPath, pattern+"/") || strings.Contains(finalPath, pattern+".") {
+				return fmt.Sprintf("The link redirected to %s, which looks like a login page.", final.Redacted()), true
+			}
+		} else if strings.HasPrefix(finalHost, pattern) {
+			return fmt.Sprintf("The link redirected to %s, which looks like a login page.", final.Redacted()), true
+		}
+	}
+
+	if config.LoginRedirectMaxHTMLBytes > 0 && metadata.MimeType == "text/html" &&
+		metadata.Size >= 0 && metadata.Size < int64(config.LoginRedirectMaxHTMLBytes) {
+		return fmt.Sprintf("The link redirected to %s, which returned a very small page (%s) that is likely a login or error page.",
+			final.Redacted(), formatFileSize(metadata.Size)), true
+	}
+
+	return "", false
+}
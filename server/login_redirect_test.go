@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLoginRedirect(t *testing.T) {
+	enabled := &configuration{LoginRedirectDetection: true, LoginRedirectMaxHTMLBytes: 1024}
+
+	tests := []struct {
+		name     string
+		url      string
+		metadata *URLMetadata
+		config   *configuration
+		flagged  bool
+	}{
+		{
+			name:     "login path on another host",
+			url:      "https://wiki.example.com/page",
+			metadata: &URLMetadata{FinalURL: "https://id.example.com/login?next=%2Fpage", MimeType: "text/html", Size: -1},
+			config:   enabled,
+			flagged:  true,
+		},
+		{
+			name:     "login script on another host",
+			url:      "https://wiki.example.com/page",
+			metadata: &URLMetadata{FinalURL: "https://portal.example.com/users/login.php", MimeType: "text/html", Size: -1},
+			config:   enabled,
+			flagged:  true,
+		},
+		{
+			name:     "accounts host",
+			url:      "https://docs.example.com/d/123",
+			metadata: &URLMetadata{FinalURL: "https://accounts.example.com/ServiceLogin", MimeType: "text/html", Size: -1},
+			config:   enabled,
+			flagged:  true,
+		},
+		{
+			name:     "very small HTML page on another host",
+			url:      "https://example.com/report",
+			metadata: &URLMetadata{FinalURL: "https://cdn.example.net/", MimeType: "text/html", Size: 300},
+			config:   enabled,
+			flagged:  true,
+		},
+		{
+			name:     "login path on the same host isn't flagged",
+			url:      "https://example.com/login",
+			metadata: &URLMetadata{FinalURL: "https://example.com/login", MimeType: "text/html", Size: 300},
+			config:   enabled,
+		},
+		{
+			name:     "redirect to a content page isn't flagged",
+			url:      "https://example.com/file.pdf",
+			metadata: &URLMetadata{FinalURL: "https://cdn.example.net/files/file.pdf", MimeType: "application/pdf", Size: 300},
+			config:   enabled,
+		},
+		{
+			name:     "similar path segment isn't flagged",
+			url:      "https://example.com/post",
+			metadata: &URLMetadata{FinalURL: "https://blog.example.net/logins-explained", MimeType: "text/html", Size: -1},
+			config:   enabled,
+		},
+		{
+			name:     "unknown size isn't flagged as small",
+			url:      "https://example.com/report",
+			metadata: &URLMetadata{FinalURL: "https://cdn.example.net/", MimeType: "text/html", Size: -1},
+			config:   enabled,
+		},
+		{
+			name:     "custom patterns replace the defaults",
+			url:      "https://example.com/page",
+			metadata: &URLMetadata{FinalURL: "https://id.example.com/login", MimeType: "text/html", Size: -1},
+			config:   &configuration{LoginRedirectDetection: true, LoginRedirectPatterns: "/gate, idp."},
+		},
+		{
+			name:     "custom pattern",
+			url:      "https://example.com/page",
+			metadata: &URLMetadata{FinalURL: "https://IDP.example.com/start", MimeType: "text/html", Size: -1},
+			config:   &configuration{LoginRedirectDetection: true, LoginRedirectPatterns: "/gate, idp."},
+			flagged:  true,
+		},
+		{
+			name:     "disabled",
+			url:      "https://wiki.example.com/page",
+			metadata: &URLMetadata{FinalURL: "https://id.example.com/login", MimeType: "text/html", Size: -1},
+			config:   &configuration{},
+		},
+		{
+			name:   "no metadata",
+			url:    "https://wiki.example.com/page",
+			config: enabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, flagged := detectLoginRedirect(tt.url, tt.metadata, tt.config)
+			assert.Equal(t, tt.flagged, flagged)
+			if tt.flagged {
+				assert.NotEmpty(t, reason)
+			}
+		})
+	}
+}
+
+func TestGetURLMetadataFinalURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer server.Close()
+
+	metadata, err := NewContentDetector(0).GetURLMetadata(server.URL + "/private")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/login", metadata.FinalURL)
+}
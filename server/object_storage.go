@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// objectStorageTimeout is the timeout for uploads to the external object storage
+	objectStorageTimeout = 2 * time.Minute
+	// objectStoragePresignExpiry is how long presigned links to mirrored files stay valid, the
+	// maximum allowed by Signature Version 4
+	objectStoragePresignExpiry = 7 * 24 * time.Hour
+)
+
+// ObjectStorageMirror uploads archived files to an external S3-compatible bucket
+type ObjectStorageMirror struct {
+	client        *http.Client
+	endpoint      *url.URL
+	region        string
+	bucket        string
+	accessKeyID   string
+	secretKey     string
+	publicBaseURL string
+}
+
+// NewObjectStorageMirror creates a mirror from the configuration.
+// Returns nil if mirroring is disabled, or an error if the configuration is incomplete.
+func NewObjectStorageMirror(config *configuration) (*ObjectStorageMirror, error) {
+	if !config.S3MirrorEnabled {
+		return nil, nil
+	}
+
+	if config.S3Endpoint == "" || config.S3Bucket == "" || config.S3AccessKeyID == "" || config.S3SecretAccessKey == "" {
+		return nil, errors.New("object storage mirror requires an endpoint, bucket, access key ID and secret access key")
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(config.S3Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, errors.Errorf("invalid object storage endpoint: %s", config.S3Endpoint)
+	}
+
+	region := config.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &ObjectStorageMirror{
+		client:        &http.Client{Timeout: objectStorageTimeout},
+		endpoint:      endpoint,
+		region:        region,
+		bucket:        config.S3Bucket,
+		accessKeyID:   config.S3AccessKeyID,
+		secretKey:     config.S3SecretAccessKey,
+		publicBaseURL: strings.TrimSuffix(config.S3PublicBaseURL, "/"),
+	}, nil
+}
+
+// Upload stores the data under the given object key and returns the external URL of the object
+func (m *ObjectStorageMirror) Upload(key string, data []byte, contentType string) (string, error) {
+	// Path-style addressing works with AWS S3 as well as MinIO and other S3-compatible servers
+	objectPath := "/" + m.bucket + "/" + escapeObjectKey(key)
+	objectURL := m.endpoint.Scheme + "://" + m.endpoint.Host + m.endpoint.Path + objectPath
+
+	req, err := http.NewRequest(http.MethodPut, objectURL, bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create upload request")
+	}
+	req.ContentLength = int64(len(data))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	m.sign(req, m.endpoint.Path+objectPath, data, time.Now().UTC())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to upload to object storage")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", errors.Errorf("object storage upload failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return m.ObjectURL(key, time.Now().UTC()), nil
+}
+
+// ObjectURL returns the link users open a mirrored object with. Without a public base URL, the link
+// is presigned so objects of private buckets can be read until it expires.
+func (m *ObjectStorageMirror) ObjectURL(key string, now time.Time) string {
+	if m.publicBaseURL != "" {
+		return m.publicBaseURL + "/" + escapeObjectKey(key)
+	}
+	canonicalURI := m.endpoint.Path + "/" + m.bucket + "/" + escapeObjectKey(key)
+	return m.presign(m.endpoint.Scheme, m.endpoint.Host, canonicalURI, now, objectStoragePresignExpiry)
+}
+
+// presign returns a GET URL of the object authenticated with an AWS Signature Version 4 query string
+func (m *ObjectStorageMirror) presign(scheme, host, canonicalURI string, now time.Time, expiry time.Duration) string {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + m.region + "/s3/aws4_request"
+
+	// Parameters are sorted by name, as required for the canonical query string
+	query := strings.Join([]string{
+		"X-Amz-Algorithm=AWS4-HMAC-SHA256",
+		"X-Amz-Credential=" + escapeQueryValue(m.accessKeyID+"/"+scope),
+		"X-Amz-Date=" + amzDate,
+		fmt.Sprintf("X-Amz-Expires=%d", int(expiry.Seconds())),
+		"X-Amz-SignedHeaders=host",
+	}, "&")
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI,
+		query,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	signature := m.signature(date, amzDate, scope, canonicalRequest)
+	return scheme + "://" + host + canonicalURI + "?" + query + "&X-Amz-Signature=" + signature
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to the request
+func (m *ObjectStorageMirror) sign(req *http.Request, canonicalURI string, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		"", // No query string
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + m.region + "/s3/aws4_request"
+	signature := m.signature(date, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		m.accessKeyID, scope, signedHeaders, signature))
+}
+
+// signature returns the Signature Version 4 signature of the canonical request
+func (m *ObjectStorageMirror) signature(date, amzDate, scope, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+m.secretKey), date)
+	signingKey = hmacSHA256(signingKey, m.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+// getMirrorObjectKey generates the object key for an archived file
+// Files are keyed by content hash so identical content maps to the same object
+func getMirrorObjectKey(contentHash, filename string) string {
+	filename = strings.NewReplacer("/", "_", "\\", "_").Replace(filename)
+	if filename == "" {
+		filename = "archived_file"
+	}
+	return "link-archiver/" + contentHash + "/" + filename
+}
+
+// escapeObjectKey URI-encodes an object key as required by Signature Version 4,
+// encoding every byte except unreserved characters and the "/" separators
+func escapeObjectKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// escapeQueryValue URI-encodes a query string value as required by Signature Version 4, "/" included
+func escapeQueryValue(value string) string {
+	return strings.ReplaceAll(escapeObjectKey(value), "/", "%2F")
+}
+
+// sha256Hex returns the hex-encoded SHA256 hash of data
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 computes the HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
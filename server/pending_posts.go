@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Pending post modes, see configuration.PendingPosts
+const (
+	// PendingPostsArchive archives pending posts right away, like any other post
+	PendingPostsArchive = "archive"
+	// PendingPostsDefer archives pending posts once they stop being edited
+	PendingPostsDefer = "defer"
+	// PendingPostsSkip doesn't archive the links of pending posts
+	PendingPostsSkip = "skip"
+)
+
+const (
+	// defaultPendingPostDelay is the time a deferred post must go unedited to be archived, when not configured
+	defaultPendingPostDelay = 15 * time.Second
+	// pendingPostMaxChecks bounds the times a deferred post is re-read, so posts edited continuously
+	// are still archived
+	pendingPostMaxChecks = 5
+)
+
+// defaultPendingPostProps are the props marking posts as pending when none are configured: posts
+// of incoming webhooks, often edited by their integration with the final content, and an explicit
+// pending flag
+var defaultPendingPostProps = []string{model.PostPropsFromWebhook, "pending"}
+
+// getPendingPostsMode returns how pending posts are archived, archived right away if unset or unknown
+func (c *configuration) getPendingPostsMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(c.PendingPosts)); mode {
+	case PendingPostsDefer, PendingPostsSkip:
+		return mode
+	default:
+		return PendingPostsArchive
+	}
+}
+
+// getPendingPostProps returns the props marking posts as pending, the defaults if unset
+func (c *configuration) getPendingPostProps() []string {
+	if props := parseListSetting(c.PendingPostProps); len(props) > 0 {
+		return props
+	}
+	return defaultPendingPostProps
+}
+
+// getPendingPostDelay returns the time a deferred post must go unedited to be archived
+func (c *configuration) getPendingPostDelay() time.Duration {
+	if c.PendingPostDelaySeconds <= 0 {
+		return defaultPendingPostDelay
+	}
+	return time.Duration(c.PendingPostDelaySeconds) * time.Second
+}
+
+// isPendingPost reports whether a post has one of the props marking pending posts, set to a value
+// other than false
+func (c *configuration) isPendingPost(post *model.Post) bool {
+	return slices.ContainsFunc(c.getPendingPostProps(), func(prop string) bool {
+		switch value := post.GetProp(prop).(type) {
+		case nil:
+			return false
+		case bool:
+			return value
+		case string:
+			return value != "" && !strings.EqualFold(value, "false")
+		default:
+			return true
+		}
+	})
+}
+
+// awaitStablePost waits for a post to go unedited for the delay, re-reading it after each wait, and
+// returns its latest version. Posts still edited after pendingPostMaxChecks waits are returned as
+// they are. Returns nil if the post was deleted or the plugin deactivated meanwhile.
+func (p *Plugin) awaitStablePost(post *model.Post, delay time.Duration) *model.Post {
+	for check := 1; ; check++ {
+		select {
+		case <-time.After(delay):
+		case <-p.stopDeferrals:
+			return nil
+		}
+
+		current, appErr := p.API.GetPost(post.Id)
+		if appErr != nil {
+			p.API.LogDebug("Deferred post is gone, not archiving it", "postID", post.Id, "error", appErr.Error())
+			return nil
+		}
+		if current.DeleteAt != 0 {
+			return nil
+		}
+		if current.EditAt == post.EditAt {
+			return current
+		}
+		if check == pendingPostMaxChecks {
+			p.API.LogInfo("Deferred post is still being edited, archiving its latest version", "postID", post.Id)
+			return current
+		}
+		post = current
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPendingPost(t *testing.T) {
+	postWithProp := func(key string, value any) *model.Post {
+		post := &model.Post{Id: "post1"}
+		post.AddProp(key, value)
+		return post
+	}
+
+	tests := []struct {
+		name     string
+		post     *model.Post
+		config   *configuration
+		expected bool
+	}{
+		{"webhook posts", postWithProp(model.PostPropsFromWebhook, "true"), &configuration{}, true},
+		{"pending flag", postWithProp("pending", true), &configuration{}, true},
+		{"pending flag set to false", postWithProp("pending", "false"), &configuration{}, false},
+		{"regular posts", &model.Post{Id: "post1"}, &configuration{}, false},
+		{"configured props", postWithProp("status", "rendering"), &configuration{PendingPostProps: "status, placeholder"}, true},
+		{"configured props replace the defaults", postWithProp(model.PostPropsFromWebhook, "true"), &configuration{PendingPostProps: "status"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.config.isPendingPost(tt.post))
+		})
+	}
+}
+
+func TestGetPendingPostsMode(t *testing.T) {
+	assert.Equal(t, PendingPostsArchive, (&configuration{}).getPendingPostsMode())
+	assert.Equal(t, PendingPostsDefer, (&configuration{PendingPosts: " Defer"}).getPendingPostsMode())
+	assert.Equal(t, PendingPostsSkip, (&configuration{PendingPosts: "skip"}).getPendingPostsMode())
+	assert.Equal(t, PendingPostsArchive, (&configuration{PendingPosts: "later"}).getPendingPostsMode())
+
+	assert.Equal(t, defaultPendingPostDelay, (&configuration{}).getPendingPostDelay())
+	assert.Equal(t, 3*time.Second, (&configuration{PendingPostDelaySeconds: 3}).getPendingPostDelay())
+}
+
+func TestAwaitStablePost(t *testing.T) {
+	const delay = 5 * time.Millisecond
+	placeholder := &model.Post{Id: "post1", Message: "Rendering..."}
+
+	t.Run("returns the post once it stops changing", func(t *testing.T) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", Message: "Report: https://example.com/report.pdf", EditAt: 100}, nil).Once()
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", Message: "Report: https://example.com/report.pdf", EditAt: 100}, nil).Once()
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		stable := p.awaitStablePost(placeholder, delay)
+		if assert.NotNil(t, stable) {
+			assert.Equal(t, "Report: https://example.com/report.pdf", stable.Message)
+		}
+		api.AssertNumberOfCalls(t, "GetPost", 2)
+	})
+
+	t.Run("unedited posts are returned after a single wait", func(t *testing.T) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		api.On("GetPost", "post1").Return(placeholder, nil).Once()
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		assert.Equal(t, placeholder, p.awaitStablePost(placeholder, delay))
+	})
+
+	t.Run("posts edited continuously are returned after the last check", func(t *testing.T) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		for i := 1; i <= pendingPostMaxChecks; i++ {
+			api.On("GetPost", "post1").Return(&model.Post{Id: "post1", EditAt: int64(i)}, nil).Once()
+		}
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		stable := p.awaitStablePost(placeholder, delay)
+		if assert.NotNil(t, stable) {
+			assert.Equal(t, int64(pendingPostMaxChecks), stable.EditAt)
+		}
+	})
+
+	t.Run("deleted posts aren't returned", func(t *testing.T) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		api.On("GetPost", "post1").Return(nil, model.NewAppError("GetPost", "not_found", nil, "", http.StatusNotFound))
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		assert.Nil(t, p.awaitStablePost(placeholder, delay))
+	})
+
+	t.Run("stops on deactivation", func(t *testing.T) {
+		p := &Plugin{stopDeferrals: make(chan struct{})}
+		p.SetAPI(&plugintest.API{})
+		close(p.stopDeferrals)
+
+		assert.Nil(t, p.awaitStablePost(placeholder, time.Hour))
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -45,6 +46,21 @@ type Plugin struct {
 
 	// threadReplyService handles creating thread replies
 	threadReplyService *ThreadReplyService
+
+	// storageUsage caches the storage usage reported by /archive usage, see StorageUsage
+	storageUsageLock sync.Mutex
+	storageUsage     *command.StorageUsage
+
+	// stopDeferrals is closed on deactivation, to stop waiting for deferred pending posts
+	stopDeferrals chan struct{}
+
+	// backfills are the backfills running on this server, by channel ID
+	backfillsLock sync.Mutex
+	backfills     map[string]*backfillRun
+
+	// purging is set while a purge of all archives runs on this server, see startPurge
+	purgeLock sync.Mutex
+	purging   bool
 }
 
 // OnActivate is invoked when the plugin is activated. If an error is returned, the plugin will be deactivated.
@@ -53,7 +69,9 @@ func (p *Plugin) OnActivate() error {
 
 	p.kvstore = kvstore.NewKVStore(p.client)
 
-	p.commandClient = command.NewCommandHandler(p.client)
+	p.commandClient = command.NewCommandHandler(p.client, p)
+
+	p.stopDeferrals = make(chan struct{})
 
 	// Initialize bot service and ensure bot exists
 	p.botService = NewBotService(p.API)
@@ -63,12 +81,27 @@ func (p *Plugin) OnActivate() error {
 
 	// Initialize thread reply service
 	p.threadReplyService = NewThreadReplyService(p.API, p.botService.GetBotID())
+	p.threadReplyService.SetChannelJoiner(p.botService)
 
 	// Initialize archive processor
 	linkExtractor := NewLinkExtractor()
-	contentDetector := NewContentDetector(10 * time.Second)
+	contentDetector := NewContentDetector(DefaultDetectionTimeout)
 	storageService := NewStorageService(p.API)
+	storageService.SetFileDeleter(p)
 	p.archiveProcessor = NewArchiveProcessor(p.API, linkExtractor, contentDetector, storageService, p.threadReplyService)
+	p.archiveProcessor.ApplyConfiguration(p.getConfiguration())
+
+	// Archives stored before the archive index existed are listed in it once
+	go func() {
+		added, err := storageService.BackfillArchiveIndex()
+		if err != nil {
+			p.API.LogWarn("Failed to backfill the archive index", "error", err.Error())
+			return
+		}
+		if added > 0 {
+			p.API.LogInfo("Backfilled the archive index", "archives", added)
+		}
+	}()
 
 	job, err := cluster.Schedule(
 		p.API,
@@ -87,6 +120,10 @@ func (p *Plugin) OnActivate() error {
 
 // OnDeactivate is invoked when the plugin is deactivated.
 func (p *Plugin) OnDeactivate() error {
+	if p.stopDeferrals != nil {
+		close(p.stopDeferrals)
+	}
+	p.cancelBackfills()
 	if p.backgroundJob != nil {
 		if err := p.backgroundJob.Close(); err != nil {
 			p.API.LogError("Failed to close background job", "err", err)
@@ -104,6 +141,19 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 	return response, nil
 }
 
+// JoinChannel adds the bot to a channel and its team, so it can reply there
+func (p *Plugin) JoinChannel(channelID string) (bool, error) {
+	return p.botService.EnsureChannelMember(channelID)
+}
+
+// ArchivingEnabled reports whether archiving is enabled in the plugin settings. Only the settings
+// are read, not the rules stored in the KV store, so it can be checked often.
+func (p *Plugin) ArchivingEnabled() bool {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+	return p.configuration == nil || p.configuration.isEnabled()
+}
+
 // MessageHasBeenPosted is invoked when a message has been posted by a user.
 // This hook is called after the message has been committed to the database.
 func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
@@ -114,13 +164,117 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 
 	// Get current configuration
 	config := p.getConfiguration()
+	if !config.isEnabled() || p.isIgnoredPost(post, config) {
+		return
+	}
+
+	// Pending posts are placeholders their integration edits with the final content
+	if config.isPendingPost(post) {
+		switch config.getPendingPostsMode() {
+		case PendingPostsSkip:
+			p.API.LogDebug("Not archiving the links of a pending post", "postID", post.Id)
+			return
+		case PendingPostsDefer:
+			go func() {
+				stable := p.awaitStablePost(post, config.getPendingPostDelay())
+				if stable == nil {
+					return
+				}
+				// The configuration may have changed while waiting
+				config := p.getConfiguration()
+				if !config.isEnabled() || p.isIgnoredPost(stable, config) {
+					return
+				}
+				p.archiveNewPost(stable, config)
+			}()
+			return
+		}
+	}
 
 	// Process the post for archival (async, non-blocking)
+	go p.archiveNewPost(post, config)
+}
+
+// archiveNewPost archives the links of a new post, if automatic archival is enabled or the post
+// mentions the bot
+func (p *Plugin) archiveNewPost(post *model.Post, config *configuration) {
+	// Mentioning the bot archives the links on demand, even if automatic archival is disabled
+	message := post.Message
+	mentioned := p.botService != nil && p.botService.IsMentioned(message)
+	if mentioned {
+		message = p.botService.StripMention(message)
+	}
+	if config.DisableAutoArchive && !mentioned {
+		return
+	}
+
+	if err := p.archiveProcessor.ProcessPost(post, message, config); err != nil {
+		p.API.LogError("Failed to process post for archival", "postID", post.Id, "error", err.Error())
+	}
+}
+
+// MessageHasBeenUpdated is invoked when a message has been updated, which includes pinning it.
+// Newly pinned posts have their links archived if enabled, when they weren't archived yet.
+func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	config := p.getConfiguration()
+	if !p.shouldArchiveOnPin(newPost, oldPost, config) {
+		return
+	}
+
 	go func() {
-		if err := p.archiveProcessor.ProcessPost(post.Id, post.Message, config); err != nil {
-			p.API.LogError("Failed to process post for archival", "postID", post.Id, "error", err.Error())
+		if err := p.archiveProcessor.ProcessPost(newPost, newPost.Message, config); err != nil {
+			p.API.LogError("Failed to process pinned post for archival", "postID", newPost.Id, "error", err.Error())
 		}
 	}()
 }
 
+// shouldArchiveOnPin reports whether an update pinned a post whose links must be archived.
+// Links already archived for the post, when it was posted or by a backfill, are skipped by
+// ProcessPost, so only the ones that weren't archived are.
+func (p *Plugin) shouldArchiveOnPin(newPost, oldPost *model.Post, config *configuration) bool {
+	if !config.ArchiveOnPin || newPost == nil || oldPost == nil || !newPost.IsPinned || oldPost.IsPinned {
+		return false
+	}
+	if p.botService != nil && newPost.UserId == p.botService.GetBotID() {
+		return false
+	}
+	return config.isEnabled() && !p.isIgnoredPost(newPost, config)
+}
+
+// isIgnoredPost reports whether the links of a post must not be archived: system messages and posts
+// of custom types not archived, posts of the ignored users, posts in the types of channels not
+// archived, and posts of bots and webhooks if bot posts are ignored
+func (p *Plugin) isIgnoredPost(post *model.Post, config *configuration) bool {
+	if !config.archivesPostType(post.Type) {
+		return true
+	}
+	if slices.Contains(config.getIgnoredUserIDs(), post.UserId) {
+		return true
+	}
+	if !config.archivesAllChannelTypes() {
+		// Links of channels whose type is unknown aren't archived, as they may be private
+		channel, appErr := p.API.GetChannel(post.ChannelId)
+		if appErr != nil {
+			p.API.LogWarn("Failed to get post channel, ignoring the post", "postID", post.Id, "error", appErr.Error())
+			return true
+		}
+		if !config.archivesChannelType(channel.Type) {
+			return true
+		}
+	}
+	if !config.IgnoreBotPosts {
+		return false
+	}
+	if post.GetProp(model.PostPropsFromWebhook) == "true" || post.GetProp(model.PostPropsFromBot) == "true" {
+		return true
+	}
+
+	user, appErr := p.API.GetUser(post.UserId)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get post author, not ignoring the post", "postID", post.Id, "error", appErr.Error())
+		return false
+	}
+	return user.IsBot
+}
+
 // See https://developers.mattermost.com/extend/plugins/server/reference/
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,3 +29,128 @@ func TestServeHTTP(t *testing.T) {
 
 	a.Equal("Hello, world!", bodyString)
 }
+
+func TestIsIgnoredPost(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1"}, nil)
+	api.On("GetUser", "ci-bot").Return(&model.User{Id: "ci-bot", IsBot: true}, nil)
+	api.On("GetUser", "missing").Return(nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound))
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	webhookPost := &model.Post{Id: "post3", UserId: "user1"}
+	webhookPost.AddProp(model.PostPropsFromWebhook, "true")
+
+	tests := []struct {
+		name     string
+		post     *model.Post
+		config   *configuration
+		expected bool
+	}{
+		{"bot posts archived by default", &model.Post{UserId: "ci-bot"}, &configuration{}, false},
+		{"bot posts", &model.Post{UserId: "ci-bot"}, &configuration{IgnoreBotPosts: true}, true},
+		{"webhook posts", webhookPost, &configuration{IgnoreBotPosts: true}, true},
+		{"system messages", &model.Post{UserId: "user1", Type: model.PostTypeHeaderChange}, &configuration{}, true},
+		{"system messages listed as custom types", &model.Post{UserId: "user1", Type: model.PostTypeJoinChannel}, &configuration{ArchiveCustomPostTypes: model.PostTypeJoinChannel}, true},
+		{"custom post types", &model.Post{UserId: "user1", Type: "custom_poll"}, &configuration{}, true},
+		{"archived custom post types", &model.Post{UserId: "user1", Type: "custom_poll"}, &configuration{ArchiveCustomPostTypes: "custom_todo, custom_poll"}, false},
+		{"user posts", &model.Post{UserId: "user1"}, &configuration{IgnoreBotPosts: true}, false},
+		{"unknown authors", &model.Post{UserId: "missing"}, &configuration{IgnoreBotPosts: true}, false},
+		{"ignored users", &model.Post{UserId: "user1"}, &configuration{IgnoredUserIDs: "integration1, user1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, p.isIgnoredPost(tt.post, tt.config))
+		})
+	}
+}
+
+func TestIsIgnoredPostChannelTypes(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetChannel", "direct").Return(&model.Channel{Id: "direct", Type: model.ChannelTypeDirect}, nil)
+	api.On("GetChannel", "group").Return(&model.Channel{Id: "group", Type: model.ChannelTypeGroup}, nil)
+	api.On("GetChannel", "private").Return(&model.Channel{Id: "private", Type: model.ChannelTypePrivate}, nil)
+	api.On("GetChannel", "public").Return(&model.Channel{Id: "public", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetChannel", "missing").Return(nil, model.NewAppError("GetChannel", "not_found", nil, "", http.StatusNotFound))
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	disabled := model.NewPointer(false)
+	tests := []struct {
+		name      string
+		channelID string
+		config    *configuration
+	}{
+		{"direct messages", "direct", &configuration{ArchiveInDirectMessages: disabled}},
+		{"group messages", "group", &configuration{ArchiveInGroupMessages: disabled}},
+		{"private channels", "private", &configuration{ArchiveInPrivateChannels: disabled}},
+		{"public channels", "public", &configuration{ArchiveInPublicChannels: disabled}},
+	}
+
+	channelIDs := []string{"direct", "group", "private", "public"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the channel type whose flag is disabled is ignored
+			for _, channelID := range channelIDs {
+				post := &model.Post{Id: "post1", UserId: "user1", ChannelId: channelID}
+				assert.Equal(t, channelID == tt.channelID, p.isIgnoredPost(post, tt.config), channelID)
+			}
+		})
+	}
+
+	t.Run("archived by default without looking up the channel", func(t *testing.T) {
+		post := &model.Post{Id: "post1", UserId: "user1", ChannelId: "missing"}
+		assert.False(t, p.isIgnoredPost(post, &configuration{ArchiveInPublicChannels: model.NewPointer(true)}))
+		api.AssertNotCalled(t, "GetChannel", "missing")
+	})
+
+	t.Run("unknown channels are ignored", func(t *testing.T) {
+		post := &model.Post{Id: "post1", UserId: "user1", ChannelId: "missing"}
+		assert.True(t, p.isIgnoredPost(post, &configuration{ArchiveInDirectMessages: disabled}))
+	})
+}
+
+func TestShouldArchiveOnPin(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1"}, nil)
+	p := &Plugin{botService: &BotService{botID: "bot1"}}
+	p.SetAPI(api)
+
+	unpinned := &model.Post{Id: "post1", UserId: "user1", Message: "https://example.com/"}
+	pinned := &model.Post{Id: "post1", UserId: "user1", Message: "https://example.com/", IsPinned: true}
+	onPin := &configuration{ArchiveOnPin: true}
+
+	tests := []struct {
+		name     string
+		newPost  *model.Post
+		oldPost  *model.Post
+		config   *configuration
+		expected bool
+	}{
+		{"newly pinned post", pinned, unpinned, onPin, true},
+		{"only archiving on mention", pinned, unpinned, &configuration{ArchiveOnPin: true, DisableAutoArchive: true}, true},
+		{"disabled by default", pinned, unpinned, &configuration{DisableAutoArchive: true}, false},
+		{"edits of pinned posts", pinned, pinned, onPin, false},
+		{"unpinned posts", unpinned, pinned, onPin, false},
+		{
+			// Links already archived for the post are skipped when processing it
+			"posts mentioning the bot",
+			&model.Post{UserId: "user1", Message: "@link-archiver https://example.com/", IsPinned: true},
+			&model.Post{UserId: "user1", Message: "@link-archiver https://example.com/"},
+			onPin, true,
+		},
+		{"bot posts", &model.Post{UserId: "bot1", IsPinned: true}, &model.Post{UserId: "bot1"}, onPin, false},
+		{"ignored users", pinned, unpinned, &configuration{ArchiveOnPin: true, IgnoredUserIDs: "user1"}, false},
+		{"plugin disabled", pinned, unpinned, &configuration{ArchiveOnPin: true, Enabled: model.NewPointer(false)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, p.shouldArchiveOnPin(tt.newPost, tt.oldPost, tt.config))
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// archiveKeyPrefix prefixes the KV keys of archive metadata, file reference counts and the archive index
+	archiveKeyPrefix = "archive_"
+	// purgeBatchSize is the number of KV keys listed, read or deleted at a time when purging archives
+	purgeBatchSize = 200
+	// purgeBatchPause spaces out the batches of a purge, so it doesn't hold the KV store for long
+	purgeBatchPause = 20 * time.Millisecond
+
+	// purgeConfirmation must be sent along with the confirmation token to purge all archives
+	purgeConfirmation = "PURGE ALL ARCHIVES"
+	// purgeTokenKey holds the pending purge confirmation token. It doesn't start with archiveKeyPrefix,
+	// so purges don't delete it.
+	purgeTokenKey = "purge_confirmation_token"
+	// purgeTokenTTLSeconds is how long purge confirmation tokens can be used
+	purgeTokenTTLSeconds = 300
+	// purgeStatusKey holds the status of the last purge. It doesn't start with archiveKeyPrefix, so
+	// purges don't delete it.
+	purgeStatusKey = "purge_status"
+
+	// States of a purge
+	purgeRunning   = "running"
+	purgeCompleted = "completed"
+	purgeFailed    = "failed"
+)
+
+// purgeToken is a pending purge confirmation, only usable by the admin who requested it
+type purgeToken struct {
+	Token  string `json:"token"`
+	UserID string `json:"userId"`
+}
+
+// purgeResult counts what a purge removed
+type purgeResult struct {
+	// FilesDeleted counts the archived files deleted, FilesKept the ones that couldn't be, like files
+	// never attached to a post or attached to posts of other users
+	FilesDeleted int `json:"filesDeleted"`
+	FilesKept    int `json:"filesKept"`
+	// PostsDeleted counts the bot replies deleted along with the files they attached
+	PostsDeleted int `json:"postsDeleted"`
+	// KeysDeleted counts the KV keys of archive metadata, reference counts, indexes and retries deleted
+	KeysDeleted int `json:"keysDeleted"`
+}
+
+// purgeStatus is the progress of a purge running in the background, or the outcome of the last one
+type purgeStatus struct {
+	// State is purgeRunning, purgeCompleted or purgeFailed
+	State     string    `json:"state"`
+	UserID    string    `json:"userId"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// FilesTotal is the number of archived files to delete, known once the archive metadata is read
+	FilesTotal int `json:"filesTotal"`
+	purgeResult
+	// Error is why a failed purge stopped, the counts are what it removed until then
+	Error string `json:"error,omitempty"`
+}
+
+// newPurgeToken creates the token confirming a purge of all archives by the user, replacing any pending one
+func (p *Plugin) newPurgeToken(userID string) (string, error) {
+	token := purgeToken{Token: model.NewId(), UserID: userID}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal purge token")
+	}
+	if appErr := p.API.KVSetWithExpiry(purgeTokenKey, data, purgeTokenTTLSeconds); appErr != nil {
+		return "", errors.Wrap(appErr, "failed to store purge token")
+	}
+	return token.Token, nil
+}
+
+// consumePurgeToken checks that a token confirms a purge requested by the user, and deletes it so
+// it's only used once
+func (p *Plugin) consumePurgeToken(userID, token string) (bool, error) {
+	data, appErr := p.API.KVGet(purgeTokenKey)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get purge token")
+	}
+	if data == nil || token == "" {
+		return false, nil
+	}
+	var pending purgeToken
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return false, errors.Wrap(err, "failed to unmarshal purge token")
+	}
+	if pending.Token != token || pending.UserID != userID {
+		return false, nil
+	}
+
+	if appErr := p.API.KVDelete(purgeTokenKey); appErr != nil {
+		return false, errors.Wrap(appErr, "failed to delete purge token")
+	}
+	return true, nil
+}
+
+// startPurge starts purging all archives in the background for the user, reporting its progress
+// in the purge status. Returns false if a purge is already running on this server.
+func (p *Plugin) startPurge(userID string) (*purgeStatus, bool) {
+	p.purgeLock.Lock()
+	defer p.purgeLock.Unlock()
+	if p.purging {
+		return nil, false
+	}
+	p.purging = true
+
+	now := time.Now()
+	status := &purgeStatus{State: purgeRunning, UserID: userID, StartedAt: now, UpdatedAt: now}
+	p.storePurgeStatus(status)
+	started := *status
+
+	go p.runPurge(status)
+	return &started, true
+}
+
+// runPurge purges all archives and stores the outcome in the purge status
+func (p *Plugin) runPurge(status *purgeStatus) {
+	defer func() {
+		p.purgeLock.Lock()
+		p.purging = false
+		p.purgeLock.Unlock()
+	}()
+
+	err := p.purgeArchives(status)
+	status.State = purgeCompleted
+	if err != nil {
+		status.State = purgeFailed
+		status.Error = err.Error()
+		p.API.LogError("Failed to purge archives", "userID", status.UserID, "error", err.Error(), "filesDeleted", status.FilesDeleted, "keysDeleted", status.KeysDeleted)
+	} else {
+		p.API.LogWarn("Purged all archives", "userID", status.UserID, "filesDeleted", status.FilesDeleted, "filesKept", status.FilesKept, "postsDeleted", status.PostsDeleted, "keysDeleted", status.KeysDeleted)
+	}
+	p.storePurgeStatus(status)
+}
+
+// storePurgeStatus stores the progress of a purge. Failures are only logged, they don't stop the purge.
+func (p *Plugin) storePurgeStatus(status *purgeStatus) {
+	status.UpdatedAt = time.Now()
+	data, err := json.Marshal(status)
+	if err != nil {
+		p.API.LogWarn("Failed to marshal purge status", "error", err.Error())
+		return
+	}
+	if appErr := p.API.KVSet(purgeStatusKey, data); appErr != nil {
+		p.API.LogWarn("Failed to store purge status", "error", appErr.Error())
+	}
+}
+
+// getPurgeStatus returns the status of the running or last purge, nil if none ran
+func (p *Plugin) getPurgeStatus() (*purgeStatus, error) {
+	data, appErr := p.API.KVGet(purgeStatusKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get purge status")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	status := &purgeStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal purge status")
+	}
+	return status, nil
+}
+
+// purgeArchives deletes every archived file and all archive metadata, reference counts and indexes,
+// counting what it removed in the status and storing it after each batch of files. The links of
+// error replies are deleted first, so retries don't archive content again once purged. Files are
+// deleted with the bot replies attaching them, archive keys are deleted last so a failing purge
+// can be run again. Archives stored while purging may be left over.
+func (p *Plugin) purgeArchives(status *purgeStatus) error {
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		return errors.New("storage service not initialized")
+	}
+	storage := p.archiveProcessor.storageService
+
+	retryKeys, err := storage.listKeys(retryKeyPrefix)
+	if err != nil {
+		return err
+	}
+	deleted, err := storage.deleteKeys(retryKeys)
+	status.KeysDeleted += deleted
+	if err != nil {
+		return err
+	}
+
+	keys, err := storage.listArchiveKeys()
+	if err != nil {
+		return err
+	}
+	fileIDs, err := storage.archivedFileIDs(keys)
+	if err != nil {
+		return err
+	}
+	status.FilesTotal = len(fileIDs)
+	p.storePurgeStatus(status)
+
+	botID := p.botService.GetBotID()
+	var deletedPosts []string
+	for i, fileID := range fileIDs {
+		if i > 0 && i%purgeBatchSize == 0 {
+			p.storePurgeStatus(status)
+			time.Sleep(purgeBatchPause)
+		}
+		postID, deleted := p.purgeArchivedFile(fileID, botID, deletedPosts)
+		if !deleted {
+			status.FilesKept++
+			continue
+		}
+		status.FilesDeleted++
+		if postID != "" {
+			deletedPosts = append(deletedPosts, postID)
+			status.PostsDeleted++
+		}
+	}
+	p.storePurgeStatus(status)
+
+	deleted, err = storage.deleteArchiveKeys(keys)
+	status.KeysDeleted += deleted
+	if err != nil {
+		return err
+	}
+
+	// Cached storage usage counts the purged files
+	p.storageUsageLock.Lock()
+	p.storageUsage = nil
+	p.storageUsageLock.Unlock()
+
+	return nil
+}
+
+// purgeArchivedFile deletes an archived file by deleting the bot post attaching it, whatever else the
+// post attaches as every archive is purged. Returns the ID of the post deleted, empty if it already
+// was, and whether the file was deleted.
+func (p *Plugin) purgeArchivedFile(fileID, botID string, deletedPosts []string) (string, bool) {
+	fileInfo, appErr := p.API.GetFileInfo(fileID)
+	if appErr != nil || fileInfo.PostId == "" {
+		return "", false
+	}
+	if slices.Contains(deletedPosts, fileInfo.PostId) {
+		return "", true
+	}
+
+	holder, appErr := p.API.GetPost(fileInfo.PostId)
+	if appErr != nil || holder.UserId != botID {
+		p.API.LogInfo("Archived file is attached to a post of another user, keeping it", "fileID", fileID, "postID", fileInfo.PostId)
+		return "", false
+	}
+	if appErr := p.API.DeletePost(holder.Id); appErr != nil {
+		p.API.LogWarn("Failed to delete post holding archived file", "postID", holder.Id, "error", appErr.Error())
+		return "", false
+	}
+	return holder.Id, true
+}
+
+// listArchiveKeys returns the KV keys of archive metadata, file reference counts and the archive index
+func (s *StorageService) listArchiveKeys() ([]string, error) {
+	return s.listKeys(archiveKeyPrefix)
+}
+
+// listKeys returns the KV keys starting with the prefix
+func (s *StorageService) listKeys(prefix string) ([]string, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		batch, appErr := s.api.KVList(page, purgeBatchSize)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list KV keys")
+		}
+		for _, key := range batch {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		if len(batch) < purgeBatchSize {
+			return keys, nil
+		}
+	}
+}
+
+// archivedFileIDs returns the IDs of the files referenced by the archive metadata of the keys,
+// previous captures included, each once
+func (s *StorageService) archivedFileIDs(keys []string) ([]string, error) {
+	var fileIDs []string
+	seen := make(map[string]bool)
+	add := func(fileID string) {
+		if fileID != "" && !seen[fileID] {
+			seen[fileID] = true
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	for i, key := range keys {
+		// Only metadata keys reference files, reference counts and the indexes hold other values
+		if strings.HasPrefix(key, "archive_file_refs_") || strings.HasPrefix(key, archiveIndexKeyPrefix) || strings.HasPrefix(key, postArchiveIndexKeyPrefix) {
+			continue
+		}
+		if i > 0 && i%purgeBatchSize == 0 {
+			time.Sleep(purgeBatchPause)
+		}
+
+		data, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get archive metadata")
+		}
+		if data == nil {
+			continue
+		}
+		// Post keys hold the list of archives of the post, global and thread keys a single archive
+		var metadataList []*ArchiveMetadata
+		var err error
+		if strings.HasPrefix(key, "archive_post_") {
+			err = json.Unmarshal(data, &metadataList)
+		} else {
+			metadata := &ArchiveMetadata{}
+			err = json.Unmarshal(data, metadata)
+			metadataList = []*ArchiveMetadata{metadata}
+		}
+		if err != nil {
+			s.api.LogWarn("Failed to unmarshal archive metadata, purging it without its files", "key", key, "error", err.Error())
+			continue
+		}
+		for _, metadata := range metadataList {
+			for _, fileID := range metadata.FileIDs() {
+				add(fileID)
+			}
+			for _, entry := range metadata.History {
+				add(entry.FileID)
+			}
+		}
+	}
+	return fileIDs, nil
+}
+
+// deleteArchiveKeys deletes KV keys of archives in batches and drops the cached archive lookups.
+// Returns the number of keys deleted.
+func (s *StorageService) deleteArchiveKeys(keys []string) (int, error) {
+	defer s.lookupCache.invalidateAll()
+	return s.deleteKeys(keys)
+}
+
+// deleteKeys deletes KV keys in batches. Returns the number of keys deleted.
+func (s *StorageService) deleteKeys(keys []string) (int, error) {
+	for i, key := range keys {
+		if i > 0 && i%purgeBatchSize == 0 {
+			time.Sleep(purgeBatchPause)
+		}
+		if appErr := s.api.KVDelete(key); appErr != nil {
+			return i, errors.Wrapf(appErr, "failed to delete key %s", key)
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeArchives(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	kv := setupMemoryKV(api)
+	api.On("KVSetWithExpiry", purgeTokenKey, mock.Anything, int64(purgeTokenTTLSeconds)).Return(func(key string, value []byte, _ int64) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		kv.data[key] = value
+		return nil
+	})
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(func(key string) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		delete(kv.data, key)
+		return nil
+	})
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	// file1 and file2 are attached to the same summary reply, file3 to a post of a user, file4 to no post
+	api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", PostId: "summary1"}, nil)
+	api.On("GetFileInfo", "file2").Return(&model.FileInfo{Id: "file2", PostId: "summary1"}, nil)
+	api.On("GetFileInfo", "file3").Return(&model.FileInfo{Id: "file3", PostId: "user-post"}, nil)
+	api.On("GetFileInfo", "file4").Return(&model.FileInfo{Id: "file4"}, nil)
+	api.On("GetPost", "summary1").Return(&model.Post{Id: "summary1", UserId: "bot1", FileIds: []string{"file1", "file2"}}, nil)
+	api.On("GetPost", "user-post").Return(&model.Post{Id: "user-post", UserId: "user"}, nil)
+	api.On("DeletePost", "summary1").Return(nil).Once()
+
+	storage := NewStorageService(api)
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a", FileID: "file1"}))
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a", FileID: "file1",
+		History: []ArchiveHistoryEntry{{PostID: "post0", FileID: "file2"}}}, ""))
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post2", OriginalURL: "https://example.com/b", FileID: "file3",
+		AdditionalFiles: []AdditionalFile{{FileID: "file4"}}}))
+	require.NoError(t, storage.StoreRetryContext("error-reply", "post3", "https://example.com/c"))
+	kv.data[archivalRulesKey] = []byte("[]")
+
+	p := &Plugin{
+		archiveProcessor: &ArchiveProcessor{api: api, storageService: storage},
+		botService:       &BotService{botID: "bot1"},
+	}
+	p.SetAPI(api)
+
+	request := func(userID string, body any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/archives/purge", bytes.NewReader(data))
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+	requestStatus := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archives/purge", nil)
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+	requestToken := func(t *testing.T) string {
+		w := request("admin", map[string]string{})
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			ConfirmationToken string `json:"confirmationToken"`
+			Confirm           string `json:"confirm"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		require.NotEmpty(t, response.ConfirmationToken)
+		assert.Equal(t, purgeConfirmation, response.Confirm)
+		return response.ConfirmationToken
+	}
+
+	t.Run("requires system admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("user", map[string]string{}).Code)
+	})
+
+	t.Run("requires the confirmation phrase and a valid token", func(t *testing.T) {
+		token := requestToken(t)
+		assert.Equal(t, http.StatusBadRequest, request("admin", map[string]string{"confirmationToken": token, "confirm": "yes"}).Code)
+		assert.Equal(t, http.StatusForbidden, request("admin", map[string]string{"confirmationToken": "other", "confirm": purgeConfirmation}).Code)
+	})
+
+	t.Run("tokens are only usable by the admin who requested them", func(t *testing.T) {
+		token := requestToken(t)
+		api.On("GetUser", "admin2").Return(&model.User{Id: "admin2", Roles: model.SystemAdminRoleId}, nil)
+		assert.Equal(t, http.StatusForbidden, request("admin2", map[string]string{"confirmationToken": token, "confirm": purgeConfirmation}).Code)
+	})
+
+	t.Run("only one purge runs at a time", func(t *testing.T) {
+		token := requestToken(t)
+		p.purging = true
+		defer func() { p.purging = false }()
+		assert.Equal(t, http.StatusConflict, request("admin", map[string]string{"confirmationToken": token, "confirm": purgeConfirmation}).Code)
+	})
+
+	t.Run("status requires system admin and a purge", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, requestStatus("user").Code)
+		assert.Equal(t, http.StatusNotFound, requestStatus("admin").Code)
+	})
+
+	t.Run("purges archives and their files in the background", func(t *testing.T) {
+		token := requestToken(t)
+		w := request("admin", map[string]string{"confirmationToken": token, "confirm": purgeConfirmation})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var started purgeStatus
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&started))
+		assert.Equal(t, purgeRunning, started.State)
+		assert.Equal(t, "admin", started.UserID)
+
+		var status purgeStatus
+		require.Eventually(t, func() bool {
+			w := requestStatus("admin")
+			require.Equal(t, http.StatusOK, w.Code)
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+			return status.State != purgeRunning
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, purgeCompleted, status.State)
+		assert.Empty(t, status.Error)
+		assert.Equal(t, 4, status.FilesTotal)
+		assert.Equal(t, 2, status.FilesDeleted)
+		assert.Equal(t, 2, status.FilesKept, "files of other users' posts and without a post are kept")
+		assert.Equal(t, 1, status.PostsDeleted)
+		assert.Equal(t, 10, status.KeysDeleted, "two post archives and their post indexes, a global archive, three reference counts, an index shard and a retry")
+		api.AssertCalled(t, "DeletePost", "summary1")
+
+		kv.lock.Lock()
+		assert.ElementsMatch(t, []string{archivalRulesKey, purgeStatusKey}, slices.Collect(maps.Keys(kv.data)), "only the keys of archives and retries are deleted")
+		kv.lock.Unlock()
+		archive, err := storage.GetExistingArchiveForURL("https://example.com/a", "")
+		require.NoError(t, err)
+		assert.Nil(t, archive)
+
+		// The token was used
+		assert.Equal(t, http.StatusForbidden, request("admin", map[string]string{"confirmationToken": token, "confirm": purgeConfirmation}).Code)
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+const (
+	// defaultRefreshInterval is the time between refreshes of the watched URLs, when not configured
+	defaultRefreshInterval = 24 * time.Hour
+	// defaultMaxRefreshesPerRun is the number of watched URLs refreshed by each run of the background
+	// job, when not configured
+	defaultMaxRefreshesPerRun = 10
+	// defaultMaxRefreshesPerHost is the number of URLs of a host refreshed by each run of the
+	// background job, when not configured
+	defaultMaxRefreshesPerHost = 2
+)
+
+// getRefreshURLs returns the watched URLs refreshed by the background job, URLs starting with them match
+func (c *configuration) getRefreshURLs() []string {
+	return parseListSetting(c.RefreshURLs)
+}
+
+// getRefreshInterval returns the time between refreshes of the watched URLs
+func (c *configuration) getRefreshInterval() time.Duration {
+	if c.RefreshIntervalSeconds <= 0 {
+		return defaultRefreshInterval
+	}
+	return time.Duration(c.RefreshIntervalSeconds) * time.Second
+}
+
+// getMaxRefreshesPerRun returns the number of watched URLs refreshed by each run of the background job
+func (c *configuration) getMaxRefreshesPerRun() int {
+	if c.MaxRefreshesPerRun <= 0 {
+		return defaultMaxRefreshesPerRun
+	}
+	return c.MaxRefreshesPerRun
+}
+
+// getMaxRefreshesPerHost returns the number of URLs of a host refreshed by each run of the background job
+func (c *configuration) getMaxRefreshesPerHost() int {
+	if c.MaxRefreshesPerHost <= 0 {
+		return defaultMaxRefreshesPerHost
+	}
+	return c.MaxRefreshesPerHost
+}
+
+// refreshesArchives reports whether any URL is watched, by the watched URLs or by an archival rule
+func (c *configuration) refreshesArchives() bool {
+	return len(c.getRefreshURLs()) > 0 || slices.ContainsFunc(c.ArchivalRules, func(rule ArchivalRule) bool {
+		return rule.RefreshIntervalSeconds > 0
+	})
+}
+
+// refreshCandidate is an archived URL due for a refresh
+type refreshCandidate struct {
+	// key is the key of the URL's global archive metadata
+	key      string
+	metadata *ArchiveMetadata
+	rule     ArchivalRule
+	interval time.Duration
+	due      time.Time
+}
+
+// refreshSummary counts the outcomes of a run of the refresh job
+type refreshSummary struct {
+	// Due is the number of watched URLs due for a refresh, including the ones left for later runs
+	Due       int
+	Refreshed int
+	Changed   int
+	Failed    int
+}
+
+// debugLog downgrades the info lines of a logger to debug, for the rule matches of the archives
+// scanned on each run of the refresh job
+type debugLog struct {
+	logger
+}
+
+// LogInfo logs an info message at debug level
+func (l debugLog) LogInfo(msg string, keyValuePairs ...any) {
+	l.LogDebug(msg, keyValuePairs...)
+}
+
+// refreshInterval returns the time between refreshes of an archived URL and the rule it's archived
+// by. The interval of the rule takes precedence over the watched URLs, zero if the URL isn't watched
+// or is excluded from archiving since.
+func (p *ArchiveProcessor) refreshInterval(metadata *ArchiveMetadata, config *configuration) (time.Duration, ArchivalRule) {
+	rule := p.matchArchivalRule(debugLog{p.api}, metadata.OriginalURL, metadata.MimeType, config)
+	if rule.Exclude {
+		return 0, rule
+	}
+	if rule.RefreshIntervalSeconds > 0 {
+		return time.Duration(rule.RefreshIntervalSeconds) * time.Second, rule
+	}
+	if slices.ContainsFunc(config.getRefreshURLs(), func(prefix string) bool {
+		return strings.HasPrefix(metadata.OriginalURL, prefix)
+	}) {
+		return config.getRefreshInterval(), rule
+	}
+	return 0, rule
+}
+
+// RefreshArchives archives the watched URLs due for a refresh again, creating a new capture and
+// replying in the thread of the post they were archived for when their content changed. URLs are
+// refreshed most overdue first, up to the maximum per run and per host, the others are left for
+// the next runs. Only URLs archived within a deduplication scope are refreshed, others have no
+// global archive to compare against. Nothing is refreshed while archiving is paused.
+func (p *ArchiveProcessor) RefreshArchives(config *configuration, now time.Time) refreshSummary {
+	var summary refreshSummary
+	if !config.isEnabled() || !config.refreshesArchives() || config.getDedupScope() == DedupScopeNone {
+		return summary
+	}
+
+	var candidates []refreshCandidate
+	err := p.storageService.ForEachGlobalArchive(func(key string, metadata *ArchiveMetadata) {
+		interval, rule := p.refreshInterval(metadata, config)
+		if interval <= 0 {
+			return
+		}
+		due := metadata.NextRefreshAt
+		if due.IsZero() {
+			due = metadata.ArchivedAt.Add(interval)
+		}
+		if !due.After(now) {
+			candidates = append(candidates, refreshCandidate{key: key, metadata: metadata, rule: rule, interval: interval, due: due})
+		}
+	})
+	if err != nil {
+		p.api.LogError("Failed to list archives to refresh", "error", err.Error())
+		return summary
+	}
+	summary.Due = len(candidates)
+
+	slices.SortStableFunc(candidates, func(a, b refreshCandidate) int {
+		return a.due.Compare(b.due)
+	})
+
+	perHost := map[string]int{}
+	for _, candidate := range candidates {
+		if summary.Refreshed == config.getMaxRefreshesPerRun() {
+			break
+		}
+		host := ""
+		if parsedURL, err := url.Parse(candidate.metadata.OriginalURL); err == nil {
+			host = strings.ToLower(parsedURL.Hostname())
+		}
+		if perHost[host] == config.getMaxRefreshesPerHost() {
+			continue
+		}
+		perHost[host]++
+		summary.Refreshed++
+
+		changed, err := p.refreshArchive(candidate, config, now)
+		switch {
+		case err != nil:
+			summary.Failed++
+		case changed:
+			summary.Changed++
+		}
+	}
+
+	return summary
+}
+
+// refreshArchive archives a watched URL again with the tool of its last capture, and stores a new
+// capture if its content changed. URLs failing to refresh wait for the next interval too, so they
+// don't take the refreshes of other URLs on every run.
+func (p *ArchiveProcessor) refreshArchive(candidate refreshCandidate, config *configuration, now time.Time) (bool, error) {
+	existing := candidate.metadata
+	log := newArchiveLog(p.api, existing.PostID)
+	nextRefresh := now.Add(candidate.interval)
+	postpone := func() {
+		if err := p.storageService.SetNextRefresh(candidate.key, nextRefresh); err != nil {
+			log.LogWarn("Failed to store next refresh time", "url", redactURL(existing.OriginalURL), "error", err.Error())
+		}
+	}
+
+	changed, err := p.refreshCapture(log, candidate, config, nextRefresh)
+	if err != nil {
+		log.LogWarn("Failed to refresh archive", "url", redactURL(existing.OriginalURL), "error", err.Error())
+		postpone()
+		return false, err
+	}
+	if !changed {
+		log.LogDebug("Refreshed URL content unchanged", "url", redactURL(existing.OriginalURL), "fileID", existing.FileID)
+		postpone()
+	}
+	return changed, nil
+}
+
+// refreshCapture downloads a watched URL and, if its content changed, stores the new capture as
+// the URL's most recent archive and replies with it in the original thread
+func (p *ArchiveProcessor) refreshCapture(log logger, candidate refreshCandidate, config *configuration, nextRefresh time.Time) (bool, error) {
+	existing := candidate.metadata
+
+	// Archives of a deduplication scope configured before aren't stored under the current one
+	scopeID, err := p.getDedupScopeID(existing.PostID, config.getDedupScope())
+	if err != nil {
+		return false, err
+	}
+	if p.storageService.globalArchiveKey(existing.OriginalURL, scopeID) != candidate.key {
+		return false, errors.New("archive belongs to another deduplication scope")
+	}
+
+	tool, ok := p.archivalTools[existing.ToolUsed]
+	if !ok {
+		return false, errors.Errorf("archival tool not found: %s", existing.ToolUsed)
+	}
+	if _, expanding := tool.(archiver.ExpandingArchivalTool); expanding {
+		return false, errors.Errorf("archival tool %s expands URLs instead of archiving them", existing.ToolUsed)
+	}
+
+	targetURL := existing.OriginalURL
+	if existing.CanonicalURL != "" {
+		targetURL = existing.CanonicalURL
+	}
+
+	release := p.acquireArchiveSlot()
+	defer release()
+
+	archivedFiles, err := p.timedArchiveFiles(log, tool, targetURL, existing.MimeType, p.archiveOptions(log, targetURL, candidate.rule, config))
+	if err != nil {
+		return false, err
+	}
+	archivedFile := archivedFiles[0]
+	if reason, flagged := detectSoft404(archivedFile, config); flagged {
+		return false, errors.New(reason)
+	}
+
+	if existing.MatchesContent(archivedFile.Data) {
+		return false, nil
+	}
+	log.LogInfo("Refreshed URL content changed, creating new archive", "url", redactURL(existing.OriginalURL), "oldHash", existing.ContentHash)
+
+	metadata, err := p.storageService.StoreArchivedFile(existing.PostID, existing.OriginalURL, archivedFile, existing.ToolUsed)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to store archived file")
+	}
+	metadata.DisplayName = config.getDisplayName(metadata)
+	metadata.CanonicalURL = existing.CanonicalURL
+	metadata.NextRefreshAt = nextRefresh
+	// Favicons rarely change, they aren't captured again
+	metadata.AdditionalFiles = p.storeAdditionalFiles(log, existing.PostID, existing.OriginalURL, archivedFiles[1:], existing.ToolUsed)
+	if config.IndexExtractedText && archiver.SupportsTextExtraction(archivedFile.MimeType) {
+		if text := p.storeExtractedText(log, existing.PostID, existing.OriginalURL, archivedFile, existing.ToolUsed); text != nil {
+			metadata.AdditionalFiles = append(metadata.AdditionalFiles, *text)
+		}
+	}
+	if existing.ToolUsed == archiver.DirectDownloadToolName {
+		metadata.SampleHash = sampleHashOfData(archivedFile.Data)
+	}
+
+	if err = p.storageService.StoreArchiveMetadata(metadata); err != nil {
+		log.LogError("Failed to store archive metadata", "error", err.Error())
+	}
+	if config.KeepHistory || candidate.rule.KeepHistory {
+		err = p.storageService.StoreGlobalArchiveCapture(metadata, scopeID, config.getMaxHistoryEntries())
+	} else {
+		err = p.storageService.StoreGlobalArchiveMetadata(metadata, scopeID)
+	}
+	if err != nil {
+		log.LogWarn("Failed to store global archive metadata", "error", err.Error())
+	}
+
+	if candidate.rule.replyOptions().Mode != ReplyModeNone && p.threadReplyService != nil {
+		if err := p.threadReplyService.ReplyWithRefresh(existing.PostID, metadata, existing.ArchivedAt); err != nil {
+			log.LogError("Failed to create refresh thread reply", "url", redactURL(existing.OriginalURL), "error", err.Error())
+		}
+	}
+	return true, nil
+}
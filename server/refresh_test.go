@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestRefreshArchives(t *testing.T) {
+	var content atomic.Value // string served for the URLs
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(content.Load().(string)))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	rules := []ArchivalRule{{Kind: "default", ArchivalTool: archiver.DirectDownloadToolName}}
+	hashOf := func(data string) string {
+		hash := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(hash[:])
+	}
+
+	setup := func(t *testing.T, archives ...*ArchiveMetadata) (*ArchiveProcessor, *plugintest.API, *[]*model.Post) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		setupMemoryKV(api)
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+		api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(&model.FileInfo{Id: "file2"}, nil)
+		var replies []*model.Post
+		api.On("CreatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+			replies = append(replies, post)
+			return post, nil
+		})
+
+		storage := NewStorageService(api)
+		for _, archive := range archives {
+			require.NoError(t, storage.StoreGlobalArchiveMetadata(archive, ""))
+		}
+		return NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), storage, NewThreadReplyService(api, "bot1")), api, &replies
+	}
+	archive := func(path string, archivedAt time.Time) *ArchiveMetadata {
+		return &ArchiveMetadata{
+			PostID:      "post1",
+			OriginalURL: server.URL + path,
+			FileID:      "file1",
+			Filename:    "status.txt",
+			MimeType:    "text/plain",
+			ToolUsed:    archiver.DirectDownloadToolName,
+			ContentHash: hashOf("all systems operational"),
+			ArchivedAt:  archivedAt,
+		}
+	}
+
+	t.Run("changed content creates a new capture and replies in the thread", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, _, replies := setup(t, archive("/status", now.Add(-48*time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status"}
+
+		summary := processor.RefreshArchives(config, now)
+		assert.Equal(t, refreshSummary{Due: 1, Refreshed: 1, Changed: 1}, summary)
+
+		latest, err := processor.storageService.GetExistingArchiveForURL(server.URL+"/status", "")
+		require.NoError(t, err)
+		require.NotNil(t, latest)
+		assert.Equal(t, "file2", latest.FileID)
+		assert.Equal(t, hashOf("partial outage"), latest.ContentHash)
+		assert.True(t, latest.NextRefreshAt.Equal(now.Add(defaultRefreshInterval)))
+
+		require.Len(t, *replies, 1)
+		reply := (*replies)[0]
+		assert.Equal(t, "post1", reply.RootId)
+		assert.Equal(t, []string{"file2"}, []string(reply.FileIds))
+		assert.Contains(t, reply.Message, "Content changed")
+	})
+
+	t.Run("unchanged content only schedules the next refresh", func(t *testing.T) {
+		content.Store("all systems operational")
+		processor, api, replies := setup(t, archive("/status", now.Add(-48*time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status", RefreshIntervalSeconds: 3600}
+
+		summary := processor.RefreshArchives(config, now)
+		assert.Equal(t, refreshSummary{Due: 1, Refreshed: 1}, summary)
+
+		latest, err := processor.storageService.GetExistingArchiveForURL(server.URL+"/status", "")
+		require.NoError(t, err)
+		assert.Equal(t, "file1", latest.FileID)
+		assert.True(t, latest.NextRefreshAt.Equal(now.Add(time.Hour)))
+		assert.Empty(t, *replies)
+		api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+
+		// Not due again until the next refresh time
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now.Add(30*time.Minute)))
+	})
+
+	t.Run("URLs aren't refreshed before their interval passed", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, _, _ := setup(t, archive("/status", now.Add(-time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status"}
+
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now))
+	})
+
+	t.Run("URLs not watched aren't refreshed", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, _, _ := setup(t, archive("/other", now.Add(-48*time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status"}
+
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now))
+	})
+
+	t.Run("rules watch their URLs", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, _, _ := setup(t, archive("/status", now.Add(-2*time.Hour)))
+		config := &configuration{ArchivalRules: []ArchivalRule{
+			{Kind: "hostname", Pattern: "127.0.0.1", ArchivalTool: archiver.DirectDownloadToolName, RefreshIntervalSeconds: 3600},
+			rules[0],
+		}}
+
+		assert.Equal(t, refreshSummary{Due: 1, Refreshed: 1, Changed: 1}, processor.RefreshArchives(config, now))
+	})
+
+	t.Run("refreshes are bounded per run and per host", func(t *testing.T) {
+		content.Store("all systems operational")
+		archives := []*ArchiveMetadata{
+			archive("/status/a", now.Add(-48*time.Hour)),
+			archive("/status/b", now.Add(-49*time.Hour)),
+			archive("/status/c", now.Add(-50*time.Hour)),
+		}
+		processor, _, _ := setup(t, archives...)
+
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status"}
+		assert.Equal(t, refreshSummary{Due: 3, Refreshed: 2}, processor.RefreshArchives(config, now))
+
+		// The most overdue URLs were refreshed first, the last one is left for the next run
+		latest, err := processor.storageService.GetExistingArchiveForURL(server.URL+"/status/a", "")
+		require.NoError(t, err)
+		assert.True(t, latest.NextRefreshAt.IsZero())
+
+		config.MaxRefreshesPerHost = 5
+		config.MaxRefreshesPerRun = 1
+		assert.Equal(t, refreshSummary{Due: 1, Refreshed: 1}, processor.RefreshArchives(config, now))
+	})
+
+	t.Run("failed refreshes wait for the next interval", func(t *testing.T) {
+		// Requests to a closed server fail right away
+		failing := httptest.NewServer(http.NotFoundHandler())
+		failing.Close()
+		stored := archive("/status", now.Add(-48*time.Hour))
+		stored.OriginalURL = failing.URL + "/status"
+		processor, _, _ := setup(t, stored)
+		config := &configuration{ArchivalRules: rules, RefreshURLs: failing.URL + "/status"}
+
+		assert.Equal(t, refreshSummary{Due: 1, Refreshed: 1, Failed: 1}, processor.RefreshArchives(config, now))
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now))
+	})
+
+	t.Run("nothing is refreshed while archiving is paused", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, api, replies := setup(t, archive("/status", now.Add(-48*time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status", Enabled: model.NewPointer(false)}
+
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now))
+		latest, err := processor.storageService.GetExistingArchiveForURL(server.URL+"/status", "")
+		require.NoError(t, err)
+		assert.Equal(t, "file1", latest.FileID)
+		assert.Empty(t, *replies)
+		api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("archives without a deduplication scope aren't refreshed", func(t *testing.T) {
+		content.Store("partial outage")
+		processor, _, _ := setup(t, archive("/status", now.Add(-48*time.Hour)))
+		config := &configuration{ArchivalRules: rules, RefreshURLs: server.URL + "/status", DedupScope: DedupScopeNone}
+
+		assert.Equal(t, refreshSummary{}, processor.RefreshArchives(config, now))
+	})
+}
+
+func TestValidateRefreshInterval(t *testing.T) {
+	p := &Plugin{}
+	assert.NoError(t, p.validateArchivalRules([]ArchivalRule{{Kind: "hostname", Pattern: "status.example.com", ArchivalTool: "obelisk", RefreshIntervalSeconds: 3600}}))
+	assert.ErrorContains(t, p.validateArchivalRules([]ArchivalRule{{Kind: "hostname", Pattern: "status.example.com", ArchivalTool: "obelisk", RefreshIntervalSeconds: -1}}), "invalid refresh interval")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// retryKeyPrefix prefixes the KV keys of the links error replies failed to archive. It doesn't
+	// start with archiveKeyPrefix, so purges don't list them as archive metadata.
+	retryKeyPrefix = "retry_"
+	// retryTTLSeconds is how long the archive of a link can be retried from its error reply
+	retryTTLSeconds = 7 * 24 * 60 * 60
+)
+
+// retryContext is the link an error reply failed to archive, and the post it was archived for
+type retryContext struct {
+	PostID string `json:"postId"`
+	URL    string `json:"url"`
+}
+
+// getRetryEmoji returns the emoji retrying the archive of a link when reacting to its error reply,
+// empty if retries are disabled. Emoji names can be wrapped in colons like :repeat:.
+func (c *configuration) getRetryEmoji() (string, error) {
+	emojiName := strings.Trim(strings.ToLower(strings.TrimSpace(c.RetryEmoji)), ":")
+	if emojiName == "" {
+		return "", nil
+	}
+	if len(emojiName) > model.EmojiNameMaxLength || !model.IsValidAlphaNumHyphenUnderscorePlus(emojiName) {
+		return "", errors.Errorf("invalid emoji name: %s", c.RetryEmoji)
+	}
+	return emojiName, nil
+}
+
+// getRetryKey returns the KV key of the link an error reply failed to archive
+func getRetryKey(replyID string) string {
+	return retryKeyPrefix + replyID
+}
+
+// StoreRetryContext stores the link an error reply failed to archive, so it can be retried by
+// reacting to the reply until it expires
+func (s *StorageService) StoreRetryContext(replyID, postID, url string) error {
+	data, err := json.Marshal(retryContext{PostID: postID, URL: url})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal retry context")
+	}
+	if appErr := s.api.KVSetWithExpiry(getRetryKey(replyID), data, retryTTLSeconds); appErr != nil {
+		return errors.Wrap(appErr, "failed to store retry context")
+	}
+	return nil
+}
+
+// TakeRetryContext returns the link an error reply failed to archive and deletes it, so each error
+// reply is retried once even if several users react at the same time. Returns nil if the reply has
+// no link to retry, because it isn't an error reply, expired or was already retried.
+func (s *StorageService) TakeRetryContext(replyID string) (*retryContext, error) {
+	key := getRetryKey(replyID)
+	data, appErr := s.api.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get retry context")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	deleted, appErr := s.api.KVCompareAndDelete(key, data)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to delete retry context")
+	}
+	if !deleted {
+		return nil, nil
+	}
+
+	var retry retryContext
+	if err := json.Unmarshal(data, &retry); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal retry context")
+	}
+	return &retry, nil
+}
+
+// ReactionHasBeenAdded is invoked after a reaction has been committed to the database.
+// Reacting to an error reply of the bot with the retry emoji archives its link again.
+func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reaction) {
+	if reaction == nil || p.archiveProcessor == nil || p.botService == nil || reaction.UserId == p.botService.GetBotID() {
+		return
+	}
+
+	config := p.getConfiguration()
+	emojiName, _ := config.getRetryEmoji()
+	if emojiName == "" || reaction.EmojiName != emojiName || !config.isEnabled() {
+		return
+	}
+
+	go p.retryArchive(reaction.PostId, reaction.UserId, config)
+}
+
+// retryArchive archives the link an error reply of the bot failed to archive again, replying with
+// the result in the thread of the original post like the first attempt
+func (p *Plugin) retryArchive(replyID, userID string, config *configuration) {
+	reply, appErr := p.API.GetPost(replyID)
+	if appErr != nil || reply == nil || reply.UserId != p.botService.GetBotID() {
+		return
+	}
+
+	storage := p.archiveProcessor.storageService
+	retry, err := storage.TakeRetryContext(replyID)
+	if err != nil {
+		p.API.LogWarn("Failed to get the link to retry archiving", "replyID", replyID, "error", err.Error())
+		return
+	}
+	if retry == nil {
+		p.API.LogDebug("No link to retry archiving for reply", "replyID", replyID)
+		return
+	}
+
+	p.API.LogInfo("Retrying archive on reaction", "postID", retry.PostID, "url", redactURL(retry.URL), "userID", userID)
+	p.archiveProcessor.processURL(retry.PostID, retry.URL, config)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestGetRetryEmoji(t *testing.T) {
+	tests := []struct {
+		setting  string
+		expected string
+		invalid  bool
+	}{
+		{setting: "", expected: ""},
+		{setting: "repeat", expected: "repeat"},
+		{setting: " :Arrows_Counterclockwise: ", expected: "arrows_counterclockwise"},
+		{setting: "+1", expected: "+1"},
+		{setting: "not an emoji", invalid: true},
+	}
+	for _, tt := range tests {
+		emojiName, err := (&configuration{RetryEmoji: tt.setting}).getRetryEmoji()
+		if tt.invalid {
+			assert.Error(t, err, tt.setting)
+			continue
+		}
+		require.NoError(t, err, tt.setting)
+		assert.Equal(t, tt.expected, emojiName, tt.setting)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	require.NoError(t, storage.StoreRetryContext("reply1", "post1", "https://example.com/a.pdf"))
+
+	retry, err := storage.TakeRetryContext("reply1")
+	require.NoError(t, err)
+	assert.Equal(t, &retryContext{PostID: "post1", URL: "https://example.com/a.pdf"}, retry)
+
+	retry, err = storage.TakeRetryContext("reply1")
+	require.NoError(t, err)
+	assert.Nil(t, retry, "each error reply is retried once")
+
+	retry, err = storage.TakeRetryContext("reply2")
+	require.NoError(t, err)
+	assert.Nil(t, retry)
+}
+
+func TestReactionRetriesArchive(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	url := server.URL + "/hello.txt"
+
+	setup := func() (*Plugin, *memoryKV, func() []*model.Post) {
+		api := &plugintest.API{}
+		mockLogs(api)
+		kv := setupMemoryKV(api)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1", Message: url}, nil)
+		api.On("GetPost", "reply1").Return(&model.Post{Id: "reply1", ChannelId: "channel1", UserId: "bot1", RootId: "post1"}, nil)
+		api.On("GetPost", "user-reply").Return(&model.Post{Id: "user-reply", ChannelId: "channel1", UserId: "user1", RootId: "post1"}, nil)
+		api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(&model.FileInfo{Id: "file1"}, nil)
+
+		var lock sync.Mutex
+		var created []*model.Post
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			lock.Lock()
+			defer lock.Unlock()
+			created = append(created, args.Get(0).(*model.Post))
+		}).Return(&model.Post{Id: "reply1"}, nil)
+
+		config := &configuration{
+			ArchivalRules: []ArchivalRule{{Kind: "default", ArchivalTool: archiver.DirectDownloadToolName}},
+			RetryEmoji:    "repeat",
+		}
+		threadReplyService := NewThreadReplyService(api, "bot1")
+		processor := NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), NewStorageService(api), threadReplyService)
+		processor.ApplyConfiguration(config)
+
+		p := &Plugin{archiveProcessor: processor, botService: &BotService{botID: "bot1"}, threadReplyService: threadReplyService}
+		p.SetAPI(api)
+		p.setConfiguration(config)
+		// Retries archive with the latest configuration, whose default tool is stored in the KV store
+		require.NoError(t, p.saveDefaultArchivalTool(archiver.DirectDownloadToolName))
+
+		return p, kv, func() []*model.Post {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]*model.Post(nil), created...)
+		}
+	}
+
+	t.Run("reacting to an error reply archives the link again", func(t *testing.T) {
+		failing.Store(true)
+		p, kv, created := setup()
+
+		p.archiveProcessor.processURL("post1", url, p.getConfiguration())
+		require.Len(t, created(), 1)
+		assert.True(t, strings.HasPrefix(created()[0].Message, "❌ Failed to archive"))
+		assert.Contains(t, created()[0].Message, "React with :repeat: to try again.")
+		require.Contains(t, kv.data, getRetryKey("reply1"))
+
+		failing.Store(false)
+		p.ReactionHasBeenAdded(nil, &model.Reaction{PostId: "reply1", UserId: "user1", EmojiName: "repeat"})
+		require.Eventually(t, func() bool { return len(created()) == 2 }, 5*time.Second, 10*time.Millisecond)
+		assert.True(t, strings.HasPrefix(created()[1].Message, "✅"), created()[1].Message)
+		assert.Equal(t, model.StringArray{"file1"}, created()[1].FileIds)
+		assert.Equal(t, "post1", created()[1].RootId)
+
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		assert.NotContains(t, kv.data, getRetryKey("reply1"), "the error reply can't be retried again")
+	})
+
+	t.Run("other reactions and posts are ignored", func(t *testing.T) {
+		failing.Store(true)
+		p, kv, _ := setup()
+		api := p.API.(*plugintest.API)
+
+		p.ReactionHasBeenAdded(nil, &model.Reaction{PostId: "reply1", UserId: "user1", EmojiName: "+1"})
+		p.ReactionHasBeenAdded(nil, &model.Reaction{PostId: "reply1", UserId: "bot1", EmojiName: "repeat"})
+		api.AssertNotCalled(t, "GetPost", "reply1")
+
+		// Posts of users can't hold a link to retry
+		require.NoError(t, p.archiveProcessor.storageService.StoreRetryContext("user-reply", "post1", url))
+		p.retryArchive("user-reply", "user1", p.getConfiguration())
+		assert.Contains(t, kv.data, getRetryKey("user-reply"))
+		api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+
+		// Bot replies without a link to retry, like success replies, are ignored
+		p.retryArchive("reply1", "user1", p.getConfiguration())
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+
+	t.Run("retries can be disabled", func(t *testing.T) {
+		p, _, _ := setup()
+		api := p.API.(*plugintest.API)
+		p.setConfiguration(&configuration{})
+
+		p.ReactionHasBeenAdded(nil, &model.Reaction{PostId: "reply1", UserId: "user1", EmojiName: "repeat"})
+		api.AssertNotCalled(t, "GetPost", "reply1")
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// lintArchivalRules returns warnings about rules that can never match, because a rule evaluated
+// before them matches every URL they match. Rules are checked in evaluation order, by priority then
+// in order, and warnings reference the indexes of the rules as given.
+func (p *ArchiveProcessor) lintArchivalRules(rules []ArchivalRule) []string {
+	order := make([]int, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Kind != "default" && rule.Pattern != "" {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rules[order[a]].Priority < rules[order[b]].Priority
+	})
+
+	warnings := []string{}
+	for position, index := range order {
+		rule := rules[index]
+		for _, earlierIndex := range order[:position] {
+			earlier := rules[earlierIndex]
+			if earlier.Kind != rule.Kind || !p.patternSubsumes(earlier.Kind, earlier.Pattern, rule.Pattern) {
+				continue
+			}
+			if p.patternSubsumes(rule.Kind, rule.Pattern, earlier.Pattern) {
+				warnings = append(warnings, fmt.Sprintf("rule at index %d (%s '%s') duplicates the rule at index %d and can never match", index, rule.Kind, rule.Pattern, earlierIndex))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("rule at index %d (%s '%s') can never match, the rule at index %d (%s '%s') is evaluated first and matches everything it does", index, rule.Kind, rule.Pattern, earlierIndex, earlier.Kind, earlier.Pattern))
+			}
+			break
+		}
+	}
+	return warnings
+}
+
+// patternSubsumes checks if a pattern matches everything another pattern of the same kind matches.
+// Patterns are matched as values for that: *.example.com matches the pattern www.example.com and
+// *.www.example.com, and image/* matches the pattern image/png but image/png doesn't match image/*.
+func (p *ArchiveProcessor) patternSubsumes(kind, pattern, other string) bool {
+	switch kind {
+	case "hostname":
+		return p.hostnameMatches(other, pattern)
+	case "mimetype":
+		return p.mimeTypeMatches(other, pattern)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintArchivalRules(t *testing.T) {
+	processor := setupTestProcessor()
+
+	tests := []struct {
+		name     string
+		rules    []ArchivalRule
+		expected []string
+	}{
+		{
+			name: "no overlap",
+			rules: []ArchivalRule{
+				{Kind: "hostname", Pattern: "www.example.com", ArchivalTool: "obelisk"},
+				{Kind: "hostname", Pattern: "*.example.com", ArchivalTool: "direct_download"},
+				{Kind: "mimetype", Pattern: "image/png", ArchivalTool: "do_nothing"},
+				{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "direct_download"},
+				{Kind: "default", ArchivalTool: "do_nothing"},
+			},
+			expected: []string{},
+		},
+		{
+			name: "wildcard hostname before a subdomain",
+			rules: []ArchivalRule{
+				{Kind: "hostname", Pattern: "*.example.com", ArchivalTool: "direct_download"},
+				{Kind: "hostname", Pattern: "www.example.com", ArchivalTool: "obelisk"},
+				{Kind: "hostname", Pattern: "*.docs.example.com", ArchivalTool: "obelisk"},
+				{Kind: "hostname", Pattern: "example.org", ArchivalTool: "obelisk"},
+			},
+			expected: []string{
+				"rule at index 1 (hostname 'www.example.com') can never match, the rule at index 0 (hostname '*.example.com') is evaluated first and matches everything it does",
+				"rule at index 2 (hostname '*.docs.example.com') can never match, the rule at index 0 (hostname '*.example.com') is evaluated first and matches everything it does",
+			},
+		},
+		{
+			name: "wildcard MIME type before a specific one, and duplicates",
+			rules: []ArchivalRule{
+				{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "direct_download"},
+				{Kind: "mimetype", Pattern: "Image/PNG", ArchivalTool: "do_nothing"},
+				{Kind: "mimetype", Pattern: "image/*", ArchivalTool: "obelisk"},
+				{Kind: "hostname", Pattern: "image.example.com", ArchivalTool: "obelisk"},
+			},
+			expected: []string{
+				"rule at index 1 (mimetype 'Image/PNG') can never match, the rule at index 0 (mimetype 'image/*') is evaluated first and matches everything it does",
+				"rule at index 2 (mimetype 'image/*') duplicates the rule at index 0 and can never match",
+			},
+		},
+		{
+			name: "priorities change the evaluation order",
+			rules: []ArchivalRule{
+				{Kind: "hostname", Pattern: "www.example.com", ArchivalTool: "obelisk"},
+				{Kind: "hostname", Pattern: "*.example.com", ArchivalTool: "direct_download", Priority: -1},
+			},
+			expected: []string{
+				"rule at index 0 (hostname 'www.example.com') can never match, the rule at index 1 (hostname '*.example.com') is evaluated first and matches everything it does",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, processor.lintArchivalRules(tt.rules))
+		})
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+	api.On("GetUser", "user").Return(&model.User{Id: "user", Roles: model.SystemUserRoleId}, nil)
+
+	p := &Plugin{archiveProcessor: &ArchiveProcessor{api: api}}
+	p.SetAPI(api)
+
+	request := func(userID, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/rules/lint", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("user", `{"archivalRules":[]}`).Code)
+
+	w := request("admin", `{"archivalRules":[{"kind":"mimetype","pattern":"image/*","archivalTool":"direct_download"},{"kind":"mimetype","pattern":"image/png","archivalTool":"do_nothing"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Warnings []string `json:"warnings"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Warnings, 1)
+	assert.Contains(t, response.Warnings[0], "rule at index 1 (mimetype 'image/png') can never match, the rule at index 0")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+// defaultSoft404TitlePatterns are the texts of the titles of error pages when none are configured
+var defaultSoft404TitlePatterns = []string{
+	"404",
+	"not found",
+	"page doesn't exist",
+	"page does not exist",
+	"no longer available",
+}
+
+// defaultSoft404MaxTextLength is the length of the visible text of pages under which they can be
+// flagged as error pages, when not configured
+const defaultSoft404MaxTextLength = 1500
+
+// getSoft404TitlePatterns returns the lowercase title patterns of error pages, falling back to the defaults if unset
+func (c *configuration) getSoft404TitlePatterns() []string {
+	patterns := parseListSetting(strings.ToLower(c.Soft404TitlePatterns))
+	if len(patterns) == 0 {
+		return defaultSoft404TitlePatterns
+	}
+	return patterns
+}
+
+// getSoft404MaxTextLength returns the length of the visible text of pages under which they can be flagged
+func (c *configuration) getSoft404MaxTextLength() int {
+	if c.Soft404MaxTextLength <= 0 {
+		return defaultSoft404MaxTextLength
+	}
+	return c.Soft404MaxTextLength
+}
+
+// detectSoft404 checks if an archived HTML page looks like the error page of a site returning
+// success for missing pages, a soft 404. To avoid false positives on legitimate short pages,
+// the title must match an error pattern and the page must have little visible text.
+// Returns the reason the page was flagged, if any.
+func detectSoft404(file *archiver.ArchivedFile, config *configuration) (string, bool) {
+	if !config.DetectSoft404 || file == nil {
+		return "", false
+	}
+	switch normalizeMimeType(file.MimeType) {
+	case "text/html", "application/xhtml+xml":
+	default:
+		return "", false
+	}
+
+	title := archiver.HTMLTitle(file.Data)
+	lowerTitle := strings.ToLower(title)
+	matched := false
+	for _, pattern := range config.getSoft404TitlePatterns() {
+		if strings.Contains(lowerTitle, pattern) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return "", false
+	}
+
+	if utf8.RuneCountInString(archiver.HTMLText(file.Data)) > config.getSoft404MaxTextLength() {
+		return "", false
+	}
+
+	return fmt.Sprintf("The link returned a page titled \"%s\" with little content, which looks like an error page for a missing page.", title), true
+}
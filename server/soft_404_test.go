@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+func TestDetectSoft404(t *testing.T) {
+	enabled := &configuration{DetectSoft404: true}
+	page := func(title, body string) *archiver.ArchivedFile {
+		return &archiver.ArchivedFile{
+			Filename: "page.html",
+			Data:     []byte("<html><head><title>" + title + "</title></head><body>" + body + "</body></html>"),
+			MimeType: "text/html; charset=utf-8",
+		}
+	}
+	article := strings.Repeat("Handling missing pages well matters for users and crawlers alike. ", 40)
+
+	tests := []struct {
+		name    string
+		file    *archiver.ArchivedFile
+		config  *configuration
+		flagged bool
+	}{
+		{"not found page", page("Page Not Found | Example", "<p>Sorry, we couldn't find that page.</p>"), enabled, true},
+		{"404 page", page("404", "<h1>Oops</h1>"), enabled, true},
+		{"short page with a regular title", page("Contact us", "<p>Email us.</p>"), enabled, false},
+		{"long page with an error title", page("How to design a 404 page", "<article>"+article+"</article>"), enabled, false},
+		{"disabled by default", page("Page Not Found", "<p>Sorry.</p>"), &configuration{}, false},
+		{"configured patterns replace the defaults", page("Page Not Found", "<p>Sorry.</p>"), &configuration{DetectSoft404: true, Soft404TitlePatterns: "Gone"}, false},
+		{"configured patterns", page("This page is gone", "<p>Sorry.</p>"), &configuration{DetectSoft404: true, Soft404TitlePatterns: "Gone"}, true},
+		{"configured text length", page("Not Found", "<p>"+article+"</p>"), &configuration{DetectSoft404: true, Soft404MaxTextLength: 10000}, true},
+		{"other content types", &archiver.ArchivedFile{Filename: "404.txt", Data: []byte("404 Not Found"), MimeType: "text/plain"}, enabled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, flagged := detectSoft404(tt.file, tt.config)
+			assert.Equal(t, tt.flagged, flagged)
+			if tt.flagged {
+				assert.Contains(t, reason, archiver.HTMLTitle(tt.file.Data))
+			}
+		})
+	}
+}
+
+func TestSoft404NotArchived(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>Not Found</title></head><body>The page is gone.</body></html>"))
+	}))
+	defer server.Close()
+
+	api := &plugintest.API{}
+	mockLogs(api)
+	setupMemoryKV(api)
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+	processor := NewArchiveProcessor(api, NewLinkExtractor(), NewContentDetector(0), NewStorageService(api), nil)
+	config := &configuration{
+		ArchivalRules: []ArchivalRule{{Kind: "default", ArchivalTool: archiver.DirectDownloadToolName}},
+		DetectSoft404: true,
+	}
+
+	result := processor.archiveLink(api, "post1", server.URL+"/missing", config, false)
+	require.NoError(t, result.Err)
+	assert.Contains(t, result.Notice, "looks like an error page")
+	assert.Nil(t, result.Metadata)
+	api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"cmp"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -14,27 +24,179 @@ import (
 
 // ArchiveMetadata stores metadata about an archived file
 type ArchiveMetadata struct {
-	PostID      string    `json:"postId"`
-	OriginalURL string    `json:"originalUrl"`
-	FileID      string    `json:"fileId"`
-	Filename    string    `json:"filename"`
+	PostID      string `json:"postId"`
+	OriginalURL string `json:"originalUrl"`
+	FileID      string `json:"fileId"`
+	Filename    string `json:"filename"`
+	// DisplayName is the name of the file shown in replies, rendered from the display name template,
+	// while the file keeps its stored Filename. Empty to show the filename.
+	DisplayName string    `json:"displayName,omitempty"`
 	MimeType    string    `json:"mimeType"`
 	ArchivedAt  time.Time `json:"archivedAt"`
 	ToolUsed    string    `json:"toolUsed"`
 	Size        int64     `json:"size"`
 	ETag        string    `json:"etag,omitempty"`
 	ContentHash string    `json:"contentHash,omitempty"`
+	// HashAlgorithm is the algorithm of ContentHash, SHA-256 if empty as for archives made before it
+	// was configurable
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	// SampleHash is the hash of the size and the first and last bytes of direct downloads, see sampleHash
+	SampleHash  string `json:"sampleHash,omitempty"`
+	ExternalURL string `json:"externalUrl,omitempty"`
+	// CanonicalURL is the URL actually archived when following the page's meta refresh or canonical link
+	CanonicalURL string `json:"canonicalUrl,omitempty"`
+	// History lists the previous captures of the URL, newest first, when keeping history
+	History []ArchiveHistoryEntry `json:"history,omitempty"`
+	// AdditionalFiles lists the other files archived along with the main one, by tools producing
+	// several files, and the favicon of HTML pages when capturing favicons
+	AdditionalFiles []AdditionalFile `json:"additionalFiles,omitempty"`
+	// ResponseHeaders holds the headers and status of the download response, when capturing them
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	// StatusCode and FetchDurationMs are the HTTP status the URL answered with and the time the tool
+	// took to fetch it, for the tools reporting them. Reused archives don't have them.
+	StatusCode      int   `json:"statusCode,omitempty"`
+	FetchDurationMs int64 `json:"fetchDurationMs,omitempty"`
+	// NextRefreshAt is when the background job archives the URL again, for watched URLs. Unset
+	// until their first refresh, which is due an interval after they were archived.
+	NextRefreshAt time.Time `json:"nextRefreshAt,omitzero"`
+}
+
+// AdditionalFile is a file archived for a URL along with its main file
+type AdditionalFile struct {
+	FileID   string `json:"fileId"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	// Favicon marks the site icon of an archived page, kept in the metadata but not attached to replies
+	Favicon bool `json:"favicon,omitempty"`
+	// ExtractedText marks the plain text extracted from the archived file, see archiver.ExtractText
+	ExtractedText bool `json:"extractedText,omitempty"`
+}
+
+// FileIDs returns the IDs of the files of the archive, the main file first
+func (m *ArchiveMetadata) FileIDs() []string {
+	fileIDs := []string{m.FileID}
+	for _, file := range m.AdditionalFiles {
+		fileIDs = append(fileIDs, file.FileID)
+	}
+	return fileIDs
+}
+
+// ShownName returns the name of the archived file shown in replies, its display name if any
+func (m *ArchiveMetadata) ShownName() string {
+	if m.DisplayName != "" {
+		return m.DisplayName
+	}
+	return m.Filename
+}
+
+// AttachedFileIDs returns the IDs of the files of the archive attached to replies, the main file first
+func (m *ArchiveMetadata) AttachedFileIDs() []string {
+	fileIDs := []string{m.FileID}
+	for _, file := range m.AttachedFiles() {
+		fileIDs = append(fileIDs, file.FileID)
+	}
+	return fileIDs
+}
+
+// AttachedFiles returns the additional files of the archive attached to replies, without the favicon
+func (m *ArchiveMetadata) AttachedFiles() []AdditionalFile {
+	var files []AdditionalFile
+	for _, file := range m.AdditionalFiles {
+		if !file.Favicon {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Favicon returns the site icon captured with the archive, or nil if none was
+func (m *ArchiveMetadata) Favicon() *AdditionalFile {
+	for i := range m.AdditionalFiles {
+		if m.AdditionalFiles[i].Favicon {
+			return &m.AdditionalFiles[i]
+		}
+	}
+	return nil
+}
+
+const (
+	// HashAlgorithmSHA256 hashes archived content with SHA-256, the default
+	HashAlgorithmSHA256 = "sha256"
+	// HashAlgorithmSHA512 hashes archived content with SHA-512
+	HashAlgorithmSHA512 = "sha512"
+)
+
+// contentHash returns the hex-encoded hash of archived content with an algorithm, SHA-256 for
+// empty or unknown ones
+func contentHash(algorithm string, data []byte) string {
+	if algorithm == HashAlgorithmSHA512 {
+		hash := sha512.Sum512(data)
+		return hex.EncodeToString(hash[:])
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// MatchesContent reports whether content is the archived one, hashing it with the algorithm of the
+// archive's hash so archives made before the algorithm changed still compare. False if the archive
+// has no content hash.
+func (m *ArchiveMetadata) MatchesContent(data []byte) bool {
+	return m.ContentHash != "" && contentHash(m.HashAlgorithm, data) == m.ContentHash
+}
+
+// ArchiveHistoryEntry is a previous capture of a URL whose content has changed since
+type ArchiveHistoryEntry struct {
+	PostID      string    `json:"postId"`
+	FileID      string    `json:"fileId"`
+	Filename    string    `json:"filename"`
+	MimeType    string    `json:"mimeType"`
+	Size        int64     `json:"size"`
+	ContentHash string    `json:"contentHash,omitempty"`
+	ArchivedAt  time.Time `json:"archivedAt"`
+	// HashAlgorithm is the algorithm of ContentHash, SHA-256 if empty
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
 }
 
 // StorageService handles storing archived files in Mattermost
 type StorageService struct {
 	api plugin.API
+
+	// objectStorageMirror also uploads archived files to external object storage, if set
+	objectStorageLock   sync.RWMutex
+	objectStorageMirror *ObjectStorageMirror
+
+	// canonicalizeURLs ignores fragments and default ports when matching the URLs of archives
+	canonicalizeURLs atomic.Bool
+	// ignoreQuery ignores the query string and fragment when matching the URLs of archives
+	ignoreQuery atomic.Bool
+	// ignoredParams are the query parameters ignored when matching the URLs of archives, per host
+	ignoredParamsLock sync.RWMutex
+	ignoredParams     []dedupIgnoredParams
+	// downloadHTMLArchives uploads HTML archives so Mattermost offers them as downloads, not previews
+	downloadHTMLArchives atomic.Bool
+	// hashAlgorithm is the algorithm of the content hashes of new archives, SHA-256 if unset
+	hashAlgorithm atomic.Value
+
+	// maxArchivesPerURL bounds the captures kept per URL within a deduplication scope, zero for no limit
+	maxArchivesPerURL atomic.Int64
+	// fileDeleter deletes the files of evicted captures nothing references anymore, if set
+	fileDeleter fileDeleter
+
+	// lookupCache caches the global archive metadata of recently looked up URLs
+	lookupCache *archiveLookupCache
+}
+
+// fileDeleter deletes archived files, returning whether the file was deleted
+type fileDeleter interface {
+	DeleteArchivedFile(fileID string) bool
 }
 
 // NewStorageService creates a new storage service
 func NewStorageService(api plugin.API) *StorageService {
 	return &StorageService{
-		api: api,
+		api:         api,
+		lookupCache: newArchiveLookupCache(),
 	}
 }
 
@@ -52,89 +214,549 @@ func (s *StorageService) StoreArchivedFile(postID, originalURL string, archivedF
 	}
 
 	// Upload the file to Mattermost using the plugin API
+	filename := s.uploadFilename(archivedFile)
 	fileInfo, appErr := s.api.UploadFile(
 		archivedFile.Data,
 		post.ChannelId,
-		archivedFile.Filename,
+		filename,
 	)
 	if appErr != nil {
 		return nil, errors.Wrap(appErr, "failed to upload file to Mattermost")
 	}
 
 	// Calculate content hash
-	hash := sha256.Sum256(archivedFile.Data)
-	contentHash := hex.EncodeToString(hash[:])
+	hashAlgorithm := s.getHashAlgorithm()
+	hash := contentHash(hashAlgorithm, archivedFile.Data)
 
 	// Create metadata
 	metadata := &ArchiveMetadata{
-		PostID:      postID,
-		OriginalURL: originalURL,
-		FileID:      fileInfo.Id,
-		Filename:    archivedFile.Filename,
-		MimeType:    archivedFile.MimeType,
-		ArchivedAt:  time.Now(),
-		ToolUsed:    toolName,
-		Size:        archivedFile.Size,
-		ContentHash: contentHash,
+		PostID:          postID,
+		OriginalURL:     originalURL,
+		FileID:          fileInfo.Id,
+		Filename:        filename,
+		MimeType:        archivedFile.MimeType,
+		ArchivedAt:      time.Now(),
+		ToolUsed:        toolName,
+		Size:            archivedFile.Size,
+		ContentHash:     hash,
+		HashAlgorithm:   hashAlgorithm,
+		ResponseHeaders: archivedFile.ResponseHeaders,
+		StatusCode:      archivedFile.StatusCode,
+		FetchDurationMs: archivedFile.Duration.Milliseconds(),
+	}
+
+	// Mirror to external object storage. Failures don't fail the archive, the file is already stored.
+	if mirror := s.getObjectStorageMirror(); mirror != nil {
+		externalURL, err := mirror.Upload(getMirrorObjectKey(hash, archivedFile.Filename), archivedFile.Data, archivedFile.MimeType)
+		if err != nil {
+			s.api.LogWarn("Failed to mirror archived file to object storage", "url", redactURL(originalURL), "error", err.Error())
+		} else {
+			metadata.ExternalURL = externalURL
+		}
 	}
 
 	return metadata, nil
 }
 
+// downloadOnlyExtension is appended to the name of the HTML archives uploaded to be downloaded
+const downloadOnlyExtension = ".bin"
+
+// SetHashAlgorithm sets the algorithm of the content hashes of new archives. Existing archives keep
+// the algorithm they were hashed with.
+func (s *StorageService) SetHashAlgorithm(algorithm string) {
+	s.hashAlgorithm.Store(algorithm)
+}
+
+// getHashAlgorithm returns the algorithm of the content hashes of new archives
+func (s *StorageService) getHashAlgorithm() string {
+	if algorithm, ok := s.hashAlgorithm.Load().(string); ok && algorithm != "" {
+		return algorithm
+	}
+	return HashAlgorithmSHA256
+}
+
+// SetDownloadHTMLArchives sets whether HTML archives are uploaded to be downloaded rather than
+// previewed. Mattermost picks the type of uploaded files from their extension, so they're uploaded
+// with downloadOnlyExtension appended, which it stores as application/octet-stream.
+func (s *StorageService) SetDownloadHTMLArchives(enabled bool) {
+	s.downloadHTMLArchives.Store(enabled)
+}
+
+// uploadFilename returns the name an archived file is uploaded to Mattermost with
+func (s *StorageService) uploadFilename(archivedFile *archiver.ArchivedFile) string {
+	if !s.downloadHTMLArchives.Load() {
+		return archivedFile.Filename
+	}
+	switch normalizeMimeType(archivedFile.MimeType) {
+	case "text/html", "application/xhtml+xml":
+		return archivedFile.Filename + downloadOnlyExtension
+	default:
+		return archivedFile.Filename
+	}
+}
+
+// SetFileDeleter sets what deletes the files of evicted captures once nothing references them.
+// Without it, evicted captures are forgotten but their files are kept. It must be set before archiving.
+func (s *StorageService) SetFileDeleter(deleter fileDeleter) {
+	s.fileDeleter = deleter
+}
+
+// SetLookupCache sets the number of global archive lookups cached and for how long, so links posted
+// repeatedly don't read their archive from the KV store every time. Zero entries disables the cache.
+// Archives stored by other servers of a cluster are only seen once the cached lookup expires.
+func (s *StorageService) SetLookupCache(maxEntries int, ttl time.Duration) {
+	s.lookupCache.configure(maxEntries, ttl)
+}
+
+// SetMaxArchivesPerURL sets the number of captures kept per URL within a deduplication scope, the
+// most recent one included. Older captures are evicted, zero keeps them all.
+func (s *StorageService) SetMaxArchivesPerURL(maxArchives int) {
+	s.maxArchivesPerURL.Store(int64(maxArchives))
+}
+
+// SetObjectStorageMirror sets the external object storage archived files are mirrored to.
+// A nil mirror disables mirroring.
+func (s *StorageService) SetObjectStorageMirror(mirror *ObjectStorageMirror) {
+	s.objectStorageLock.Lock()
+	defer s.objectStorageLock.Unlock()
+	s.objectStorageMirror = mirror
+}
+
+// getObjectStorageMirror returns the configured object storage mirror, if any
+func (s *StorageService) getObjectStorageMirror() *ObjectStorageMirror {
+	s.objectStorageLock.RLock()
+	defer s.objectStorageLock.RUnlock()
+	return s.objectStorageMirror
+}
+
+// displayNameTokens are the tokens replaced in the display name template, see renderDisplayName
+var displayNameTokens = []string{"{filename}", "{name}", "{ext}", "{host}", "{date}", "{type}"}
+
+// maxDisplayNameLength is the maximum length of display names, in characters
+const maxDisplayNameLength = 100
+
+// renderDisplayName renders the display name of an archived file from a template. Returns an empty
+// name, showing the filename, when the template is empty or renders the filename or nothing.
+func renderDisplayName(template string, metadata *ArchiveMetadata) string {
+	if template == "" {
+		return ""
+	}
+	ext := path.Ext(metadata.Filename)
+	host := ""
+	if parsed, err := url.Parse(metadata.OriginalURL); err == nil {
+		host = parsed.Hostname()
+	}
+	name := strings.NewReplacer(
+		"{filename}", metadata.Filename,
+		"{name}", strings.TrimSuffix(metadata.Filename, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{host}", host,
+		"{date}", metadata.ArchivedAt.Format(time.DateOnly),
+		"{type}", metadata.MimeType,
+	).Replace(template)
+
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > maxDisplayNameLength {
+		name = strings.TrimSpace(string(runes[:maxDisplayNameLength]))
+	}
+	if name == metadata.Filename {
+		return ""
+	}
+	return name
+}
+
 // CreateMetadataForExistingFile creates metadata for an existing file (reused archive)
 func (s *StorageService) CreateMetadataForExistingFile(postID, originalURL string, existingMetadata *ArchiveMetadata) *ArchiveMetadata {
+	externalURL := existingMetadata.ExternalURL
+	// Presigned links expire, reused archives get a fresh one
+	if mirror := s.getObjectStorageMirror(); mirror != nil && strings.Contains(externalURL, "X-Amz-Signature=") {
+		externalURL = mirror.ObjectURL(getMirrorObjectKey(existingMetadata.ContentHash, existingMetadata.Filename), time.Now().UTC())
+	}
+
 	return &ArchiveMetadata{
 		PostID:      postID,
 		OriginalURL: originalURL,
 		FileID:      existingMetadata.FileID,
 		Filename:    existingMetadata.Filename,
+		// The display name was rendered for the reused capture
+		DisplayName: existingMetadata.DisplayName,
 		MimeType:    existingMetadata.MimeType,
 		ArchivedAt:  time.Now(),
 		ToolUsed:    existingMetadata.ToolUsed,
 		Size:        existingMetadata.Size,
 		ETag:        existingMetadata.ETag,
 		ContentHash: existingMetadata.ContentHash,
+		// The hash is of the reused file
+		HashAlgorithm: existingMetadata.HashAlgorithm,
+		SampleHash:    existingMetadata.SampleHash,
+		ExternalURL:   externalURL,
+		// The canonical URL of the page is the same regardless of the post
+		CanonicalURL:    existingMetadata.CanonicalURL,
+		AdditionalFiles: slices.Clone(existingMetadata.AdditionalFiles),
+		// The headers describe the capture of the reused file
+		ResponseHeaders: maps.Clone(existingMetadata.ResponseHeaders),
 	}
 }
 
 // StoreArchiveMetadata stores archive metadata in KV store (per-post)
 func (s *StorageService) StoreArchiveMetadata(metadata *ArchiveMetadata) error {
 	// Store metadata keyed by post ID and URL hash
-	key := getArchiveMetadataKey(metadata.PostID, metadata.OriginalURL)
+	key := getArchiveMetadataKey(metadata.PostID, s.dedupURL(metadata.OriginalURL))
+
+	var previousFileIDs []string
+	err := s.updateKV(key, func(existing []byte) ([]byte, error) {
+		// If metadata already exists, update the entry for this URL or append to list
+		previousFileIDs = nil
+		var metadataList []*ArchiveMetadata
+		if existing != nil {
+			if err := json.Unmarshal(existing, &metadataList); err != nil {
+				// If unmarshal fails, start fresh
+				metadataList = []*ArchiveMetadata{metadata}
+			} else {
+				if previous := s.findArchiveMetadata(metadataList, metadata.OriginalURL); previous != nil {
+					previousFileIDs = previous.FileIDs()
+				}
+				metadataList = s.upsertArchiveMetadata(metadataList, metadata)
+			}
+		} else {
+			metadataList = []*ArchiveMetadata{metadata}
+		}
 
-	// Get existing metadata for this post
+		data, err := json.Marshal(metadataList)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal metadata")
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.addToPostArchiveIndex(metadata.PostID, key); err != nil {
+		s.api.LogWarn("Failed to add archive to post archive index", "postID", metadata.PostID, "error", err.Error())
+	}
+
+	// Track which files are referenced by archive records, so deletion knows when a file is unused
+	fileIDs := metadata.FileIDs()
+	for _, fileID := range fileIDs {
+		if slices.Contains(previousFileIDs, fileID) {
+			continue
+		}
+		if err := s.addFileReference(fileID); err != nil {
+			s.api.LogWarn("Failed to add file reference", "fileID", fileID, "error", err.Error())
+		}
+	}
+	for _, fileID := range previousFileIDs {
+		if fileID == "" || slices.Contains(fileIDs, fileID) {
+			continue
+		}
+		if _, err := s.ReleaseFileReference(fileID); err != nil {
+			s.api.LogWarn("Failed to release file reference", "fileID", fileID, "error", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// DeleteArchiveMetadata removes the archive record of a URL from a post.
+// Returns the removed metadata, or nil if the URL wasn't archived for the post.
+func (s *StorageService) DeleteArchiveMetadata(postID, url string) (*ArchiveMetadata, error) {
+	return s.deleteArchiveMetadata(postID, url, "")
+}
+
+// deleteArchiveMetadata removes the archive record of a URL from a post, only if it references the
+// file when a file ID is given. Returns the removed metadata, or nil if no record was removed.
+func (s *StorageService) deleteArchiveMetadata(postID, url, fileID string) (*ArchiveMetadata, error) {
+	key := getArchiveMetadataKey(postID, s.dedupURL(url))
+
+	var removed *ArchiveMetadata
+	var empty bool
+	err := s.updateKV(key, func(existing []byte) ([]byte, error) {
+		removed = nil
+		var metadataList []*ArchiveMetadata
+		if existing != nil {
+			if err := json.Unmarshal(existing, &metadataList); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal metadata")
+			}
+		}
+
+		remaining := make([]*ArchiveMetadata, 0, len(metadataList))
+		for _, m := range metadataList {
+			if s.sameURL(m.OriginalURL, url) && (fileID == "" || m.FileID == fileID) {
+				removed = m
+				continue
+			}
+			remaining = append(remaining, m)
+		}
+		empty = len(remaining) == 0
+
+		data, err := json.Marshal(remaining)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal metadata")
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if removed != nil && empty {
+		if err := s.removeFromPostArchiveIndex(postID, key); err != nil {
+			s.api.LogWarn("Failed to remove archive from post archive index", "postID", postID, "error", err.Error())
+		}
+	}
+
+	return removed, nil
+}
+
+// getFileRefCountKey generates a KV store key for the number of archive records referencing a file
+func getFileRefCountKey(fileID string) string {
+	return "archive_file_refs_" + fileID
+}
+
+// addFileReference increments the number of archive records referencing a file
+func (s *StorageService) addFileReference(fileID string) error {
+	if fileID == "" {
+		return nil
+	}
+	return s.updateKV(getFileRefCountKey(fileID), func(existing []byte) ([]byte, error) {
+		count, _ := strconv.Atoi(string(existing))
+		return []byte(strconv.Itoa(count + 1)), nil
+	})
+}
+
+// ReleaseFileReference decrements the number of archive records referencing a file and returns
+// the remaining count. Returns -1 if the file has no reference count, e.g. archived before counts existed.
+func (s *StorageService) ReleaseFileReference(fileID string) (int, error) {
+	key := getFileRefCountKey(fileID)
 	existing, appErr := s.api.KVGet(key)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to get existing metadata")
+		return -1, errors.Wrap(appErr, "failed to get file reference count")
+	}
+	if existing == nil {
+		return -1, nil
 	}
 
-	// If metadata already exists, append to list
-	var metadataList []*ArchiveMetadata
-	if existing != nil {
-		if err := json.Unmarshal(existing, &metadataList); err != nil {
-			// If unmarshal fails, start fresh
-			metadataList = []*ArchiveMetadata{metadata}
-		} else {
-			metadataList = append(metadataList, metadata)
+	remaining := 0
+	err := s.updateKV(key, func(existing []byte) ([]byte, error) {
+		count, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid file reference count")
 		}
-	} else {
-		metadataList = []*ArchiveMetadata{metadata}
+		remaining = max(count-1, 0)
+		return []byte(strconv.Itoa(remaining)), nil
+	})
+	if err != nil {
+		return -1, err
 	}
 
-	// Store updated metadata
-	data, err := json.Marshal(metadataList)
+	return remaining, nil
+}
+
+// DeleteGlobalArchiveMetadataForFile removes the most recent archive metadata of a URL within a
+// deduplication scope if it references the file, so a deleted file isn't reused
+func (s *StorageService) DeleteGlobalArchiveMetadataForFile(url, scopeID, fileID string) error {
+	// Read the archive from the KV store, a lookup cached before another server archived the URL
+	// would keep the archive of the deleted file
+	key := getGlobalArchiveKey(s.dedupURL(url), scopeID)
+	s.lookupCache.invalidate(key)
+	existing, err := s.GetExistingArchiveForURL(url, scopeID)
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal metadata")
+		return err
+	}
+	if existing == nil || existing.FileID != fileID {
+		return nil
 	}
 
-	appErr = s.api.KVSet(key, data)
+	appErr := s.api.KVDelete(key)
+	s.lookupCache.invalidate(key)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to store metadata")
+		return errors.Wrap(appErr, "failed to delete global archive metadata")
+	}
+	if err := s.removeFromArchiveIndex(key); err != nil {
+		return errors.Wrap(err, "failed to update archive index")
+	}
+	return nil
+}
+
+// maxKVUpdateAttempts is the number of times a read-modify-write is retried on conflicting writes
+const maxKVUpdateAttempts = 10
+
+// updateKV performs a read-modify-write of a KV value using compare-and-set, retrying when
+// another writer changed the value in the meantime so concurrent updates aren't lost
+func (s *StorageService) updateKV(key string, update func(existing []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxKVUpdateAttempts; attempt++ {
+		existing, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get existing metadata")
+		}
+
+		data, err := update(existing)
+		if err != nil {
+			return err
+		}
+
+		ok, appErr := s.api.KVCompareAndSet(key, existing, data)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store metadata")
+		}
+		if ok {
+			return nil
+		}
+
+		// Value changed since we read it, back off briefly and retry with the new value
+		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
 	}
 
+	return errors.Errorf("failed to store metadata for key %s after %d attempts due to concurrent updates", key, maxKVUpdateAttempts)
+}
+
+// findArchiveMetadata returns the entry with the given original URL, or nil if there is none
+func (s *StorageService) findArchiveMetadata(metadataList []*ArchiveMetadata, url string) *ArchiveMetadata {
+	for _, m := range metadataList {
+		if s.sameURL(m.OriginalURL, url) {
+			return m
+		}
+	}
 	return nil
 }
 
+// upsertArchiveMetadata replaces the entry with the same original URL in place, or appends the
+// metadata if there is none, so re-archival doesn't add duplicate records
+func (s *StorageService) upsertArchiveMetadata(metadataList []*ArchiveMetadata, metadata *ArchiveMetadata) []*ArchiveMetadata {
+	for i, m := range metadataList {
+		if s.sameURL(m.OriginalURL, metadata.OriginalURL) {
+			metadataList[i] = metadata
+			return metadataList
+		}
+	}
+	return append(metadataList, metadata)
+}
+
+// SetCanonicalizeURLs sets whether fragments and default ports are ignored when matching the URLs
+// of archives, so https://example.com:443/page#section reuses the archive of https://example.com/page.
+// Archives keep the URL as posted.
+func (s *StorageService) SetCanonicalizeURLs(enabled bool) {
+	s.canonicalizeURLs.Store(enabled)
+}
+
+// SetIgnoreQuery sets whether the query string is ignored when matching the URLs of archives, so
+// https://example.com/list?page=2 reuses the archive of https://example.com/list?page=1. For sites
+// whose query parameters are all incidental. Archives keep the URL as posted.
+func (s *StorageService) SetIgnoreQuery(enabled bool) {
+	s.ignoreQuery.Store(enabled)
+}
+
+// dedupIgnoredParams are the query parameters ignored when matching the URLs of archives of the
+// hosts matching a pattern
+type dedupIgnoredParams struct {
+	Pattern string
+	// Params are the names of the parameters, * for all of them
+	Params []string
+}
+
+// SetIgnoredParams sets the query parameters ignored when matching the URLs of archives of some
+// hosts, so variants of a file like https://cdn.example.com/photo.jpg?w=200 reuse the archive of
+// https://cdn.example.com/photo.jpg?w=800. The first matching host applies. Archives keep the URL as posted.
+func (s *StorageService) SetIgnoredParams(rules []dedupIgnoredParams) {
+	s.ignoredParamsLock.Lock()
+	defer s.ignoredParamsLock.Unlock()
+	s.ignoredParams = rules
+}
+
+// getIgnoredParams returns the query parameters ignored per host
+func (s *StorageService) getIgnoredParams() []dedupIgnoredParams {
+	s.ignoredParamsLock.RLock()
+	defer s.ignoredParamsLock.RUnlock()
+	return s.ignoredParams
+}
+
+// dedupURL returns the form of a URL its archives are stored and looked up with
+func (s *StorageService) dedupURL(url string) string {
+	// Data URIs hold their content, every character of them matters
+	if archiver.IsDataURI(url) {
+		return url
+	}
+	if s.ignoreQuery.Load() {
+		url = stripQueryForDedup(url)
+	} else if rules := s.getIgnoredParams(); len(rules) > 0 {
+		url = stripParamsForDedup(url, rules)
+	}
+	if !s.canonicalizeURLs.Load() {
+		return url
+	}
+	return canonicalizeForDedup(url)
+}
+
+// sameURL reports whether two URLs refer to the same archives
+func (s *StorageService) sameURL(a, b string) bool {
+	return a == b || s.dedupURL(a) == s.dedupURL(b)
+}
+
+// canonicalizeForDedup removes the fragment and the default port of a URL, which don't change the
+// resource it refers to. URLs that can't be parsed are returned unchanged.
+func canonicalizeForDedup(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	parsedURL.Fragment = ""
+	parsedURL.RawFragment = ""
+	if port := parsedURL.Port(); (parsedURL.Scheme == "http" && port == "80") || (parsedURL.Scheme == "https" && port == "443") {
+		parsedURL.Host = strings.TrimSuffix(parsedURL.Host, ":"+port)
+	}
+	return parsedURL.String()
+}
+
+// stripQueryForDedup removes the query string and the fragment of a URL, keeping its scheme, host
+// and path. URLs that can't be parsed are returned unchanged.
+func stripQueryForDedup(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	parsedURL.RawQuery = ""
+	parsedURL.ForceQuery = false
+	parsedURL.Fragment = ""
+	parsedURL.RawFragment = ""
+	return parsedURL.String()
+}
+
+// stripParamsForDedup removes the query parameters ignored for the host of a URL, keeping the
+// others in their order. URLs that can't be parsed or whose host has no ignored parameters are
+// returned unchanged.
+func stripParamsForDedup(rawURL string, rules []dedupIgnoredParams) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.RawQuery == "" {
+		return rawURL
+	}
+
+	hostname := strings.ToLower(parsedURL.Hostname())
+	index := slices.IndexFunc(rules, func(rule dedupIgnoredParams) bool {
+		return archiver.HostnameMatches(hostname, rule.Pattern)
+	})
+	if index < 0 {
+		return rawURL
+	}
+	if slices.Contains(rules[index].Params, "*") {
+		return stripQueryForDedup(rawURL)
+	}
+
+	var kept []string
+	for _, pair := range strings.Split(parsedURL.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if pair != "" && !slices.Contains(rules[index].Params, name) {
+			kept = append(kept, pair)
+		}
+	}
+	parsedURL.RawQuery = strings.Join(kept, "&")
+	parsedURL.ForceQuery = false
+	return parsedURL.String()
+}
+
 // getArchiveMetadataKey generates a KV store key for archive metadata (per-post)
 func getArchiveMetadataKey(postID, url string) string {
 	// Hash the URL to keep key length within limits
@@ -143,17 +765,156 @@ func getArchiveMetadataKey(postID, url string) string {
 	return "archive_post_" + postID + "_" + urlHash
 }
 
-// getGlobalArchiveKey generates a KV store key for global URL archive metadata
-// Uses hash of URL to keep key within 150 character limit
-func getGlobalArchiveKey(url string) string {
+// postArchiveIndexKeyPrefix prefixes the keys of the per-post archive indexes. It must not start
+// with the prefix of the per-post archive metadata keys, whose values are read as archive lists.
+const postArchiveIndexKeyPrefix = "archive_postindex_"
+
+// getPostArchiveIndexKey generates a KV store key for the list of the archive metadata keys of a post
+func getPostArchiveIndexKey(postID string) string {
+	return postArchiveIndexKeyPrefix + postID
+}
+
+// updatePostArchiveIndex applies a change to the archive metadata keys listed for a post
+func (s *StorageService) updatePostArchiveIndex(postID string, update func(keys []string) []string) error {
+	return s.updateKV(getPostArchiveIndexKey(postID), func(existing []byte) ([]byte, error) {
+		var keys []string
+		if existing != nil {
+			if err := json.Unmarshal(existing, &keys); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal post archive index")
+			}
+		}
+		data, err := json.Marshal(update(keys))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal post archive index")
+		}
+		return data, nil
+	})
+}
+
+// addToPostArchiveIndex lists an archive metadata key of a post in its index, once
+func (s *StorageService) addToPostArchiveIndex(postID, key string) error {
+	return s.updatePostArchiveIndex(postID, func(keys []string) []string {
+		if slices.Contains(keys, key) {
+			return keys
+		}
+		return append(keys, key)
+	})
+}
+
+// removeFromPostArchiveIndex removes an archive metadata key of a post from its index
+func (s *StorageService) removeFromPostArchiveIndex(postID, key string) error {
+	return s.updatePostArchiveIndex(postID, func(keys []string) []string {
+		return slices.DeleteFunc(keys, func(listed string) bool {
+			return listed == key
+		})
+	})
+}
+
+// GetPostArchives returns the archive metadata of every URL archived for a post, oldest first.
+// Archives are found through the post's archive index, and through the given URLs for archives
+// stored before the index existed.
+func (s *StorageService) GetPostArchives(postID string, urls []string) ([]*ArchiveMetadata, error) {
+	var keys []string
+	existing, appErr := s.api.KVGet(getPostArchiveIndexKey(postID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get post archive index")
+	}
+	if existing != nil {
+		if err := json.Unmarshal(existing, &keys); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal post archive index")
+		}
+	}
+	for _, url := range urls {
+		if key := getArchiveMetadataKey(postID, s.dedupURL(url)); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	archives := []*ArchiveMetadata{}
+	for _, key := range keys {
+		data, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get archive metadata")
+		}
+		if data == nil {
+			continue
+		}
+		var metadataList []*ArchiveMetadata
+		if err := json.Unmarshal(data, &metadataList); err != nil {
+			s.api.LogWarn("Failed to unmarshal archive metadata, leaving it out", "key", key, "error", err.Error())
+			continue
+		}
+		archives = append(archives, metadataList...)
+	}
+
+	slices.SortStableFunc(archives, func(a, b *ArchiveMetadata) int {
+		return a.ArchivedAt.Compare(b.ArchivedAt)
+	})
+	return archives, nil
+}
+
+// globalArchiveKeyPrefix prefixes the keys of the archive metadata shared within deduplication scopes
+const globalArchiveKeyPrefix = "archive_url_"
+
+// getGlobalArchiveKey generates a KV store key for URL archive metadata shared within a deduplication scope
+// Uses hash of URL to keep key within 150 character limit. The global scope has an empty scope ID.
+func getGlobalArchiveKey(url, scopeID string) string {
+	hash := sha256.Sum256([]byte(url))
+	urlHash := hex.EncodeToString(hash[:])
+	if scopeID == "" {
+		return globalArchiveKeyPrefix + urlHash
+	}
+	return globalArchiveKeyPrefix + scopeID + "_" + urlHash
+}
+
+// globalArchiveKey returns the KV store key of the archive of a URL in a deduplication scope, matching
+// the URLs deduplicated together
+func (s *StorageService) globalArchiveKey(url, scopeID string) string {
+	return getGlobalArchiveKey(s.dedupURL(url), scopeID)
+}
+
+// getThreadArchiveKey generates a KV store key for the first archive of a URL within a thread
+func getThreadArchiveKey(rootID, url string) string {
 	hash := sha256.Sum256([]byte(url))
 	urlHash := hex.EncodeToString(hash[:])
-	return "archive_url_" + urlHash
+	return "archive_thread_" + rootID + "_" + urlHash
+}
+
+// StoreThreadArchive records the archive of a URL for its thread, unless the URL was already archived
+// in the thread. The first archive is kept so later posts point to the earliest one.
+func (s *StorageService) StoreThreadArchive(rootID string, metadata *ArchiveMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal thread archive metadata")
+	}
+
+	// Only set the value if there isn't one already
+	if _, appErr := s.api.KVCompareAndSet(getThreadArchiveKey(rootID, s.dedupURL(metadata.OriginalURL)), nil, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store thread archive metadata")
+	}
+	return nil
+}
+
+// GetThreadArchive retrieves the first archive of a URL within a thread
+func (s *StorageService) GetThreadArchive(rootID, url string) (*ArchiveMetadata, error) {
+	existing, appErr := s.api.KVGet(getThreadArchiveKey(rootID, s.dedupURL(url)))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get thread archive metadata")
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	var metadata ArchiveMetadata
+	if err := json.Unmarshal(existing, &metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal thread archive metadata")
+	}
+	return &metadata, nil
 }
 
 // IsURLAlreadyArchived checks if a URL has already been archived for a given post
 func (s *StorageService) IsURLAlreadyArchived(postID, url string) (bool, error) {
-	key := getArchiveMetadataKey(postID, url)
+	key := getArchiveMetadataKey(postID, s.dedupURL(url))
 	existing, appErr := s.api.KVGet(key)
 	if appErr != nil {
 		return false, errors.Wrap(appErr, "failed to check if URL is already archived")
@@ -173,7 +934,7 @@ func (s *StorageService) IsURLAlreadyArchived(postID, url string) (bool, error)
 
 	// Check if any entry matches this URL
 	for _, m := range metadataList {
-		if m.OriginalURL == url {
+		if s.sameURL(m.OriginalURL, url) {
 			return true, nil
 		}
 	}
@@ -181,12 +942,17 @@ func (s *StorageService) IsURLAlreadyArchived(postID, url string) (bool, error)
 	return false, nil
 }
 
-// GetExistingArchiveForURL retrieves the most recent archive metadata for a URL (globally)
-func (s *StorageService) GetExistingArchiveForURL(url string) (*ArchiveMetadata, error) {
-	key := getGlobalArchiveKey(url)
-	existing, appErr := s.api.KVGet(key)
-	if appErr != nil {
-		return nil, errors.Wrap(appErr, "failed to get existing archive for URL")
+// GetExistingArchiveForURL retrieves the most recent archive metadata for a URL within a deduplication scope
+func (s *StorageService) GetExistingArchiveForURL(url, scopeID string) (*ArchiveMetadata, error) {
+	key := s.globalArchiveKey(url, scopeID)
+	existing, cached, version := s.lookupCache.get(key, time.Now())
+	if !cached {
+		data, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get existing archive for URL")
+		}
+		s.lookupCache.set(key, data, version, time.Now())
+		existing = data
 	}
 
 	if existing == nil {
@@ -201,18 +967,442 @@ func (s *StorageService) GetExistingArchiveForURL(url string) (*ArchiveMetadata,
 	return &metadata, nil
 }
 
-// StoreGlobalArchiveMetadata stores the most recent archive metadata for a URL (globally)
-func (s *StorageService) StoreGlobalArchiveMetadata(metadata *ArchiveMetadata) error {
-	key := getGlobalArchiveKey(metadata.OriginalURL)
+// StoreGlobalArchiveCapture stores a new capture of a URL as the most recent archive within a
+// deduplication scope, moving the previous capture to the history. At most maxHistory previous
+// captures are kept, and each keeps a reference to its file so deleting archives doesn't remove it.
+// Captures beyond the maximum number of archives per URL are evicted, see evictCapture.
+func (s *StorageService) StoreGlobalArchiveCapture(metadata *ArchiveMetadata, scopeID string, maxHistory int) error {
+	maxHistory, evict := s.historyLimit(maxHistory)
+	var added []string
+	var dropped []ArchiveHistoryEntry
+	key := getGlobalArchiveKey(s.dedupURL(metadata.OriginalURL), scopeID)
+	err := s.updateKV(key, func(existing []byte) ([]byte, error) {
+		added, dropped = nil, nil
+		metadata.History = nil
+
+		var previous ArchiveMetadata
+		if existing != nil && json.Unmarshal(existing, &previous) == nil {
+			history := previous.History
+			if previous.FileID != "" && previous.FileID != metadata.FileID {
+				history = append([]ArchiveHistoryEntry{{
+					PostID:        previous.PostID,
+					FileID:        previous.FileID,
+					Filename:      previous.Filename,
+					MimeType:      previous.MimeType,
+					Size:          previous.Size,
+					ContentHash:   previous.ContentHash,
+					ArchivedAt:    previous.ArchivedAt,
+					HashAlgorithm: previous.HashAlgorithm,
+				}}, history...)
+				added = append(added, previous.FileID)
+			}
+			if len(history) > maxHistory {
+				dropped = append(dropped, history[maxHistory:]...)
+				history = history[:maxHistory]
+			}
+			metadata.History = history
+		}
+
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal global archive metadata")
+		}
+		return data, nil
+	})
+	s.lookupCache.invalidate(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to store global archive metadata")
+	}
+	if err := s.addToArchiveIndex(key, metadata.ArchivedAt); err != nil {
+		return errors.Wrap(err, "failed to update archive index")
+	}
+
+	// Files dropped from the history are left in place, posts may still reference them. Only
+	// evicted captures have their files deleted.
+	for _, fileID := range added {
+		if err := s.addFileReference(fileID); err != nil {
+			return errors.Wrap(err, "failed to add file reference")
+		}
+	}
+	for _, entry := range dropped {
+		if evict {
+			if err := s.evictCapture(metadata.OriginalURL, entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := s.ReleaseFileReference(entry.FileID); err != nil {
+			return errors.Wrap(err, "failed to release file reference")
+		}
+	}
+
+	return nil
+}
+
+// historyLimit returns the number of previous captures kept per URL, given the configured history
+// size, and whether the captures beyond it are evicted because of the maximum number of archives per URL
+func (s *StorageService) historyLimit(maxHistory int) (int, bool) {
+	maxArchives := int(s.maxArchivesPerURL.Load())
+	if maxArchives > 0 && maxArchives-1 <= maxHistory {
+		return maxArchives - 1, true
+	}
+	return maxHistory, false
+}
+
+// evictCapture removes a capture of a URL evicted from its history, with the archive record of the
+// post it was captured for. Its file is deleted once no other archive references it, like the
+// records of posts that reused it, and kept if it has no reference count.
+func (s *StorageService) evictCapture(url string, entry ArchiveHistoryEntry) error {
+	remaining, err := s.ReleaseFileReference(entry.FileID)
+	if err != nil {
+		return errors.Wrap(err, "failed to release file reference")
+	}
+
+	if entry.PostID != "" {
+		removed, err := s.deleteArchiveMetadata(entry.PostID, url, entry.FileID)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete evicted archive metadata")
+		}
+		if removed != nil {
+			if remaining, err = s.ReleaseFileReference(entry.FileID); err != nil {
+				return errors.Wrap(err, "failed to release file reference")
+			}
+			// The other files of the record are released and deleted the same way
+			for _, file := range removed.AdditionalFiles {
+				fileRemaining, err := s.ReleaseFileReference(file.FileID)
+				if err != nil {
+					return errors.Wrap(err, "failed to release file reference")
+				}
+				if fileRemaining == 0 && s.fileDeleter != nil {
+					s.fileDeleter.DeleteArchivedFile(file.FileID)
+				}
+			}
+		}
+	}
+
+	fileDeleted := false
+	if remaining == 0 && s.fileDeleter != nil {
+		fileDeleted = s.fileDeleter.DeleteArchivedFile(entry.FileID)
+	}
+	s.api.LogInfo("Evicted archive exceeding the maximum archives per URL", "url", redactURL(url), "postID", entry.PostID, "fileID", entry.FileID, "fileDeleted", fileDeleted)
+	return nil
+}
+
+// StoreGlobalArchiveMetadata stores the most recent archive metadata for a URL within a deduplication scope
+func (s *StorageService) StoreGlobalArchiveMetadata(metadata *ArchiveMetadata, scopeID string) error {
+	// Histories kept before the maximum number of archives per URL was lowered are evicted here too
+	var evicted []ArchiveHistoryEntry
+	if maxHistory, evict := s.historyLimit(len(metadata.History)); evict && len(metadata.History) > maxHistory {
+		evicted = metadata.History[maxHistory:]
+		metadata.History = metadata.History[:maxHistory:maxHistory]
+	}
+
+	key := getGlobalArchiveKey(s.dedupURL(metadata.OriginalURL), scopeID)
 	data, err := json.Marshal(metadata)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal global archive metadata")
 	}
 
 	appErr := s.api.KVSet(key, data)
+	s.lookupCache.invalidate(key)
 	if appErr != nil {
 		return errors.Wrap(appErr, "failed to store global archive metadata")
 	}
+	if err := s.addToArchiveIndex(key, metadata.ArchivedAt); err != nil {
+		return errors.Wrap(err, "failed to update archive index")
+	}
+
+	for _, entry := range evicted {
+		if err := s.evictCapture(metadata.OriginalURL, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	// archiveIndexShards is the number of KV entries the archive index is split into, so each entry
+	// stays small as the number of archived URLs grows. Must divide 256.
+	archiveIndexShards = 64
+	// archiveIndexKeyPrefix prefixes the keys of the archive index shards
+	archiveIndexKeyPrefix = "archive_index_"
+	// archiveIndexBackfilledKey is set once the global archives stored before the archive index
+	// existed are listed in it, see BackfillArchiveIndex
+	archiveIndexBackfilledKey = archiveIndexKeyPrefix + "backfilled"
+)
+
+// archiveIndexEntry is an archived URL listed in the archive index
+type archiveIndexEntry struct {
+	// Key is the key of the URL's global archive metadata
+	Key string
+	// ArchivedAt is when the URL was last archived, in milliseconds since the epoch
+	ArchivedAt int64
+}
+
+// getArchiveIndexShardKey returns the key of the archive index shard listing a global archive metadata key.
+// Listing the KV store pages through every key of the plugin, so the archived URLs are enumerated
+// from the index instead.
+func getArchiveIndexShardKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return archiveIndexKeyPrefix + strconv.Itoa(int(hash[0])%archiveIndexShards)
+}
+
+// updateArchiveIndex applies a change to the shard of the archive index listing a global archive
+// metadata key. Shards map the keys to the time they were last archived.
+func (s *StorageService) updateArchiveIndex(key string, update func(entries map[string]int64)) error {
+	return s.updateArchiveIndexShard(getArchiveIndexShardKey(key), update)
+}
+
+// updateArchiveIndexShard applies a change to a shard of the archive index
+func (s *StorageService) updateArchiveIndexShard(shardKey string, update func(entries map[string]int64)) error {
+	return s.updateKV(shardKey, func(existing []byte) ([]byte, error) {
+		entries := map[string]int64{}
+		if existing != nil {
+			if err := json.Unmarshal(existing, &entries); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal archive index")
+			}
+		}
+		update(entries)
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal archive index")
+		}
+		return data, nil
+	})
+}
+
+// addToArchiveIndex lists a global archive metadata key in the index, archived at the given time or now if unset
+func (s *StorageService) addToArchiveIndex(key string, archivedAt time.Time) error {
+	if archivedAt.IsZero() {
+		archivedAt = time.Now()
+	}
+	return s.updateArchiveIndex(key, func(entries map[string]int64) {
+		entries[key] = archivedAt.UnixMilli()
+	})
+}
+
+// removeFromArchiveIndex removes a global archive metadata key from the index
+func (s *StorageService) removeFromArchiveIndex(key string) error {
+	return s.updateArchiveIndex(key, func(entries map[string]int64) {
+		delete(entries, key)
+	})
+}
+
+// BackfillArchiveIndex lists the global archives stored before the archive index existed in it,
+// by listing the KV store once. Archives already listed keep their entry. Returns the number of
+// archives added to the index.
+func (s *StorageService) BackfillArchiveIndex() (int, error) {
+	backfilled, appErr := s.api.KVGet(archiveIndexBackfilledKey)
+	if appErr != nil {
+		return 0, errors.Wrap(appErr, "failed to get archive index backfill marker")
+	}
+	if backfilled != nil {
+		return 0, nil
+	}
+
+	keys, err := s.listArchiveKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	// Entries are grouped by shard, so each shard is written once
+	shards := make(map[string]map[string]int64)
+	read := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, globalArchiveKeyPrefix) {
+			continue
+		}
+		if read > 0 && read%purgeBatchSize == 0 {
+			time.Sleep(purgeBatchPause)
+		}
+		read++
+
+		data, appErr := s.api.KVGet(key)
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to get global archive metadata")
+		}
+		if data == nil {
+			continue
+		}
+		var metadata ArchiveMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return 0, errors.Wrap(err, "failed to unmarshal global archive metadata")
+		}
+		archivedAt := metadata.ArchivedAt
+		if archivedAt.IsZero() {
+			archivedAt = time.Now()
+		}
+
+		shardKey := getArchiveIndexShardKey(key)
+		if shards[shardKey] == nil {
+			shards[shardKey] = make(map[string]int64)
+		}
+		shards[shardKey][key] = archivedAt.UnixMilli()
+	}
+
+	total := 0
+	for shardKey, missing := range shards {
+		var added int
+		err := s.updateArchiveIndexShard(shardKey, func(entries map[string]int64) {
+			// Called again if the shard changed meanwhile
+			added = 0
+			for key, archivedAt := range missing {
+				if _, listed := entries[key]; !listed {
+					entries[key] = archivedAt
+					added++
+				}
+			}
+		})
+		if err != nil {
+			return total, errors.Wrap(err, "failed to update archive index")
+		}
+		total += added
+	}
+
+	if appErr := s.api.KVSet(archiveIndexBackfilledKey, []byte("true")); appErr != nil {
+		return total, errors.Wrap(appErr, "failed to store archive index backfill marker")
+	}
+	return total, nil
+}
+
+// listArchiveIndex returns the entries of every shard of the archive index, most recently archived first
+func (s *StorageService) listArchiveIndex() ([]archiveIndexEntry, error) {
+	var index []archiveIndexEntry
+	for shard := 0; shard < archiveIndexShards; shard++ {
+		data, appErr := s.api.KVGet(archiveIndexKeyPrefix + strconv.Itoa(shard))
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to get archive index")
+		}
+		if data == nil {
+			continue
+		}
+		var entries map[string]int64
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal archive index")
+		}
+		for key, archivedAt := range entries {
+			index = append(index, archiveIndexEntry{Key: key, ArchivedAt: archivedAt})
+		}
+	}
+
+	slices.SortFunc(index, func(a, b archiveIndexEntry) int {
+		if a.ArchivedAt != b.ArchivedAt {
+			return cmp.Compare(b.ArchivedAt, a.ArchivedAt)
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return index, nil
+}
+
+// ScanGlobalArchives calls fn with the most recent archive metadata of every archived URL, in all
+// deduplication scopes, most recently archived URLs first, stopping after limit archives. Returns
+// the number of archived URLs, which can be more than the scanned ones.
+func (s *StorageService) ScanGlobalArchives(limit int, fn func(metadata *ArchiveMetadata)) (int, error) {
+	index, err := s.listArchiveIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range index[:min(limit, len(index))] {
+		existing, appErr := s.api.KVGet(entry.Key)
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to get global archive metadata")
+		}
+		if existing == nil {
+			continue
+		}
+		var metadata ArchiveMetadata
+		if err := json.Unmarshal(existing, &metadata); err != nil {
+			return 0, errors.Wrap(err, "failed to unmarshal global archive metadata")
+		}
+		fn(&metadata)
+	}
+
+	return len(index), nil
+}
+
+// ForEachGlobalArchive calls fn with the key and the most recent archive metadata of every archived
+// URL, in all deduplication scopes
+func (s *StorageService) ForEachGlobalArchive(fn func(key string, metadata *ArchiveMetadata)) error {
+	index, err := s.listArchiveIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range index {
+		existing, appErr := s.api.KVGet(entry.Key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get global archive metadata")
+		}
+		if existing == nil {
+			continue
+		}
+		var metadata ArchiveMetadata
+		if err := json.Unmarshal(existing, &metadata); err != nil {
+			return errors.Wrap(err, "failed to unmarshal global archive metadata")
+		}
+		fn(entry.Key, &metadata)
+	}
 
 	return nil
 }
+
+// errArchiveDeleted stops the update of a global archive metadata deleted meanwhile
+var errArchiveDeleted = errors.New("global archive metadata deleted")
+
+// SetNextRefresh stores when the URL of a global archive metadata key is refreshed next. Archives
+// deleted meanwhile are left deleted.
+func (s *StorageService) SetNextRefresh(key string, next time.Time) error {
+	err := s.updateKV(key, func(existing []byte) ([]byte, error) {
+		if existing == nil {
+			return nil, errArchiveDeleted
+		}
+		var metadata ArchiveMetadata
+		if err := json.Unmarshal(existing, &metadata); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal global archive metadata")
+		}
+		metadata.NextRefreshAt = next
+		data, err := json.Marshal(&metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal global archive metadata")
+		}
+		return data, nil
+	})
+	s.lookupCache.invalidate(key)
+	if errors.Is(err, errArchiveDeleted) {
+		return nil
+	}
+	return err
+}
+
+// ListGlobalArchives returns a page of the most recent archive metadata of every archived URL, in
+// all deduplication scopes, most recently archived URLs first. Also returns the number of archived URLs.
+func (s *StorageService) ListGlobalArchives(page, perPage int) ([]*ArchiveMetadata, int, error) {
+	index, err := s.listArchiveIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	archives := []*ArchiveMetadata{}
+	start := page * perPage
+	if start >= len(index) {
+		return archives, len(index), nil
+	}
+	for _, entry := range index[start:min(start+perPage, len(index))] {
+		existing, appErr := s.api.KVGet(entry.Key)
+		if appErr != nil {
+			return nil, 0, errors.Wrap(appErr, "failed to get global archive metadata")
+		}
+		if existing == nil {
+			continue
+		}
+		var metadata ArchiveMetadata
+		if err := json.Unmarshal(existing, &metadata); err != nil {
+			return nil, 0, errors.Wrap(err, "failed to unmarshal global archive metadata")
+		}
+		archives = append(archives, &metadata)
+	}
+
+	return archives, len(index), nil
+}
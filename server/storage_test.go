@@ -0,0 +1,801 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+// memoryKV is an in-memory KV store backing the KV methods of a plugintest.API
+type memoryKV struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+// setupMemoryKV mocks the KV methods of the API with an in-memory store
+func setupMemoryKV(api *plugintest.API) *memoryKV {
+	kv := &memoryKV{data: make(map[string][]byte)}
+
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		return kv.data[key]
+	}, func(key string) *model.AppError {
+		return nil
+	})
+	api.On("KVSet", mock.AnythingOfType("string"), mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		kv.data[key] = value
+		return nil
+	})
+	api.On("KVCompareAndSet", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) bool {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		current, exists := kv.data[key]
+		if (oldValue == nil && exists) || (oldValue != nil && !bytes.Equal(current, oldValue)) {
+			return false
+		}
+		kv.data[key] = newValue
+		return true
+	}, func(key string, oldValue, newValue []byte) *model.AppError {
+		return nil
+	})
+	// Expiry isn't simulated, keys are kept until deleted
+	api.On("KVSetWithExpiry", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("int64")).Return(func(key string, value []byte, expireInSeconds int64) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		kv.data[key] = value
+		return nil
+	})
+	api.On("KVList", mock.AnythingOfType("int"), mock.AnythingOfType("int")).Return(func(page, perPage int) []string {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		var keys []string
+		for key := range kv.data {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		start := min(page*perPage, len(keys))
+		return keys[start:min(start+perPage, len(keys))]
+	}, func(int, int) *model.AppError { return nil })
+	api.On("KVCompareAndDelete", mock.AnythingOfType("string"), mock.Anything).Return(func(key string, oldValue []byte) bool {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		current, exists := kv.data[key]
+		if !exists || !bytes.Equal(current, oldValue) {
+			return false
+		}
+		delete(kv.data, key)
+		return true
+	}, func(key string, oldValue []byte) *model.AppError {
+		return nil
+	})
+
+	return kv
+}
+
+func TestStoreArchiveMetadata(t *testing.T) {
+	t.Run("storing the same URL twice keeps a single entry", func(t *testing.T) {
+		api := &plugintest.API{}
+		kv := setupMemoryKV(api)
+		storage := NewStorageService(api)
+
+		first := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}
+		second := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a.pdf", FileID: "file2"}
+		require.NoError(t, storage.StoreArchiveMetadata(first))
+		require.NoError(t, storage.StoreArchiveMetadata(second))
+
+		var stored []*ArchiveMetadata
+		require.NoError(t, json.Unmarshal(kv.data[getArchiveMetadataKey("post1", "https://example.com/a.pdf")], &stored))
+		require.Len(t, stored, 1)
+		assert.Equal(t, "file2", stored[0].FileID)
+	})
+
+	t.Run("stored URL is reported as already archived", func(t *testing.T) {
+		api := &plugintest.API{}
+		setupMemoryKV(api)
+		storage := NewStorageService(api)
+
+		archived, err := storage.IsURLAlreadyArchived("post1", "https://example.com/a.pdf")
+		require.NoError(t, err)
+		assert.False(t, archived)
+
+		require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}))
+
+		archived, err = storage.IsURLAlreadyArchived("post1", "https://example.com/a.pdf")
+		require.NoError(t, err)
+		assert.True(t, archived)
+	})
+}
+
+func TestStoreArchiveMetadataConcurrent(t *testing.T) {
+	t.Run("concurrent archives of one post are all stored", func(t *testing.T) {
+		api := &plugintest.API{}
+		setupMemoryKV(api)
+		storage := NewStorageService(api)
+
+		const count = 20
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: fmt.Sprintf("https://example.com/%d.pdf", i), FileID: fmt.Sprintf("file%d", i)}
+				assert.NoError(t, storage.StoreArchiveMetadata(metadata))
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < count; i++ {
+			archived, err := storage.IsURLAlreadyArchived("post1", fmt.Sprintf("https://example.com/%d.pdf", i))
+			require.NoError(t, err)
+			assert.True(t, archived, "archive %d was lost", i)
+		}
+	})
+
+	t.Run("concurrent updates of the same key are not lost", func(t *testing.T) {
+		api := &plugintest.API{}
+		kv := setupMemoryKV(api)
+		storage := NewStorageService(api)
+
+		const count = 5
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := storage.updateKV("shared", func(existing []byte) ([]byte, error) {
+					var values []int
+					if existing != nil {
+						if err := json.Unmarshal(existing, &values); err != nil {
+							return nil, err
+						}
+					}
+					return json.Marshal(append(values, i))
+				})
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		var values []int
+		require.NoError(t, json.Unmarshal(kv.data["shared"], &values))
+		assert.Len(t, values, count)
+	})
+}
+
+func TestGlobalArchiveMetadataScopes(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, "channel1"))
+
+	existing, err := storage.GetExistingArchiveForURL("https://example.com/a.pdf", "channel1")
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "file1", existing.FileID)
+
+	existing, err = storage.GetExistingArchiveForURL("https://example.com/a.pdf", "channel2")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "archives must not be shared across scopes")
+
+	existing, err = storage.GetExistingArchiveForURL("https://example.com/a.pdf", "")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "scoped archives must not leak into the global scope")
+
+	assert.Len(t, getGlobalArchiveKey("https://example.com/a.pdf", model.NewId()), 12+26+1+64, "key must stay within KV limits")
+}
+
+func TestResponseHeadersMetadata(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+	api.On("UploadFile", mock.Anything, "channel1", "a.pdf").Return(&model.FileInfo{Id: "file1"}, nil)
+	storage := NewStorageService(api)
+
+	headers := map[string]string{"Status": "200", "ETag": `"v1"`}
+	metadata, err := storage.StoreArchivedFile("post1", "https://example.com/a.pdf", &archiver.ArchivedFile{
+		Filename: "a.pdf", Data: []byte("%PDF"), MimeType: "application/pdf", Size: 4, ResponseHeaders: headers,
+	}, archiver.DirectDownloadToolName)
+	require.NoError(t, err)
+	assert.Equal(t, headers, metadata.ResponseHeaders)
+
+	// Reused archives keep the headers of the capture
+	reused := storage.CreateMetadataForExistingFile("post2", "https://example.com/a.pdf", metadata)
+	assert.Equal(t, headers, reused.ResponseHeaders)
+	reused.ResponseHeaders["Status"] = "304"
+	assert.Equal(t, "200", metadata.ResponseHeaders["Status"])
+
+	data, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"responseHeaders":{"ETag":"\"v1\"","Status":"200"}`)
+}
+
+func TestDownloadHTMLArchives(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+	api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(&model.FileInfo{Id: "file1"}, nil)
+	storage := NewStorageService(api)
+
+	page := &archiver.ArchivedFile{Filename: "page.obelisk.html", Data: []byte("<html></html>"), MimeType: "text/html; charset=utf-8", Size: 13}
+	text := &archiver.ArchivedFile{Filename: "page.txt", Data: []byte("text"), MimeType: "text/plain", Size: 4}
+
+	metadata, err := storage.StoreArchivedFile("post1", "https://example.com/page", page, archiver.ObeliskToolName)
+	require.NoError(t, err)
+	assert.Equal(t, "page.obelisk.html", metadata.Filename, "HTML archives are previewable by default")
+
+	storage.SetDownloadHTMLArchives(true)
+	metadata, err = storage.StoreArchivedFile("post1", "https://example.com/page", page, archiver.ObeliskToolName)
+	require.NoError(t, err)
+	assert.Equal(t, "page.obelisk.html.bin", metadata.Filename)
+	assert.Equal(t, "text/html; charset=utf-8", metadata.MimeType, "the metadata keeps the type of the page")
+	api.AssertCalled(t, "UploadFile", page.Data, "channel1", "page.obelisk.html.bin")
+
+	metadata, err = storage.StoreArchivedFile("post1", "https://example.com/page", text, archiver.ObeliskToolName)
+	require.NoError(t, err)
+	assert.Equal(t, "page.txt", metadata.Filename, "other archives are uploaded as is")
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetPost", "post1").Return(&model.Post{Id: "post1", ChannelId: "channel1"}, nil)
+	api.On("UploadFile", mock.Anything, "channel1", mock.Anything).Return(&model.FileInfo{Id: "file1"}, nil)
+	storage := NewStorageService(api)
+	file := &archiver.ArchivedFile{Filename: "hello.txt", Data: []byte("hello"), MimeType: "text/plain", Size: 5}
+
+	tests := []struct {
+		algorithm string
+		expected  string
+	}{
+		{HashAlgorithmSHA256, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{HashAlgorithmSHA512, "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			storage.SetHashAlgorithm(tt.algorithm)
+			metadata, err := storage.StoreArchivedFile("post1", "https://example.com/hello.txt", file, archiver.DirectDownloadToolName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, metadata.ContentHash)
+			assert.Equal(t, tt.algorithm, metadata.HashAlgorithm)
+
+			assert.True(t, metadata.MatchesContent(file.Data))
+			assert.False(t, metadata.MatchesContent([]byte("hello!")))
+			assert.Equal(t, tt.algorithm, storage.CreateMetadataForExistingFile("post2", metadata.OriginalURL, metadata).HashAlgorithm, "reused archives keep the hash of their file")
+		})
+	}
+
+	t.Run("archives without an algorithm were hashed with SHA-256", func(t *testing.T) {
+		legacy := &ArchiveMetadata{ContentHash: tests[0].expected}
+		assert.True(t, legacy.MatchesContent(file.Data))
+		assert.False(t, (&ArchiveMetadata{}).MatchesContent(nil), "archives without a hash never match")
+	})
+
+	t.Run("unknown algorithms fall back to SHA-256", func(t *testing.T) {
+		assert.Equal(t, HashAlgorithmSHA256, (&configuration{HashAlgorithm: "md5"}).getHashAlgorithm())
+		assert.Equal(t, HashAlgorithmSHA512, (&configuration{HashAlgorithm: " SHA512 "}).getHashAlgorithm())
+		assert.Equal(t, HashAlgorithmSHA256, (&configuration{}).getHashAlgorithm())
+	})
+}
+
+func TestRenderDisplayName(t *testing.T) {
+	metadata := &ArchiveMetadata{
+		OriginalURL: "https://docs.example.com/download?id=123",
+		Filename:    "download_id_123 (1).pdf",
+		MimeType:    "application/pdf",
+		ArchivedAt:  time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC),
+	}
+
+	assert.Equal(t, "docs.example.com 2026-03-14.pdf", renderDisplayName("{host} {date}.{ext}", metadata))
+	assert.Equal(t, "download_id_123 (1) (application/pdf)", renderDisplayName("  {name}  ({type}) ", metadata))
+	assert.Empty(t, renderDisplayName("", metadata))
+	assert.Empty(t, renderDisplayName("{filename}", metadata), "rendering the filename shows it as is")
+	assert.Len(t, []rune(renderDisplayName(strings.Repeat("é", 150), metadata)), maxDisplayNameLength)
+
+	metadata.DisplayName = renderDisplayName("{host}.{ext}", metadata)
+	assert.Equal(t, "docs.example.com.pdf", metadata.ShownName())
+	assert.Equal(t, "download_id_123 (1).pdf", metadata.Filename, "the stored name is kept")
+	assert.Equal(t, "docs.example.com.pdf", NewStorageService(nil).CreateMetadataForExistingFile("post2", metadata.OriginalURL, metadata).DisplayName)
+}
+
+func TestGetDisplayNameTemplate(t *testing.T) {
+	template, err := (&configuration{DisplayNameTemplate: " {host} - {name}.{ext} "}).getDisplayNameTemplate()
+	require.NoError(t, err)
+	assert.Equal(t, "{host} - {name}.{ext}", template)
+
+	config := &configuration{DisplayNameTemplate: "{title}.{ext}"}
+	_, err = config.getDisplayNameTemplate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown token {title}")
+	assert.Empty(t, config.getDisplayName(&ArchiveMetadata{Filename: "page.html"}), "invalid templates show the filename")
+}
+
+func TestFileReferences(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	// Two posts reuse the same file
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}))
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post2", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}))
+	// Storing the same record again doesn't add a reference
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post2", OriginalURL: "https://example.com/a.pdf", FileID: "file1"}))
+
+	removed, err := storage.DeleteArchiveMetadata("post1", "https://example.com/a.pdf")
+	require.NoError(t, err)
+	require.NotNil(t, removed)
+	assert.Equal(t, "file1", removed.FileID)
+
+	archived, err := storage.IsURLAlreadyArchived("post1", "https://example.com/a.pdf")
+	require.NoError(t, err)
+	assert.False(t, archived)
+
+	remaining, err := storage.ReleaseFileReference("file1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, remaining, "post2 still references the file")
+
+	remaining, err = storage.ReleaseFileReference("file1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+
+	remaining, err = storage.ReleaseFileReference("legacy-file")
+	require.NoError(t, err)
+	assert.Equal(t, -1, remaining, "files without a count are unknown")
+
+	removed, err = storage.DeleteArchiveMetadata("post1", "https://example.com/a.pdf")
+	require.NoError(t, err)
+	assert.Nil(t, removed)
+}
+
+func TestStoreGlobalArchiveCapture(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	const url = "https://status.example.com/"
+	capture := func(fileID string) {
+		require.NoError(t, storage.StoreGlobalArchiveCapture(&ArchiveMetadata{PostID: "post-" + fileID, OriginalURL: url, FileID: fileID, ContentHash: "hash-" + fileID}, "", 2))
+	}
+
+	capture("file1")
+	capture("file2")
+	capture("file3")
+	// Storing the current capture again doesn't add it to the history
+	capture("file3")
+
+	metadata, err := storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	require.NotNil(t, metadata)
+	assert.Equal(t, "file3", metadata.FileID)
+	require.Len(t, metadata.History, 2)
+	assert.Equal(t, "file2", metadata.History[0].FileID, "history is newest first")
+	assert.Equal(t, "hash-file2", metadata.History[0].ContentHash)
+	assert.Equal(t, "file1", metadata.History[1].FileID)
+
+	// The history keeps a reference to its files
+	remaining, err := storage.ReleaseFileReference("file2")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+
+	// Captures dropped from the history release their reference
+	capture("file4")
+	metadata, err = storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	require.Len(t, metadata.History, 2)
+	assert.Equal(t, "file3", metadata.History[0].FileID)
+	assert.Equal(t, "file2", metadata.History[1].FileID)
+
+	remaining, err = storage.ReleaseFileReference("file1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+}
+
+// recordingFileDeleter records the files it is asked to delete
+type recordingFileDeleter struct {
+	deleted []string
+}
+
+func (d *recordingFileDeleter) DeleteArchivedFile(fileID string) bool {
+	d.deleted = append(d.deleted, fileID)
+	return true
+}
+
+func TestMaxArchivesPerURL(t *testing.T) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+	deleter := &recordingFileDeleter{}
+	storage.SetFileDeleter(deleter)
+	storage.SetMaxArchivesPerURL(2)
+
+	const url = "https://status.example.com/"
+	capture := func(postID, fileID string) {
+		metadata := &ArchiveMetadata{PostID: postID, OriginalURL: url, FileID: fileID}
+		require.NoError(t, storage.StoreArchiveMetadata(metadata))
+		require.NoError(t, storage.StoreGlobalArchiveCapture(metadata, "", 10))
+	}
+
+	capture("post1", "file1")
+	capture("post2", "file2")
+	assert.Empty(t, deleter.deleted)
+
+	// Exceeding the limit evicts the oldest capture and deletes its file
+	capture("post3", "file3")
+	metadata, err := storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	assert.Equal(t, "file3", metadata.FileID)
+	require.Len(t, metadata.History, 1)
+	assert.Equal(t, "file2", metadata.History[0].FileID)
+	assert.Equal(t, []string{"file1"}, deleter.deleted)
+
+	archived, err := storage.IsURLAlreadyArchived("post1", url)
+	require.NoError(t, err)
+	assert.False(t, archived, "the record of the evicted capture is removed")
+
+	// Files reused by other posts are kept
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post4", OriginalURL: url, FileID: "file2"}))
+	capture("post5", "file5")
+	assert.Equal(t, []string{"file1"}, deleter.deleted)
+	archived, err = storage.IsURLAlreadyArchived("post2", url)
+	require.NoError(t, err)
+	assert.False(t, archived)
+	archived, err = storage.IsURLAlreadyArchived("post4", url)
+	require.NoError(t, err)
+	assert.True(t, archived)
+
+	// Lowering the limit evicts the history when the archive is stored again
+	storage.SetMaxArchivesPerURL(1)
+	metadata, err = storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+	metadata, err = storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	assert.Empty(t, metadata.History)
+	assert.Equal(t, []string{"file1", "file3"}, deleter.deleted)
+
+	// Zero keeps every capture up to the history size
+	storage.SetMaxArchivesPerURL(0)
+	capture("post6", "file6")
+	capture("post7", "file7")
+	metadata, err = storage.GetExistingArchiveForURL(url, "")
+	require.NoError(t, err)
+	assert.Len(t, metadata.History, 2)
+	assert.Equal(t, []string{"file1", "file3"}, deleter.deleted)
+}
+
+func TestArchiveIndex(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	const count = 500
+	for i := 0; i < count; i++ {
+		require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{OriginalURL: fmt.Sprintf("https://example.com/%d", i), FileID: fmt.Sprintf("file%d", i)}, ""))
+	}
+	// Storing a URL again doesn't list it twice
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{OriginalURL: "https://example.com/0", FileID: "file0"}, ""))
+
+	// The index is split across shards, each listing a part of the URLs
+	shards := 0
+	for key, data := range kv.data {
+		if !strings.HasPrefix(key, archiveIndexKeyPrefix) {
+			continue
+		}
+		shards++
+		var entries map[string]int64
+		require.NoError(t, json.Unmarshal(data, &entries))
+		assert.Less(t, len(entries), count/4)
+		for entryKey := range entries {
+			assert.Equal(t, key, getArchiveIndexShardKey(entryKey))
+		}
+	}
+	assert.Greater(t, shards, archiveIndexShards/2)
+
+	index, err := storage.listArchiveIndex()
+	require.NoError(t, err)
+	assert.Len(t, index, count)
+
+	require.NoError(t, storage.removeFromArchiveIndex(getGlobalArchiveKey("https://example.com/1", "")))
+	index, err = storage.listArchiveIndex()
+	require.NoError(t, err)
+	assert.Len(t, index, count-1)
+}
+
+func TestBackfillArchiveIndex(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	// Archives stored before the index existed, in the global and a channel scope
+	archivedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	legacy := map[string]*ArchiveMetadata{
+		getGlobalArchiveKey("https://example.com/old", ""):         {OriginalURL: "https://example.com/old", FileID: "file1", ArchivedAt: archivedAt},
+		getGlobalArchiveKey("https://example.com/old", "channel1"): {OriginalURL: "https://example.com/old", FileID: "file2", ArchivedAt: archivedAt},
+	}
+	for key, metadata := range legacy {
+		data, err := json.Marshal(metadata)
+		require.NoError(t, err)
+		kv.data[key] = data
+	}
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/old", FileID: "file1"}))
+	newArchivedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(&ArchiveMetadata{OriginalURL: "https://example.com/new", FileID: "file3", ArchivedAt: newArchivedAt}, ""))
+
+	index, err := storage.listArchiveIndex()
+	require.NoError(t, err)
+	require.Len(t, index, 1, "only archives stored since are listed")
+
+	added, err := storage.BackfillArchiveIndex()
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	index, err = storage.listArchiveIndex()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []archiveIndexEntry{
+		{Key: getGlobalArchiveKey("https://example.com/new", ""), ArchivedAt: newArchivedAt.UnixMilli()},
+		{Key: getGlobalArchiveKey("https://example.com/old", ""), ArchivedAt: archivedAt.UnixMilli()},
+		{Key: getGlobalArchiveKey("https://example.com/old", "channel1"), ArchivedAt: archivedAt.UnixMilli()},
+	}, index, "archives keep the time they were archived at")
+
+	// The KV store is only listed once
+	delete(kv.data, getArchiveIndexShardKey(getGlobalArchiveKey("https://example.com/old", "")))
+	added, err = storage.BackfillArchiveIndex()
+	require.NoError(t, err)
+	assert.Zero(t, added)
+	api.AssertNumberOfCalls(t, "KVList", 1)
+}
+
+func TestCanonicalizeForDedup(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://x.com:443/page#section", "https://x.com/page"},
+		{"http://x.com:80/page?q=1#top", "http://x.com/page?q=1"},
+		{"https://x.com:80/page", "https://x.com:80/page"},
+		{"http://x.com:8080/page", "http://x.com:8080/page"},
+		{"https://[::1]:443/page", "https://[::1]/page"},
+		{"https://x.com/page", "https://x.com/page"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.expected, canonicalizeForDedup(tt.url))
+		})
+	}
+}
+
+func TestStripQueryForDedup(t *testing.T) {
+	assert.Equal(t, "https://example.com/list", stripQueryForDedup("https://example.com/list?page=2&sort=asc#top"))
+	assert.Equal(t, "https://example.com/list", stripQueryForDedup("https://example.com/list?"))
+	assert.Equal(t, "https://example.com/", stripQueryForDedup("https://example.com/"))
+	assert.Equal(t, "://bad?x=1", stripQueryForDedup("://bad?x=1"), "unparsable URLs are unchanged")
+}
+
+func TestDedupIgnoreQuery(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+
+	metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/list?page=1", FileID: "file1"}
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+
+	existing, err := storage.GetExistingArchiveForURL("https://example.com/list?page=2", "")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "query strings are matched by default")
+
+	storage.SetIgnoreQuery(true)
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+	require.NoError(t, storage.StoreArchiveMetadata(metadata))
+
+	for _, url := range []string{"https://example.com/list?page=2", "https://example.com/list", "https://example.com/list?page=1#top"} {
+		existing, err = storage.GetExistingArchiveForURL(url, "")
+		require.NoError(t, err)
+		require.NotNil(t, existing, url)
+		assert.Equal(t, "https://example.com/list?page=1", existing.OriginalURL, "the posted URL is kept")
+
+		archived, err := storage.IsURLAlreadyArchived("post1", url)
+		require.NoError(t, err)
+		assert.True(t, archived, url)
+	}
+
+	existing, err = storage.GetExistingArchiveForURL("https://example.com/other?page=1", "")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "paths are still matched")
+}
+
+func TestGetPostArchives(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	storage := NewStorageService(api)
+	archivedAt := time.Now().Add(-time.Hour)
+
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/b", FileID: "file2", ToolUsed: "obelisk", ArchivedAt: archivedAt.Add(time.Minute)}))
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a", FileID: "file1", ToolUsed: "direct_download", ContentHash: "abc", ArchivedAt: archivedAt}))
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/a", FileID: "file3", ToolUsed: "direct_download", ContentHash: "def", ArchivedAt: archivedAt}))
+	require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post2", OriginalURL: "https://example.com/c", FileID: "file4"}))
+
+	archives, err := storage.GetPostArchives("post1", nil)
+	require.NoError(t, err)
+	require.Len(t, archives, 2, "archiving a URL again updates its archive")
+	assert.Equal(t, "https://example.com/a", archives[0].OriginalURL, "oldest first")
+	assert.Equal(t, "file3", archives[0].FileID)
+	assert.Equal(t, "def", archives[0].ContentHash)
+	assert.Equal(t, "https://example.com/b", archives[1].OriginalURL)
+
+	t.Run("deleted archives are removed from the index", func(t *testing.T) {
+		_, err := storage.DeleteArchiveMetadata("post1", "https://example.com/b")
+		require.NoError(t, err)
+
+		archives, err := storage.GetPostArchives("post1", nil)
+		require.NoError(t, err)
+		require.Len(t, archives, 1)
+		assert.Equal(t, "https://example.com/a", archives[0].OriginalURL)
+		assert.NotContains(t, string(kv.data[getPostArchiveIndexKey("post1")]), getArchiveMetadataKey("post1", "https://example.com/b"))
+	})
+
+	t.Run("archives stored before the index are found through the post's links", func(t *testing.T) {
+		delete(kv.data, getPostArchiveIndexKey("post2"))
+
+		archives, err := storage.GetPostArchives("post2", nil)
+		require.NoError(t, err)
+		assert.Empty(t, archives)
+
+		archives, err = storage.GetPostArchives("post2", []string{"https://example.com/c", "https://example.com/unarchived"})
+		require.NoError(t, err)
+		require.Len(t, archives, 1)
+		assert.Equal(t, "file4", archives[0].FileID)
+	})
+}
+
+func TestStripParamsForDedup(t *testing.T) {
+	rules := []dedupIgnoredParams{
+		{Pattern: "images.example-cdn.com", Params: []string{"w", "h"}},
+		{Pattern: "*.imgix.net", Params: []string{"*"}},
+	}
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://images.example-cdn.com/photo.jpg?w=200&h=200", "https://images.example-cdn.com/photo.jpg"},
+		{"https://images.example-cdn.com/photo.jpg?v=2&w=200&h=200", "https://images.example-cdn.com/photo.jpg?v=2"},
+		{"https://images.example-cdn.com/photo.jpg?h=1&v=2&w=200#top", "https://images.example-cdn.com/photo.jpg?v=2#top"},
+		{"https://IMAGES.example-cdn.com/photo.jpg?%77=200", "https://IMAGES.example-cdn.com/photo.jpg"},
+		{"https://photos.imgix.net/photo.jpg?auto=format&fit=crop", "https://photos.imgix.net/photo.jpg"},
+		{"https://example.com/photo.jpg?w=200", "https://example.com/photo.jpg?w=200"},
+		{"https://images.example-cdn.com/photo.jpg", "https://images.example-cdn.com/photo.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.expected, stripParamsForDedup(tt.url, rules))
+		})
+	}
+}
+
+func TestDedupIgnoredParams(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	storage := NewStorageService(api)
+	storage.SetIgnoredParams([]dedupIgnoredParams{{Pattern: "images.example-cdn.com", Params: []string{"w", "h", "fit"}}})
+
+	metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://images.example-cdn.com/photo.jpg?w=200&h=200&v=2", FileID: "file1"}
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+
+	existing, err := storage.GetExistingArchiveForURL("https://images.example-cdn.com/photo.jpg?w=800&h=600&fit=crop&v=2", "")
+	require.NoError(t, err)
+	require.NotNil(t, existing, "sized variants share their archive")
+	assert.Equal(t, metadata.OriginalURL, existing.OriginalURL, "the posted URL is kept")
+
+	existing, err = storage.GetExistingArchiveForURL("https://images.example-cdn.com/photo.jpg?w=200&v=3", "")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "other parameters are still matched")
+}
+
+func TestGetDedupIgnoreParams(t *testing.T) {
+	config := &configuration{DedupIgnoreParams: "# Image CDNs\nImages.example-cdn.com = w, h,,fit\n*.imgix.net=*\ninvalid\ncdn.example.com=,\n"}
+
+	rules, err := config.getDedupIgnoreParams()
+	assert.ErrorContains(t, err, "invalid lines: 4, 5")
+	assert.Equal(t, []dedupIgnoredParams{
+		{Pattern: "images.example-cdn.com", Params: []string{"w", "h", "fit"}},
+		{Pattern: "*.imgix.net", Params: []string{"*"}},
+	}, rules)
+}
+
+func TestLookupCache(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(func(key string) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		delete(kv.data, key)
+		return nil
+	})
+	storage := NewStorageService(api)
+	storage.SetLookupCache(10, time.Minute)
+	key := getGlobalArchiveKey("https://example.com/page", "")
+
+	for range 3 {
+		existing, err := storage.GetExistingArchiveForURL("https://example.com/page", "")
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	}
+	api.AssertNumberOfCalls(t, "KVGet", 1)
+
+	metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/page", FileID: "file1"}
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+
+	existing, err := storage.GetExistingArchiveForURL("https://example.com/page", "")
+	require.NoError(t, err)
+	require.NotNil(t, existing, "writes invalidate the cached lookup")
+	assert.Equal(t, "file1", existing.FileID)
+
+	require.NoError(t, storage.DeleteGlobalArchiveMetadataForFile("https://example.com/page", "", "file1"))
+	existing, err = storage.GetExistingArchiveForURL("https://example.com/page", "")
+	require.NoError(t, err)
+	assert.Nil(t, existing, "deletions invalidate the cached lookup")
+
+	_, cached, _ := storage.lookupCache.get(key, time.Now())
+	assert.True(t, cached)
+}
+
+func TestCanonicalizeURLs(t *testing.T) {
+	api := &plugintest.API{}
+	kv := setupMemoryKV(api)
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	storage := NewStorageService(api)
+
+	posted := "https://example.com:443/page#section"
+	metadata := &ArchiveMetadata{PostID: "post1", OriginalURL: posted, FileID: "file1"}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+
+		existing, err := storage.GetExistingArchiveForURL("https://example.com/page", "")
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+
+	storage.SetCanonicalizeURLs(true)
+	require.NoError(t, storage.StoreGlobalArchiveMetadata(metadata, ""))
+	require.NoError(t, storage.StoreArchiveMetadata(metadata))
+
+	t.Run("fragments and default ports are ignored", func(t *testing.T) {
+		for _, url := range []string{"https://example.com/page", "https://example.com/page#other", posted} {
+			existing, err := storage.GetExistingArchiveForURL(url, "")
+			require.NoError(t, err)
+			require.NotNil(t, existing, url)
+			assert.Equal(t, posted, existing.OriginalURL, "the posted URL is kept")
+
+			archived, err := storage.IsURLAlreadyArchived("post1", url)
+			require.NoError(t, err)
+			assert.True(t, archived, url)
+		}
+	})
+
+	t.Run("re-archival replaces the record of the post", func(t *testing.T) {
+		require.NoError(t, storage.StoreArchiveMetadata(&ArchiveMetadata{PostID: "post1", OriginalURL: "https://example.com/page", FileID: "file2"}))
+
+		var stored []*ArchiveMetadata
+		require.NoError(t, json.Unmarshal(kv.data[getArchiveMetadataKey("post1", "https://example.com/page")], &stored))
+		require.Len(t, stored, 1)
+		assert.Equal(t, "file2", stored[0].FileID)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/command"
+)
+
+const (
+	// maxStorageUsageArchives bounds the archived URLs read to compute the storage usage, the most
+	// recent ones, as every archive is a KV read
+	maxStorageUsageArchives = 5000
+	// storageUsageTTL is how long the storage usage is cached, so repeated commands don't scan again
+	storageUsageTTL = 5 * time.Minute
+	// storageUsageTopDomains is the number of domains reported in the storage usage
+	storageUsageTopDomains = 10
+)
+
+// StorageUsage reports the storage used by archived files, cached for a few minutes
+func (p *Plugin) StorageUsage() (*command.StorageUsage, error) {
+	if p.archiveProcessor == nil || p.archiveProcessor.storageService == nil {
+		return nil, errors.New("storage service not initialized")
+	}
+
+	p.storageUsageLock.Lock()
+	defer p.storageUsageLock.Unlock()
+
+	if p.storageUsage != nil && time.Since(p.storageUsage.ComputedAt) < storageUsageTTL {
+		return p.storageUsage, nil
+	}
+
+	usage, err := computeStorageUsage(p.archiveProcessor.storageService, maxStorageUsageArchives, storageUsageTopDomains)
+	if err != nil {
+		return nil, err
+	}
+	p.storageUsage = usage
+	return usage, nil
+}
+
+// computeStorageUsage adds up the size of the files of the most recent archived URLs and their
+// capture history. Files shared by several archives, like reused archives in other scopes, are
+// counted once, for the domain of the most recent archive using them.
+func computeStorageUsage(storage *StorageService, limit, topDomains int) (*command.StorageUsage, error) {
+	usage := &command.StorageUsage{}
+	domains := make(map[string]*command.DomainUsage)
+	counted := make(map[string]bool)
+	add := func(domain, fileID string, size int64) {
+		if fileID == "" || counted[fileID] {
+			return
+		}
+		counted[fileID] = true
+
+		usage.TotalBytes += size
+		usage.Files++
+		if domains[domain] == nil {
+			domains[domain] = &command.DomainUsage{Domain: domain}
+		}
+		domains[domain].Bytes += size
+		domains[domain].Files++
+	}
+
+	total, err := storage.ScanGlobalArchives(limit, func(metadata *ArchiveMetadata) {
+		usage.Scanned++
+		domain := "unknown"
+		if parsedURL, err := url.Parse(metadata.OriginalURL); err == nil && parsedURL.Hostname() != "" {
+			domain = strings.ToLower(parsedURL.Hostname())
+		}
+		add(domain, metadata.FileID, metadata.Size)
+		for _, file := range metadata.AdditionalFiles {
+			add(domain, file.FileID, file.Size)
+		}
+		for _, entry := range metadata.History {
+			add(domain, entry.FileID, entry.Size)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan archives")
+	}
+	usage.Total = total
+
+	for _, domain := range domains {
+		usage.TopDomains = append(usage.TopDomains, *domain)
+	}
+	sort.Slice(usage.TopDomains, func(i, j int) bool {
+		if usage.TopDomains[i].Bytes != usage.TopDomains[j].Bytes {
+			return usage.TopDomains[i].Bytes > usage.TopDomains[j].Bytes
+		}
+		return usage.TopDomains[i].Domain < usage.TopDomains[j].Domain
+	})
+	if len(usage.TopDomains) > topDomains {
+		usage.TopDomains = usage.TopDomains[:topDomains]
+	}
+
+	usage.ComputedAt = time.Now()
+	return usage, nil
+}
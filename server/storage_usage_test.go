@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/command"
+)
+
+func TestStorageUsage(t *testing.T) {
+	api := &plugintest.API{}
+	setupMemoryKV(api)
+	mockLogs(api)
+
+	storage := NewStorageService(api)
+	archivedAt := time.Now().Add(-time.Hour)
+	store := func(archivedURL, fileID string, size int64, scopeID string, minutes int) {
+		require.NoError(t, storage.StoreGlobalArchiveCapture(&ArchiveMetadata{OriginalURL: archivedURL, FileID: fileID, Size: size, ArchivedAt: archivedAt.Add(time.Duration(minutes) * time.Minute)}, scopeID, 5))
+	}
+	store("https://files.example.com/a.pdf", "file1", 1000, "", 0)
+	store("https://Files.example.com/b.pdf", "file2", 2000, "", 1)
+	store("https://example.com/", "file3", 500, "", 2)
+	// New captures keep the previous ones in the history, and reused files are counted once
+	store("https://example.com/", "file4", 700, "", 3)
+	store("https://example.com/", "file4", 700, "team1", 4)
+
+	p := &Plugin{archiveProcessor: &ArchiveProcessor{api: api, storageService: storage}}
+	p.SetAPI(api)
+
+	usage, err := p.StorageUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4200), usage.TotalBytes)
+	assert.Equal(t, 4, usage.Files)
+	assert.Equal(t, 4, usage.Scanned)
+	assert.Equal(t, 4, usage.Total)
+	assert.Equal(t, []command.DomainUsage{
+		{Domain: "files.example.com", Bytes: 3000, Files: 2},
+		{Domain: "example.com", Bytes: 1200, Files: 2},
+	}, usage.TopDomains)
+
+	t.Run("cached", func(t *testing.T) {
+		store("https://other.example.com/", "file5", 100, "", 5)
+		cached, err := p.StorageUsage()
+		require.NoError(t, err)
+		assert.Same(t, usage, cached)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		bounded, err := computeStorageUsage(storage, 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 2, bounded.Scanned)
+		assert.Equal(t, 5, bounded.Total)
+		// The most recent archives are the new URL and the capture of the team
+		assert.Equal(t, int64(800), bounded.TotalBytes)
+		assert.Equal(t, []command.DomainUsage{{Domain: "example.com", Bytes: 700, Files: 1}}, bounded.TopDomains)
+	})
+}
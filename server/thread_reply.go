@@ -2,17 +2,135 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
 )
 
 // ThreadReplyService handles creating thread replies with attachments and error messages
 type ThreadReplyService struct {
 	api   plugin.API
 	botID string
+
+	// archiveChannelID is the channel replies are posted to instead of the thread, if set
+	archiveChannelLock sync.RWMutex
+	archiveChannelID   string
+
+	// displayName and iconURL override the author shown on replies, if set and allowed by the server
+	authorLock  sync.RWMutex
+	displayName string
+	iconURL     string
+
+	// channelJoiner adds the bot to channels it failed to post in, if set
+	channelJoiner channelJoiner
+
+	// groupByDomain groups the links of summary replies by domain
+	groupByDomain atomic.Bool
+	// maxPostLength is the maximum length of the bot's posts, Mattermost's limit if zero
+	maxPostLength atomic.Int64
+	// includeDownloadLink adds the download URL of archived files to replies
+	includeDownloadLink atomic.Bool
+	// linkSourceReply links replies in threads to the thread reply the archived links were posted in
+	linkSourceReply atomic.Bool
+
+	// countSummaryFormat is the message of count summaries, the default one if empty
+	countSummaryLock   sync.RWMutex
+	countSummaryFormat string
+
+	// retryEmoji is the emoji retrying failed archives when reacting to their error reply, if set
+	retryEmojiLock sync.RWMutex
+	retryEmoji     string
+}
+
+// Reply modes of archival rules, where the success replies of the URLs they archive are posted
+const (
+	// ReplyModeThread replies in the post's thread, even when replies go to an archive channel
+	ReplyModeThread = "thread"
+	// ReplyModeEphemeral replies only to the author of the post, without attaching the files
+	ReplyModeEphemeral = "ephemeral"
+	// ReplyModeNone doesn't reply, the files are still archived
+	ReplyModeNone = "none"
+)
+
+// replyTemplateTokens are the tokens replaced in the reply templates of archival rules
+var replyTemplateTokens = []string{"{url}", "{filename}", "{size}", "{type}", "{excerpt}", "{rule}"}
+
+// defaultCountSummaryFormat is the message of count summaries unless configured otherwise
+const defaultCountSummaryFormat = "📊 Archived {archived} of {total} link(s): {reused} already archived, {skipped} skipped, {failed} failed."
+
+// countSummaryTokens are the tokens replaced in the message of count summaries, see archiveCounts
+var countSummaryTokens = []string{"{total}", "{archived}", "{reused}", "{skipped}", "{failed}", "{pending}"}
+
+// archiveCounts counts the outcomes of the archives of a post's links, for count summaries
+type archiveCounts struct {
+	Total int
+	// Archived counts the links with an archive, Reused the ones among them archived by an earlier post
+	Archived int
+	Reused   int
+	// Skipped counts the links not archived on purpose, silently or with a notice
+	Skipped int
+	Failed  int
+	// Pending counts the links still being archived
+	Pending int
+}
+
+// countResults counts the outcomes of archives, skipped results included
+func countResults(results []*archiveResult, pending int) archiveCounts {
+	counts := archiveCounts{Total: len(results) + pending, Pending: pending}
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			counts.Failed++
+		case result.Skipped || result.Notice != "" || result.Metadata == nil:
+			counts.Skipped++
+		default:
+			counts.Archived++
+			if result.OriginalPostID != "" {
+				counts.Reused++
+			}
+		}
+	}
+	return counts
+}
+
+// format replaces the tokens of a count summary message with the counts
+func (c archiveCounts) format(message string) string {
+	return strings.NewReplacer(
+		"{total}", strconv.Itoa(c.Total),
+		"{archived}", strconv.Itoa(c.Archived),
+		"{reused}", strconv.Itoa(c.Reused),
+		"{skipped}", strconv.Itoa(c.Skipped),
+		"{failed}", strconv.Itoa(c.Failed),
+		"{pending}", strconv.Itoa(c.Pending),
+	).Replace(message)
+}
+
+// ReplyOptions overrides the reply settings for the archive of a URL, from the archival rule that
+// selected its tool. The zero value uses the global settings.
+type ReplyOptions struct {
+	// Template replaces the success message and excerpt of the reply, see replyTemplateTokens
+	Template string
+	// Mode is one of the ReplyMode constants, empty for the global settings
+	Mode string
+	// Rule is the label of the rule, shown by the {rule} token
+	Rule string
+}
+
+// channelJoiner adds the bot to a channel, joined is false if it already was a member
+type channelJoiner interface {
+	EnsureChannelMember(channelID string) (joined bool, err error)
 }
 
 // NewThreadReplyService creates a new thread reply service
@@ -23,92 +141,115 @@ func NewThreadReplyService(api plugin.API, botID string) *ThreadReplyService {
 	}
 }
 
-// ReplyWithAttachment creates a thread reply with a file attachment and success message
+// SetChannelJoiner sets what adds the bot to the channels it fails to post in, before trying again.
+// It must be set before replies are posted.
+func (t *ThreadReplyService) SetChannelJoiner(joiner channelJoiner) {
+	t.channelJoiner = joiner
+}
+
+// createPost creates a post as the bot. If that fails because the bot isn't a member of the
+// channel, the bot joins it and the post is created again, once.
+func (t *ThreadReplyService) createPost(post *model.Post) (*model.Post, error) {
+	createdPost, appErr := t.api.CreatePost(post)
+	if appErr == nil {
+		return createdPost, nil
+	}
+	if t.channelJoiner == nil {
+		return nil, appErr
+	}
+
+	joined, err := t.channelJoiner.EnsureChannelMember(post.ChannelId)
+	if err != nil {
+		return nil, errors.Wrapf(appErr, "the bot may not be a member of the channel and couldn't join it (%s)", err.Error())
+	}
+	if !joined {
+		// The bot already was a member, that's not why the post failed
+		return nil, appErr
+	}
+
+	createdPost, appErr = t.api.CreatePost(post)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return createdPost, nil
+}
+
+// ReplyWithAttachment creates a thread reply with the archived file attached and a success message
 // originalPostID is optional - if provided, a link to the original post will be included
-func (t *ThreadReplyService) ReplyWithAttachment(postID, fileID, url, filename, mimeType string, size int64, originalPostID string) error {
+// excerpt is optional - if provided, it's quoted so readers get context without opening the file
+// options are the reply settings of the rule that archived the URL, if any
+func (t *ThreadReplyService) ReplyWithAttachment(postID string, metadata *ArchiveMetadata, originalPostID, excerpt string, options ReplyOptions) error {
+	if options.Mode == ReplyModeNone {
+		return nil
+	}
+
 	// Get the original post to get channel ID and determine root ID
 	post, appErr := t.api.GetPost(postID)
 	if appErr != nil {
 		return errors.Wrap(appErr, "failed to get original post")
 	}
 
-	// Determine the root ID for the thread
-	// If the post is already a reply (has RootId), use that. Otherwise, use the post ID itself.
-	rootID := postID
-	if post.RootId != "" {
-		rootID = post.RootId
+	// Format success message
+	var message string
+	if options.Template != "" {
+		message = renderReplyTemplate(options.Template, metadata, excerpt, options.Rule)
+	} else {
+		message = fmt.Sprintf("✅ Successfully archived: %s\n\n**File:** %s\n**Size:** %s\n**Type:** %s",
+			redactURL(metadata.OriginalURL),
+			escapeLinkText(metadata.ShownName()),
+			formatFileSize(metadata.Size),
+			metadata.MimeType,
+		)
+		if fetched := formatFetch(metadata); fetched != "" {
+			message += "\n**Fetched:** " + fetched
+		}
+
+		if excerpt != "" {
+			message += "\n\n> " + escapeExcerpt(excerpt)
+		}
 	}
 
-	// Format success message
-	message := fmt.Sprintf("✅ Successfully archived: %s\n\n**File:** %s\n**Size:** %s\n**Type:** %s",
-		url,
-		filename,
-		formatFileSize(size),
-		mimeType,
-	)
+	// The canonical URL archived instead of the posted one, if any
+	if metadata.CanonicalURL != "" {
+		message += fmt.Sprintf("\n**Canonical URL:** %s", redactURL(metadata.CanonicalURL))
+	}
+
+	// Link to the copy mirrored to external object storage, if any
+	if metadata.ExternalURL != "" {
+		message += fmt.Sprintf("\n**External copy:** [%s](%s)", escapeLinkText(metadata.ShownName()), metadata.ExternalURL)
+	}
+
+	if t.getIncludeDownloadLink() {
+		message += fmt.Sprintf("\n**Download:** %s", t.getDownloadURL(metadata.FileID))
+	}
+
+	// Other files archived along with the main one, attached to the same reply
+	for _, file := range metadata.AttachedFiles() {
+		message += "\n" + t.additionalFileLine(file)
+	}
 
 	// If originalPostID is provided and different from current post, add link to original post
 	if originalPostID != "" && originalPostID != postID {
-		// Get the original post to construct the permalink
-		var originalPost *model.Post
-		originalPost, appErr = t.api.GetPost(originalPostID)
-		if appErr == nil && originalPost != nil {
-			// Get the channel to find the team
-			var channel *model.Channel
-			channel, appErr = t.api.GetChannel(originalPost.ChannelId)
-			if appErr == nil && channel != nil {
-				var permalink string
-				// For team channels, include team name in permalink: /<team-name>/pl/<post-id>
-				// For DM/GM channels, use simple format: /pl/<post-id>
-				if channel.TeamId != "" {
-					var team *model.Team
-					team, appErr = t.api.GetTeam(channel.TeamId)
-					if appErr == nil && team != nil {
-						permalink = fmt.Sprintf("/%s/pl/%s", team.Name, originalPostID)
-					} else {
-						// Fallback to simple format if team lookup fails
-						permalink = fmt.Sprintf("/pl/%s", originalPostID)
-					}
-				} else {
-					// DM or GM channel - use simple format
-					permalink = fmt.Sprintf("/pl/%s", originalPostID)
-				}
-				message += fmt.Sprintf("\n\n📎 Originally archived in [this post](%s)", permalink)
-			}
+		if permalink := t.getPermalink(originalPostID); permalink != "" {
+			message += fmt.Sprintf("\n\n📎 Originally archived in [this post](%s)", permalink)
 		}
 	}
 
-	// Create thread reply post
-	replyPost := &model.Post{
-		UserId:    t.botID,
-		ChannelId: post.ChannelId,
-		RootId:    rootID,
-		Message:   message,
-		FileIds:   []string{fileID},
-		CreateAt:  model.GetMillis(),
-	}
-
-	_, appErr = t.api.CreatePost(replyPost)
-	if appErr != nil {
-		return errors.Wrap(appErr, "failed to create thread reply")
+	// Create thread reply post, the file is attached to its first part
+	if _, err := t.postReplies(post, t.fitMessage(message), metadata.AttachedFileIDs(), options.Mode); err != nil {
+		return errors.Wrap(err, "failed to create thread reply")
 	}
 
 	return nil
 }
 
-// ReplyWithError creates a thread reply with an error message
-func (t *ThreadReplyService) ReplyWithError(postID, url string, err error) error {
+// ReplyWithError creates a thread reply with an error message and returns the ID of the reply, so
+// the archive can be retried by reacting to it
+func (t *ThreadReplyService) ReplyWithError(postID, url string, err error) (string, error) {
 	// Get the original post to get channel ID and determine root ID
 	post, appErr := t.api.GetPost(postID)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to get original post")
-	}
-
-	// Determine the root ID for the thread
-	// If the post is already a reply (has RootId), use that. Otherwise, use the post ID itself.
-	rootID := postID
-	if post.RootId != "" {
-		rootID = post.RootId
+		return "", errors.Wrap(appErr, "failed to get original post")
 	}
 
 	// Format error message
@@ -116,28 +257,713 @@ func (t *ThreadReplyService) ReplyWithError(postID, url string, err error) error
 	reason := extractErrorReason(err)
 
 	message := fmt.Sprintf("❌ Failed to archive: %s\n\n**Error:** %s\n**Reason:** %s",
-		url,
+		redactURL(url),
 		errorMsg,
 		reason,
 	)
+	if emojiName := t.getRetryEmoji(); emojiName != "" {
+		message += fmt.Sprintf("\n\n🔁 React with :%s: to try again.", emojiName)
+	}
 
 	// Create thread reply post
-	replyPost := &model.Post{
+	replyID, replyErr := t.postReplies(post, t.fitMessage(message), nil, "")
+	if replyErr != nil {
+		return "", errors.Wrap(replyErr, "failed to create error thread reply")
+	}
+
+	return replyID, nil
+}
+
+// ReplyWithNotice creates a thread reply explaining why a URL was not archived
+func (t *ThreadReplyService) ReplyWithNotice(postID, url, notice string) error {
+	// Get the original post to get channel ID and determine root ID
+	post, appErr := t.api.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get original post")
+	}
+
+	message := fmt.Sprintf("ℹ️ Skipped archiving: %s\n\n%s", redactURL(url), notice)
+
+	// Create thread reply post
+	if _, err := t.postReplies(post, t.fitMessage(message), nil, ""); err != nil {
+		return errors.Wrap(err, "failed to create notice thread reply")
+	}
+
+	return nil
+}
+
+// ReplyWithRefresh creates a thread reply with the new capture of a watched URL whose content
+// changed since its previous capture
+func (t *ThreadReplyService) ReplyWithRefresh(postID string, metadata *ArchiveMetadata, previousArchivedAt time.Time) error {
+	// Get the original post to get channel ID and determine root ID
+	post, appErr := t.api.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get original post")
+	}
+
+	message := fmt.Sprintf("🔄 Content changed: %s\n\n**File:** %s\n**Size:** %s\n**Type:** %s\n**Previous capture:** %s",
+		redactURL(metadata.OriginalURL),
+		escapeLinkText(metadata.ShownName()),
+		formatFileSize(metadata.Size),
+		metadata.MimeType,
+		previousArchivedAt.UTC().Format("2006-01-02 15:04 MST"),
+	)
+	if fetched := formatFetch(metadata); fetched != "" {
+		message += "\n**Fetched:** " + fetched
+	}
+
+	// Link to the copy mirrored to external object storage, if any
+	if metadata.ExternalURL != "" {
+		message += fmt.Sprintf("\n**External copy:** [%s](%s)", escapeLinkText(metadata.ShownName()), metadata.ExternalURL)
+	}
+
+	// Updates always go in the original thread, the refresh isn't a reply to anyone's post
+	if _, err := t.postReplies(post, t.fitMessage(message), metadata.AttachedFileIDs(), ReplyModeThread); err != nil {
+		return errors.Wrap(err, "failed to create refresh thread reply")
+	}
+
+	return nil
+}
+
+// PostAdminAlert posts an alert for admins to a channel
+func (t *ThreadReplyService) PostAdminAlert(channelID, message string) error {
+	alert := &model.Post{
 		UserId:    t.botID,
-		ChannelId: post.ChannelId,
-		RootId:    rootID,
+		ChannelId: channelID,
 		Message:   message,
-		CreateAt:  model.GetMillis(),
 	}
+	t.applyReplyAuthor(alert)
 
-	_, appErr = t.api.CreatePost(replyPost)
+	if _, err := t.createPost(alert); err != nil {
+		return errors.Wrap(err, "failed to create admin alert post")
+	}
+	return nil
+}
+
+// AddReactions reacts to a post as the bot with each of the emojis it didn't react with already,
+// so posts archived again don't get their reactions twice
+func (t *ThreadReplyService) AddReactions(postID string, emojiNames []string) error {
+	existing, appErr := t.api.GetReactions(postID)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to create error thread reply")
+		return errors.Wrap(appErr, "failed to get reactions of post")
+	}
+
+	reacted := make(map[string]bool, len(existing))
+	for _, reaction := range existing {
+		if reaction.UserId == t.botID {
+			reacted[reaction.EmojiName] = true
+		}
 	}
 
+	for _, emojiName := range emojiNames {
+		if reacted[emojiName] {
+			continue
+		}
+		if _, appErr := t.api.AddReaction(&model.Reaction{UserId: t.botID, PostId: postID, EmojiName: emojiName}); appErr != nil {
+			return errors.Wrapf(appErr, "failed to add reaction %s", emojiName)
+		}
+		reacted[emojiName] = true
+	}
 	return nil
 }
 
+const (
+	// maxFileIDsPerPost is the maximum number of files Mattermost allows to attach to a single post
+	maxFileIDsPerPost = 10
+	// replyLinkReserve is the room left in replies for the link back to the post, in the archive
+	// channel or when linking source replies
+	replyLinkReserve = 200
+)
+
+// ReplyWithSummary creates a single thread reply summarizing the archival of several URLs.
+// Archived files are attached to the reply, and pending is the number of URLs still being archived.
+// Summaries too long for a single post continue in follow-up replies in the same thread.
+func (t *ThreadReplyService) ReplyWithSummary(postID string, results []*archiveResult, pending int) error {
+	return t.replyWithSummary(postID, results, pending, nil)
+}
+
+// ReplyWithBundle creates a summary reply like ReplyWithSummary, attaching the bundle of the
+// archived files instead of the files themselves
+func (t *ThreadReplyService) ReplyWithBundle(postID string, results []*archiveResult, pending int, bundle *archiveBundle) error {
+	return t.replyWithSummary(postID, results, pending, bundle)
+}
+
+// replyWithSummary creates the summary reply of several URLs, attaching the bundle if not nil and
+// the archived files otherwise
+func (t *ThreadReplyService) replyWithSummary(postID string, results []*archiveResult, pending int, bundle *archiveBundle) error {
+	// Get the original post to get channel ID and determine root ID
+	post, appErr := t.api.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get original post")
+	}
+
+	var blocks []string
+	if t.getGroupByDomain() {
+		blocks = t.domainSummaryBlocks(postID, results)
+	} else {
+		blocks = t.summaryBlocks(postID, results)
+	}
+	if bundle != nil {
+		blocks = append(blocks, "", fmt.Sprintf("📦 **Bundle:** %s (%s, %d files)", bundle.Filename, formatFileSize(bundle.Size), bundle.Files))
+	}
+	if pending > 0 {
+		blocks = append(blocks, "", fmt.Sprintf("⏳ %d link(s) are still being archived, their results will be posted separately.", pending))
+	}
+	messages := splitMessage(blocks, t.replyMessageLimit())
+
+	var fileIDs []string
+	if bundle != nil {
+		fileIDs = []string{bundle.FileID}
+	} else {
+		fileIDs = summaryFileIDs(results)
+	}
+
+	if _, err := t.postReplies(post, messages, fileIDs, ""); err != nil {
+		return errors.Wrap(err, "failed to create summary thread reply")
+	}
+
+	return nil
+}
+
+// ReplyWithCounts creates a single thread reply with the counts of the outcomes of a post's links,
+// for posts with too many links to list. results include the skipped ones. The archived files are
+// attached, or the bundle of the files if set, and pending is the number of URLs still being archived.
+func (t *ThreadReplyService) ReplyWithCounts(postID string, results []*archiveResult, pending int, bundle *archiveBundle) error {
+	post, appErr := t.api.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get original post")
+	}
+
+	blocks := []string{countResults(results, pending).format(t.getCountSummaryFormat())}
+	if bundle != nil {
+		blocks = append(blocks, "", fmt.Sprintf("📦 **Bundle:** %s (%s, %d files)", bundle.Filename, formatFileSize(bundle.Size), bundle.Files))
+	}
+	if pending > 0 {
+		blocks = append(blocks, "", fmt.Sprintf("⏳ %d link(s) are still being archived, their results will be posted separately.", pending))
+	}
+
+	var fileIDs []string
+	if bundle != nil {
+		fileIDs = []string{bundle.FileID}
+	} else {
+		var archived []*archiveResult
+		for _, result := range results {
+			if !result.Skipped && result.Metadata != nil {
+				archived = append(archived, result)
+			}
+		}
+		fileIDs = summaryFileIDs(archived)
+	}
+
+	if _, err := t.postReplies(post, splitMessage(blocks, t.replyMessageLimit()), fileIDs, ""); err != nil {
+		return errors.Wrap(err, "failed to create count summary thread reply")
+	}
+
+	return nil
+}
+
+// summaryFileIDs returns the IDs of the files archived for the results of a summary, once each
+func summaryFileIDs(results []*archiveResult) []string {
+	var fileIDs []string
+	seenFileIDs := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil || result.Notice != "" {
+			continue
+		}
+		for _, fileID := range result.Metadata.AttachedFileIDs() {
+			if !seenFileIDs[fileID] {
+				seenFileIDs[fileID] = true
+				fileIDs = append(fileIDs, fileID)
+			}
+		}
+	}
+	return fileIDs
+}
+
+// postReplies posts the parts of a reply, the first one in reply to the post and the others in the
+// same thread. Files are attached from the first part on, continuing in follow-up replies past
+// the number of files allowed per post. mode is one of the ReplyMode constants, or empty. Returns
+// the ID of the first part, empty if the reply isn't stored.
+func (t *ThreadReplyService) postReplies(post *model.Post, messages []string, fileIDs []string, mode string) (string, error) {
+	switch mode {
+	case ReplyModeNone:
+		return "", nil
+	case ReplyModeEphemeral:
+		t.sendEphemeralReplies(post, messages, len(fileIDs) > 0)
+		return "", nil
+	}
+
+	var rootID, replyID string
+	for i := 0; i == 0 || i < len(messages) || len(fileIDs) > 0; i++ {
+		chunk := fileIDs[:min(len(fileIDs), maxFileIDsPerPost)]
+		fileIDs = fileIDs[len(chunk):]
+
+		message := "📎 More archived files from the reply above"
+		if i < len(messages) {
+			message = messages[i]
+		}
+
+		replyPost := t.newReply(post, message, chunk, mode)
+		if i > 0 {
+			replyPost.RootId = rootID
+		}
+
+		createdPost, err := t.createPost(replyPost)
+		if err != nil {
+			return "", err
+		}
+
+		// Follow-up replies go in the same thread as the first one
+		if i == 0 {
+			replyID = createdPost.Id
+			rootID = replyPost.RootId
+			if rootID == "" {
+				rootID = createdPost.Id
+			}
+		}
+	}
+	return replyID, nil
+}
+
+// sendEphemeralReplies sends the parts of a reply in the post's thread, only visible to its author.
+// Ephemeral posts aren't stored, so the archived files can't be attached to them.
+func (t *ThreadReplyService) sendEphemeralReplies(post *model.Post, messages []string, hasFiles bool) {
+	if hasFiles && len(messages) > 0 {
+		messages[len(messages)-1] += "\n\n🗄️ The archived files are stored, but can't be attached to replies only visible to you."
+	}
+	for _, message := range messages {
+		t.api.SendEphemeralPost(post.UserId, t.newReply(post, message, nil, ReplyModeThread))
+	}
+}
+
+// fitMessage splits a message into parts within the length limit of replies, between lines.
+// Lines too long for a single reply, like very long URLs, are truncated.
+func (t *ThreadReplyService) fitMessage(message string) []string {
+	return splitMessage(strings.Split(message, "\n"), t.replyMessageLimit())
+}
+
+// replyMessageLimit returns the maximum length of the message of replies
+func (t *ThreadReplyService) replyMessageLimit() int {
+	return max(t.getMaxPostLength()-replyLinkReserve, replyLinkReserve)
+}
+
+// summaryBlocks formats the results of a summary in sections of archived, failed and skipped links.
+// Blocks are joined with newlines, empty blocks separate the sections.
+func (t *ThreadReplyService) summaryBlocks(postID string, results []*archiveResult) []string {
+	var archived, failed, skipped []string
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed = append(failed, fmt.Sprintf("- %s\n  %s", redactURL(result.URL), summaryErrorLine(result)))
+		case result.Notice != "":
+			skipped = append(skipped, fmt.Sprintf("- %s\n  %s", redactURL(result.URL), result.Notice))
+		default:
+			archived = append(archived, fmt.Sprintf("- %s\n  %s", redactURL(result.URL), t.summaryFileLine(postID, result)))
+		}
+	}
+
+	var blocks []string
+	addSection := func(header string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		if len(blocks) > 0 {
+			blocks = append(blocks, "")
+		}
+		blocks = append(blocks, header, "")
+		blocks = append(blocks, lines...)
+	}
+	addSection(fmt.Sprintf("✅ Successfully archived %d link(s):", len(archived)), archived)
+	addSection(fmt.Sprintf("❌ Failed to archive %d link(s):", len(failed)), failed)
+	addSection(fmt.Sprintf("ℹ️ Skipped %d link(s):", len(skipped)), skipped)
+	return blocks
+}
+
+// domainSummaryBlocks formats the results of a summary in sections per domain, sorted by hostname.
+// Links keep their order within their domain, and links without a hostname come last.
+func (t *ThreadReplyService) domainSummaryBlocks(postID string, results []*archiveResult) []string {
+	var archived, failed, skipped int
+	linesByDomain := make(map[string][]string)
+	for _, result := range results {
+		var line string
+		switch {
+		case result.Err != nil:
+			failed++
+			line = fmt.Sprintf("- ❌ %s\n  %s", redactURL(result.URL), summaryErrorLine(result))
+		case result.Notice != "":
+			skipped++
+			line = fmt.Sprintf("- ℹ️ %s\n  %s", redactURL(result.URL), result.Notice)
+		default:
+			archived++
+			line = fmt.Sprintf("- ✅ %s\n  %s", redactURL(result.URL), t.summaryFileLine(postID, result))
+		}
+
+		domain := ""
+		if parsedURL, err := url.Parse(result.URL); err == nil {
+			domain = strings.ToLower(parsedURL.Hostname())
+		}
+		linesByDomain[domain] = append(linesByDomain[domain], line)
+	}
+
+	var counts []string
+	if archived > 0 {
+		counts = append(counts, fmt.Sprintf("✅ %d archived", archived))
+	}
+	if failed > 0 {
+		counts = append(counts, fmt.Sprintf("❌ %d failed", failed))
+	}
+	if skipped > 0 {
+		counts = append(counts, fmt.Sprintf("ℹ️ %d skipped", skipped))
+	}
+	blocks := []string{fmt.Sprintf("Archived links by domain: %s", strings.Join(counts, ", "))}
+
+	domains := make([]string, 0, len(linesByDomain))
+	for domain := range linesByDomain {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		// Links without a hostname go last
+		if (domains[i] == "") != (domains[j] == "") {
+			return domains[j] == ""
+		}
+		return domains[i] < domains[j]
+	})
+	for _, domain := range domains {
+		header := "#### " + domain
+		if domain == "" {
+			header = "#### Other links"
+		}
+		blocks = append(blocks, "", header, "")
+		blocks = append(blocks, linesByDomain[domain]...)
+	}
+	return blocks
+}
+
+// summaryFileLine describes the archived file of a result in a summary
+func (t *ThreadReplyService) summaryFileLine(postID string, result *archiveResult) string {
+	metadata := result.Metadata
+	line := fmt.Sprintf("**File:** %s (%s, %s)", escapeLinkText(metadata.ShownName()), formatFileSize(metadata.Size), metadata.MimeType)
+	if metadata.CanonicalURL != "" {
+		line += fmt.Sprintf(", canonical URL %s", redactURL(metadata.CanonicalURL))
+	}
+	if metadata.ExternalURL != "" {
+		line += fmt.Sprintf(", [external copy](%s)", metadata.ExternalURL)
+	}
+	if t.getIncludeDownloadLink() {
+		line += fmt.Sprintf(", [download](%s)", t.getDownloadURL(metadata.FileID))
+	}
+	if result.OriginalPostID != "" && result.OriginalPostID != postID {
+		if permalink := t.getPermalink(result.OriginalPostID); permalink != "" {
+			line += fmt.Sprintf(", originally archived in [this post](%s)", permalink)
+		}
+	}
+	for _, file := range metadata.AttachedFiles() {
+		line += "\n  " + t.additionalFileLine(file)
+	}
+	return line
+}
+
+// additionalFileLine describes a file archived along with the main file of a URL
+func (t *ThreadReplyService) additionalFileLine(file AdditionalFile) string {
+	line := fmt.Sprintf("**Also archived:** %s (%s, %s)", escapeLinkText(file.Filename), formatFileSize(file.Size), file.MimeType)
+	if t.getIncludeDownloadLink() {
+		line += fmt.Sprintf(", [download](%s)", t.getDownloadURL(file.FileID))
+	}
+	return line
+}
+
+// summaryErrorLine describes the error of a failed result in a summary
+func summaryErrorLine(result *archiveResult) string {
+	return fmt.Sprintf("**Error:** %s (%s)", result.Err.Error(), extractErrorReason(result.Err))
+}
+
+// splitMessage joins blocks with newlines into messages of at most limit characters, splitting
+// between blocks. Blocks longer than the limit are truncated.
+func splitMessage(blocks []string, limit int) []string {
+	var messages []string
+	var current []string
+	length := 0
+	flush := func() {
+		if message := strings.Trim(strings.Join(current, "\n"), "\n"); message != "" {
+			messages = append(messages, message)
+		}
+		current = nil
+		length = 0
+	}
+
+	for _, block := range blocks {
+		if runes := []rune(block); len(runes) > limit {
+			block = string(runes[:limit-1]) + "…"
+		}
+		// Every block adds its newline separator
+		blockLength := utf8.RuneCountInString(block) + 1
+		if len(current) > 0 && length+blockLength > limit+1 {
+			flush()
+		}
+		current = append(current, block)
+		length += blockLength
+	}
+	flush()
+
+	return messages
+}
+
+// SetMaxPostLength sets the maximum length of the bot's posts, for servers allowing shorter posts
+// than Mattermost's limit. Zero restores the limit, which can't be exceeded.
+func (t *ThreadReplyService) SetMaxPostLength(length int) {
+	t.maxPostLength.Store(int64(length))
+}
+
+// getMaxPostLength returns the maximum length of the bot's posts
+func (t *ThreadReplyService) getMaxPostLength() int {
+	if length := int(t.maxPostLength.Load()); length > 0 {
+		return min(length, model.PostMessageMaxRunesV2)
+	}
+	return model.PostMessageMaxRunesV2
+}
+
+// SetGroupByDomain sets whether summary replies group their links by domain
+func (t *ThreadReplyService) SetGroupByDomain(enabled bool) {
+	t.groupByDomain.Store(enabled)
+}
+
+// getGroupByDomain returns whether summary replies group their links by domain
+func (t *ThreadReplyService) getGroupByDomain() bool {
+	return t.groupByDomain.Load()
+}
+
+// SetIncludeDownloadLink sets whether replies include the download URL of archived files
+func (t *ThreadReplyService) SetIncludeDownloadLink(enabled bool) {
+	t.includeDownloadLink.Store(enabled)
+}
+
+// getIncludeDownloadLink returns whether replies include the download URL of archived files
+func (t *ThreadReplyService) getIncludeDownloadLink() bool {
+	return t.includeDownloadLink.Load()
+}
+
+// SetLinkSourceReply sets whether replies in threads link to the thread reply the archived links were posted in
+func (t *ThreadReplyService) SetLinkSourceReply(enabled bool) {
+	t.linkSourceReply.Store(enabled)
+}
+
+// getLinkSourceReply returns whether replies in threads link to the thread reply the archived links were posted in
+func (t *ThreadReplyService) getLinkSourceReply() bool {
+	return t.linkSourceReply.Load()
+}
+
+// SetRetryEmoji sets the emoji retrying failed archives when reacting to their error reply, shown on
+// error replies. An empty emoji name hides the hint.
+func (t *ThreadReplyService) SetRetryEmoji(emojiName string) {
+	t.retryEmojiLock.Lock()
+	defer t.retryEmojiLock.Unlock()
+	t.retryEmoji = emojiName
+}
+
+// getRetryEmoji returns the emoji retrying failed archives
+func (t *ThreadReplyService) getRetryEmoji() string {
+	t.retryEmojiLock.RLock()
+	defer t.retryEmojiLock.RUnlock()
+	return t.retryEmoji
+}
+
+// SetCountSummaryFormat sets the message of count summaries, see countSummaryTokens. An empty format
+// restores the default one.
+func (t *ThreadReplyService) SetCountSummaryFormat(format string) {
+	t.countSummaryLock.Lock()
+	defer t.countSummaryLock.Unlock()
+	t.countSummaryFormat = format
+}
+
+// getCountSummaryFormat returns the message of count summaries
+func (t *ThreadReplyService) getCountSummaryFormat() string {
+	t.countSummaryLock.RLock()
+	defer t.countSummaryLock.RUnlock()
+	if t.countSummaryFormat == "" {
+		return defaultCountSummaryFormat
+	}
+	return t.countSummaryFormat
+}
+
+// getDownloadURL returns the URL to download an archived file: its public link if the server allows
+// public file links and can generate one, which needs the file to be attached to a post already like
+// reused archives, or the API path of the file otherwise, which requires being logged in
+func (t *ThreadReplyService) getDownloadURL(fileID string) string {
+	serverConfig := t.api.GetConfig()
+	if serverConfig != nil && serverConfig.FileSettings.EnablePublicLink != nil && *serverConfig.FileSettings.EnablePublicLink {
+		if link, appErr := t.api.GetFileLink(fileID); appErr == nil && link != "" {
+			return link
+		}
+	}
+
+	siteURL := ""
+	if serverConfig != nil && serverConfig.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimRight(*serverConfig.ServiceSettings.SiteURL, "/")
+	}
+	return siteURL + "/api/v4/files/" + url.PathEscape(fileID) + "?download=1"
+}
+
+// SetArchiveChannelID sets the channel replies are posted to instead of the post's thread.
+// An empty channel ID restores thread replies.
+func (t *ThreadReplyService) SetArchiveChannelID(channelID string) {
+	t.archiveChannelLock.Lock()
+	defer t.archiveChannelLock.Unlock()
+	t.archiveChannelID = channelID
+}
+
+// getArchiveChannelID returns the configured archive channel ID
+func (t *ThreadReplyService) getArchiveChannelID() string {
+	t.archiveChannelLock.RLock()
+	defer t.archiveChannelLock.RUnlock()
+	return t.archiveChannelID
+}
+
+// SetReplyAuthor sets the display name and icon URL shown on replies instead of the bot's.
+// Empty values keep the bot's profile.
+func (t *ThreadReplyService) SetReplyAuthor(displayName, iconURL string) {
+	t.authorLock.Lock()
+	defer t.authorLock.Unlock()
+	t.displayName = displayName
+	t.iconURL = iconURL
+}
+
+// getReplyAuthor returns the configured display name and icon URL overrides
+func (t *ThreadReplyService) getReplyAuthor() (displayName, iconURL string) {
+	t.authorLock.RLock()
+	defer t.authorLock.RUnlock()
+	return t.displayName, t.iconURL
+}
+
+// applyReplyAuthor sets the author overrides on a reply, skipping the ones the server doesn't allow
+func (t *ThreadReplyService) applyReplyAuthor(reply *model.Post) {
+	displayName, iconURL := t.getReplyAuthor()
+	if displayName == "" && iconURL == "" {
+		return
+	}
+
+	serverConfig := t.api.GetConfig()
+	if serverConfig == nil {
+		return
+	}
+	overridden := false
+	if displayName != "" && serverConfig.ServiceSettings.EnablePostUsernameOverride != nil && *serverConfig.ServiceSettings.EnablePostUsernameOverride {
+		reply.AddProp(model.PostPropsOverrideUsername, displayName)
+		overridden = true
+	}
+	if iconURL != "" && serverConfig.ServiceSettings.EnablePostIconOverride != nil && *serverConfig.ServiceSettings.EnablePostIconOverride {
+		reply.AddProp(model.PostPropsOverrideIconURL, iconURL)
+		overridden = true
+	}
+	// Clients only show the overrides of posts coming from webhooks
+	if overridden {
+		reply.AddProp(model.PostPropsFromWebhook, "true")
+	}
+}
+
+// newReply builds the reply post for a post's archival results.
+// Replies go in the post's thread, or to the archive channel with a link back to the post if configured
+// and mode isn't ReplyModeThread.
+func (t *ThreadReplyService) newReply(post *model.Post, message string, fileIDs []string, mode string) *model.Post {
+	reply := &model.Post{
+		UserId:   t.botID,
+		Message:  message,
+		FileIds:  fileIDs,
+		CreateAt: model.GetMillis(),
+	}
+	t.applyReplyAuthor(reply)
+
+	archiveChannelID := t.getArchiveChannelID()
+	if mode == ReplyModeThread || archiveChannelID == "" || archiveChannelID == post.ChannelId {
+		// Determine the root ID for the thread
+		// If the post is already a reply (has RootId), use that. Otherwise, use the post ID itself.
+		reply.ChannelId = post.ChannelId
+		reply.RootId = post.Id
+		if post.RootId != "" {
+			reply.RootId = post.RootId
+
+			// In long threads, point to the reply the links came from
+			if t.getLinkSourceReply() {
+				if permalink := t.getPermalink(post.Id); permalink != "" {
+					reply.Message += fmt.Sprintf("\n\n↪️ Links from [this reply](%s)", permalink)
+				}
+			}
+		}
+		return reply
+	}
+
+	reply.ChannelId = archiveChannelID
+	if permalink := t.getPermalink(post.Id); permalink != "" {
+		reply.Message += fmt.Sprintf("\n\n🔗 Posted in [this post](%s)", permalink)
+	}
+
+	// Members of the archive channel may not have access to the original post
+	channel, appErr := t.api.GetChannel(post.ChannelId)
+	if appErr == nil && channel != nil && (channel.Type == model.ChannelTypePrivate || channel.IsGroupOrDirect()) {
+		reply.Message += "\n\n🔒 The original post is in a private channel or conversation and may not be visible to members of this channel."
+	}
+
+	return reply
+}
+
+// getPermalink returns the relative permalink for a post, or an empty string if it can't be built
+func (t *ThreadReplyService) getPermalink(postID string) string {
+	post, appErr := t.api.GetPost(postID)
+	if appErr != nil || post == nil {
+		return ""
+	}
+
+	// Get the channel to find the team
+	channel, appErr := t.api.GetChannel(post.ChannelId)
+	if appErr != nil || channel == nil {
+		return ""
+	}
+
+	// For team channels, include team name in permalink: /<team-name>/pl/<post-id>
+	// For DM/GM channels, use simple format: /pl/<post-id>
+	if channel.TeamId != "" {
+		team, appErr := t.api.GetTeam(channel.TeamId)
+		if appErr == nil && team != nil {
+			return fmt.Sprintf("/%s/pl/%s", team.Name, postID)
+		}
+		// Fallback to simple format if team lookup fails
+	}
+
+	return fmt.Sprintf("/pl/%s", postID)
+}
+
+// renderReplyTemplate replaces the tokens of a reply template with the details of an archive
+func renderReplyTemplate(template string, metadata *ArchiveMetadata, excerpt, rule string) string {
+	return strings.NewReplacer(
+		"{url}", redactURL(metadata.OriginalURL),
+		"{filename}", escapeLinkText(metadata.ShownName()),
+		"{size}", formatFileSize(metadata.Size),
+		"{type}", metadata.MimeType,
+		"{excerpt}", escapeExcerpt(excerpt),
+		"{rule}", rule,
+	).Replace(template)
+}
+
+// excerptEscaper escapes the Markdown syntax of page excerpts. Mentions, emoji and autolinks are broken
+// with zero-width spaces so archived pages can't notify users or add link previews.
+var excerptEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]",
+	"<", "\\<", ">", "\\>", "#", "\\#", "|", "\\|", "~", "\\~", "!", "\\!",
+	"@", "@\u200b", ":", ":\u200b",
+)
+
+// escapeExcerpt makes a page excerpt safe to quote in a reply
+func escapeExcerpt(excerpt string) string {
+	return excerptEscaper.Replace(excerpt)
+}
+
+// linkTextEscaper escapes the Markdown syntax of links, so filenames can't close or add links
+var linkTextEscaper = strings.NewReplacer("\\", "\\\\", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)")
+
+// escapeLinkText makes a filename safe to show in a reply, as the text of a link or next to one
+func escapeLinkText(text string) string {
+	return linkTextEscaper.Replace(text)
+}
+
 // formatFileSize formats file size in human-readable format
 func formatFileSize(size int64) string {
 	const unit = 1024
@@ -152,10 +978,40 @@ func formatFileSize(size int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
+// formatFetch formats the HTTP status and fetch duration of an archive, like "200 OK in 1.2s".
+// Empty when the tool reported neither.
+func formatFetch(metadata *ArchiveMetadata) string {
+	var parts []string
+	if metadata.StatusCode > 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%d %s", metadata.StatusCode, http.StatusText(metadata.StatusCode))))
+	}
+	if metadata.FetchDurationMs > 0 {
+		parts = append(parts, "in "+formatFetchDuration(time.Duration(metadata.FetchDurationMs)*time.Millisecond))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatFetchDuration rounds a fetch duration to what's meaningful for readers: milliseconds under
+// a second, tenths of seconds under a minute, seconds above
+func formatFetchDuration(duration time.Duration) string {
+	switch {
+	case duration < time.Second:
+		return duration.Round(time.Millisecond).String()
+	case duration < time.Minute:
+		return duration.Round(100 * time.Millisecond).String()
+	default:
+		return duration.Round(time.Second).String()
+	}
+}
+
 // extractErrorReason extracts a user-friendly reason from an error
 func extractErrorReason(err error) string {
 	errStr := err.Error()
 
+	if errors.Is(err, archiver.ErrTooManyRedirects) {
+		return "Too many redirects, the link may redirect in a loop"
+	}
+
 	// Check for common error patterns
 	if contains(errStr, "timeout") || contains(errStr, "Timeout") {
 		return "Timeout while fetching URL"
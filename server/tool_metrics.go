@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fmartingrmattermost-plugin-link-archiver/server/archiver"
+)
+
+// toolMetrics counts the archives of each archival tool on this server since the plugin started,
+// with their durations, to find the tools that are slow or keep failing
+type toolMetrics struct {
+	lock  sync.Mutex
+	since time.Time
+	tools map[string]*toolRuns
+}
+
+// toolRuns are the counters of the archives of a tool
+type toolRuns struct {
+	successes     int
+	failures      int
+	totalDuration time.Duration
+	maxDuration   time.Duration
+	bytes         int64 // Size of the files archived successfully
+}
+
+// toolMetricsSummary is the summary of the archives of a tool, for the metrics endpoint
+type toolMetricsSummary struct {
+	Tool                string `json:"tool"`
+	Successes           int    `json:"successes"`
+	Failures            int    `json:"failures"`
+	AverageMilliseconds int64  `json:"averageMilliseconds"`
+	MaxMilliseconds     int64  `json:"maxMilliseconds"`
+	Bytes               int64  `json:"bytes"`
+}
+
+// newToolMetrics creates new tool metrics, starting now
+func newToolMetrics() *toolMetrics {
+	return &toolMetrics{since: time.Now(), tools: make(map[string]*toolRuns)}
+}
+
+// record counts an archive of a tool
+func (m *toolMetrics) record(toolName string, duration time.Duration, bytes int64, failed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	runs, ok := m.tools[toolName]
+	if !ok {
+		runs = &toolRuns{}
+		m.tools[toolName] = runs
+	}
+	if failed {
+		runs.failures++
+	} else {
+		runs.successes++
+		runs.bytes += bytes
+	}
+	runs.totalDuration += duration
+	runs.maxDuration = max(runs.maxDuration, duration)
+}
+
+// summary returns the time counting started and the summaries of the tools that archived
+// anything, sorted by tool name
+func (m *toolMetrics) summary() (time.Time, []toolMetricsSummary) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	summaries := make([]toolMetricsSummary, 0, len(m.tools))
+	for toolName, runs := range m.tools {
+		summaries = append(summaries, toolMetricsSummary{
+			Tool:                toolName,
+			Successes:           runs.successes,
+			Failures:            runs.failures,
+			AverageMilliseconds: (runs.totalDuration / time.Duration(runs.successes+runs.failures)).Milliseconds(),
+			MaxMilliseconds:     runs.maxDuration.Milliseconds(),
+			Bytes:               runs.bytes,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Tool < summaries[j].Tool
+	})
+	return m.since, summaries
+}
+
+// timedArchiveFiles archives a URL with a tool like archiveFiles, logging how long the tool took
+func (p *ArchiveProcessor) timedArchiveFiles(log logger, tool archiver.ArchivalTool, url, mimeType string, options archiver.ArchiveOptions) ([]*archiver.ArchivedFile, error) {
+	start := time.Now()
+	archivedFiles, err := archiveFiles(tool, url, mimeType, options)
+	p.recordToolRun(log, tool.Name(), url, time.Since(start), archivedFiles, err)
+	return archivedFiles, err
+}
+
+// recordToolRun logs the outcome, duration and size of an archive by a tool, and counts it in the
+// tool metrics. Failures are logged at info level too, with the failure itself logged by the caller.
+func (p *ArchiveProcessor) recordToolRun(log logger, toolName, url string, duration time.Duration, archivedFiles []*archiver.ArchivedFile, err error) {
+	var size int64
+	for _, file := range archivedFiles {
+		size += file.Size
+	}
+
+	keyValuePairs := []any{"toolName", toolName, "url", redactURL(url), "durationMs", duration.Milliseconds()}
+	if err != nil {
+		keyValuePairs = append(keyValuePairs, "outcome", "failure", "error", err.Error())
+	} else {
+		keyValuePairs = append(keyValuePairs, "outcome", "success", "bytes", size, "files", len(archivedFiles))
+	}
+	log.LogInfo("Archival tool finished", keyValuePairs...)
+
+	if p.toolMetrics != nil {
+		p.toolMetrics.record(toolName, duration, size, err != nil)
+	}
+}
+
+// ToolMetrics returns the time the tool metrics started counting and the summaries of the archives
+// of each tool on this server
+func (p *ArchiveProcessor) ToolMetrics() (time.Time, []toolMetricsSummary) {
+	if p.toolMetrics == nil {
+		return time.Time{}, []toolMetricsSummary{}
+	}
+	return p.toolMetrics.summary()
+}
@@ -4,6 +4,12 @@ type ArchivalRule = {
     kind: 'hostname' | 'mimetype';
     pattern: string;
     archivalTool: string;
+    maxBytes?: number; // Optional file size limit, the tool's default is used when unset
+    keepHistory?: boolean; // Keep previous captures when the content of a matching URL changes
+    priority?: number; // Lower numbers are evaluated first, ties keep the table order
+    exclude?: boolean; // Matching URLs are never archived, the archival tool is ignored
+    label?: string; // Optional short name identifying the rule in logs
+    description?: string; // Optional note documenting why the rule exists
 };
 
 type Config = {
@@ -188,7 +194,7 @@ const AdminSettings: React.FC<Props> = ({config, setConfig, archivalTools, disab
         setConfig(newConfig);
     };
 
-    const handleUpdateRule = (index: number, field: keyof ArchivalRule, value: string) => {
+    const handleUpdateRule = <K extends keyof ArchivalRule>(index: number, field: K, value: ArchivalRule[K]) => {
         const newRules = [...localConfig.archivalRules];
         newRules[index] = {
             ...newRules[index],
@@ -234,7 +240,7 @@ const AdminSettings: React.FC<Props> = ({config, setConfig, archivalTools, disab
                 <div style={styles.sectionTitle}>{'Archival Rules'}</div>
                 <div style={styles.formGroup}>
                     <div style={styles.helpText}>
-                        {'Configure archival rules that match on hostname or MIME type patterns. Rules are evaluated in order, and the first matching rule determines which archival tool to use. The last rule is the default (always matches) and cannot be removed or reordered. Use wildcards like "*.example.com" for hostnames or "image/*" for MIME types.'}
+                        {'Configure archival rules that match on hostname or MIME type patterns. Rules are evaluated in order, and the first matching rule determines which archival tool to use. The last rule is the default (always matches) and cannot be removed or reordered. Use wildcards like "*.example.com" for hostnames or "image/*" for MIME types. Max Size overrides the archival tool\'s file size limit for files matching the rule. Keep History keeps previous captures of matching URLs when their content changes. Priority reorders rules without moving them: lower numbers are evaluated first, and rules with the same priority keep their order. Exclude stops the evaluation and never archives matching URLs, even if a broader rule would. Label and Description document why a rule exists, and are logged when the rule matches.'}
                     </div>
 
                     <table style={styles.table}>
@@ -244,6 +250,12 @@ const AdminSettings: React.FC<Props> = ({config, setConfig, archivalTools, disab
                                 <th style={styles.tableHeader}>{'Kind'}</th>
                                 <th style={styles.tableHeader}>{'Pattern'}</th>
                                 <th style={styles.tableHeader}>{'Archival Tool'}</th>
+                                <th style={styles.tableHeader}>{'Max Size (MB)'}</th>
+                                <th style={styles.tableHeader}>{'Keep History'}</th>
+                                <th style={styles.tableHeader}>{'Priority'}</th>
+                                <th style={styles.tableHeader}>{'Exclude'}</th>
+                                <th style={styles.tableHeader}>{'Label'}</th>
+                                <th style={styles.tableHeader}>{'Description'}</th>
                                 <th style={styles.tableHeader}>{'Actions'}</th>
                             </tr>
                         </thead>
@@ -342,7 +354,7 @@ const AdminSettings: React.FC<Props> = ({config, setConfig, archivalTools, disab
                                                 style={styles.tableSelect}
                                                 value={rule.archivalTool}
                                                 onChange={(e) => handleUpdateRule(index, 'archivalTool', e.target.value)}
-                                                disabled={disabled}
+                                                disabled={disabled || Boolean(rule.exclude)}
                                             >
                                                 {archivalTools.map((tool) => (
                                                     <option
@@ -354,6 +366,78 @@ const AdminSettings: React.FC<Props> = ({config, setConfig, archivalTools, disab
                                                 ))}
                                             </select>
                                         </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='number'
+                                                    min={0}
+                                                    style={styles.tableInput}
+                                                    value={rule.maxBytes ? rule.maxBytes / (1024 * 1024) : ''}
+                                                    onChange={(e) => handleUpdateRule(index, 'maxBytes', e.target.value === '' ? undefined : Math.round(Number(e.target.value) * 1024 * 1024))}
+                                                    placeholder='Tool default'
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='checkbox'
+                                                    checked={Boolean(rule.keepHistory)}
+                                                    onChange={(e) => handleUpdateRule(index, 'keepHistory', e.target.checked || undefined)}
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='number'
+                                                    step={1}
+                                                    style={styles.tableInput}
+                                                    value={rule.priority ?? ''}
+                                                    onChange={(e) => handleUpdateRule(index, 'priority', e.target.value === '' ? undefined : Math.trunc(Number(e.target.value)))}
+                                                    placeholder='0'
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='checkbox'
+                                                    checked={Boolean(rule.exclude)}
+                                                    onChange={(e) => handleUpdateRule(index, 'exclude', e.target.checked || undefined)}
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='text'
+                                                    maxLength={64}
+                                                    style={styles.tableInput}
+                                                    value={rule.label ?? ''}
+                                                    onChange={(e) => handleUpdateRule(index, 'label', e.target.value || undefined)}
+                                                    placeholder='Optional'
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
+                                        <td style={styles.tableCell}>
+                                            {!isDefault && (
+                                                <input
+                                                    type='text'
+                                                    maxLength={500}
+                                                    style={styles.tableInput}
+                                                    value={rule.description ?? ''}
+                                                    onChange={(e) => handleUpdateRule(index, 'description', e.target.value || undefined)}
+                                                    placeholder='Why this rule exists'
+                                                    disabled={disabled}
+                                                />
+                                            )}
+                                        </td>
                                         <td style={styles.tableCell}>
                                             {isDefault ? (
                                                 <span style={{color: '#666', fontSize: '12px'}}>{'Default'}</span>
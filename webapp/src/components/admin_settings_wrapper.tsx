@@ -108,10 +108,10 @@ const AdminSettingsWrapper: React.FC<CustomSettingProps> = ({id, value, onChange
             });
             if (response.ok) {
                 const data = await response.json();
-                const tools = data.tools || [];
+                const tools: string[] = (data.tools || []).map((t: {name: string}) => t.name);
 
                 // Ensure do_nothing is always first
-                const allTools = ['do_nothing', ...tools.filter((t: string) => t !== 'do_nothing')];
+                const allTools = ['do_nothing', ...tools.filter((t) => t !== 'do_nothing')];
                 setArchivalTools(allTools);
             }
         } catch (err) {